@@ -61,6 +61,9 @@ type AppConfigODS struct {
 	ForceMasterConnection bool `yaml:"force_master_connection"`
 
 	InternalEncoding string `yaml:"internal_encoding"`
+	//NullEncoding is one of: null, omit. Controls how NULL fields are
+	//represented in the output records (default: null)
+	NullEncoding string `yaml:"null_encoding"`
 
 	TableParams `yaml:",inline"` //Merged with table specific config if any
 
@@ -75,6 +78,11 @@ type EncryptionConfig struct {
 	PublicKey  string `yaml:"public_key"`  // used to encrypt in producer
 	PrivateKey string `yaml:"private_key"` // used to decrypt in consumer
 	SigningKey string `yaml:"signing_key"` // used to sign in producer and verify in consumer
+	//Topics is the regular expression of the topics to encrypt. Empty - all
+	Topics string `yaml:"topics"`
+	//WrappingKey is hex encoded AES-256 key, which seals the PGP key packets
+	//of the files, with the recipient key ids, into the file header
+	WrappingKey string `yaml:"wrapping_key"`
 }
 
 // PipeConfig holds pipe configuration options
@@ -88,23 +96,190 @@ type PipeConfig struct {
 	MaxFileDataSize int64 `yaml:"max_file_data_size"` //uncompressed data size
 
 	Compression bool
+	//CompressionType is one of: gzip, zstd (default: gzip)
+	CompressionType string `yaml:"compression_type"`
+	//TopicCompression maps the topic to its compression codec, one of:
+	//gzip, zstd, none, overriding Compression and CompressionType
+	TopicCompression map[string]string `yaml:"topic_compression"`
+	//DecompressBufferSize is the size of consumer read buffers around
+	//decompressor (default: bufio default)
+	DecompressBufferSize int `yaml:"decompress_buffer_size"`
+	//MaxConsumerMemory bounds read and decompression buffers of the file
+	//consumer and the size of the record. Larger records are rejected.
+	//Split across ParallelReaders. 0 - unlimited
+	MaxConsumerMemory int64 `yaml:"max_consumer_memory"`
+	//ZstdWindowSize is compression window size for zstd, power of two
+	ZstdWindowSize int `yaml:"zstd_window_size"`
 	//Delimited enables producing delimited message to text files and length
 	//prepended messages to binary files
 	FileDelimited bool `yaml:"file_delimited"`
+	//ChunkSize splits binary delimited records larger than this size into
+	//fragments, which are reassembled by the consumer. 0 - disabled
+	ChunkSize int `yaml:"chunk_size"`
+	//RecordSeparator is inserted between the records streamed by the topic
+	//reader. Default is newline
+	RecordSeparator string `yaml:"record_separator"`
+	//SortMode is one of: memory, external. Enables sorting of the records
+	//by key within every file. Records are written out sorted when the file
+	//is finalized. In external mode sorted runs are spilled to temporary
+	//files when buffered records exceed SortMemoryBudget
+	SortMode string `yaml:"sort_mode"`
+	//SortMemoryBudget is the size of the records buffered in memory in
+	//external sort mode, before spilling them to temporary file (default:
+	//64MiB)
+	SortMemoryBudget int64 `yaml:"sort_memory_budget"`
 
 	NonBlocking bool `yaml:"non_blocking"`
+	//StopAtCurrentEnd makes consumer report the end of the stream after the
+	//last file finalized at the consumer creation, instead of waiting for
+	//new files
+	StopAtCurrentEnd bool `yaml:"stop_at_current_end"`
+	//ParallelReaders is the number of files read concurrently by the
+	//consumer with StopAtCurrentEnd. Records are delivered unordered.
+	//0, 1 - files are read one by one in order
+	ParallelReaders int `yaml:"parallel_readers"`
+	//AttachLocation makes consumer deliver records with their location in the
+	//topic, which can be used to read the record again by ReadAt
+	AttachLocation bool `yaml:"attach_location"`
+	//DebugOrderCheck makes consumer verify the order of the delivered
+	//records and fail on out of order delivery. For tests, not for
+	//production
+	DebugOrderCheck bool `yaml:"debug_order_check"`
+	//ClockAnomalyCheck makes consumer detect the files, which appear after
+	//the file with the later timestamp in the name has been consumed
+	ClockAnomalyCheck bool `yaml:"clock_anomaly_check"`
+	//ClockAnomalyLog makes consumer log detected clock anomalies
+	ClockAnomalyLog bool `yaml:"clock_anomaly_log"`
+	//PollIntervalMin is the interval of polling of the topic by the consumers
+	//of the remote pipes, which grows up to PollIntervalMax while no new
+	//files appear
+	PollIntervalMin time.Duration `yaml:"poll_interval_min"`
+	PollIntervalMax time.Duration `yaml:"poll_interval_max"`
+	//EventTimeField is the field of JSON records with the event time of the
+	//record, either Unix seconds or RFC3339 string. Producer records event
+	//time range of the files, which is used by SeekToTimestamp
+	EventTimeField string `yaml:"event_time_field"`
+	//EventTimeFields overrides EventTimeField for the topics
+	EventTimeFields map[string]string `yaml:"event_time_fields"`
+	//TimePartitionFormat partitions records by the event time, formatted
+	//with this Go time layout, instead of the key
+	TimePartitionFormat string `yaml:"time_partition_format"`
+
+	//TransformErrorPolicy is one of: fail, skip, dead_letter. Determines
+	//handling of the consumer transform failures. Default is fail
+	TransformErrorPolicy string `yaml:"transform_error_policy"`
+	//TransformDeadLetterTopic is the topic of the messages failed by the
+	//consumer transform with dead_letter policy
+	TransformDeadLetterTopic string `yaml:"transform_dead_letter_topic"`
+
+	//UTF8Validation is one of: reject, replace. Producer ensures that the
+	//records are valid BOM-free UTF-8, either rejecting invalid records or
+	//replacing invalid sequences. Disabled by default
+	UTF8Validation string `yaml:"utf8_validation"`
+	//UTF8ValidateOnRead applies UTF8Validation to the consumed records
+	UTF8ValidateOnRead bool `yaml:"utf8_validate_on_read"`
+
+	//OneRecordPerFile makes producer finalize the file after every record
+	OneRecordPerFile bool `yaml:"one_record_per_file"`
+	//FlushEveryRecords flushes file write buffers after this number of
+	//records batched by PushBatch, without waiting for PushBatchCommit.
+	//0 - disabled
+	FlushEveryRecords int64 `yaml:"flush_every_records"`
+	//ConcurrentFiles round-robins the records of every key across this number
+	//of concurrently open files, so as they can be written and read in
+	//parallel. Records keep the order within the file only. 0, 1 - disabled
+	ConcurrentFiles int `yaml:"concurrent_files"`
+
+	//StartPolicy is one of: earliest, latest, error. Enables committing file
+	//consumer offsets and determines where consumer starts when there is no
+	//committed offset or it's out of range. By default consumer starts from
+	//InitialOffset and doesn't commit offsets
+	StartPolicy string `yaml:"start_policy"`
+	//ConsumerGroup is the name of the group of consumers, which share the
+	//committed offset. Consumers of different groups commit offsets
+	//independently
+	ConsumerGroup string `yaml:"consumer_group"`
+	//ScanLookBack enables resumable directory scanning. Consumer lists the
+	//topic from the committed scan cursor minus ScanLookBack and picks up the
+	//files created within that window, which appeared late. 0 - disabled
+	ScanLookBack time.Duration `yaml:"scan_look_back"`
+	//ConsumerLease makes the consumers of the topic claim every file before
+	//reading it, so as the pool of consumers processes every file once.
+	//Lease expires if not renewed within ConsumerLease. 0 - disabled
+	ConsumerLease time.Duration `yaml:"consumer_lease"`
+	//ShardDirs are the base directories of the other shards the topics may
+	//have lived in before reassignment to BaseDir. Consumers read the topic
+	//from all of them
+	ShardDirs []string `yaml:"shard_dirs"`
+
+	//ExternalIngest allows to consume files produced by external tools, like
+	//MapReduce or Spark. Compression codec is determined by file extension
+	ExternalIngest bool `yaml:"external_ingest"`
 
 	EndOfStreamMark bool
 
+	//FileHeader writes JSON line with file metadata in the beginning of every
+	//file
+	FileHeader bool `yaml:"file_header"`
+	//FileGeneration stamps monotonic generation number of the file into the
+	//header. Consumers order files by generation instead of file names,
+	//which include timestamps. Implies FileHeader
+	FileGeneration bool `yaml:"file_generation"`
+	//BinaryHeader writes self-framing binary header, starting with the magic
+	//and version, instead of JSON line. Implies FileHeader
+	BinaryHeader bool `yaml:"binary_header"`
+
+	//SyncOnRotate fsyncs parent directory after finalized file is renamed, so
+	//as the rename survives the crash. Local file pipe only
+	SyncOnRotate bool `yaml:"sync_on_rotate"`
+
+	//AvroOCF writes Avro Object Container Files. Records are expected to be
+	//binary Avro datums of the schema pushed by PushSchema
+	AvroOCF bool `yaml:"avro_ocf"`
+
+	//CircuitBreakerThreshold is the number of consecutive producer failures
+	//after which writes fail fast with ErrCircuitOpen for the duration of
+	//CircuitBreakerCooldown. 0 - disabled
+	CircuitBreakerThreshold int           `yaml:"circuit_breaker_threshold"`
+	CircuitBreakerCooldown  time.Duration `yaml:"circuit_breaker_cooldown"`
+
+	//PartitionManifest maintains per partition manifest with running record
+	//count, updated on every file finalize
+	PartitionManifest bool `yaml:"partition_manifest"`
+
+	//PartitionWriterTTL is the time after which the producer, which crashed
+	//without unregistering from the writers of the partition, stops
+	//blocking the partition completion. Live producers renew the
+	//registration every quarter of the TTL. Default is 1h
+	PartitionWriterTTL time.Duration `yaml:"partition_writer_ttl"`
+
+	//PartitionChecksums maintains checksum manifest of the topic directory
+	//with the content hashes of the finalized files, for external verification
+	PartitionChecksums bool `yaml:"partition_checksums"`
+
+	SchemaValidation SchemaValidationConfig `yaml:"schema_validation"`
+
 	Encryption EncryptionConfig
 
 	S3     S3Config
 	Hadoop HadoopConfig
 	Kafka  KafkaConfig
+	HTTP   HTTPConfig `yaml:"http"`
 
 	SQL SQLConfig `yaml:"sql"`
 }
 
+//SchemaValidationConfig holds JSON Schema validation configuration of the
+//produced records
+type SchemaValidationConfig struct {
+	//Schemas maps topic to the source of its schema: inline schema, path of
+	//the schema file or http(s) URL
+	Schemas map[string]string
+	//Policy is one of: reject, dead_letter, pass. Default is reject
+	Policy          string
+	DeadLetterTopic string `yaml:"dead_letter_topic"`
+}
+
 // KafkaConfig holds Kafka pipe configuration
 type KafkaConfig struct {
 	Addresses       []string
@@ -121,6 +296,11 @@ type S3Config struct {
 	AccessKeyID     string `yaml:"access_key_id"`
 	SecretAccessKey string `yaml:"secret_access_key"`
 	SessionToken    string `yaml:"session_token"`
+	//CredentialProvider is one of: static, env, instance_role or the name of
+	//the provider registered by pipe.RegisterCredentialProvider.
+	//By default static credentials are used if AccessKeyID is set, otherwise
+	//default AWS SDK credentials chain
+	CredentialProvider string `yaml:"credential_provider"`
 
 	Timeout time.Duration
 }
@@ -130,6 +310,25 @@ type HadoopConfig struct {
 	User      string
 	Addresses []string
 	BaseDir   string `yaml:"base_dir"`
+	//MaxConcurrentNamenodeOps limits number of concurrent metadata operations
+	//of all producers and consumers of the pipe. 0 - unlimited
+	MaxConcurrentNamenodeOps int `yaml:"max_concurrent_namenode_ops"`
+	//NoRetry disables retries of the namenode failovers, operations fail
+	//on the first error
+	NoRetry bool `yaml:"no_retry"`
+	//UseTrash makes Remove move files to the user's trash directory instead
+	//of deleting them permanently
+	UseTrash bool `yaml:"use_trash"`
+	//KeepAliveInterval is the interval of pinging the namenode, which keeps
+	//idle connection from being dropped. Connection is reestablished if the
+	//ping fails. 0 - disabled
+	KeepAliveInterval time.Duration `yaml:"keep_alive_interval"`
+}
+
+// HTTPConfig holds read-only HTTP pipe configuration
+type HTTPConfig struct {
+	BaseURL string `yaml:"base_url"`
+	Timeout time.Duration
 }
 
 // SQLConfig holds SQL output pipe configuration
@@ -210,6 +409,7 @@ func getDefaultConfig() *AppConfigODS {
 		LogLevel: "info",
 
 		InternalEncoding: "json",
+		NullEncoding:     "null",
 
 		TableParams: TableParams{
 			Pipe: PipeConfig{
@@ -425,7 +625,7 @@ func sanitizeForLog(s string) string {
 
 // String sanitizes config for log output
 func (e EncryptionConfig) String() string {
-	return fmt.Sprintf("{Enabled:%v, PublicKey:%v, PrivateKey:%v, SigningKey:%v}", e.Enabled, sanitizeForLog(e.PublicKey), sanitizeForLog(e.PrivateKey), sanitizeForLog(e.SigningKey))
+	return fmt.Sprintf("{Enabled:%v, PublicKey:%v, PrivateKey:%v, SigningKey:%v, Topics:%v, WrappingKey:%v}", e.Enabled, sanitizeForLog(e.PublicKey), sanitizeForLog(e.PrivateKey), sanitizeForLog(e.SigningKey), e.Topics, sanitizeForLog(e.WrappingKey))
 }
 
 //CopyForMerge clear all compound fields in preparation for merge by json.Unmarshal
@@ -433,6 +633,10 @@ func (t *TableParams) CopyForMerge() *TableParams {
 	tp := *t
 	tp.Pipe.Hadoop.Addresses = nil
 	tp.Pipe.Kafka.Addresses = nil
+	tp.Pipe.SchemaValidation.Schemas = nil
+	tp.Pipe.ShardDirs = nil
+	tp.Pipe.EventTimeFields = nil
+	tp.Pipe.TopicCompression = nil
 	tp.RowFilter.Values = nil
 	return &tp
 }
@@ -446,6 +650,18 @@ func (t *TableParams) MergeCompound(r *TableParams) {
 	if len(t.Pipe.Kafka.Addresses) == 0 && len(r.Pipe.Kafka.Addresses) != 0 {
 		t.Pipe.Kafka.Addresses = r.Pipe.Kafka.Addresses
 	}
+	if len(t.Pipe.SchemaValidation.Schemas) == 0 && len(r.Pipe.SchemaValidation.Schemas) != 0 {
+		t.Pipe.SchemaValidation.Schemas = r.Pipe.SchemaValidation.Schemas
+	}
+	if len(t.Pipe.ShardDirs) == 0 && len(r.Pipe.ShardDirs) != 0 {
+		t.Pipe.ShardDirs = r.Pipe.ShardDirs
+	}
+	if len(t.Pipe.EventTimeFields) == 0 && len(r.Pipe.EventTimeFields) != 0 {
+		t.Pipe.EventTimeFields = r.Pipe.EventTimeFields
+	}
+	if len(t.Pipe.TopicCompression) == 0 && len(r.Pipe.TopicCompression) != 0 {
+		t.Pipe.TopicCompression = r.Pipe.TopicCompression
+	}
 	if len(t.RowFilter.Values) == 0 && len(r.RowFilter.Values) != 0 {
 		t.RowFilter.Values = r.RowFilter.Values
 	}
@@ -59,6 +59,18 @@ func TestBasic(t *testing.T) {
 	if def.Pipe.Hadoop.Addresses == nil {
 		def.Pipe.Hadoop.Addresses = make([]string, 0)
 	}
+	if def.Pipe.SchemaValidation.Schemas == nil {
+		def.Pipe.SchemaValidation.Schemas = make(map[string]string)
+	}
+	if def.Pipe.ShardDirs == nil {
+		def.Pipe.ShardDirs = make([]string, 0)
+	}
+	if def.Pipe.EventTimeFields == nil {
+		def.Pipe.EventTimeFields = make(map[string]string)
+	}
+	if def.Pipe.TopicCompression == nil {
+		def.Pipe.TopicCompression = make(map[string]string)
+	}
 	if def.OutputTopicNameTemplate == nil {
 		def.OutputTopicNameTemplate = make(map[string]map[string]string)
 	}
@@ -73,6 +73,16 @@ func (e *avroEncoder) EncodeSchema(seqno uint64) ([]byte, error) {
 	return nil, nil
 }
 
+//AvroOutputSchema returns the Avro schema the records of the Avro encoder are
+//encoded with. Returns nil for other encoders
+func AvroOutputSchema(e Encoder) []byte {
+	a, ok := e.(*avroEncoder)
+	if !ok || a.codec == nil {
+		return nil
+	}
+	return []byte(a.codec.Schema())
+}
+
 //Row convert raw binary log event into Avro record
 func (e *avroEncoder) Row(tp int, row *[]interface{}, seqno uint64, _ time.Time) ([]byte, error) {
 	r, err := goavro.NewRecord(*e.setter)
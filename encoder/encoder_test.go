@@ -1121,6 +1121,75 @@ func runBenchmarks() {
 	testing.Main(anything, nil, benchmarks, nil)
 }
 
+func testNullEncodingSchema(enc Encoder, s *types.TableSchema) {
+	switch e := enc.(type) {
+	case *jsonEncoder:
+		e.inSchema = s
+	case *msgPackEncoder:
+		e.inSchema = s
+	}
+}
+
+func TestNullEncoding(t *testing.T) {
+	s := &types.TableSchema{DBName: testDB, TableName: testTable, Columns: []types.ColumnSchema{
+		{Name: "f1", DataType: "bigint", Key: "PRI"},
+		{Name: "f2", DataType: "varchar"},
+		{Name: "f3", DataType: "varchar"},
+	}}
+
+	rows := [][]interface{}{
+		{int64(1), nil, ""},
+		{int64(2), "a", nil},
+		{int64(3), "", "b"},
+		{int64(4), nil, nil},
+	}
+
+	defer func() { config.Get().NullEncoding = NullEncodingNull }()
+
+	for _, ne := range []string{NullEncodingNull, NullEncodingOmit} {
+		config.Get().NullEncoding = ne
+		for _, encType := range []string{"json", "msgpack"} {
+			t.Run(ne+"_"+encType, func(t *testing.T) {
+				enc, err := InitEncoder(encType, testSvc, testDB, testTable, testInput, testOutput, 0)
+				require.NoError(t, err)
+				testNullEncodingSchema(enc, s)
+
+				for i, row := range rows {
+					b, err := enc.Row(types.Insert, &row, uint64(i), time.Time{})
+					require.NoError(t, err)
+
+					if encType == "json" {
+						for j, v := range row {
+							name := fmt.Sprintf(`"Name":"f%v"`, j+1)
+							require.Equal(t, v != nil || ne == NullEncodingNull, strings.Contains(string(b), name))
+						}
+					}
+
+					cf, err := enc.DecodeEvent(b)
+					require.NoError(t, err)
+					require.NotNil(t, cf.Fields)
+					require.Equal(t, len(row), len(*cf.Fields))
+					for j, v := range row {
+						require.Equal(t, s.Columns[j].Name, (*cf.Fields)[j].Name)
+						require.Equal(t, v, (*cf.Fields)[j].Value)
+					}
+				}
+
+				//Fields of delete events are not affected by null encoding
+				b, err := enc.Row(types.Delete, &rows[0], 5, time.Time{})
+				require.NoError(t, err)
+				cf, err := enc.DecodeEvent(b)
+				require.NoError(t, err)
+				require.Nil(t, cf.Fields)
+			})
+		}
+	}
+
+	config.Get().NullEncoding = "invalid"
+	_, err := InitEncoder("json", testSvc, testDB, testTable, testInput, testOutput, 0)
+	require.Error(t, err)
+}
+
 func TestMain(m *testing.M) {
 	cfg = test.LoadConfig()
 
@@ -28,6 +28,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/uber/storagetapper/config"
 	"github.com/uber/storagetapper/log"
 	"github.com/uber/storagetapper/state"
 	"github.com/uber/storagetapper/types"
@@ -48,8 +49,15 @@ type jsonEncoder struct {
 	inSchema  *types.TableSchema
 	filter    []int //Contains indexes of fields which are not in output schema
 	outSchema *types.CommonFormatEvent
+	omitNulls bool //NULL fields are omitted from the output records
 }
 
+//Supported representations of NULL fields, see null_encoding config option
+const (
+	NullEncodingNull = "null"
+	NullEncodingOmit = "omit"
+)
+
 //GenTimeFunc created to be able to return deterministic timestamp in test
 /*TODO: Come up with better day of doing this*/
 type GenTimeFunc func() int64
@@ -66,7 +74,21 @@ var GenTime GenTimeFunc = genTime
 var ZeroTime = time.Time{}.In(time.UTC)
 
 func initJSONEncoder(service, db, table, input string, output string, version int) (Encoder, error) {
-	return &jsonEncoder{Service: service, DB: db, Table: table, Input: input, Output: output, Version: version}, nil
+	omit, err := omitNullsConfig()
+	if err != nil {
+		return nil, err
+	}
+	return &jsonEncoder{Service: service, DB: db, Table: table, Input: input, Output: output, Version: version, omitNulls: omit}, nil
+}
+
+func omitNullsConfig() (bool, error) {
+	switch config.Get().NullEncoding {
+	case "", NullEncodingNull:
+		return false, nil
+	case NullEncodingOmit:
+		return true, nil
+	}
+	return false, fmt.Errorf("unknown null encoding: %v", config.Get().NullEncoding)
 }
 
 //Type returns this encoder type
@@ -106,6 +128,49 @@ func filterCommonFormat(filter []int, cf *types.CommonFormatEvent) *types.Common
 	return c
 }
 
+//omitNullFields returns copy of the event without NULL fields
+func omitNullFields(cf *types.CommonFormatEvent) *types.CommonFormatEvent {
+	if cf.Type == "schema" || cf.Fields == nil {
+		return cf
+	}
+
+	c := *cf
+	f := make([]types.CommonFormatField, 0, len(*cf.Fields))
+	for _, v := range *cf.Fields {
+		if v.Value != nil {
+			f = append(f, v)
+		}
+	}
+	c.Fields = &f
+
+	return &c
+}
+
+//restoreNullFields reinserts fields omitted by the producer as NULLs, so as
+//field positions match the output schema again
+func (e *jsonEncoder) restoreNullFields(cf *types.CommonFormatEvent) {
+	if !e.omitNulls || e.inSchema == nil || cf.Type == "schema" || cf.Fields == nil {
+		return
+	}
+
+	f := make([]types.CommonFormatField, 0, len(e.inSchema.Columns))
+	var j, k int
+	for i := 0; i < len(e.inSchema.Columns); i++ {
+		if filteredField(e.filter, i, &j) {
+			continue
+		}
+		n := e.inSchema.Columns[i].Name
+		if k < len(*cf.Fields) && (*cf.Fields)[k].Name == n {
+			f = append(f, (*cf.Fields)[k])
+			k++
+		} else {
+			f = append(f, types.CommonFormatField{Name: n})
+		}
+	}
+	f = append(f, (*cf.Fields)[k:]...)
+	cf.Fields = &f
+}
+
 //CommonFormat encodes common format event into byte array
 func (e *jsonEncoder) CommonFormat(cf *types.CommonFormatEvent) ([]byte, error) {
 	if cf.Type == "schema" {
@@ -115,6 +180,9 @@ func (e *jsonEncoder) CommonFormat(cf *types.CommonFormatEvent) ([]byte, error)
 		}
 	}
 	cf = filterCommonFormat(e.filter, cf)
+	if e.omitNulls {
+		cf = omitNullFields(cf)
+	}
 	return e.CommonFormatEncode(cf)
 }
 
@@ -206,6 +274,8 @@ func fixFieldType(f interface{}, dt string) (interface{}, error) {
 func (e *jsonEncoder) fixFieldTypes(res *types.CommonFormatEvent) (err error) {
 	k := 0
 
+	e.restoreNullFields(res)
+
 	//Restore field types according to schema
 	if e.inSchema != nil && res.Type != "schema" {
 		var j int
@@ -338,6 +408,10 @@ func (e *jsonEncoder) convertRowToCommonFormat(tp int, row *[]interface{}, schem
 		panic("unknown event type")
 	}
 
+	if e.omitNulls {
+		return omitNullFields(&c)
+	}
+
 	return &c
 }
 
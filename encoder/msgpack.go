@@ -39,7 +39,11 @@ type msgPackEncoder struct {
 }
 
 func initMsgPackEncoder(service, db, table, input string, output string, version int) (Encoder, error) {
-	return &msgPackEncoder{jsonEncoder{Service: service, DB: db, Table: table, Input: input, Output: output, Version: version}}, nil
+	omit, err := omitNullsConfig()
+	if err != nil {
+		return nil, err
+	}
+	return &msgPackEncoder{jsonEncoder{Service: service, DB: db, Table: table, Input: input, Output: output, Version: version, omitNulls: omit}}, nil
 }
 
 //Row encodes row into CommonFormat
@@ -62,6 +66,9 @@ func (e *msgPackEncoder) CommonFormat(cf *types.CommonFormatEvent) ([]byte, erro
 		}
 	}
 	cf = filterCommonFormat(e.filter, cf)
+	if e.omitNulls {
+		cf = omitNullFields(cf)
+	}
 	return cf.MarshalMsg(nil)
 }
 
@@ -101,6 +108,7 @@ func (e *msgPackEncoder) fixFieldType(dtype string, f interface{}) interface{} {
 
 func (e *msgPackEncoder) fixFieldTypes(cf *types.CommonFormatEvent) (err error) {
 	k := 0
+	e.restoreNullFields(cf)
 	//Restore field types according to schema
 	//MsgPack doesn't preserve int type size, so fix it
 	if e.inSchema != nil && cf.Type != "schema" {
@@ -14,7 +14,9 @@ require (
 	github.com/go-sql-driver/mysql v1.6.0
 	github.com/gofrs/uuid v4.1.0+incompatible
 	github.com/golang/protobuf v1.5.2 // indirect
+	github.com/golang/snappy v0.0.4
 	github.com/google/uuid v1.3.0 // indirect
+	github.com/klauspost/compress v1.13.6
 	github.com/lib/pq v1.10.4
 	github.com/linkedin/goavro v1.0.5
 	github.com/mailru/go-clickhouse v1.7.0
@@ -28,6 +30,7 @@ require (
 	github.com/twmb/murmur3 v1.1.6 // indirect
 	github.com/uber-common/bark v1.3.0
 	github.com/uber-go/tally v3.4.2+incompatible
+	github.com/xeipuuv/gojsonschema v1.2.0
 	go.uber.org/atomic v1.9.0 // indirect
 	go.uber.org/multierr v1.7.0 // indirect
 	go.uber.org/zap v1.19.1
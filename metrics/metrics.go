@@ -137,6 +137,22 @@ type FilePipeMetrics struct {
 	FilesCreated *Counter
 	FilesOpened  *Counter
 	FilesClosed  *Counter // == FilesCreated
+
+	FilesVerified   *Counter
+	RecordsVerified *Counter
+	VerifyErrors    *Counter
+
+	CircuitBreakerState *Counter //0 - closed, 1 - open, 2 - half open
+
+	FilesFinalized   *Counter
+	FinalizeErrors   *Counter
+	FinalizeDuration *Timer
+
+	SchemaViolations *Counter
+
+	ClockAnomalies *Counter //files appeared after the file with later name has been consumed
+
+	EventLatency *Timer //from the event time of the record to the produce
 }
 
 //getEventsMetrics returns the Events metrics object for a given process (ChangelogReader, Snapshot or Streamer)
@@ -239,6 +255,22 @@ func NewFilePipeMetrics(prefix string, tags map[string]string) *FilePipeMetrics
 		BytesRead:    CounterInit(s, prefix+"_bytes_read"),
 		FilesOpened:  CounterInit(s, prefix+"_files_opened"),
 		FilesClosed:  CounterInit(s, prefix+"_files_closed"),
+
+		FilesVerified:   CounterInit(s, prefix+"_files_verified"),
+		RecordsVerified: CounterInit(s, prefix+"_records_verified"),
+		VerifyErrors:    CounterInit(s, prefix+"_verify_errors"),
+
+		CircuitBreakerState: CounterInit(s, prefix+"_circuit_breaker_state"),
+
+		FilesFinalized:   CounterInit(s, prefix+"_files_finalized"),
+		FinalizeErrors:   CounterInit(s, prefix+"_finalize_errors"),
+		FinalizeDuration: TimerInit(s, prefix+"_finalize_duration"),
+
+		SchemaViolations: CounterInit(s, prefix+"_schema_violations"),
+
+		ClockAnomalies: CounterInit(s, prefix+"_clock_anomalies"),
+
+		EventLatency: TimerInit(s, prefix+"_event_latency"),
 	}
 }
 
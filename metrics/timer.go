@@ -20,11 +20,15 @@
 
 package metrics
 
-import "time"
+import (
+	"sync/atomic"
+	"time"
+)
 
 //Timer is a wrapper around metrics.Timer
 type Timer struct {
 	backend timer
+	last    int64
 }
 
 //TimerInit is a constructor for Timer
@@ -47,5 +51,11 @@ func (t *Timer) Stop() {
 
 //Record sets the value of the timer to a specific value
 func (t *Timer) Record(v time.Duration) {
+	atomic.StoreInt64(&t.last, int64(v))
 	t.backend.Record(v)
 }
+
+//Last returns the last value set by Record
+func (t *Timer) Last() time.Duration {
+	return time.Duration(atomic.LoadInt64(&t.last))
+}
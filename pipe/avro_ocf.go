@@ -0,0 +1,161 @@
+package pipe
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+
+	"github.com/linkedin/goavro"
+)
+
+const (
+	ocfMagic      = "Obj\x01"
+	ocfSyncLength = 16
+)
+
+//ocfBlockSize is the size of the block of the container file, after which
+//the block is written out before the file rotation, so as the memory held
+//by the block is bounded
+var ocfBlockSize = 4 * 1024 * 1024
+
+var ocfMetaCodec, ocfLongCodec goavro.Codec
+
+func init() {
+	var err error
+	if ocfMetaCodec, err = goavro.NewCodec(`{"type":"map","values":"bytes"}`); err != nil {
+		panic(err)
+	}
+	if ocfLongCodec, err = goavro.NewCodec(`"long"`); err != nil {
+		panic(err)
+	}
+}
+
+//ocfWriter frames binary Avro datums into Avro Object Container File.
+//Every Write is one datum. Datums are accumulated in the block, which is
+//written out, followed by the sync marker, on Close, when the file is
+//rotated, or when the block reaches ocfBlockSize
+type ocfWriter struct {
+	w     flushWriteCloser
+	sync  []byte
+	block bytes.Buffer
+	count int64
+}
+
+//newOCFWriter writes container file header with the given schema
+func newOCFWriter(schema []byte, w flushWriteCloser) (*ocfWriter, error) {
+	o := &ocfWriter{w: w, sync: make([]byte, ocfSyncLength)}
+	if _, err := rand.Read(o.sync); err != nil {
+		return nil, err
+	}
+
+	var h bytes.Buffer
+	h.WriteString(ocfMagic)
+	meta := map[string]interface{}{"avro.schema": schema, "avro.codec": []byte("null")}
+	if err := ocfMetaCodec.Encode(&h, meta); err != nil {
+		return nil, err
+	}
+	h.Write(o.sync)
+
+	if _, err := w.Write(h.Bytes()); err != nil {
+		return nil, err
+	}
+
+	return o, nil
+}
+
+func (o *ocfWriter) Write(b []byte) (int, error) {
+	o.count++
+	n, err := o.block.Write(b)
+	if err == nil && o.block.Len() >= ocfBlockSize {
+		err = o.writeBlock()
+	}
+	return n, err
+}
+
+func (o *ocfWriter) writeBlock() error {
+	if o.count == 0 {
+		return nil
+	}
+
+	var h bytes.Buffer
+	if err := ocfLongCodec.Encode(&h, o.count); err != nil {
+		return err
+	}
+	if err := ocfLongCodec.Encode(&h, int64(o.block.Len())); err != nil {
+		return err
+	}
+
+	for _, b := range [][]byte{h.Bytes(), o.block.Bytes(), o.sync} {
+		if _, err := o.w.Write(b); err != nil {
+			return err
+		}
+	}
+
+	o.block.Reset()
+	o.count = 0
+
+	return nil
+}
+
+//Flush flushes the blocks written so far. Current block is kept open, so as
+//the records pushed one by one are not written in the blocks of one record
+func (o *ocfWriter) Flush() error {
+	return o.w.Flush()
+}
+
+//Close writes out last block and closes underlying writer
+func (o *ocfWriter) Close() error {
+	err := o.writeBlock()
+	if err1 := o.w.Close(); err == nil {
+		err = err1
+	}
+	return err
+}
+
+//checkOCFConfig checks that container file can be started
+func (p *fileProducer) checkOCFConfig() error {
+	if !p.cfg.AvroOCF {
+		return nil
+	}
+	if hasHeader(&p.cfg) {
+		return fmt.Errorf("file header is not supported in avro container files")
+	}
+	if len(p.avroSchema) == 0 {
+		return fmt.Errorf("avro schema should be pushed before records when writing avro container files")
+	}
+	return nil
+}
+
+//ocfWriter wraps file writer into Avro container writer, if enabled by config
+func (p *fileProducer) ocfWriter(offset int64, w flushWriteCloser) (flushWriteCloser, error) {
+	if !p.cfg.AvroOCF {
+		return w, nil
+	}
+	if offset != 0 {
+		return nil, fmt.Errorf("continue writing to existing avro container file is not supported")
+	}
+	return newOCFWriter(p.avroSchema, w)
+}
+
+//setAvroSchema sets the schema of the container files. Schema change
+//finalizes currently open files, so as records of the new schema go to new
+//files
+func (p *fileProducer) setAvroSchema(schema []byte) error {
+	if bytes.Equal(schema, p.avroSchema) {
+		return nil
+	}
+
+	if _, err := goavro.NewCodec(string(schema)); err != nil {
+		return fmt.Errorf("invalid avro schema: %v", err)
+	}
+
+	for p.ffirst != nil {
+		if err := p.closeFile(p.ffirst, true); err != nil {
+			return err
+		}
+	}
+
+	p.avroSchema = schema
+
+	return nil
+}
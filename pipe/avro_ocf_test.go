@@ -0,0 +1,182 @@
+package pipe
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/linkedin/goavro"
+	"github.com/stretchr/testify/require"
+)
+
+const testOCFSchema1 = `{"type":"record","name":"ocf_test","fields":[{"name":"id","type":"long"},{"name":"name","type":["null","string"]}]}`
+const testOCFSchema2 = `{"type":"record","name":"ocf_test","fields":[{"name":"id","type":"long"},{"name":"name","type":["null","string"]},{"name":"score","type":"double"}]}`
+
+func encodeTestOCFRecord(t *testing.T, schema string, fields map[string]interface{}) []byte {
+	c, err := goavro.NewCodec(schema)
+	require.NoError(t, err)
+	r, err := goavro.NewRecord(goavro.RecordSchema(schema))
+	require.NoError(t, err)
+	for k, v := range fields {
+		require.NoError(t, r.Set(k, v))
+	}
+	var b bytes.Buffer
+	require.NoError(t, c.Encode(&b, r))
+	return b.Bytes()
+}
+
+func readTestOCFFile(t *testing.T, name string) (string, []map[string]interface{}) {
+	f, err := os.Open(name)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, f.Close()) }()
+
+	r, err := goavro.NewReader(goavro.FromReader(f))
+	require.NoError(t, err)
+	defer func() { require.NoError(t, r.Close()) }()
+
+	res := make([]map[string]interface{}, 0)
+	for r.Scan() {
+		d, err := r.Read()
+		require.NoError(t, err)
+		rec := d.(*goavro.Record)
+		m := make(map[string]interface{})
+		for _, f := range rec.Fields {
+			m[f.Name] = f.Datum
+		}
+		res = append(res, m)
+	}
+
+	return r.DataSchema, res
+}
+
+func TestAvroOCF(t *testing.T) {
+	deleteTestTopics(t)
+
+	topic := "avro-ocf-test-topic/"
+
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	fp.cfg.AvroOCF = true
+
+	p, err := fp.NewProducer(topic)
+	require.NoError(t, err)
+
+	require.Error(t, p.PushBatch("key1", encodeTestOCFRecord(t, testOCFSchema1, map[string]interface{}{"id": int64(0), "name": nil})))
+
+	require.NoError(t, p.PushSchema("", []byte(testOCFSchema1)))
+	for i := int64(1); i <= 3; i++ {
+		require.NoError(t, p.PushBatch("key1", encodeTestOCFRecord(t, testOCFSchema1, map[string]interface{}{"id": i, "name": "name"})))
+	}
+	require.NoError(t, p.PushBatchCommit())
+	require.NoError(t, p.PushBatch("key1", encodeTestOCFRecord(t, testOCFSchema1, map[string]interface{}{"id": int64(4), "name": nil})))
+
+	//Same schema doesn't rotate the file
+	require.NoError(t, p.PushSchema("", []byte(testOCFSchema1)))
+
+	require.NoError(t, p.PushSchema("", []byte(testOCFSchema2)))
+	require.NoError(t, p.Push(encodeTestOCFRecord(t, testOCFSchema2, map[string]interface{}{"id": int64(5), "name": "five", "score": 5.5})))
+	require.NoError(t, p.Close())
+
+	files, err := ioutil.ReadDir(baseDir + "/" + topic)
+	require.NoError(t, err)
+	names := make([]string, 0)
+	for _, f := range files {
+		if !isMetaFile(f.Name()) {
+			names = append(names, baseDir+"/"+topic+f.Name())
+		}
+	}
+	sort.Strings(names)
+	require.Equal(t, 2, len(names))
+
+	schema, recs := readTestOCFFile(t, names[0])
+	require.Contains(t, schema, `"name":"ocf_test"`)
+	require.NotContains(t, schema, "score")
+	require.Equal(t, 4, len(recs))
+	for i, r := range recs {
+		require.Equal(t, int64(i+1), r["id"])
+	}
+	require.Equal(t, "name", recs[0]["name"])
+	require.Nil(t, recs[3]["name"])
+
+	schema, recs = readTestOCFFile(t, names[1])
+	require.Contains(t, schema, "score")
+	require.Equal(t, 1, len(recs))
+	require.Equal(t, int64(5), recs[0]["id"])
+	require.Equal(t, "five", recs[0]["name"])
+	require.Equal(t, 5.5, recs[0]["score"])
+}
+
+func TestAvroOCFInvalidSchema(t *testing.T) {
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	fp.cfg.AvroOCF = true
+
+	p, err := fp.NewProducer("avro-ocf-invalid-schema")
+	require.NoError(t, err)
+	require.Error(t, p.PushSchema("", []byte(`{"type":"unknown"}`)))
+	require.NoError(t, p.Close())
+}
+
+//ocfBlocks returns the number of the blocks of the container file
+func ocfBlocks(t *testing.T, name string) int {
+	b, err := ioutil.ReadFile(name)
+	require.NoError(t, err)
+	//Header and every block end with the sync marker
+	return bytes.Count(b, b[len(b)-ocfSyncLength:]) - 1
+}
+
+func TestAvroOCFBlocks(t *testing.T) {
+	topic := "avro-ocf-blocks-test/"
+
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	fp.cfg.AvroOCF = true
+
+	produce := func() string {
+		deleteTestTopics(t)
+		p, err := fp.NewProducer(topic)
+		require.NoError(t, err)
+		require.NoError(t, p.PushSchema("", []byte(testOCFSchema1)))
+		for i := int64(0); i < 5; i++ {
+			require.NoError(t, p.Push(encodeTestOCFRecord(t, testOCFSchema1, map[string]interface{}{"id": i, "name": "name"})))
+		}
+		require.NoError(t, p.Close())
+
+		files, err := ioutil.ReadDir(baseDir + "/" + topic)
+		require.NoError(t, err)
+		require.Equal(t, 1, len(files))
+		return baseDir + "/" + topic + files[0].Name()
+	}
+
+	//Records pushed one by one are written in one block on rotation
+	name := produce()
+	require.Equal(t, 1, ocfBlocks(t, name))
+	_, recs := readTestOCFFile(t, name)
+	require.Equal(t, 5, len(recs))
+	fi, err := os.Stat(name)
+	require.NoError(t, err)
+
+	saveBlockSize := ocfBlockSize
+	defer func() { ocfBlockSize = saveBlockSize }()
+	ocfBlockSize = 1
+
+	name = produce()
+	require.Equal(t, 5, ocfBlocks(t, name))
+	_, recs = readTestOCFFile(t, name)
+	require.Equal(t, 5, len(recs))
+	ocfBlockSize = saveBlockSize
+
+	//Records of the current block count towards the file size limit, so as
+	//ten records don't fit the size of the file of five records
+	fp.cfg.MaxFileSize = fi.Size()
+	deleteTestTopics(t)
+	p, err := fp.NewProducer(topic)
+	require.NoError(t, err)
+	require.NoError(t, p.PushSchema("", []byte(testOCFSchema1)))
+	for i := int64(0); i < 10; i++ {
+		require.NoError(t, p.Push(encodeTestOCFRecord(t, testOCFSchema1, map[string]interface{}{"id": i, "name": "name"})))
+	}
+	require.NoError(t, p.Close())
+	files, err := ioutil.ReadDir(baseDir + "/" + topic)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(files))
+}
@@ -0,0 +1,103 @@
+package pipe
+
+import (
+	"os"
+	"time"
+
+	"github.com/uber/storagetapper/log"
+)
+
+//BackoffStrategy decides whether and after what delay failed operation should
+//be retried. Attempt is the number of the failed attempt, starting from 1
+type BackoffStrategy interface {
+	NextDelay(attempt int, err error) (time.Duration, bool)
+}
+
+//FixedBackoff retries retriable errors with constant delay
+type FixedBackoff struct {
+	Delay       time.Duration
+	MaxAttempts int
+	Retriable   func(error) bool
+}
+
+//NextDelay implements BackoffStrategy
+func (b *FixedBackoff) NextDelay(attempt int, err error) (time.Duration, bool) {
+	if attempt > b.MaxAttempts || (b.Retriable != nil && !b.Retriable(err)) {
+		return 0, false
+	}
+	return b.Delay, true
+}
+
+//ExponentialBackoff retries retriable errors doubling the delay after every
+//attempt, up to Max
+type ExponentialBackoff struct {
+	Initial     time.Duration
+	Max         time.Duration
+	MaxAttempts int
+	Retriable   func(error) bool
+}
+
+//NextDelay implements BackoffStrategy
+func (b *ExponentialBackoff) NextDelay(attempt int, err error) (time.Duration, bool) {
+	if attempt > b.MaxAttempts || (b.Retriable != nil && !b.Retriable(err)) {
+		return 0, false
+	}
+	d := b.Initial
+	for i := 1; i < attempt && d < b.Max; i++ {
+		d *= 2
+	}
+	if d > b.Max {
+		d = b.Max
+	}
+	return d, true
+}
+
+type noRetry struct{}
+
+//NoRetry makes operations attempt exactly once and return the first error
+var NoRetry BackoffStrategy = noRetry{}
+
+//NextDelay implements BackoffStrategy
+func (noRetry) NextDelay(int, error) (time.Duration, bool) {
+	return 0, false
+}
+
+//withRetry calls fn until it succeeds or backoff strategy stops the retries
+func withRetry(b BackoffStrategy, fn func() error) error {
+	err := fn()
+	if b == NoRetry {
+		return err
+	}
+	for attempt := 1; err != nil; attempt++ {
+		d, ok := b.NextDelay(attempt, err)
+		if !ok {
+			break
+		}
+		time.Sleep(d)
+		err = fn()
+	}
+	return err
+}
+
+//retryRename retries rename like withRetry. Failed rename can be applied,
+//while its response is lost, then the retry fails because the source is
+//already gone. Such failure is treated as success if the target exists
+func retryRename(b BackoffStrategy, rename func() error, exists func() (bool, error)) error {
+	var unknown bool //previous attempt might have been applied
+	return withRetry(b, func() error {
+		err := rename()
+		if !os.IsNotExist(err) {
+			unknown = err != nil
+			return err
+		}
+		if !unknown {
+			return err
+		}
+		ok, serr := exists()
+		if serr != nil || !ok {
+			return err
+		}
+		log.Warnf("rename retry didn't find the source, but the target exists, assuming the previous attempt succeeded: %v", err)
+		return nil
+	})
+}
@@ -0,0 +1,179 @@
+package pipe
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+//testBackoff returns predefined delays and stops when they are exhausted
+type testBackoff struct {
+	delays   []time.Duration
+	attempts []int
+	errs     []error
+}
+
+func (b *testBackoff) NextDelay(attempt int, err error) (time.Duration, bool) {
+	b.attempts = append(b.attempts, attempt)
+	b.errs = append(b.errs, err)
+	if attempt > len(b.delays) {
+		return 0, false
+	}
+	return b.delays[attempt-1], true
+}
+
+func TestBackoffCustomStrategy(t *testing.T) {
+	b := &testBackoff{delays: []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 30 * time.Millisecond}}
+
+	var calls []time.Time
+	start := time.Now()
+	err := withRetry(b, func() error {
+		calls = append(calls, time.Now())
+		return fmt.Errorf("error %v", len(calls))
+	})
+	require.Error(t, err)
+	require.Equal(t, "error 4", err.Error())
+
+	require.Equal(t, 4, len(calls))
+	require.Equal(t, []int{1, 2, 3, 4}, b.attempts)
+	require.Equal(t, "error 1", b.errs[0].Error())
+	require.Equal(t, "error 4", b.errs[3].Error())
+
+	prev := start
+	for i, d := range b.delays {
+		require.True(t, calls[i+1].Sub(calls[i]) >= d, "delay %v is not honored", d)
+		prev = calls[i+1]
+	}
+	require.True(t, prev.Sub(start) >= 60*time.Millisecond)
+
+	//Succeeds after second attempt
+	b = &testBackoff{delays: []time.Duration{time.Millisecond, time.Millisecond, time.Millisecond}}
+	n := 0
+	err = withRetry(b, func() error {
+		n++
+		if n < 2 {
+			return fmt.Errorf("error")
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	require.Equal(t, []int{1}, b.attempts)
+}
+
+func TestBackoffStrategies(t *testing.T) {
+	errRetriable := fmt.Errorf("retriable")
+	errFatal := fmt.Errorf("fatal")
+	r := func(err error) bool { return err == errRetriable }
+
+	f := &FixedBackoff{Delay: 100 * time.Millisecond, MaxAttempts: 2, Retriable: r}
+	for i := 1; i <= 2; i++ {
+		d, ok := f.NextDelay(i, errRetriable)
+		require.True(t, ok)
+		require.Equal(t, 100*time.Millisecond, d)
+	}
+	_, ok := f.NextDelay(3, errRetriable)
+	require.False(t, ok)
+	_, ok = f.NextDelay(1, errFatal)
+	require.False(t, ok)
+
+	e := &ExponentialBackoff{Initial: 10 * time.Millisecond, Max: 50 * time.Millisecond, MaxAttempts: 5, Retriable: r}
+	for i, exp := range []time.Duration{10, 20, 40, 50, 50} {
+		d, ok := e.NextDelay(i+1, errRetriable)
+		require.True(t, ok)
+		require.Equal(t, exp*time.Millisecond, d)
+	}
+	_, ok = e.NextDelay(6, errRetriable)
+	require.False(t, ok)
+	_, ok = e.NextDelay(1, errFatal)
+	require.False(t, ok)
+}
+
+func TestNoRetry(t *testing.T) {
+	n := 0
+	err := withRetry(NoRetry, func() error {
+		n++
+		return fmt.Errorf("error %v", n)
+	})
+	require.Error(t, err)
+	require.Equal(t, "error 1", err.Error())
+	require.Equal(t, 1, n)
+
+	//Client of no retry pipe attempts writes once
+	p := &hdfsPipe{filePipe: filePipe{cfg: cfg.Pipe}}
+	p.cfg.Hadoop.NoRetry = true
+	c := p.newClient()
+	require.Equal(t, NoRetry, c.backoff)
+	_, ok := c.backoff.NextDelay(1, err)
+	require.False(t, ok)
+
+	p.cfg.Hadoop.NoRetry = false
+	require.Equal(t, hdfsBackoff, p.newClient().backoff)
+}
+
+//lostAckFS loses the response of the first rename, which has been applied
+type lostAckFS struct {
+	fileFS
+	renames int
+}
+
+func (f *lostAckFS) Rename(oldpath, newpath string) error {
+	b := &FixedBackoff{Delay: time.Millisecond, MaxAttempts: 3}
+	return retryRename(b, func() error {
+		f.renames++
+		err := os.Rename(oldpath, newpath)
+		if f.renames == 1 && err == nil {
+			return fmt.Errorf("read: connection reset by peer")
+		}
+		return err
+	}, func() (bool, error) {
+		_, err := os.Stat(newpath)
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return err == nil, err
+	})
+}
+
+func TestRetryRenameLostAck(t *testing.T) {
+	deleteTestTopics(t)
+
+	topic := "lost-ack-test/"
+
+	lfs := &lostAckFS{}
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	fp.fs = lfs
+	fp.cfg.NonBlocking = true
+
+	msgs := []string{"first", "second"}
+	produceTestMsgs(t, fp, topic, msgs)
+	require.Equal(t, 2, lfs.renames)
+
+	require.Equal(t, msgs, consumeTestMsgs(t, fp, topic))
+}
+
+func TestRetryRename(t *testing.T) {
+	b := &FixedBackoff{Delay: time.Millisecond, MaxAttempts: 3}
+	notExist := &os.PathError{Op: "rename", Path: "a", Err: os.ErrNotExist}
+
+	//Source doesn't exist in the first attempt, it's not a lost response
+	n := 0
+	err := retryRename(b, func() error { n++; return notExist }, func() (bool, error) { return true, nil })
+	require.True(t, os.IsNotExist(err))
+	require.Equal(t, 4, n)
+
+	//Retry doesn't find the source and the target
+	n = 0
+	err = retryRename(b, func() error {
+		n++
+		if n == 1 {
+			return fmt.Errorf("timeout")
+		}
+		return notExist
+	}, func() (bool, error) { return false, nil })
+	require.True(t, os.IsNotExist(err))
+	require.Equal(t, 4, n)
+}
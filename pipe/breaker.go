@@ -0,0 +1,77 @@
+package pipe
+
+import (
+	"errors"
+	"time"
+
+	"github.com/uber/storagetapper/log"
+	"github.com/uber/storagetapper/metrics"
+)
+
+//ErrCircuitOpen is returned by the producer when the circuit breaker is open
+//after consecutive backend failures
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+var defaultCircuitBreakerCooldown = 30 * time.Second
+
+const (
+	circuitClosed int64 = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+//circuitBreaker stops calling the backend after threshold consecutive
+//failures. After the cooldown single call is let through, which closes the
+//breaker on success and opens it again on failure.
+//Zero value is closed breaker
+type circuitBreaker struct {
+	state    int64
+	failures int
+	openedAt time.Time
+}
+
+func (b *circuitBreaker) setState(state int64, m *metrics.FilePipeMetrics) {
+	b.state = state
+	m.CircuitBreakerState.Set(state)
+}
+
+//guard calls fn unless circuit breaker is open
+func (p *fileProducer) guard(fn func() error) error {
+	threshold := p.cfg.CircuitBreakerThreshold
+	if threshold == 0 {
+		return fn()
+	}
+
+	cooldown := p.cfg.CircuitBreakerCooldown
+	if cooldown == 0 {
+		cooldown = defaultCircuitBreakerCooldown
+	}
+
+	b := &p.breaker
+	if b.state == circuitOpen {
+		if timeNow().Sub(b.openedAt) < cooldown {
+			return ErrCircuitOpen
+		}
+		log.Infof("Circuit breaker of %v half open, testing backend", p.topic)
+		b.setState(circuitHalfOpen, p.metrics)
+	}
+
+	err := fn()
+	if err == nil {
+		if b.state != circuitClosed {
+			log.Infof("Circuit breaker of %v closed", p.topic)
+			b.setState(circuitClosed, p.metrics)
+		}
+		b.failures = 0
+		return nil
+	}
+
+	b.failures++
+	if b.state == circuitHalfOpen || b.failures >= threshold {
+		log.Errorf("Circuit breaker of %v open for %v after %v consecutive failures, last error: %v", p.topic, cooldown, b.failures, err)
+		b.openedAt = timeNow()
+		b.setState(circuitOpen, p.metrics)
+	}
+
+	return err
+}
@@ -0,0 +1,72 @@
+package pipe
+
+import (
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+//failingFS fails file creation while broken is set
+type failingFS struct {
+	fileFS
+	broken bool
+	calls  int
+}
+
+func (f *failingFS) OpenWrite(name string) (flushWriteCloser, io.Seeker, error) {
+	f.calls++
+	if f.broken {
+		return nil, nil, fmt.Errorf("backend failure")
+	}
+	return f.fileFS.OpenWrite(name)
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	deleteTestTopics(t)
+
+	now := time.Unix(1000, 0)
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	fs := &failingFS{broken: true}
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	fp.fs = fs
+	fp.cfg.CircuitBreakerThreshold = 3
+	fp.cfg.CircuitBreakerCooldown = 10 * time.Second
+
+	pr, err := fp.NewProducer("breaker-test-topic/")
+	require.NoError(t, err)
+	p := pr.(*fileProducer)
+
+	for i := 0; i < 3; i++ {
+		err := p.Push([]byte("msg"))
+		require.Error(t, err)
+		require.NotEqual(t, ErrCircuitOpen, err)
+	}
+	require.Equal(t, 3, fs.calls)
+	require.Equal(t, circuitOpen, p.metrics.CircuitBreakerState.Get())
+
+	//Fail fast without calling the backend
+	require.Equal(t, ErrCircuitOpen, p.Push([]byte("msg")))
+	require.Equal(t, ErrCircuitOpen, p.PushBatch("key", []byte("msg")))
+	require.Equal(t, 3, fs.calls)
+
+	//Half open, test call fails and opens the breaker again
+	now = now.Add(10 * time.Second)
+	require.NotEqual(t, ErrCircuitOpen, p.Push([]byte("msg")))
+	require.Equal(t, 4, fs.calls)
+	require.Equal(t, circuitOpen, p.metrics.CircuitBreakerState.Get())
+	require.Equal(t, ErrCircuitOpen, p.Push([]byte("msg")))
+
+	//Backend recovered
+	fs.broken = false
+	now = now.Add(10 * time.Second)
+	require.NoError(t, p.Push([]byte("msg")))
+	require.Equal(t, circuitClosed, p.metrics.CircuitBreakerState.Get())
+	require.NoError(t, p.PushBatch("key", []byte("msg")))
+	require.NoError(t, p.PushBatchCommit())
+	require.NoError(t, p.Close())
+}
@@ -0,0 +1,159 @@
+package pipe
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+//checksumsFileName returns the name of the checksum manifest of the topic
+//directory. The manifest lists the data files of the directory and SHA-256
+//of their content in the format of sha256sum, so as it can be verified
+//without storagetapper by running "sha256sum -c" in the directory
+func checksumsFileName(tp string) string {
+	return filepath.Dir(tp) + "/_checksums"
+}
+
+//readChecksums returns the file name to hash map of the checksum manifest
+func readChecksums(f fs, name string) (map[string]string, error) {
+	sums := make(map[string]string)
+
+	r, err := f.OpenRead(name, 0)
+	if os.IsNotExist(err) {
+		return sums, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = r.Close() }()
+
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		l := strings.SplitN(s.Text(), "  ", 2)
+		if len(l) != 2 {
+			return nil, fmt.Errorf("corrupted checksum manifest %v: %v", name, s.Text())
+		}
+		sums[l[1]] = l[0]
+	}
+
+	return sums, s.Err()
+}
+
+func writeChecksums(f fs, name string, sums map[string]string) error {
+	files := make([]string, 0, len(sums))
+	for fn := range sums {
+		files = append(files, fn)
+	}
+	sort.Strings(files)
+
+	var b bytes.Buffer
+	for _, fn := range files {
+		fmt.Fprintf(&b, "%s  %s\n", sums[fn], fn)
+	}
+
+	if err := f.Remove(name + ".open"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	w, _, err := f.OpenWrite(name + ".open")
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(b.Bytes()); err != nil {
+		_ = w.Close()
+		return err
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return f.Rename(name+".open", name)
+}
+
+//updateChecksums adds the finalized file to the checksum manifest of the
+//topic directory. Manifest is shared by the producers of all the processes,
+//so it's updated under the lock
+func (p *fileProducer) updateChecksums(s *stat) error {
+	name := checksumsFileName(p.topicPath(p.topic))
+
+	unlock, err := p.lockMetaFile(p.fs, name)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	sums, err := readChecksums(p.fs, name)
+	if err != nil {
+		return err
+	}
+
+	sums[filepath.Base(s.FileName)] = s.Hash
+
+	return writeChecksums(p.fs, name, sums)
+}
+
+//VerifyPartition checks the files of the topic partition against the
+//checksum manifest written by the producers with PartitionChecksums.
+//Files missing from the topic are reported as well
+func (p *filePipe) VerifyPartition(topic string, partition string) (*VerifyReport, error) {
+	tp := topicPath(p.datadir, topic)
+	dir := filepath.Dir(tp)
+
+	sums, err := readChecksums(p.fs, checksumsFileName(tp))
+	if err != nil {
+		return nil, err
+	}
+
+	//Manifest lists the files of all the partitions and topics of the
+	//directory
+	files := make([]string, 0, len(sums))
+	for fn := range sums {
+		if part, _, err := parseFileName(tp, dir+"/"+fn); err == nil && part == partition {
+			files = append(files, fn)
+		}
+	}
+	sort.Strings(files)
+
+	r := &VerifyReport{Topic: topic, Complete: true}
+	for _, fn := range files {
+		name := dir + "/" + fn
+		hash, err := p.fileChecksum(name)
+		if os.IsNotExist(err) {
+			r.Errors = append(r.Errors, VerifyError{name, -1, "file is missing"})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hash != sums[fn] {
+			r.Errors = append(r.Errors, VerifyError{name, -1, fmt.Sprintf("hash mismatch, expected %v, got %v", sums[fn], hash)})
+		}
+		r.FilesChecked++
+		r.LastFile = name
+	}
+
+	return r, nil
+}
+
+func (p *filePipe) fileChecksum(name string) (string, error) {
+	f, err := p.fs.OpenRead(name, 0)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = f.Close() }()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
@@ -0,0 +1,146 @@
+package pipe
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPartitionChecksums(t *testing.T) {
+	deleteTestTopics(t)
+
+	topic := "checksums-test/"
+
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	fp.cfg.NonBlocking = true
+	fp.cfg.OneRecordPerFile = true
+	fp.cfg.PartitionChecksums = true
+	fp.cfg.Compression = true
+
+	//Finalizes of the concurrent producers update the same manifest
+	var wg sync.WaitGroup
+	errCh := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			p, err := fp.NewProducer(topic)
+			if err != nil {
+				errCh <- err
+				return
+			}
+			p.(*fileProducer).seqno = i * 100
+			for j := 0; j < 5 && err == nil; j++ {
+				err = p.PushK("key", []byte(fmt.Sprintf("producer %v record %v", i, j)))
+			}
+			if err == nil {
+				err = p.Close()
+			}
+			errCh <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errCh)
+	for err := range errCh {
+		require.NoError(t, err)
+	}
+	produceTestMsgs(t, fp, topic, []string{"other partition"})
+
+	tp := topicPath(fp.datadir, topic)
+	require.Equal(t, baseDir+"/"+topic+"_checksums", checksumsFileName(tp))
+	sums, err := readChecksums(fp.fs, checksumsFileName(tp))
+	require.NoError(t, err)
+	require.Equal(t, 21, len(sums))
+
+	//Manifest lists all the files of the directory with their content hash
+	names := oneRecordTestFiles(t, fp, topic)
+	require.Equal(t, 21, len(names))
+	for _, n := range names {
+		hash, ok := sums[filepath.Base(n)]
+		require.True(t, ok, n)
+		got, err := fp.fileChecksum(n)
+		require.NoError(t, err)
+		require.Equal(t, got, hash)
+	}
+
+	_, err = os.Stat(checksumsFileName(tp) + ".lock")
+	require.True(t, os.IsNotExist(err))
+
+	r, err := fp.VerifyPartition(topic, "key")
+	require.NoError(t, err)
+	require.Empty(t, r.Errors)
+	require.Equal(t, int64(20), r.FilesChecked)
+
+	r, err = fp.VerifyPartition(topic, "default")
+	require.NoError(t, err)
+	require.Empty(t, r.Errors)
+	require.Equal(t, int64(1), r.FilesChecked)
+
+	//Tampered and removed files fail verification
+	var tampered, removed string
+	for _, n := range names {
+		if strings.Contains(filepath.Base(n), ".default") {
+			continue
+		}
+		if tampered == "" {
+			tampered = n
+			b, err := ioutil.ReadFile(n)
+			require.NoError(t, err)
+			b[len(b)-1] ^= 0xff
+			require.NoError(t, ioutil.WriteFile(n, b, 0644))
+		} else {
+			removed = n
+			require.NoError(t, os.Remove(n))
+			break
+		}
+	}
+
+	r, err = fp.VerifyPartition(topic, "key")
+	require.NoError(t, err)
+	require.Equal(t, 2, len(r.Errors))
+	require.Equal(t, int64(19), r.FilesChecked)
+	errs := map[string]string{r.Errors[0].File: r.Errors[0].Err, r.Errors[1].File: r.Errors[1].Err}
+	require.Contains(t, errs[tampered], "hash mismatch")
+	require.Equal(t, "file is missing", errs[removed])
+}
+
+//nonLeaseFS hides exclusive create of the wrapped filesystem, like S3
+type nonLeaseFS struct {
+	fs
+}
+
+func TestMetaFileLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "meta-lock-test")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	f := &fileFS{}
+	name := dir + "/_checksums"
+
+	unlock, err := lockFile(f, name)
+	require.NoError(t, err)
+
+	//Lock left by the dead process is taken over after timeout
+	saveTimeout := metaLockTimeout
+	metaLockTimeout = -time.Second
+	defer func() { metaLockTimeout = saveTimeout }()
+
+	unlock1, err := lockFile(f, name)
+	require.NoError(t, err)
+	unlock1()
+	unlock()
+
+	_, err = os.Stat(name + ".lock")
+	require.True(t, os.IsNotExist(err))
+
+	//Storage without exclusive create can't be locked without the state DB
+	_, err = lockFile(&nonLeaseFS{f}, name)
+	require.Error(t, err)
+}
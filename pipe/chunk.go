@@ -0,0 +1,149 @@
+package pipe
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+//fragmentFlag is set in the length prefix of the binary framed record, which
+//is a fragment of the record larger than ChunkSize.
+//Fragment starts with fragment index and total number of fragments, followed
+//by fragment data
+const fragmentFlag uint32 = 1 << 31
+
+const fragmentHeaderLen = 8
+
+//chunked returns true if the message should be split into fragments
+func (p *fileProducer) chunked(msgLen int) bool {
+	return p.cfg.ChunkSize > 0 && msgLen > p.cfg.ChunkSize && p.cfg.FileDelimited && !p.cfg.AvroOCF && atomic.LoadInt64(&p.text) == 0
+}
+
+//writeFragments writes the message as a sequence of framed fragments.
+//All the fragments are written to the same file
+func (p *fileProducer) writeFragments(f *file, b []byte) error {
+	total := (len(b) + p.cfg.ChunkSize - 1) / p.cfg.ChunkSize
+	h := make([]byte, 4+fragmentHeaderLen)
+	for i := 0; i < total; i++ {
+		d := b[i*p.cfg.ChunkSize:]
+		if len(d) > p.cfg.ChunkSize {
+			d = d[:p.cfg.ChunkSize]
+		}
+		binary.LittleEndian.PutUint32(h, uint32(fragmentHeaderLen+len(d))|fragmentFlag)
+		binary.LittleEndian.PutUint32(h[4:], uint32(i))
+		binary.LittleEndian.PutUint32(h[8:], uint32(total))
+		if _, err := f.writer.Write(h); err != nil {
+			return err
+		}
+		if _, err := f.writer.Write(d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//readFrame reads binary framed record or fragment. Returns fragment index
+//and total number of fragments, which are 0 and 1 for not fragmented
+//records
+func readFrame(r io.Reader) ([]byte, uint32, uint32, error) {
+	return readFrameLimit(r, 0)
+}
+
+//readFrameLimit reads the frame failing if it's larger than the limit,
+//0 - unlimited
+func readFrameLimit(r io.Reader, limit int64) ([]byte, uint32, uint32, error) {
+	l := make([]byte, 4)
+	if _, err := io.ReadFull(r, l); err != nil {
+		return nil, 0, 0, err
+	}
+
+	n := binary.LittleEndian.Uint32(l)
+	if limit != 0 && int64(n&^fragmentFlag) > limit {
+		return nil, 0, 0, &RecordTooLargeError{Size: int64(n &^ fragmentFlag), Limit: limit}
+	}
+
+	if n&fragmentFlag == 0 {
+		msg := make([]byte, n)
+		_, err := io.ReadFull(r, msg)
+		return msg, 0, 1, err
+	}
+
+	n &^= fragmentFlag
+	if n < fragmentHeaderLen {
+		return nil, 0, 0, fmt.Errorf("broken fragment, length: %v", n)
+	}
+
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, 0, 0, err
+	}
+
+	idx, total := binary.LittleEndian.Uint32(msg), binary.LittleEndian.Uint32(msg[4:])
+	if idx >= total {
+		return nil, 0, 0, fmt.Errorf("broken fragment, index: %v, total: %v", idx, total)
+	}
+
+	return msg[fragmentHeaderLen:], idx, total, nil
+}
+
+//assembler reassembles fragmented records
+type assembler struct {
+	frags [][]byte
+	total uint32
+	size  int64
+	//limit of the record size, fragments and assembled record are accounted
+	//both, 0 - unlimited
+	limit int64
+}
+
+//add adds the frame and returns the assembled record if the frame completes
+//the record
+func (a *assembler) add(b []byte, idx uint32, total uint32) ([]byte, error) {
+	if total == 1 && len(a.frags) == 0 {
+		return b, nil
+	}
+
+	if idx != uint32(len(a.frags)) || (idx != 0 && total != a.total) {
+		err := fmt.Errorf("unexpected fragment %v of %v, while assembling fragment %v of %v", idx, total, len(a.frags), a.total)
+		a.reset()
+		return nil, err
+	}
+
+	a.frags = append(a.frags, b)
+	a.total = total
+	a.size += int64(len(b))
+
+	if a.limit != 0 && 2*a.size > a.limit {
+		err := &RecordTooLargeError{Size: a.size, Limit: a.limit / 2}
+		a.reset()
+		return nil, err
+	}
+
+	if idx != total-1 {
+		return nil, nil
+	}
+
+	var sz int
+	for _, f := range a.frags {
+		sz += len(f)
+	}
+	msg := make([]byte, 0, sz)
+	for _, f := range a.frags {
+		msg = append(msg, f...)
+	}
+	a.reset()
+
+	return msg, nil
+}
+
+//pending returns true if assembling of the record is in progress
+func (a *assembler) pending() bool {
+	return len(a.frags) != 0
+}
+
+func (a *assembler) reset() {
+	a.frags = nil
+	a.total = 0
+	a.size = 0
+}
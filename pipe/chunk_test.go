@@ -0,0 +1,108 @@
+package pipe
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestChunking(t *testing.T) {
+	deleteTestTopics(t)
+
+	topic := "chunk-test-topic/"
+
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	fp.cfg.FileDelimited = true
+	fp.cfg.NonBlocking = true
+	fp.cfg.ChunkSize = 10
+	fp.cfg.PartitionManifest = true
+
+	big := strings.Repeat("0123456789", 3) + "abcde"
+	msgs := []string{"small", big, "0123456789"}
+	produceTestMsgs(t, fp, topic, msgs)
+
+	files, err := fp.readTopicDir(topicPath(fp.datadir, topic))
+	require.NoError(t, err)
+	var name string
+	for _, f := range files {
+		if !isMetaFile(f.Name()) {
+			require.Equal(t, "", name)
+			name = baseDir + "/" + topic + f.Name()
+		}
+	}
+
+	//Big record is split into 4 fragments on write
+	f, err := os.Open(name)
+	require.NoError(t, err)
+	r := bufio.NewReader(f)
+	frames := make([]string, 0)
+	for {
+		b, idx, total, err := readFrame(r)
+		if err != nil {
+			break
+		}
+		frames = append(frames, string(b))
+		if total > 1 {
+			require.Equal(t, uint32(4), total)
+			require.Equal(t, uint32(len(frames)-2), idx)
+		}
+	}
+	require.NoError(t, f.Close())
+	require.Equal(t, []string{"small", "0123456789", "0123456789", "0123456789", "abcde", "0123456789"}, frames)
+
+	//and reassembled on read
+	require.Equal(t, msgs, consumeTestMsgs(t, fp, topic))
+
+	v, err := fp.VerifyTopic(topic)
+	require.NoError(t, err)
+	require.Empty(t, v.Errors)
+	require.Equal(t, int64(3), v.NumRecs)
+
+	//File truncated in the middle of fragmented record
+	b, err := ioutil.ReadFile(name)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(name, b[:4+5+2*(4+8+10)], 0644))
+
+	saveOffset := InitialOffset
+	InitialOffset = OffsetOldest
+	defer func() { InitialOffset = saveOffset }()
+
+	c, err := fp.NewConsumer(topic)
+	require.NoError(t, err)
+	m, err := c.FetchNext()
+	require.NoError(t, err)
+	require.Equal(t, "small", string(m.([]byte)))
+	_, err = c.FetchNext()
+	require.Error(t, err)
+	require.NoError(t, c.CloseOnFailure())
+}
+
+func TestAssembler(t *testing.T) {
+	var a assembler
+
+	m, err := a.add([]byte("a"), 0, 2)
+	require.NoError(t, err)
+	require.Nil(t, m)
+
+	//Fragment of another record
+	_, err = a.add([]byte("x"), 0, 3)
+	require.Error(t, err)
+	require.False(t, a.pending())
+
+	m, err = a.add([]byte("a"), 0, 2)
+	require.NoError(t, err)
+	require.Nil(t, m)
+	m, err = a.add([]byte("b"), 1, 2)
+	require.NoError(t, err)
+	require.Equal(t, "ab", string(m))
+
+	//Non fragmented record in the middle of fragmented one
+	_, err = a.add([]byte("a"), 0, 2)
+	require.NoError(t, err)
+	_, err = a.add([]byte("r"), 0, 1)
+	require.Error(t, err)
+}
@@ -0,0 +1,62 @@
+package pipe
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+//EncryptionClassifier decides whether the files of the topic are encrypted
+type EncryptionClassifier func(topic string) bool
+
+//SetEncryptionClassifier sets the function deciding which topics are
+//encrypted by the producers created afterwards. Classifier takes precedence
+//over Encryption.Topics
+func (p *filePipe) SetEncryptionClassifier(fn EncryptionClassifier) {
+	p.classifier = fn
+}
+
+//topicsClassified returns true if encryption is decided per topic
+func (p *filePipe) topicsClassified() bool {
+	return p.classifier != nil || p.cfg.Encryption.Topics != ""
+}
+
+//encryptTopic decides whether the producer of the topic encrypts the files
+func (p *filePipe) encryptTopic(topic string) (bool, error) {
+	if !p.cfg.Encryption.Enabled {
+		return false, nil
+	}
+	if p.classifier != nil {
+		return p.classifier(topic), nil
+	}
+	if p.cfg.Encryption.Topics == "" {
+		return true, nil
+	}
+	m, err := regexp.MatchString(p.cfg.Encryption.Topics, topic)
+	if err != nil {
+		return false, fmt.Errorf("invalid encryption topics pattern: %v", err)
+	}
+	return m, nil
+}
+
+//fileEncrypted returns true if the consumer needs to decrypt current file.
+//Files with header are encrypted if the header lists the pgp filter.
+//When encryption is decided per topic, files without header are encrypted
+//if they have .gpg suffix
+func (p *fileConsumer) fileEncrypted() bool {
+	if !p.cfg.Encryption.Enabled {
+		return false
+	}
+	if hasHeader(&p.cfg) {
+		for _, f := range p.header.Filters {
+			if f == "pgp" {
+				return true
+			}
+		}
+		return false
+	}
+	if p.topicsClassified() {
+		return strings.HasSuffix(p.name, ".gpg")
+	}
+	return true
+}
@@ -0,0 +1,75 @@
+package pipe
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testEncryptionClassifier(t *testing.T, header bool) {
+	deleteTestTopics(t)
+
+	pii, public := "pii-test/", "public-test/"
+
+	fp := initTestFilePipe(&cfg.Pipe, true, t)
+	fp.cfg.NonBlocking = true
+	fp.cfg.FileHeader = header
+	fp.cfg.Encryption.Topics = "^pii-"
+
+	msgs := []string{"first record", "second record"}
+	produceTestMsgs(t, fp, pii, msgs)
+	produceTestMsgs(t, fp, public, msgs)
+
+	d, err := fp.DescribeTopic(pii)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(d))
+	require.Equal(t, "pgp", d[0].Cipher)
+	require.True(t, strings.HasSuffix(d[0].Name, ".gpg"))
+	b, err := ioutil.ReadFile(d[0].Name)
+	require.NoError(t, err)
+	require.NotContains(t, string(b), msgs[0])
+
+	d, err = fp.DescribeTopic(public)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(d))
+	require.Equal(t, "", d[0].Cipher)
+	require.False(t, strings.HasSuffix(d[0].Name, ".gpg"))
+	b, err = ioutil.ReadFile(d[0].Name)
+	require.NoError(t, err)
+	require.Contains(t, string(b), msgs[0])
+
+	//Consumer decrypts only encrypted files
+	require.Equal(t, msgs, consumeTestMsgs(t, fp, pii))
+	require.Equal(t, msgs, consumeTestMsgs(t, fp, public))
+}
+
+func TestEncryptionClassifier(t *testing.T) {
+	testEncryptionClassifier(t, false)
+	testEncryptionClassifier(t, true)
+}
+
+func TestEncryptionClassifierFunc(t *testing.T) {
+	fp := initTestFilePipe(&cfg.Pipe, true, t)
+	fp.cfg.Encryption.Topics = "^pii-"
+
+	fp.SetEncryptionClassifier(func(topic string) bool { return topic == "public-test/" })
+	enc, err := fp.encryptTopic("public-test/")
+	require.NoError(t, err)
+	require.True(t, enc)
+	enc, err = fp.encryptTopic("pii-test/")
+	require.NoError(t, err)
+	require.False(t, enc)
+
+	fp.SetEncryptionClassifier(nil)
+	fp.cfg.Encryption.Topics = "("
+	_, err = fp.NewProducer("pii-test/")
+	require.Error(t, err)
+
+	fp.cfg.Encryption.Enabled = false
+	fp.cfg.Encryption.Topics = ""
+	enc, err = fp.encryptTopic("pii-test/")
+	require.NoError(t, err)
+	require.False(t, enc)
+}
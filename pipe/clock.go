@@ -0,0 +1,80 @@
+package pipe
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/uber/storagetapper/log"
+)
+
+//ClockAnomaly is the file, which appeared in the topic after the consumer had
+//consumed the file with the later name. File names start with the creation
+//timestamp, so this happens when the clocks of the producers are out of sync.
+//Consumer doesn't go back, the late file is skipped
+type ClockAnomaly struct {
+	File     string //late file
+	Consumed string //file consumed before the late file appeared
+}
+
+//clockChecker detects clock anomalies by remembering the files of the
+//previous listing of the topic directory. Relies on the full listing
+type clockChecker struct {
+	known map[string]bool //files of the previous listing, nil - none
+}
+
+func newClockChecker(p *filePipe) *clockChecker {
+	if !p.cfg.ClockAnomalyCheck || p.cfg.FileGeneration {
+		return nil
+	}
+	return &clockChecker{}
+}
+
+//reset forgets the listed files, when the consumer starts over
+func (c *clockChecker) reset() {
+	if c == nil {
+		return
+	}
+	c.known = nil
+}
+
+//check returns the anomalies among the files of the topic directory listing,
+//given the current file of the consumer
+func (c *clockChecker) check(tp string, files []os.FileInfo, curFile string) []ClockAnomaly {
+	if c == nil {
+		return nil
+	}
+
+	dir := filepath.Dir(tp)
+	cur := strings.TrimSuffix(curFile, ".open")
+	known := make(map[string]bool)
+	var res []ClockAnomaly
+
+	for _, f := range files {
+		fn := dir + "/" + f.Name()
+		if !strings.HasPrefix(fn, tp) || f.IsDir() || isMetaFile(f.Name()) {
+			continue
+		}
+		//Open file is known under its final name
+		name := strings.TrimSuffix(f.Name(), ".open")
+		known[name] = true
+		if c.known != nil && !c.known[name] && fn < cur {
+			res = append(res, ClockAnomaly{File: f.Name(), Consumed: filepath.Base(curFile)})
+		}
+	}
+
+	c.known = known
+
+	return res
+}
+
+//reportClockAnomalies checks the listing of the topic directory for clock
+//anomalies and reports them to the metrics and optionally to the log
+func (p *fileConsumer) reportClockAnomalies(tp string, files []os.FileInfo, curFile string) {
+	for _, a := range p.clock.check(tp, files, curFile) {
+		p.metrics.ClockAnomalies.Inc(1)
+		if p.cfg.ClockAnomalyLog {
+			log.Warnf("%v: clock anomaly, file %v appeared after %v has been consumed, skipped", p.topic, a.File, a.Consumed)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package pipe
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClockChecker(t *testing.T) {
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	require.Nil(t, newClockChecker(fp))
+
+	fp.cfg.ClockAnomalyCheck = true
+	c := newClockChecker(fp)
+	require.NotNil(t, c)
+
+	tp := "/dir/topic-"
+	list := func(names ...string) []os.FileInfo {
+		res := make([]os.FileInfo, 0)
+		for _, n := range names {
+			res = append(res, &httpFileInfo{name: n})
+		}
+		return res
+	}
+
+	//First listing only remembers the files
+	require.Nil(t, c.check(tp, list("topic-2", "topic-3.open"), "/dir/topic-4"))
+	//Open file has been closed
+	require.Nil(t, c.check(tp, list("topic-2", "topic-3", "topic-5"), "/dir/topic-5"))
+	require.Equal(t, []ClockAnomaly{{File: "topic-1", Consumed: "topic-5"}},
+		c.check(tp, list("other-0", "topic-1", "topic-2", "topic-3", "topic-5", "topic-6"), "/dir/topic-5"))
+	require.Nil(t, c.check(tp, list("topic-1", "topic-2", "topic-3", "topic-5", "topic-6"), "/dir/topic-6"))
+
+	c.reset()
+	require.Nil(t, c.check(tp, list("topic-0", "topic-1"), "/dir/topic-6"))
+}
+
+func TestClockAnomaly(t *testing.T) {
+	deleteTestTopics(t)
+
+	topic := "clock-anomaly-test/"
+
+	saveTimeNow := timeNow
+	defer func() { timeNow = saveTimeNow }()
+	start := time.Now()
+	now := start
+	timeNow = func() time.Time { return now }
+
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	fp.cfg.StartPolicy = StartPolicyEarliest
+	fp.cfg.ClockAnomalyCheck = true
+	fp.cfg.ClockAnomalyLog = true
+
+	produce := func(ts time.Duration, msg string) {
+		now = start.Add(ts * time.Second)
+		produceTestMsgs(t, fp, topic, []string{msg})
+	}
+
+	produce(10, "first")
+
+	c, err := fp.NewConsumer(topic)
+	require.NoError(t, err)
+	m, err := c.FetchNext()
+	require.NoError(t, err)
+	require.Equal(t, "first", string(m.([]byte)))
+
+	//Producer with the clock behind creates the file named before the
+	//consumed one
+	produce(5, "late")
+	produce(20, "second")
+
+	m, err = c.FetchNext()
+	require.NoError(t, err)
+	require.Equal(t, "second", string(m.([]byte)))
+	require.NoError(t, c.Close())
+	require.Equal(t, int64(1), c.(*fileConsumer).metrics.ClockAnomalies.Get())
+}
@@ -0,0 +1,173 @@
+package pipe
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/uber/storagetapper/log"
+)
+
+//concatFS is implemented by filesystems, which can merge files server side,
+//without reading the data through the client, like HDFS concat
+type concatFS interface {
+	//BlockSize returns the block size of the file
+	BlockSize(name string) (int64, error)
+	//Concat appends the sources to the target and removes the sources. All
+	//the files must be in the same directory
+	Concat(target string, sources []string) error
+}
+
+//concatAligned returns true if the files meet concat constraints: files have
+//the same block size and all the files, except the last one, consist of
+//full blocks
+func concatAligned(c concatFS, dir string, files []os.FileInfo) bool {
+	var bs int64
+	for i, f := range files {
+		b, err := c.BlockSize(dir + "/" + f.Name())
+		if err != nil || b <= 0 || (i != 0 && b != bs) {
+			return false
+		}
+		bs = b
+		if i < len(files)-1 && f.Size()%bs != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+//coalesced returns the files of the topic directory listing with the given
+//names, which must be consecutive finalized data files
+func coalesced(tp string, files []os.FileInfo, names []string) ([]os.FileInfo, error) {
+	dir := filepath.Dir(tp)
+
+	data := make([]os.FileInfo, 0, len(files))
+	for _, f := range files {
+		if strings.HasPrefix(dir+"/"+f.Name(), tp) && !f.IsDir() && !isMetaFile(f.Name()) {
+			data = append(data, f)
+		}
+	}
+
+	i := 0
+	for i < len(data) && data[i].Name() != names[0] {
+		i++
+	}
+
+	res := make([]os.FileInfo, 0, len(names))
+	for j, n := range names {
+		if i+j >= len(data) || data[i+j].Name() != n {
+			return nil, fmt.Errorf("%v is not found or files are not consecutive", n)
+		}
+		if strings.HasSuffix(n, ".open") || strings.HasSuffix(n, ".gpg") {
+			return nil, fmt.Errorf("%v can't be coalesced, it's not finalized or encrypted", n)
+		}
+		res = append(res, data[i+j])
+	}
+
+	return res, nil
+}
+
+//CoalesceFiles merges consecutive finalized files of the topic into the
+//first of them, compacting many small files into one. Files are merged by
+//the filesystem concat, if supported and the files are aligned to the block
+//size, otherwise the data is read and rewritten. Files are merged as is, so
+//only topics without file header, encryption, Avro container files,
+//partition manifest and checksums are supported. Merge is not atomic, the
+//sources left after the crash are consumed again. Returns the name of the
+//merged file
+func (p *filePipe) CoalesceFiles(topic string, names []string) (string, error) {
+	if len(names) < 2 {
+		return "", fmt.Errorf("at least two files required to coalesce")
+	}
+	if hasHeader(&p.cfg) || p.cfg.PartitionManifest || p.cfg.Encryption.Enabled {
+		return "", fmt.Errorf("coalescing files with header, encryption or partition manifest is not supported")
+	}
+	//Each container file starts with its own header, and the checksums of
+	//the merged file wouldn't match the manifest
+	if p.cfg.AvroOCF || p.cfg.PartitionChecksums {
+		return "", fmt.Errorf("coalescing Avro container files or files with partition checksums is not supported")
+	}
+
+	tp := topicPath(p.datadir, topic)
+	dir := filepath.Dir(tp)
+
+	files, err := p.readTopicDir(tp)
+	if err != nil {
+		return "", err
+	}
+
+	sel, err := coalesced(tp, files, names)
+	if err != nil {
+		return "", err
+	}
+
+	target := dir + "/" + names[0]
+	if c, ok := p.fs.(concatFS); ok && concatAligned(c, dir, sel) {
+		sources := make([]string, 0, len(names)-1)
+		for _, n := range names[1:] {
+			sources = append(sources, dir+"/"+n)
+		}
+		err := c.Concat(target, sources)
+		if err == nil {
+			log.Debugf("Coalesced %v files into %v by concat", len(names), target)
+			return target, nil
+		}
+		log.Warnf("Concat into %v failed, rewriting the files: %v", target, err)
+	}
+
+	return target, p.rewriteFiles(dir, names)
+}
+
+//rewriteFiles merges the files into the first of them by reading and writing
+//the data through the client
+func (p *filePipe) rewriteFiles(dir string, names []string) error {
+	tmp := dir + "/_" + names[0] + ".coalesce"
+
+	w, _, err := p.fs.OpenWrite(tmp)
+	if err != nil {
+		return err
+	}
+
+	fail := func(err error) error {
+		log.E(p.fs.Cancel(w))
+		_ = w.Close()
+		_ = p.fs.Remove(tmp)
+		return err
+	}
+
+	for _, n := range names {
+		r, err := p.fs.OpenRead(dir+"/"+n, 0)
+		if err != nil {
+			return fail(err)
+		}
+		_, err = io.Copy(w, r)
+		log.E(r.Close())
+		if err != nil {
+			return fail(err)
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		return fail(err)
+	}
+	if err := w.Close(); err != nil {
+		return fail(err)
+	}
+
+	if err := p.fs.Rename(tmp, dir+"/"+names[0]); err != nil {
+		_ = p.fs.Remove(tmp)
+		return err
+	}
+
+	for _, n := range names[1:] {
+		if e := p.fs.Remove(dir + "/" + n); log.E(e) && !os.IsNotExist(e) {
+			err = e
+		}
+	}
+
+	log.Debugf("Coalesced %v files into %v by rewrite", len(names), dir+"/"+names[0])
+
+	return err
+}
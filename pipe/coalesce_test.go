@@ -0,0 +1,124 @@
+package pipe
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+//concatTestFS implements concat by appending the files locally
+type concatTestFS struct {
+	fileFS
+	blockSize int64
+	concats   int
+}
+
+func (f *concatTestFS) BlockSize(name string) (int64, error) {
+	return f.blockSize, nil
+}
+
+func (f *concatTestFS) Concat(target string, sources []string) error {
+	f.concats++
+	w, err := os.OpenFile(target, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	for _, s := range sources {
+		b, err := ioutil.ReadFile(s)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+		if err := os.Remove(s); err != nil {
+			return err
+		}
+	}
+	return w.Close()
+}
+
+func testCoalesceFiles(t *testing.T, blockSize int64, concats int) {
+	deleteTestTopics(t)
+
+	topic := "coalesce-test/"
+
+	saveTimeNow := timeNow
+	defer func() { timeNow = saveTimeNow }()
+	now := time.Unix(time.Now().Unix(), 0)
+	timeNow = func() time.Time { return now }
+
+	cfs := &concatTestFS{blockSize: blockSize}
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	fp.fs = cfs
+	fp.cfg.NonBlocking = true
+	fp.cfg.MaxFileSize = 1 //rotate on every message
+
+	msgs := make([]string, 0)
+	p, err := fp.NewProducer(topic)
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		m := fmt.Sprintf("record %v", i) //file of 12 bytes with the frame length
+		msgs = append(msgs, m)
+		require.NoError(t, p.Push([]byte(m)))
+		now = now.Add(time.Second)
+	}
+	require.NoError(t, p.Close())
+
+	files, err := ioutil.ReadDir(baseDir + "/" + topic)
+	require.NoError(t, err)
+	require.Equal(t, 5, len(files))
+	names := make([]string, 0)
+	for _, f := range files {
+		names = append(names, f.Name())
+	}
+
+	//Not consecutive
+	_, err = fp.CoalesceFiles(topic, []string{names[0], names[2]})
+	require.Error(t, err)
+
+	merged, err := fp.CoalesceFiles(topic, names[1:4])
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(baseDir, topic, names[1]), merged)
+	require.Equal(t, concats, cfs.concats)
+
+	files, err = ioutil.ReadDir(baseDir + "/" + topic)
+	require.NoError(t, err)
+	require.Equal(t, 3, len(files))
+	require.Equal(t, int64(36), files[1].Size())
+
+	require.Equal(t, msgs, consumeToEnd(t, fp, topic))
+}
+
+func TestCoalesceFiles(t *testing.T) {
+	t.Run("aligned", func(t *testing.T) {
+		testCoalesceFiles(t, 12, 1)
+	})
+	t.Run("unaligned", func(t *testing.T) {
+		testCoalesceFiles(t, 8, 0)
+	})
+}
+
+func TestCoalesceFilesUnsupported(t *testing.T) {
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	_, err := fp.CoalesceFiles("coalesce-test/", []string{"a"})
+	require.Error(t, err)
+
+	fp.cfg.FileHeader = true
+	_, err = fp.CoalesceFiles("coalesce-test/", []string{"a", "b"})
+	require.Error(t, err)
+
+	for _, c := range []struct{ ocf, checksums bool }{{true, false}, {false, true}} {
+		fp := initTestFilePipe(&cfg.Pipe, false, t)
+		fp.cfg.AvroOCF = c.ocf
+		fp.cfg.PartitionChecksums = c.checksums
+		_, err = fp.CoalesceFiles("coalesce-test/", []string{"a", "b"})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "not supported")
+	}
+}
@@ -0,0 +1,134 @@
+package pipe
+
+import (
+	"fmt"
+)
+
+//KeyFunc extracts compaction key from the message
+type KeyFunc func(msg interface{}) (string, error)
+
+//compactingConsumer delivers only the latest message per key of the consumed
+//range
+type compactingConsumer struct {
+	baseConsumer
+	c       Consumer
+	key     KeyFunc
+	maxKeys int
+
+	//msgs in the order of the last occurrence of the key, superseded messages
+	//are set to nil
+	msgs  []compactEntry
+	index map[string]int
+	read  bool
+}
+
+type compactEntry struct {
+	key string
+	msg interface{}
+}
+
+//NewCompactingConsumer wraps the consumer, so as only the most recent message
+//per key, determined by key function, is delivered.
+//The range is consumed till the consumer returns nil message, which means
+//the end of the stream, so the wrapped consumer should be non-blocking.
+//Messages are delivered in the order of the last occurrence of their keys.
+//Compacting consumer keeps the latest message of every seen key in memory,
+//so memory usage is bounded by maxKeys multiplied by maximum message size.
+//Error is returned when the range contains more then maxKeys distinct keys.
+func NewCompactingConsumer(c Consumer, key KeyFunc, maxKeys int) Consumer {
+	p := &compactingConsumer{c: c, key: key, maxKeys: maxKeys, index: make(map[string]int)}
+	p.initBaseConsumer(p.fetchNext)
+	return p
+}
+
+func (p *compactingConsumer) add(msg interface{}) error {
+	k, err := p.key(msg)
+	if err != nil {
+		return err
+	}
+
+	i, ok := p.index[k]
+	if ok {
+		p.msgs[i].msg = nil
+	} else if len(p.index) >= p.maxKeys {
+		return fmt.Errorf("number of distinct keys exceeds compaction limit of %v", p.maxKeys)
+	}
+
+	p.index[k] = len(p.msgs)
+	p.msgs = append(p.msgs, compactEntry{k, msg})
+
+	//Drop superseded messages, so as memory is proportional to number of keys
+	if len(p.msgs) > 2*len(p.index) {
+		j := 0
+		for _, e := range p.msgs {
+			if e.msg != nil {
+				p.msgs[j] = e
+				p.index[e.key] = j
+				j++
+			}
+		}
+		p.msgs = p.msgs[:j]
+	}
+
+	return nil
+}
+
+func (p *compactingConsumer) fetchNext() (interface{}, error) {
+	for !p.read {
+		msg, err := p.c.FetchNext()
+		if err != nil {
+			return nil, err
+		}
+		if msg == nil {
+			p.read = true
+			p.index = nil
+			break
+		}
+		if err := p.add(msg); err != nil {
+			return nil, err
+		}
+	}
+
+	for len(p.msgs) != 0 {
+		msg := p.msgs[0].msg
+		p.msgs = p.msgs[1:]
+		if msg != nil {
+			return msg, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (p *compactingConsumer) close(graceful bool) error {
+	p.cancel()
+	var err error
+	if graceful {
+		err = p.c.Close()
+	} else {
+		err = p.c.CloseOnFailure()
+	}
+	p.wg.Wait()
+	return err
+}
+
+//Close closes the consumer and the wrapped consumer
+func (p *compactingConsumer) Close() error {
+	return p.close(true)
+}
+
+//CloseOnFailure closes the consumer and the wrapped consumer without saving
+//offsets
+func (p *compactingConsumer) CloseOnFailure() error {
+	return p.close(false)
+}
+
+//SaveOffset persists the position of the wrapped consumer
+func (p *compactingConsumer) SaveOffset() error {
+	return p.c.SaveOffset()
+}
+
+//SetFormat sets the format of the wrapped consumer
+func (p *compactingConsumer) SetFormat(format string) {
+	p.c.SetFormat(format)
+}
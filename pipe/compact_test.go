@@ -0,0 +1,60 @@
+package pipe
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func compactTestKey(msg interface{}) (string, error) {
+	s := string(msg.([]byte))
+	i := strings.Index(s, ":")
+	if i < 0 {
+		return "", fmt.Errorf("no key in message: %v", s)
+	}
+	return s[:i], nil
+}
+
+func TestCompactingConsumer(t *testing.T) {
+	deleteTestTopics(t)
+
+	topic := "compact-test-topic"
+
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	fp.cfg.NonBlocking = true
+	fp.cfg.MaxFileSize = 1 //compaction spans multiple files
+
+	msgs := []string{"k1:v1", "k2:v1", "k1:v2", "k3:v1", "k2:v2", "k1:v3", "k4:v1", "k4:v2"}
+	produceTestMsgs(t, fp, topic, msgs)
+
+	saveOffset := InitialOffset
+	InitialOffset = OffsetOldest
+	defer func() { InitialOffset = saveOffset }()
+
+	c, err := fp.NewConsumer(topic)
+	require.NoError(t, err)
+	cc := NewCompactingConsumer(c, compactTestKey, 4)
+
+	res := make([]string, 0)
+	for {
+		m, err := cc.FetchNext()
+		require.NoError(t, err)
+		if m == nil {
+			break
+		}
+		res = append(res, string(m.([]byte)))
+	}
+	require.NoError(t, cc.Close())
+
+	require.Equal(t, []string{"k3:v1", "k2:v2", "k1:v3", "k4:v2"}, res)
+
+	//Number of keys exceeds the limit
+	c, err = fp.NewConsumer(topic)
+	require.NoError(t, err)
+	cc = NewCompactingConsumer(c, compactTestKey, 3)
+	_, err = cc.FetchNext()
+	require.Error(t, err)
+	require.NoError(t, cc.Close())
+}
@@ -0,0 +1,158 @@
+package pipe
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/uber/storagetapper/config"
+)
+
+//Supported compression codecs
+const (
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+//Sane limits of the configurable decompressor buffer size
+const (
+	minDecompressBufferSize = 4 * 1024
+	maxDecompressBufferSize = 64 * 1024 * 1024
+)
+
+func compressionType(cfg *config.PipeConfig) string {
+	if cfg.CompressionType == "" {
+		return CompressionGzip
+	}
+	return cfg.CompressionType
+}
+
+func compressionSuffix(cfg *config.PipeConfig) string {
+	if compressionType(cfg) == CompressionZstd {
+		return ".zst"
+	}
+	return ".gz"
+}
+
+//CompressionNone disables compression of the topic in TopicCompression
+const CompressionNone = "none"
+
+//withTopicCompression returns the pipe with compression config of the topic
+//resolved from TopicCompression
+func (p *filePipe) withTopicCompression(topic string) (*filePipe, error) {
+	codec, ok := p.cfg.TopicCompression[topic]
+	if !ok {
+		return p, nil
+	}
+
+	fp := *p
+	switch codec {
+	case CompressionNone:
+		fp.cfg.Compression = false
+	case CompressionGzip, CompressionZstd:
+		fp.cfg.Compression = true
+		fp.cfg.CompressionType = codec
+	default:
+		return nil, fmt.Errorf("unsupported compression type of topic %v: %v", topic, codec)
+	}
+
+	return &fp, nil
+}
+
+//headerCompression configures decompression of the file by the compression
+//filter of its header
+func (p *fileConsumer) headerCompression(h *Header) error {
+	cfg := p.cfg
+	cfg.Compression = false
+	for _, f := range h.Filters {
+		if f == CompressionGzip || f == CompressionZstd {
+			cfg.Compression = true
+			cfg.CompressionType = f
+		}
+	}
+
+	var err error
+	p.rcfg, p.recLimit, err = boundedConfig(cfg)
+	p.frags.limit = p.recLimit
+
+	return err
+}
+
+//validateCompressionConfig checks that compression parameters are in
+//supported ranges
+func validateCompressionConfig(cfg *config.PipeConfig) error {
+	switch compressionType(cfg) {
+	case CompressionGzip, CompressionZstd:
+	default:
+		return fmt.Errorf("unsupported compression type: %v", cfg.CompressionType)
+	}
+
+	for topic, codec := range cfg.TopicCompression {
+		switch codec {
+		case CompressionGzip, CompressionZstd, CompressionNone:
+		default:
+			return fmt.Errorf("unsupported compression type of topic %v: %v", topic, codec)
+		}
+	}
+
+	if cfg.DecompressBufferSize != 0 && (cfg.DecompressBufferSize < minDecompressBufferSize || cfg.DecompressBufferSize > maxDecompressBufferSize) {
+		return fmt.Errorf("decompress buffer size should be in the range [%v, %v], got %v", minDecompressBufferSize, maxDecompressBufferSize, cfg.DecompressBufferSize)
+	}
+
+	w := cfg.ZstdWindowSize
+	if w != 0 && (w < zstd.MinWindowSize || w > zstd.MaxWindowSize || w&(w-1) != 0) {
+		return fmt.Errorf("zstd window size should be power of two in the range [%v, %v], got %v", zstd.MinWindowSize, zstd.MaxWindowSize, w)
+	}
+
+	return nil
+}
+
+func newCompressWriter(cfg *config.PipeConfig, w io.Writer) (flushWriteCloser, error) {
+	if compressionType(cfg) == CompressionZstd {
+		var opts []zstd.EOption
+		if cfg.ZstdWindowSize != 0 {
+			opts = append(opts, zstd.WithWindowSize(cfg.ZstdWindowSize))
+		}
+		return zstd.NewWriter(w, opts...)
+	}
+	return gzip.NewWriter(w), nil
+}
+
+//zstdCloser releases decoder resources along with underlying file
+type zstdCloser struct {
+	io.ReadCloser
+	dec *zstd.Decoder
+}
+
+func (c *zstdCloser) Close() error {
+	c.dec.Close()
+	return c.ReadCloser.Close()
+}
+
+//newDecompressReader returns decompressing reader and the closer, which should
+//be used instead of the file's closer to release decompressor resources
+func newDecompressReader(cfg *config.PipeConfig, r io.Reader, file io.ReadCloser) (io.Reader, io.ReadCloser, error) {
+	if cfg.DecompressBufferSize != 0 {
+		r = bufio.NewReaderSize(r, cfg.DecompressBufferSize)
+	}
+
+	if compressionType(cfg) == CompressionZstd {
+		opts := []zstd.DOption{zstd.WithDecoderConcurrency(1)}
+		if cfg.ZstdWindowSize != 0 {
+			opts = append(opts, zstd.WithDecoderMaxWindow(uint64(cfg.ZstdWindowSize)))
+		}
+		d, err := zstd.NewReader(r, opts...)
+		if err != nil {
+			return nil, nil, err
+		}
+		return d, &zstdCloser{file, d}, nil
+	}
+
+	g, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	return g, file, nil
+}
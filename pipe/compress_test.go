@@ -0,0 +1,131 @@
+package pipe
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/uber/storagetapper/config"
+)
+
+func genCompressTestMsgs(n int) []string {
+	msgs := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		msgs = append(msgs, fmt.Sprintf(`{"seqno":%v,"data":"%v"}`, i, strings.Repeat("d", i%512)))
+	}
+	return msgs
+}
+
+func TestCompressionBufferSizes(t *testing.T) {
+	msgs := genCompressTestMsgs(1000)
+	for _, ct := range []string{CompressionGzip, CompressionZstd} {
+		for _, sz := range []int{0, minDecompressBufferSize, 64 * 1024, 1024 * 1024} {
+			deleteTestTopics(t)
+
+			fp := initTestFilePipe(&cfg.Pipe, false, t)
+			fp.cfg.Compression = true
+			fp.cfg.CompressionType = ct
+			fp.cfg.DecompressBufferSize = sz
+			fp.cfg.ZstdWindowSize = 1 << 16
+			fp.cfg.MaxFileSize = 16 * 1024
+			fp.cfg.NonBlocking = true
+
+			topic := fmt.Sprintf("compression-%v-%v-topic/", ct, sz)
+			produceTestMsgs(t, fp, topic, msgs)
+			require.Equal(t, msgs, consumeTestMsgs(t, fp, topic), "codec %v, buffer size %v", ct, sz)
+		}
+	}
+}
+
+func TestCompressionConfigValidation(t *testing.T) {
+	tests := []struct {
+		cfg config.PipeConfig
+		ok  bool
+	}{
+		{config.PipeConfig{}, true},
+		{config.PipeConfig{CompressionType: CompressionZstd, ZstdWindowSize: 1 << 20, DecompressBufferSize: 1 << 20}, true},
+		{config.PipeConfig{CompressionType: "lzma"}, false},
+		{config.PipeConfig{TopicCompression: map[string]string{"a": CompressionNone, "b": CompressionZstd}}, true},
+		{config.PipeConfig{TopicCompression: map[string]string{"a": "lzma"}}, false},
+		{config.PipeConfig{DecompressBufferSize: 16}, false},
+		{config.PipeConfig{DecompressBufferSize: 1 << 30}, false},
+		{config.PipeConfig{CompressionType: CompressionZstd, ZstdWindowSize: 3000}, false},
+		{config.PipeConfig{CompressionType: CompressionZstd, ZstdWindowSize: 512}, false},
+	}
+
+	for _, v := range tests {
+		_, err := initFilePipe(&v.cfg, nil)
+		require.Equal(t, v.ok, err == nil, "%+v %v", v.cfg, err)
+	}
+}
+
+func TestTopicCompression(t *testing.T) {
+	deleteTestTopics(t)
+
+	msgs := genCompressTestMsgs(100)
+
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	fp.cfg.Compression = true
+	fp.cfg.FileHeader = true
+	fp.cfg.NonBlocking = true
+	fp.cfg.TopicCompression = map[string]string{"topic-compression-text/": CompressionZstd, "topic-compression-blob/": CompressionNone}
+
+	tests := []struct {
+		topic   string
+		suffix  string
+		filters []string
+	}{
+		{"topic-compression-text/", ".zst", []string{CompressionZstd}},
+		{"topic-compression-blob/", ".default", nil},
+		{"topic-compression-default/", ".gz", []string{CompressionGzip}},
+	}
+
+	for _, v := range tests {
+		produceTestMsgs(t, fp, v.topic, msgs)
+
+		names := oneRecordTestFiles(t, fp, v.topic)
+		require.Equal(t, 1, len(names))
+		require.True(t, strings.HasSuffix(names[0], v.suffix), names[0])
+
+		f, err := os.Open(names[0])
+		require.NoError(t, err)
+		h, _, err := readHeader(bufio.NewReader(f))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+		require.Equal(t, v.filters, h.Filters)
+
+		require.Equal(t, msgs, consumeTestMsgs(t, fp, v.topic))
+
+		//Consumer decompresses by the header regardless of the config
+		nfp := initTestFilePipe(&cfg.Pipe, false, t)
+		nfp.cfg.FileHeader = true
+		nfp.cfg.NonBlocking = true
+		require.Equal(t, msgs, consumeTestMsgs(t, nfp, v.topic))
+	}
+}
+
+func BenchmarkDecompressBufferSize(b *testing.B) {
+	msgs := genCompressTestMsgs(20000)
+	for _, ct := range []string{CompressionGzip, CompressionZstd} {
+		fp := &filePipe{datadir: baseDir, cfg: cfg.Pipe, fs: &fileFS{}}
+		fp.cfg.Compression = true
+		fp.cfg.CompressionType = ct
+		fp.cfg.NonBlocking = true
+
+		topic := "decompress-bench-" + ct + "-topic/"
+		require.NoError(b, os.RemoveAll(baseDir+"/"+topic))
+		produceTestMsgs(b, fp, topic, msgs)
+
+		for _, sz := range []int{0, minDecompressBufferSize, 64 * 1024, 1024 * 1024} {
+			fp.cfg.DecompressBufferSize = sz
+			b.Run(fmt.Sprintf("%v-%v", ct, sz), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					require.Equal(b, len(msgs), len(consumeTestMsgs(b, fp, topic)))
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package pipe
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConcurrentFiles(t *testing.T) {
+	deleteTestTopics(t)
+
+	topic := "concurrent-files-test/"
+
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	fp.cfg.NonBlocking = true
+	fp.cfg.ConcurrentFiles = 3
+	fp.cfg.PartitionManifest = true
+
+	msgs := make([]string, 0)
+	for i := 0; i < 30; i++ {
+		msgs = append(msgs, fmt.Sprintf("record %02d", i))
+	}
+
+	p, err := fp.NewProducer(topic)
+	require.NoError(t, err)
+	for i, m := range msgs {
+		if i%2 == 0 {
+			require.NoError(t, p.Push([]byte(m)))
+		} else {
+			require.NoError(t, p.PushBatch("default", []byte(m)))
+		}
+	}
+	require.NoError(t, p.PushBatchCommit())
+	require.NoError(t, p.Close())
+
+	files := oneRecordTestFiles(t, fp, topic)
+	require.Equal(t, 3, len(files))
+	for i, f := range files {
+		require.True(t, strings.HasSuffix(f, fmt.Sprintf("-%d.default", i)), f)
+		//Records are distributed evenly
+		fi, err := os.Stat(f)
+		require.NoError(t, err)
+		require.Equal(t, int64(10*(4+len(msgs[0]))), fi.Size())
+	}
+
+	//Files are consumed one by one, every file keeps the produced order
+	expected := make([]string, 0)
+	for i := 0; i < 3; i++ {
+		for j := i; j < len(msgs); j += 3 {
+			expected = append(expected, msgs[j])
+		}
+	}
+	require.Equal(t, expected, consumeToEnd(t, fp, topic))
+
+	//Concurrent files are the files of the same partition
+	n, err := fp.PartitionRowCount(topic, "default")
+	require.NoError(t, err)
+	require.Equal(t, int64(len(msgs)), n)
+}
+
+func TestConcurrentFilesKeys(t *testing.T) {
+	deleteTestTopics(t)
+
+	topic := "concurrent-files-keys-test/"
+
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	fp.cfg.NonBlocking = true
+	fp.cfg.ConcurrentFiles = 2
+
+	//Key looking like the file index of the other key doesn't share its file
+	p, err := fp.NewProducer(topic)
+	require.NoError(t, err)
+	for _, k := range []string{"a", "a", "a-1", "a-1"} {
+		require.NoError(t, p.PushK(k, []byte(k)))
+	}
+	require.NoError(t, p.Close())
+
+	files, err := fp.readTopicDir(topicPath(fp.datadir, topic))
+	require.NoError(t, err)
+	require.Equal(t, 4, len(files))
+	for _, f := range files {
+		b, err := ioutil.ReadFile(topicPath(fp.datadir, topic) + f.Name())
+		require.NoError(t, err)
+		key := f.Name()[strings.Index(f.Name()[11:], ".")+12:]
+		require.Equal(t, key, string(b[4:]), f.Name())
+	}
+}
+
+func TestConcurrentFilesRotation(t *testing.T) {
+	deleteTestTopics(t)
+
+	topic := "concurrent-files-rotation-test/"
+
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	fp.cfg.NonBlocking = true
+	fp.cfg.ConcurrentFiles = 2
+	fp.cfg.MaxFileDataSize = 18 //rotate after 2 records
+
+	p, err := fp.NewProducer(topic)
+	require.NoError(t, err)
+	for i := 0; i < 8; i++ {
+		require.NoError(t, p.PushK("key", []byte(fmt.Sprintf("record %v", i))))
+	}
+	require.NoError(t, p.Close())
+
+	files, err := fp.readTopicDir(topicPath(fp.datadir, topic))
+	require.NoError(t, err)
+	require.Equal(t, 4, len(files))
+	for _, f := range files {
+		require.Equal(t, int64(2*(4+len("record 0"))), f.Size())
+	}
+}
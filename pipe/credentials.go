@@ -0,0 +1,151 @@
+package pipe
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/ec2rolecreds"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/session"
+
+	"github.com/uber/storagetapper/config"
+)
+
+//Credentials to access object store
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	//Expires is the expiration time of the temporary credentials. Zero value
+	//means credentials never expire
+	Expires time.Time
+}
+
+//CredentialProvider supplies object store credentials. Pipe calls
+//the provider again before the credentials expire
+type CredentialProvider interface {
+	Credentials() (*Credentials, error)
+}
+
+//CredentialProviderConstructor creates credential provider from the pipe
+//config
+type CredentialProviderConstructor func(cfg *config.PipeConfig) (CredentialProvider, error)
+
+//credentialsExpiryWindow is how long before the expiration temporary
+//credentials are refreshed
+var credentialsExpiryWindow = 5 * time.Minute
+
+var credentialProviders = map[string]CredentialProviderConstructor{
+	"static":        newStaticCredentialProvider,
+	"env":           newEnvCredentialProvider,
+	"instance_role": newInstanceRoleCredentialProvider,
+}
+
+var credentialProvidersLock sync.Mutex
+
+//RegisterCredentialProvider registers custom credential provider, which can
+//be referenced by name in the pipe config
+func RegisterCredentialProvider(name string, init CredentialProviderConstructor) {
+	credentialProvidersLock.Lock()
+	defer credentialProvidersLock.Unlock()
+	credentialProviders[strings.ToLower(name)] = init
+}
+
+//newCredentialProvider resolves credential provider configured for the pipe.
+//Returns nil if provider is not configured, so as default credential chain
+//of the SDK is used
+func newCredentialProvider(cfg *config.PipeConfig) (CredentialProvider, error) {
+	name := strings.ToLower(cfg.S3.CredentialProvider)
+	if name == "" {
+		if cfg.S3.AccessKeyID == "" {
+			return nil, nil
+		}
+		name = "static"
+	}
+
+	credentialProvidersLock.Lock()
+	init := credentialProviders[name]
+	credentialProvidersLock.Unlock()
+
+	if init == nil {
+		return nil, fmt.Errorf("unknown credential provider: %v", name)
+	}
+
+	return init(cfg)
+}
+
+type staticCredentialProvider struct {
+	c Credentials
+}
+
+func newStaticCredentialProvider(cfg *config.PipeConfig) (CredentialProvider, error) {
+	return &staticCredentialProvider{Credentials{AccessKeyID: cfg.S3.AccessKeyID, SecretAccessKey: cfg.S3.SecretAccessKey, SessionToken: cfg.S3.SessionToken}}, nil
+}
+
+func (p *staticCredentialProvider) Credentials() (*Credentials, error) {
+	c := p.c
+	return &c, nil
+}
+
+//sdkCredentialProvider adapts AWS SDK provider
+type sdkCredentialProvider struct {
+	p credentials.Provider
+}
+
+func newEnvCredentialProvider(_ *config.PipeConfig) (CredentialProvider, error) {
+	return &sdkCredentialProvider{&credentials.EnvProvider{}}, nil
+}
+
+func newInstanceRoleCredentialProvider(_ *config.PipeConfig) (CredentialProvider, error) {
+	s, err := session.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	return &sdkCredentialProvider{&ec2rolecreds.EC2RoleProvider{Client: ec2metadata.New(s)}}, nil
+}
+
+func (p *sdkCredentialProvider) Credentials() (*Credentials, error) {
+	v, err := p.p.Retrieve()
+	if err != nil {
+		return nil, err
+	}
+	c := &Credentials{AccessKeyID: v.AccessKeyID, SecretAccessKey: v.SecretAccessKey, SessionToken: v.SessionToken}
+	if e, ok := p.p.(credentials.Expirer); ok {
+		c.Expires = e.ExpiresAt()
+	}
+	return c, nil
+}
+
+//awsCredentials makes CredentialProvider usable by AWS SDK, which caches the
+//credentials till IsExpired returns true
+type awsCredentials struct {
+	credentials.Expiry
+	p CredentialProvider
+}
+
+func (a *awsCredentials) Retrieve() (credentials.Value, error) {
+	c, err := a.p.Credentials()
+	if err != nil {
+		return credentials.Value{ProviderName: "storagetapper"}, err
+	}
+	window := credentialsExpiryWindow
+	if c.Expires.IsZero() {
+		window = 0
+	}
+	a.SetExpiration(c.Expires, window)
+	return credentials.Value{AccessKeyID: c.AccessKeyID, SecretAccessKey: c.SecretAccessKey, SessionToken: c.SessionToken, ProviderName: "storagetapper"}, nil
+}
+
+func (a *awsCredentials) IsExpired() bool {
+	if a.ExpiresAt().IsZero() {
+		return false
+	}
+	return a.Expiry.IsExpired()
+}
+
+func newAWSCredentials(p CredentialProvider) *credentials.Credentials {
+	return credentials.NewCredentials(&awsCredentials{Expiry: credentials.Expiry{CurrentTime: func() time.Time { return timeNow() }}, p: p})
+}
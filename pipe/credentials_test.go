@@ -0,0 +1,126 @@
+package pipe
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/uber/storagetapper/config"
+)
+
+type rotatingCredentialProvider struct {
+	calls   int
+	expires time.Duration
+}
+
+func (p *rotatingCredentialProvider) Credentials() (*Credentials, error) {
+	p.calls++
+	return &Credentials{AccessKeyID: fmt.Sprintf("AKID%v", p.calls), SecretAccessKey: "secret", SessionToken: "token", Expires: timeNow().Add(p.expires)}, nil
+}
+
+var akidRe = regexp.MustCompile(`Credential=([^/]+)/`)
+
+//credentialsTestServer is fake object store, which records access keys of
+//the requests
+func credentialsTestServer() (*httptest.Server, func() []string) {
+	var lock sync.Mutex
+	keys := make([]string, 0)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		if m := akidRe.FindStringSubmatch(r.Header.Get("Authorization")); m != nil {
+			keys = append(keys, m[1])
+		}
+		lock.Unlock()
+		if r.Method == http.MethodGet {
+			w.Header().Set("Content-Type", "application/xml")
+			_, _ = w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><ListBucketResult></ListBucketResult>`))
+		}
+	}))
+	return s, func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]string{}, keys...)
+	}
+}
+
+func testCredentialsPipe(t *testing.T, provider string, endpoint string) *s3Pipe {
+	c := cfg.Pipe
+	c.S3 = config.S3Config{Region: "us-east-1", Endpoint: endpoint, Bucket: "test-bucket", BaseDir: "/test", Timeout: 10 * time.Second, CredentialProvider: provider}
+	p, err := initS3Pipe(&c, nil)
+	require.NoError(t, err)
+	return p.(*s3Pipe)
+}
+
+func TestCredentialProviderRefresh(t *testing.T) {
+	s, keys := credentialsTestServer()
+	defer s.Close()
+
+	//Credentials expire within the refresh window, so every request should
+	//get fresh credentials from the provider
+	rp := &rotatingCredentialProvider{expires: credentialsExpiryWindow / 2}
+	RegisterCredentialProvider("test_rotating", func(_ *config.PipeConfig) (CredentialProvider, error) {
+		return rp, nil
+	})
+
+	p := testCredentialsPipe(t, "test_rotating", s.URL)
+	_, err := p.client.ReadDir("/test/topic", "")
+	require.NoError(t, err)
+	_, err = p.client.ReadDir("/test/topic", "")
+	require.NoError(t, err)
+
+	require.Equal(t, []string{"AKID1", "AKID2", "AKID3"}, keys())
+}
+
+func TestCredentialProviderCached(t *testing.T) {
+	s, keys := credentialsTestServer()
+	defer s.Close()
+
+	rp := &rotatingCredentialProvider{expires: time.Hour}
+	RegisterCredentialProvider("test_long_lived", func(_ *config.PipeConfig) (CredentialProvider, error) {
+		return rp, nil
+	})
+
+	p := testCredentialsPipe(t, "test_long_lived", s.URL)
+	_, err := p.client.ReadDir("/test/topic", "")
+	require.NoError(t, err)
+
+	require.Equal(t, []string{"AKID1", "AKID1"}, keys())
+
+	//Move clock past the refresh point
+	saveTimeNow := timeNow
+	defer func() { timeNow = saveTimeNow }()
+	timeNow = func() time.Time { return time.Now().Add(time.Hour - credentialsExpiryWindow/2) }
+
+	_, err = p.client.ReadDir("/test/topic", "")
+	require.NoError(t, err)
+	_, err = p.client.ReadDir("/test/topic", "")
+	require.NoError(t, err)
+
+	require.Equal(t, []string{"AKID1", "AKID1", "AKID2", "AKID2"}, keys())
+}
+
+func TestCredentialProviderStatic(t *testing.T) {
+	s, keys := credentialsTestServer()
+	defer s.Close()
+
+	c := cfg.Pipe
+	c.S3 = config.S3Config{Region: "us-east-1", Endpoint: s.URL, Bucket: "test-bucket", BaseDir: "/test", Timeout: 10 * time.Second, AccessKeyID: "STATICKEY", SecretAccessKey: "secret"}
+	p, err := initS3Pipe(&c, nil)
+	require.NoError(t, err)
+	_, err = p.(*s3Pipe).client.ReadDir("/test/topic", "")
+	require.NoError(t, err)
+
+	require.Equal(t, []string{"STATICKEY", "STATICKEY"}, keys())
+}
+
+func TestCredentialProviderUnknown(t *testing.T) {
+	c := cfg.Pipe
+	c.S3 = config.S3Config{Region: "us-east-1", Bucket: "test-bucket", CredentialProvider: "no_such_provider"}
+	_, err := initS3Pipe(&c, nil)
+	require.Error(t, err)
+}
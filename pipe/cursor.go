@@ -0,0 +1,147 @@
+package pipe
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//rangeLister is implemented by filesystems, which can list the directory
+//starting from the given name, instead of listing it from the beginning
+type rangeLister interface {
+	//ReadDirFrom lists entries of the directory with path names greater
+	//than from
+	ReadDirFrom(dirname string, from string) ([]os.FileInfo, error)
+}
+
+//scanCursor is the position of the directory scan of the consumer, committed
+//in the offset record, so as restarted consumer resumes listing of the topic
+//near where it left off.
+//Files with names before the cursor can appear later because of out of order
+//naming by the producers, so files consumed within ScanLookBack window
+//before the cursor are remembered
+type scanCursor struct {
+	Cursor string          //greatest name of the consumed file
+	Recent map[string]bool `json:",omitempty"` //files consumed within look-back window
+}
+
+func (p *fileConsumer) lookBackEnabled() bool {
+	return p.cfg.ScanLookBack > 0 && !p.cfg.FileGeneration
+}
+
+//lookBackFrom returns the name, the listing starts from, to catch the files
+//created within look-back window before the cursor
+func (p *fileConsumer) lookBackFrom(tp string, c *scanCursor) string {
+	_, ts, err := parseFileName(tp, filepath.Dir(tp)+"/"+c.Cursor)
+	if err != nil {
+		return tp
+	}
+	return fmt.Sprintf("%s%010d", tp, ts-int64(p.cfg.ScanLookBack.Seconds()))
+}
+
+//addToCursor moves the cursor past the file and forgets the files, which are out of
+//the look-back window
+func (p *fileConsumer) addToCursor(c *scanCursor, name string) {
+	if c.Recent[name] {
+		return
+	}
+	if c.Recent == nil {
+		c.Recent = make(map[string]bool)
+	}
+	c.Recent[name] = true
+	if name <= c.Cursor {
+		return
+	}
+	c.Cursor = name
+
+	tp := p.topicPath(p.topic)
+	from := p.lookBackFrom(tp, c)
+	for n := range c.Recent {
+		if filepath.Dir(tp)+"/"+n < from {
+			delete(c.Recent, n)
+		}
+	}
+}
+
+//fileOpened advances read side scan cursor
+func (p *fileConsumer) fileOpened() {
+	if p.lookBackEnabled() {
+		p.addToCursor(&p.scan, filepath.Base(p.name))
+	}
+}
+
+//listFrom lists topic directory from the given name, if supported by the
+//filesystem
+func (p *fileConsumer) listFrom(dir string, from string) ([]os.FileInfo, error) {
+	if l, ok := p.fs.(rangeLister); ok {
+		return l.ReadDirFrom(dir, from)
+	}
+	return p.fs.ReadDir(dir, from)
+}
+
+//nextFileLookBack returns the first file after the cursor, unless there is a
+//file within the look-back window before the cursor, which hasn't been
+//consumed yet
+func (p *fileConsumer) nextFileLookBack(tp string) (string, error) {
+	dir := filepath.Dir(tp)
+	from := p.lookBackFrom(tp, &p.scan)
+
+	files, err := p.listFrom(dir, from)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	//Topic data has been swapped, restart from the beginning of the new data
+	if id := swapMarker(files); id != p.swapID {
+		p.scan = scanCursor{}
+		return p.nextFile(p.topic, "")
+	}
+
+	for _, f := range files {
+		fn := dir + "/" + f.Name()
+		if !strings.HasPrefix(fn, tp) || fn < from || f.IsDir() || isMetaFile(f.Name()) {
+			continue
+		}
+		if f.Name() > p.scan.Cursor {
+			return f.Name(), nil
+		}
+		//Late file, which appeared before the cursor
+		if !p.scan.Recent[f.Name()] && !strings.HasSuffix(f.Name(), ".open") {
+			return f.Name(), nil
+		}
+	}
+
+	return "", nil
+}
+
+//loadCursor restores scan position committed by the previous consumer
+func (p *fileConsumer) loadCursor(c *scanCursor) {
+	if !p.lookBackEnabled() || c == nil {
+		return
+	}
+
+	p.scan = *c
+	p.sentScan = scanCursor{Cursor: c.Cursor, Recent: make(map[string]bool)}
+	for n := range c.Recent {
+		p.sentScan.Recent[n] = true
+	}
+}
+
+//sentCursor returns the copy of the scan position of the delivered records,
+//nil if there is nothing to commit
+func (p *fileConsumer) sentCursor() *scanCursor {
+	if !p.lookBackEnabled() || p.sentScan.Cursor == "" {
+		return nil
+	}
+
+	c := scanCursor{Cursor: p.sentScan.Cursor, Recent: make(map[string]bool)}
+	for n := range p.sentScan.Recent {
+		c.Recent[n] = true
+	}
+
+	return &c
+}
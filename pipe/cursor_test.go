@@ -0,0 +1,114 @@
+package pipe
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+//cursorFS records the positions of range listings and counts full listings
+type cursorFS struct {
+	fileFS
+	dir   string
+	froms []string
+	full  int
+}
+
+func (f *cursorFS) ReadDir(dirname string, from string) ([]os.FileInfo, error) {
+	if dirname == f.dir {
+		f.full++
+	}
+	return f.fileFS.ReadDir(dirname, from)
+}
+
+func (f *cursorFS) ReadDirFrom(dirname string, from string) ([]os.FileInfo, error) {
+	files, err := ioutil.ReadDir(dirname)
+	if err != nil {
+		return nil, err
+	}
+	if dirname == f.dir {
+		f.froms = append(f.froms, from)
+	}
+	res := make([]os.FileInfo, 0)
+	for _, v := range files {
+		if dirname+"/"+v.Name() > from {
+			res = append(res, v)
+		}
+	}
+	return res, nil
+}
+
+func TestScanCursor(t *testing.T) {
+	deleteTestTopics(t)
+
+	topic := "scan-cursor-test/"
+
+	saveTimeNow := timeNow
+	defer func() { timeNow = saveTimeNow }()
+	start := time.Now()
+	now := start
+	timeNow = func() time.Time { return now }
+
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	fp.cfg.NonBlocking = true
+	fp.cfg.StartPolicy = StartPolicyEarliest
+	fp.cfg.ScanLookBack = 10 * time.Second
+
+	tp := topicPath(fp.datadir, topic)
+	cfs := &cursorFS{dir: filepath.Dir(tp)}
+	fp.fs = cfs
+
+	msgs := make([]string, 0)
+	for i := 0; i < 30; i++ {
+		msgs = append(msgs, fmt.Sprintf("file %v", i))
+		produceTestMsgs(t, fp, topic, msgs[i:])
+		now = now.Add(time.Second)
+	}
+
+	require.Equal(t, msgs[:20], consumeNumMsgs(t, fp, topic, 20))
+
+	var o committedOffset
+	ok, err := readMetaFile(fp.fs, offsetFileName(tp, ""), &o)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.NotNil(t, o.Scan)
+	c := *o.Scan
+	require.True(t, strings.HasPrefix(c.Cursor, fmt.Sprintf("%010d.", start.Unix()+19)))
+	//Files out of the look-back window are forgotten
+	require.Equal(t, 11, len(c.Recent))
+
+	//Files named within the look-back window before the cursor, appeared
+	//after the consumer had moved past them
+	late := []string{"late file 0", "late file 1"}
+	for i, ts := range []time.Duration{15, 12} {
+		now = start.Add(ts * time.Second)
+		p, err := fp.NewProducer(topic)
+		require.NoError(t, err)
+		require.NoError(t, p.PushK("late", []byte(late[i])))
+		require.NoError(t, p.Close())
+	}
+	now = start.Add(31 * time.Second)
+
+	cfs.froms, cfs.full = nil, 0
+
+	res := consumeAll(t, fp, topic)
+	require.Equal(t, 12, len(res))
+	require.Equal(t, []string{late[1], late[0]}, res[:2])
+	require.Equal(t, msgs[20:], res[2:])
+
+	//Restarted consumer hasn't listed the topic from the beginning
+	require.Equal(t, 0, cfs.full)
+	require.NotEqual(t, 0, len(cfs.froms))
+	for _, from := range cfs.froms {
+		require.True(t, from >= fmt.Sprintf("%s%010d", tp, start.Unix()+9), from)
+	}
+
+	//Nothing is consumed twice after the restart
+	require.Equal(t, []string{}, consumeAll(t, fp, topic))
+}
@@ -0,0 +1,243 @@
+package pipe
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+//jsonHeaderVersion is the version of the JSON line file header
+const jsonHeaderVersion = 1
+
+//maxJSONHeaderLen bounds the first line of the file, read as JSON header
+const maxJSONHeaderLen = maxBinaryHeaderLen
+
+//FileDescription is the format of the topic file, as recorded in its header
+type FileDescription struct {
+	Name   string
+	Format string `json:",omitempty"`
+	//Codec is the compression of the file data: gzip, zstd. Empty if not
+	//compressed
+	Codec string `json:",omitempty"`
+	//Cipher is the encryption of the file data: pgp. Empty if not encrypted
+	Cipher string `json:",omitempty"`
+	//KeyID identifies the wrapping key, which sealed the file keys
+	KeyID string `json:",omitempty"`
+	//Version of the file header, 0 - file has no header
+	Version    int
+	Generation uint64 `json:",omitempty"`
+}
+
+//headerCache caches descriptions of the finalized files, which headers are
+//immutable. Descriptions are keyed by the name and checked against the size
+//and modification time of the file, so as the file replaced under the same
+//name, for example by SwapTopicData, is described again
+type headerCache struct {
+	lock  sync.Mutex
+	descs map[string]cachedDescription
+}
+
+type cachedDescription struct {
+	desc    FileDescription
+	size    int64
+	modTime time.Time
+}
+
+func newHeaderCache() *headerCache {
+	return &headerCache{descs: make(map[string]cachedDescription)}
+}
+
+func (c *headerCache) get(name string, fi os.FileInfo) (FileDescription, bool) {
+	if c == nil {
+		return FileDescription{}, false
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	d, ok := c.descs[name]
+	if !ok || d.size != fi.Size() || !d.modTime.Equal(fi.ModTime()) {
+		return FileDescription{}, false
+	}
+	return d.desc, true
+}
+
+func (c *headerCache) put(d FileDescription, fi os.FileInfo) {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.descs[d.Name] = cachedDescription{d, fi.Size(), fi.ModTime()}
+}
+
+//evict removes the descriptions of the files of the topic, which are not
+//listed anymore
+func (c *headerCache) evict(tp string, listed map[string]bool) {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for n := range c.descs {
+		if strings.HasPrefix(n, tp) && !listed[n] {
+			delete(c.descs, n)
+		}
+	}
+}
+
+//describeFromName describes the file without header by its name suffixes
+func describeFromName(name string) FileDescription {
+	d := FileDescription{Name: name}
+	base := strings.TrimSuffix(name, ".gpg")
+	if base != name {
+		d.Cipher = "pgp"
+	}
+	switch {
+	case strings.HasSuffix(base, ".zst"):
+		d.Codec = CompressionZstd
+	case strings.HasSuffix(base, ".gz"):
+		d.Codec = CompressionGzip
+	}
+	return d
+}
+
+//newHeaderReader returns the reader, which can peek the longest JSON header
+func newHeaderReader(r io.Reader) *bufio.Reader {
+	return bufio.NewReaderSize(r, maxJSONHeaderLen+1)
+}
+
+//peekLine returns the first line of the reader without consuming it. Returns
+//nil if there is no delimiter within the reader buffer
+func peekLine(r *bufio.Reader) []byte {
+	for n := 64; ; n *= 2 {
+		if n > r.Size() {
+			n = r.Size()
+		}
+		b, err := r.Peek(n)
+		if i := bytes.IndexByte(b, delimiter); i >= 0 {
+			return b[:i+1]
+		}
+		if err != nil || n == r.Size() {
+			return nil
+		}
+	}
+}
+
+//detectHeader reads the header the file starts with. Binary header is
+//detected by the magic. JSON header is the first line of the file, which is
+//an object of the Header fields only, always including the Format. Returns
+//nil if the file has no header. Only the header is consumed from the reader,
+//see newHeaderReader
+func detectHeader(r *bufio.Reader) (*Header, error) {
+	if hasBinaryHeader(r) {
+		h, _, err := readBinaryHeader(r)
+		return h, err
+	}
+
+	if b, _ := r.Peek(1); len(b) == 0 || b[0] != '{' {
+		return nil, nil
+	}
+
+	line := peekLine(r)
+	if line == nil {
+		return nil, nil
+	}
+
+	var f struct{ Format *string }
+	if json.Unmarshal(line, &f) != nil || f.Format == nil {
+		return nil, nil
+	}
+
+	h := &Header{}
+	d := json.NewDecoder(bytes.NewReader(line))
+	d.DisallowUnknownFields()
+	if d.Decode(h) != nil {
+		return nil, nil
+	}
+	h.version = jsonHeaderVersion
+
+	_, err := r.Discard(len(line))
+
+	return h, err
+}
+
+//describeFile reads just the header of the file. Whether the file has the
+//header is detected from the file content, so as the files written with
+//different configs are described correctly
+func (p *filePipe) describeFile(name string, fi os.FileInfo) (FileDescription, error) {
+	if d, ok := p.headers.get(name, fi); ok {
+		return d, nil
+	}
+
+	r, err := p.fs.OpenRead(name, 0)
+	if err != nil {
+		return FileDescription{}, err
+	}
+	h, err := detectHeader(newHeaderReader(r))
+	_ = r.Close()
+	if err != nil {
+		return FileDescription{}, err
+	}
+
+	if h == nil {
+		d := describeFromName(name)
+		p.headers.put(d, fi)
+		return d, nil
+	}
+
+	d := FileDescription{Name: name, Format: h.Format, Version: h.version, Generation: h.Generation, KeyID: h.KeyID}
+	for _, f := range h.Filters {
+		if f == "pgp" {
+			d.Cipher = f
+		} else {
+			d.Codec = f
+		}
+	}
+	p.headers.put(d, fi)
+
+	return d, nil
+}
+
+//DescribeTopic lists finalized files of the topic with their format,
+//compression and encryption, read from the file headers, without decoding
+//the file data. Files written without the header are described by their
+//names. Descriptions are cached, because headers of the finalized files
+//never change, till the files are removed or replaced
+func (p *filePipe) DescribeTopic(topic string) ([]FileDescription, error) {
+	tp := topicPath(p.datadir, topic)
+	dir := filepath.Dir(tp)
+
+	files, err := p.readTopicDir(tp)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]FileDescription, 0)
+	listed := make(map[string]bool)
+	for _, f := range files {
+		fn := dir + "/" + f.Name()
+		if !strings.HasPrefix(fn, tp) || f.IsDir() || isMetaFile(f.Name()) || strings.HasSuffix(f.Name(), ".open") {
+			continue
+		}
+		listed[fn] = true
+
+		d, err := p.describeFile(fn, f)
+		if os.IsNotExist(err) {
+			//Removed by retention after the directory was listed
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, d)
+	}
+
+	p.headers.evict(tp, listed)
+
+	return res, nil
+}
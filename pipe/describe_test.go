@@ -0,0 +1,131 @@
+package pipe
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+//openCountingFS counts files opened for reading
+type openCountingFS struct {
+	fileFS
+	opens int
+}
+
+func (f *openCountingFS) OpenRead(name string, offset int64) (io.ReadCloser, error) {
+	f.opens++
+	return f.fileFS.OpenRead(name, offset)
+}
+
+func TestDescribeTopic(t *testing.T) {
+	deleteTestTopics(t)
+
+	topic := "describe-test/"
+
+	saveTimeNow := timeNow
+	defer func() { timeNow = saveTimeNow }()
+	now := time.Now()
+	timeNow = func() time.Time { return now }
+
+	enc := initTestFilePipe(&cfg.Pipe, true, t)
+
+	tests := []struct {
+		format      string
+		compression string
+		encryption  bool
+		header      string
+	}{
+		{"json", "", false, "json"},
+		{"msgpack", CompressionGzip, false, "json"},
+		{"avro", CompressionZstd, true, "json"},
+		{"json", "", false, "binary"},
+		//JSON records of the file without header are not taken for the header
+		{"json", "", false, ""},
+		{"msgpack", CompressionGzip, false, ""},
+	}
+
+	for _, v := range tests {
+		fp := initTestFilePipe(&cfg.Pipe, false, t)
+		fp.cfg.FileHeader = v.header != ""
+		fp.cfg.BinaryHeader = v.header == "binary"
+		fp.cfg.Compression = v.compression != ""
+		fp.cfg.CompressionType = v.compression
+		if v.encryption {
+			fp.cfg.Encryption = enc.cfg.Encryption
+		}
+
+		p, err := fp.NewProducer(topic)
+		require.NoError(t, err)
+		p.SetFormat(v.format)
+		require.NoError(t, p.Push([]byte(`{"describe":"test"}`)))
+		require.NoError(t, p.Close())
+
+		now = now.Add(time.Second)
+	}
+
+	//Headers are detected regardless of the config of the describing pipe
+	c := cfg.Pipe
+	c.BaseDir = baseDir
+	pp, err := initFilePipe(&c, nil)
+	require.NoError(t, err)
+	fp := pp.(*filePipe)
+	fs := &openCountingFS{}
+	fp.fs = fs
+
+	names := oneRecordTestFiles(t, fp, topic)
+	require.Equal(t, len(tests), len(names))
+
+	d, err := fp.DescribeTopic(topic)
+	require.NoError(t, err)
+	require.Equal(t, []FileDescription{
+		{Name: names[0], Format: "json", Version: jsonHeaderVersion},
+		{Name: names[1], Format: "msgpack", Codec: CompressionGzip, Version: jsonHeaderVersion},
+		{Name: names[2], Format: "avro", Codec: CompressionZstd, Cipher: "pgp", Version: jsonHeaderVersion},
+		{Name: names[3], Format: "json", Version: binaryHeaderVersion},
+		{Name: names[4]},
+		{Name: names[5], Codec: CompressionGzip},
+	}, d)
+	require.Equal(t, len(tests), fs.opens)
+
+	//Headers are read once
+	d1, err := fp.DescribeTopic(topic)
+	require.NoError(t, err)
+	require.Equal(t, d, d1)
+	require.Equal(t, len(tests), fs.opens)
+
+	//Descriptions of the removed files are evicted
+	require.NoError(t, os.Remove(names[0]))
+	d1, err = fp.DescribeTopic(topic)
+	require.NoError(t, err)
+	require.Equal(t, d[1:], d1)
+	_, ok := fp.headers.descs[names[0]]
+	require.False(t, ok)
+
+	//File replaced under the same name is described again
+	require.NoError(t, ioutil.WriteFile(names[3], []byte(`{"describe":"replaced test"}`+"\n"), 0644))
+	d1, err = fp.DescribeTopic(topic)
+	require.NoError(t, err)
+	require.Equal(t, FileDescription{Name: names[3]}, d1[2])
+}
+
+func TestDescribeTopicNoHeader(t *testing.T) {
+	deleteTestTopics(t)
+
+	topic := "describe-no-header-test/"
+
+	fp := initTestFilePipe(&cfg.Pipe, true, t)
+	fp.cfg.Compression = true
+	fp.cfg.CompressionType = CompressionZstd
+	produceTestMsgs(t, fp, topic, []string{"describe test"})
+
+	names := oneRecordTestFiles(t, fp, topic)
+	require.Equal(t, 1, len(names))
+
+	d, err := fp.DescribeTopic(topic)
+	require.NoError(t, err)
+	require.Equal(t, []FileDescription{{Name: names[0], Codec: CompressionZstd, Cipher: "pgp"}}, d)
+}
@@ -0,0 +1,92 @@
+package pipe
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//earliestFile returns the first data file of the topic in the consuming order
+func (p *fileConsumer) earliestFile(tp string, files []os.FileInfo) string {
+	dir := filepath.Dir(tp)
+	for _, f := range files {
+		if strings.HasPrefix(dir+"/"+f.Name(), tp) && !f.IsDir() && !isMetaFile(f.Name()) {
+			return f.Name()
+		}
+	}
+	return ""
+}
+
+func (p *filePipe) readTopicDir(tp string) ([]os.FileInfo, error) {
+	files, err := p.fs.ReadDir(filepath.Dir(tp), tp)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return files, err
+}
+
+//EarliestFile returns the earliest retained file of the topic, the file
+//consumers starting from OffsetOldest begin with. The earliest file changes
+//as old files are removed by retention. Returns empty string if the topic
+//has no files. Returned file has .open suffix if it's not finalized yet
+func (p *filePipe) EarliestFile(topic string) (string, error) {
+	tp := topicPath(p.datadir, topic)
+
+	files, err := p.readTopicDir(tp)
+	if err != nil {
+		return "", err
+	}
+
+	c := &fileConsumer{filePipe: p, fs: p.fs}
+	if p.cfg.FileGeneration {
+		if files, err = c.sortByGeneration(tp, files); err != nil {
+			return "", err
+		}
+	}
+
+	fn := c.earliestFile(tp, files)
+	if fn == "" {
+		return "", nil
+	}
+
+	return filepath.Dir(tp) + "/" + fn, nil
+}
+
+//EarliestOffset returns the offset of the first retained record of the
+//topic, which is the number of records in the finalized files removed from
+//the topic. Requires PartitionManifest to be enabled in the producer
+func (p *filePipe) EarliestOffset(topic string) (int64, error) {
+	if !p.cfg.PartitionManifest {
+		return 0, fmt.Errorf("earliest offset requires partition manifest")
+	}
+
+	tp := topicPath(p.datadir, topic)
+
+	files, err := p.readTopicDir(tp)
+	if err != nil {
+		return 0, err
+	}
+
+	stats, err := p.readStats(tp, files)
+	if err != nil {
+		return 0, err
+	}
+
+	present := make(map[string]bool)
+	for _, f := range files {
+		present[filepath.Dir(tp)+"/"+f.Name()] = true
+	}
+
+	offset, err := p.removedRecs(tp, files)
+	if err != nil {
+		return 0, err
+	}
+	for fn, s := range stats {
+		if !present[fn] {
+			offset += s.NumRecs
+		}
+	}
+
+	return offset, nil
+}
@@ -0,0 +1,91 @@
+package pipe
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+//gcFS removes the file right before it's opened, like retention running
+//concurrently with the consumer
+type gcFS struct {
+	fileFS
+	gc string
+}
+
+func (f *gcFS) OpenRead(name string, offset int64) (io.ReadCloser, error) {
+	if name == f.gc {
+		if err := os.Remove(name); err != nil {
+			return nil, err
+		}
+	}
+	return f.fileFS.OpenRead(name, offset)
+}
+
+func TestEarliestFile(t *testing.T) {
+	deleteTestTopics(t)
+
+	topic := "earliest-test-topic/"
+
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	fp.cfg.PartitionManifest = true
+	fp.cfg.NonBlocking = true
+	fp.cfg.MaxFileSize = 1 //rotate on every message
+
+	fn, err := fp.EarliestFile(topic)
+	require.NoError(t, err)
+	require.Equal(t, "", fn)
+
+	msgs := make([]string, 0)
+	for i := 0; i < 4; i++ {
+		msgs = append(msgs, fmt.Sprintf(`{"earliest":%v}`, i))
+	}
+	produceTestMsgs(t, fp, topic, msgs)
+
+	tp := topicPath(fp.datadir, topic)
+	files, err := fp.readTopicDir(tp)
+	require.NoError(t, err)
+	names := make([]string, 0)
+	for _, f := range files {
+		if !isMetaFile(f.Name()) {
+			names = append(names, baseDir+"/"+topic+f.Name())
+		}
+	}
+	require.Equal(t, 4, len(names))
+
+	fn, err = fp.EarliestFile(topic)
+	require.NoError(t, err)
+	require.Equal(t, names[0], fn)
+	o, err := fp.EarliestOffset(topic)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), o)
+
+	//Retention removes the first file
+	require.NoError(t, os.Remove(names[0]))
+
+	fn, err = fp.EarliestFile(topic)
+	require.NoError(t, err)
+	require.Equal(t, names[1], fn)
+	o, err = fp.EarliestOffset(topic)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), o)
+	require.Equal(t, msgs[1:], consumeTestMsgs(t, fp, topic))
+
+	//The earliest file is removed after the consumer listed the directory
+	fp.fs = &gcFS{gc: names[1]}
+	require.Equal(t, msgs[2:], consumeTestMsgs(t, fp, topic))
+
+	fn, err = fp.EarliestFile(topic)
+	require.NoError(t, err)
+	require.Equal(t, names[2], fn)
+	o, err = fp.EarliestOffset(topic)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), o)
+
+	fp.cfg.PartitionManifest = false
+	_, err = fp.EarliestOffset(topic)
+	require.Error(t, err)
+}
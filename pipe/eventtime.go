@@ -0,0 +1,108 @@
+package pipe
+
+import (
+	"encoding/json"
+	"math"
+	"time"
+)
+
+//EventTimeFunc extracts event time from the record. Returns false if the
+//record doesn't carry event time
+type EventTimeFunc func(record []byte) (time.Time, bool)
+
+//SetEventTimeFunc sets the function extracting event time from the records,
+//for the producers and consumers created by the pipe afterwards. By default
+//event time is read from EventTimeField of JSON records
+func (p *filePipe) SetEventTimeFunc(fn EventTimeFunc) {
+	p.eventTime = fn
+}
+
+//SetTopicEventTimeFunc sets the function extracting event time from the
+//records of the topic, instead of the one set by SetEventTimeFunc
+func (p *filePipe) SetTopicEventTimeFunc(topic string, fn EventTimeFunc) {
+	if p.topicEventTime == nil {
+		p.topicEventTime = make(map[string]EventTimeFunc)
+	}
+	p.topicEventTime[topic] = fn
+}
+
+//jsonEventTime reads event time from the field of JSON record, either Unix
+//seconds or RFC3339 string
+func jsonEventTime(field string, record []byte) (time.Time, bool) {
+	var r map[string]json.RawMessage
+	if err := json.Unmarshal(record, &r); err != nil {
+		return time.Time{}, false
+	}
+
+	v, ok := r[field]
+	if !ok {
+		return time.Time{}, false
+	}
+
+	var s string
+	if json.Unmarshal(v, &s) == nil {
+		t, err := time.Parse(time.RFC3339Nano, s)
+		return t, err == nil
+	}
+
+	var f float64
+	if json.Unmarshal(v, &f) == nil {
+		sec, frac := math.Modf(f)
+		return time.Unix(int64(sec), int64(frac*1e9)), true
+	}
+
+	return time.Time{}, false
+}
+
+//recordTime returns event time of the record of the topic, false if it's
+//unknown. Topic extractor takes precedence over the pipe one, functions
+//take precedence over the configured fields
+func (p *filePipe) recordTime(topic string, record []byte) (time.Time, bool) {
+	if fn := p.topicEventTime[topic]; fn != nil {
+		return fn(record)
+	}
+	if p.eventTime != nil {
+		return p.eventTime(record)
+	}
+	if f := p.cfg.EventTimeFields[topic]; f != "" {
+		return jsonEventTime(f, record)
+	}
+	if p.cfg.EventTimeField != "" {
+		return jsonEventTime(p.cfg.EventTimeField, record)
+	}
+	return time.Time{}, false
+}
+
+//timePartition returns the key of the file the record is written to. With
+//TimePartitionFormat records are partitioned by the event time, or by the
+//produce time if the record doesn't carry event time
+func (p *fileProducer) timePartition(key string, t time.Time, ok bool) string {
+	if p.cfg.TimePartitionFormat == "" {
+		return key
+	}
+	if !ok {
+		t = timeNow()
+	}
+	return t.UTC().Format(p.cfg.TimePartitionFormat)
+}
+
+//eventWritten extends event time range of the file by the record
+func (p *fileProducer) eventWritten(f *file, t time.Time, ok bool) {
+	if !ok {
+		return
+	}
+
+	p.metrics.EventLatency.Record(timeNow().Sub(t))
+
+	min, max := t.Unix(), t.Unix()
+	if t.Nanosecond() != 0 {
+		max++ //Range is inclusive, so round up the upper bound
+	}
+
+	if f.minEvent == 0 || min < f.minEvent {
+		f.minEvent = min
+	}
+	if max > f.maxEvent {
+		f.maxEvent = max
+	}
+}
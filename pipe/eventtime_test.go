@@ -0,0 +1,82 @@
+package pipe
+
+import (
+	"io/ioutil"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+//topicPartitions returns the keys of the data files of the topic
+func topicPartitions(t *testing.T, topic string) []string {
+	files, err := ioutil.ReadDir(baseDir + "/" + topic)
+	require.NoError(t, err)
+
+	keys := make(map[string]bool)
+	for _, f := range files {
+		if isMetaFile(f.Name()) {
+			continue
+		}
+		keys[f.Name()[strings.LastIndex(f.Name(), ".")+1:]] = true
+	}
+
+	res := make([]string, 0)
+	for k := range keys {
+		res = append(res, k)
+	}
+	sort.Strings(res)
+
+	return res
+}
+
+func TestTopicEventTime(t *testing.T) {
+	deleteTestTopics(t)
+
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	fp.cfg.EventTimeField = "ts"
+	fp.cfg.EventTimeFields = map[string]string{"orders/": "created_at", "users/": "updated_at"}
+	fp.cfg.TimePartitionFormat = "2006-01-02"
+
+	recs := []string{
+		`{"created_at":"2020-01-01T10:00:00Z","updated_at":"2020-02-01T10:00:00Z","ts":1583020800}`,
+		`{"created_at":"2020-01-02T10:00:00Z","updated_at":"2020-02-01T11:00:00Z","ts":1583020800}`,
+	}
+
+	for _, topic := range []string{"orders/", "users/", "events/"} {
+		p, err := fp.NewProducer(topic)
+		require.NoError(t, err)
+		for _, r := range recs {
+			require.NoError(t, p.PushK("key1", []byte(r)))
+		}
+		require.NoError(t, p.Close())
+	}
+
+	require.Equal(t, []string{"2020-01-01", "2020-01-02"}, topicPartitions(t, "orders/"))
+	require.Equal(t, []string{"2020-02-01"}, topicPartitions(t, "users/"))
+	//Topic without own field uses the default
+	require.Equal(t, []string{"2020-03-01"}, topicPartitions(t, "events/"))
+
+	//Topic function takes precedence over the fields
+	fp.SetTopicEventTimeFunc("orders/", func(record []byte) (time.Time, bool) {
+		return time.Date(2021, 5, 6, 0, 0, 0, 0, time.UTC), true
+	})
+	p, err := fp.NewProducer("orders/")
+	require.NoError(t, err)
+	require.NoError(t, p.PushK("key1", []byte(recs[0])))
+	require.NoError(t, p.Close())
+	require.Equal(t, []string{"2020-01-01", "2020-01-02", "2021-05-06"}, topicPartitions(t, "orders/"))
+
+	//Records without event time are partitioned by the produce time
+	saveTimeNow := timeNow
+	defer func() { timeNow = saveTimeNow }()
+	timeNow = func() time.Time { return time.Date(2022, 7, 8, 9, 0, 0, 0, time.UTC) }
+
+	p, err = fp.NewProducer("users/")
+	require.NoError(t, err)
+	require.NoError(t, p.PushK("key1", []byte(`{"id":1}`)))
+	require.NoError(t, p.Close())
+	require.Equal(t, []string{"2020-02-01", "2022-07-08"}, topicPartitions(t, "users/"))
+}
@@ -23,7 +23,6 @@ package pipe
 import (
 	"bufio"
 	"bytes"
-	"compress/gzip"
 	"crypto"
 	"crypto/sha256"
 	"database/sql"
@@ -37,6 +36,7 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -59,6 +59,9 @@ const (
 	delimiter byte = '\n'
 )
 
+//timeNow is replaced in tests to simulate clock changes
+var timeNow = time.Now
+
 var signKeyPw = ""
 var privateKeyPw = ""
 
@@ -79,9 +82,39 @@ type fs interface {
 	Cancel(io.Closer) error
 }
 
+//dirSyncer is implemented by filesystems which require explicit directory
+//fsync for renames to be durable
+type dirSyncer interface {
+	SyncDir(name string) error
+}
+
+//sizer is implemented by filesystems, which directory listing doesn't report
+//the size of the files
+type sizer interface {
+	FileSize(name string) (int64, error)
+}
+
 type filePipe struct {
 	datadir string
 	cfg     config.PipeConfig
+	fs      fs
+	hooks   FileHooks
+	sortKey func([]byte) []byte
+	headers *headerCache //nil - describe doesn't cache file headers
+	schemas *schemaCache //nil - schemas are loaded by every producer
+
+	classifier EncryptionClassifier
+
+	notifier PartitionCompleteNotifier //nil - completion isn't signaled
+
+	eventTime      EventTimeFunc //nil - event time is taken from EventTimeField
+	topicEventTime map[string]EventTimeFunc
+
+	sequence SequenceFunc //sequence number of the records, see DebugOrderCheck
+
+	db          *sql.DB //state DB of the consumer offsets, nil - offsets are kept in metadata files
+	statePrefix string  //distinguishes the state of the pipes sharing the DB
+	readOnly    bool    //metadata files can't be written
 }
 
 type file struct {
@@ -98,12 +131,31 @@ type file struct {
 	next *file
 
 	compressedSize int64
+
+	created time.Time
+
+	sorter *sorter //accumulates records when sorting is enabled
+
+	unflushed int64 //records written since last flush
+
+	minEvent, maxEvent int64 //event time range of the records, 0 - unknown
+
+	slot int //index of the file of the key, see ConcurrentFiles. -1 - disabled
 }
 
 type stat struct {
 	NumRecs  int64
 	Hash     string
 	FileName string
+	Text     bool `json:",omitempty"` //Records are delimiter separated
+	//Time range of the records in the file, from the file creation to the
+	//finalize, in Unix seconds
+	MinTimestamp int64 `json:",omitempty"`
+	MaxTimestamp int64 `json:",omitempty"`
+	//Event time range of the records, in Unix seconds, if the records carry
+	//event time. See EventTimeField
+	MinEventTime int64 `json:",omitempty"`
+	MaxEventTime int64 `json:",omitempty"`
 }
 
 // fileProducer synchronously pushes messages to File using topic specified during producer creation
@@ -124,6 +176,23 @@ type fileProducer struct {
 	metrics *metrics.FilePipeMetrics
 
 	stats map[string]*stat
+
+	avroSchema []byte //schema of Avro container files. See setAvroSchema
+
+	breaker circuitBreaker
+
+	encrypted bool //files of the topic are encrypted, see encryptTopic
+
+	completed map[string]*CompletedPartition //data finalized in the partitions since their last completion
+	writing   map[string]time.Time           //partitions, the producer is registered as a writer of, with the last heartbeat
+	partition string                         //latest time partition, see rollover
+	id        string                         //unique id of the producer, see producerID
+	fileSeq   int64                          //number of the files written by the producer, see Header.ID
+
+	schema     *jsonSchema         //nil - records of the topic are not validated
+	deadLetter *deadLetterProducer //producer of the records rejected by the schema validation
+
+	nextFile map[string]int //next file of the key, see ConcurrentFiles
 }
 
 // fileConsumer consumes messages from File using topic and partition specified during consumer creation
@@ -147,6 +216,42 @@ type fileConsumer struct {
 	watcher *fsnotify.Watcher
 
 	offset int64
+
+	//id of the last data swap of the topic directory seen by the consumer
+	swapID string
+
+	headerLen   int64  //length of the header of the current file
+	generation  uint64 //generation of the current file
+	generations map[string]uint64
+
+	frags assembler //fragments of the record larger than ChunkSize
+
+	rcfg     config.PipeConfig //config with read buffers bounded by MaxConsumerMemory
+	recLimit int64             //max record size, 0 - unlimited
+
+	stopAt string //last file of the topic at creation, see StopAtCurrentEnd
+
+	scan     scanCursor //directory scan position of the reads, see ScanLookBack
+	sentScan scanCursor //scan position of the delivered records, protected by posLock
+
+	recs    int64          //number of records read from the current file
+	readPos consumerOffset //position after the last read record
+	sentPos consumerOffset //position after the last delivered record
+	posLock sync.Mutex
+
+	counter *countingReader //position of the reader, see AttachLocation
+	loc     RecordLocation  //location of the last read record
+
+	seekTime time.Time //records before it are skipped, see SeekToTimestamp
+
+	clock *clockChecker //nil - clock anomalies are not detected
+
+	lease     string       //file claimed by the consumer, see ConsumerLease
+	leaseStop func()       //stops renewal of the lease
+	leaseLost func() error //returns the error, if the renewal of the lease has failed
+	leased    []string
+	resume    leaseResume
+	cleaned   time.Time //last cleanup of the lease markers
 }
 
 type noopFlusher struct {
@@ -216,7 +321,10 @@ func init() {
 }
 
 func initFilePipe(cfg *config.PipeConfig, db *sql.DB) (Pipe, error) {
-	return &filePipe{cfg.BaseDir, *cfg}, nil
+	if err := validateCompressionConfig(cfg); err != nil {
+		return nil, err
+	}
+	return &filePipe{datadir: cfg.BaseDir, cfg: *cfg, fs: &fileFS{}, headers: newHeaderCache(), schemas: newSchemaCache(), db: db, statePrefix: "file://"}, nil
 }
 
 // Type returns Pipe type as File
@@ -236,24 +344,78 @@ func (p *filePipe) Close() error {
 
 //NewProducer registers a new sync producer
 func (p *filePipe) NewProducer(topic string) (Producer, error) {
+	p, err := p.withTopicCompression(topic)
+	if err != nil {
+		return nil, err
+	}
+	enc, err := p.encryptTopic(topic)
+	if err != nil {
+		return nil, err
+	}
+	schema, err := p.topicSchema(topic)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.checkNotifier(p.fs); err != nil {
+		return nil, err
+	}
 	m := metrics.NewFilePipeMetrics("pipe_producer", map[string]string{"topic": topic, "pipeType": "file"})
-	return &fileProducer{filePipe: p, topic: topic, files: make(map[string]*file), fs: &fileFS{}, metrics: m, stats: make(map[string]*stat)}, nil
+	return &fileProducer{filePipe: p, topic: topic, files: make(map[string]*file), fs: p.fs, metrics: m, stats: make(map[string]*stat), encrypted: enc, schema: schema}, nil
 }
 
 func (p *filePipe) initConsumer(c *fileConsumer, fn fetchFunc) (Consumer, error) {
-	fname, offset, err := c.seek(c.topic, InitialOffset)
-	if log.E(err) {
+	c.filePipe, c.fs = p.withShards(c.fs)
+
+	var err error
+	if c.filePipe, err = c.withTopicCompression(c.topic); err != nil {
 		return nil, err
 	}
 
-	if fname != "" {
-		if strings.HasSuffix(fname, ".open") {
-			c.offset = offset
-		} else {
-			c.openFile(fname, offset)
+	if err := c.initCurrentEnd(); log.E(err) {
+		return nil, err
+	}
+
+	if c.cfg.StartPolicy != "" && !c.commitsEnabled() {
+		log.Warnf("%v: No DB configured, offsets of the read-only pipe won't be committed", c.topic)
+	}
+
+	c.order = newOrderChecker(c.filePipe, true)
+	c.clock = newClockChecker(c.filePipe)
+
+	if c.leasesEnabled() {
+		if _, err := c.leases(); log.E(err) {
+			return nil, err
+		}
+	}
+
+	for {
+		fname, offset, skip, err := c.startPosition()
+		if log.E(err) {
+			return nil, err
+		}
+
+		if fname != "" {
+			if strings.HasSuffix(fname, ".open") {
+				c.offset = offset
+			} else {
+				c.openFile(fname, offset)
+				c.skipRecords(skip)
+			}
 		}
+
+		//The file has been removed by retention after the directory was
+		//listed, resolve the start position again
+		if !os.IsNotExist(c.err) {
+			break
+		}
+		log.Debugf("%v has been removed, resolving start position again", fname)
+		c.err = nil
 	}
 
+	c.sent = c.recordSent
+	if err := p.initTransformPolicy(&c.baseConsumer, c.metrics); err != nil {
+		return nil, err
+	}
 	c.initBaseConsumer(fn)
 
 	return c, nil
@@ -261,12 +423,15 @@ func (p *filePipe) initConsumer(c *fileConsumer, fn fetchFunc) (Consumer, error)
 
 //NewConsumer registers a new file consumer with context
 func (p *filePipe) NewConsumer(topic string) (Consumer, error) {
+	m := metrics.NewFilePipeMetrics("pipe_consumer", map[string]string{"topic": topic, "pipeType": "file"})
+	if p.cfg.ParallelReaders > 1 {
+		return p.newParallelConsumer(topic, p.fs, m)
+	}
 	w, err := fsnotify.NewWatcher()
 	if err != nil {
 		return nil, err
 	}
-	m := metrics.NewFilePipeMetrics("pipe_consumer", map[string]string{"topic": topic, "pipeType": "file"})
-	c := &fileConsumer{filePipe: p, topic: topic, fs: &fileFS{}, metrics: m, watcher: w}
+	c := &fileConsumer{filePipe: p, topic: topic, fs: p.fs, metrics: m, watcher: w}
 	return p.initConsumer(c, c.fetchNext)
 }
 
@@ -300,6 +465,10 @@ func (p *fileConsumer) nextFile(topic string, curFile string) (string, error) {
 		curFile = tp
 	}
 
+	if p.lookBackEnabled() && p.scan.Cursor != "" {
+		return p.nextFileLookBack(tp)
+	}
+
 	files, err := p.fs.ReadDir(dir, curFile)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -313,11 +482,35 @@ func (p *fileConsumer) nextFile(topic string, curFile string) (string, error) {
 		return "", nil
 	}
 
+	//Topic data has been swapped, restart from the beginning of the new data
+	if id := swapMarker(files); id != p.swapID {
+		log.Infof("%v data has been swapped, restarting from the beginning", topic)
+		p.swapID = id
+		curFile = tp
+		//Listing can be restarted, when the directory is modified during
+		//the listing, so the position is reset along with the swap id
+		p.name = ""
+		p.order.reset()
+		p.clock.reset()
+	}
+
+	if p.cfg.FileGeneration {
+		fn, err := p.nextFileByGeneration(tp, files, curFile == tp)
+		log.Debugf("%v NextFile: %v, CurFile: %v, generation: %v", topic, fn, curFile, p.generation)
+		return fn, err
+	}
+
+	p.reportClockAnomalies(tp, files, curFile)
+
 	i := sort.Search(len(files), func(i int) bool {
 		fn := dir + "/" + files[i].Name()
 		return fn > curFile
 	})
 
+	for i < len(files) && isMetaFile(files[i].Name()) {
+		i++
+	}
+
 	if i < len(files) {
 		fn := dir + "/" + files[i].Name()
 		if strings.HasPrefix(fn, tp) && !files[i].IsDir() {
@@ -345,22 +538,32 @@ func (p *fileConsumer) seek(topic string, offset int64) (string, int64, error) {
 		return "", 0, nil
 	}
 
+	p.swapID = swapMarker(files)
+
+	if p.cfg.FileGeneration {
+		if files, err = p.sortByGeneration(tp, files); err != nil {
+			return "", 0, err
+		}
+	}
+
 	if offset == OffsetOldest {
-		for _, f := range files {
-			if strings.HasPrefix(dir+"/"+f.Name(), tp) && !f.IsDir() {
-				if strings.HasSuffix(f.Name(), ".open") {
-					return "", 0, nil
-				}
-				return f.Name(), 0, nil
-			}
+		fn := p.earliestFile(tp, files)
+		if strings.HasSuffix(fn, ".open") {
+			return "", 0, nil
 		}
-		return "", 0, nil
+		return fn, 0, nil
 	}
 
 	if offset == OffsetNewest {
 		for i := len(files) - 1; i >= 0; i-- {
-			if strings.HasPrefix(dir+"/"+files[i].Name(), tp) && !files[i].IsDir() {
-				return files[i].Name(), files[i].Size(), nil
+			if strings.HasPrefix(dir+"/"+files[i].Name(), tp) && !files[i].IsDir() && !isMetaFile(files[i].Name()) {
+				size := files[i].Size()
+				if z, ok := p.fs.(sizer); ok {
+					if size, err = z.FileSize(dir + "/" + files[i].Name()); err != nil {
+						return "", 0, err
+					}
+				}
+				return files[i].Name(), size, nil
 			}
 		}
 		return "", 0, nil
@@ -369,19 +572,31 @@ func (p *fileConsumer) seek(topic string, offset int64) (string, int64, error) {
 	return "", 0, fmt.Errorf("arbitrary offsets not supported, only OffsetOldest and OffsetNewest offsets supported")
 }
 
-func (p *fileProducer) newFileName(key string) string {
+func (p *fileProducer) newFileName(key string, slot int) string {
 	p.seqno++ //Precaution to not generate file with the same name if timestamps are equal
-	format := "%s%010d.%03d.%s"
+	seq := fmt.Sprintf("%03d", p.seqno)
+	if p.cfg.OneRecordPerFile {
+		//Keep files of the same second in the produced order
+		seq = fmt.Sprintf("%010d", p.seqno)
+	}
+	//Concurrent files of the key are distinguished by the index after the
+	//sequence number, so as the key in the name stays intact
+	if slot >= 0 {
+		seq += fmt.Sprintf("-%d", slot)
+	}
+	format := "%s%010d.%s.%s"
 	if p.cfg.Compression {
-		format += ".gz"
+		format += compressionSuffix(&p.cfg)
 	}
-	if p.cfg.Encryption.Enabled {
+	if p.encrypted {
 		format += ".gpg"
 	}
-	return fmt.Sprintf(format+".open", p.topicPath(p.topic), time.Now().Unix(), p.seqno, key)
+	return fmt.Sprintf(format+".open", p.topicPath(p.topic), timeNow().Unix(), seq, key)
 }
 
-func (p *fileProducer) initCrypterWriter(filename string, writer io.WriteCloser) (io.WriteCloser, error) {
+//initCrypterWriter returns the writer encrypting the data to writer. Key
+//packets of the message are written to keyWriter
+func (p *fileProducer) initCrypterWriter(filename string, keyWriter io.Writer, writer io.Writer) (io.WriteCloser, error) {
 	if len(p.cfg.Encryption.PublicKey) == 0 {
 		return nil, fmt.Errorf("public key is empty. required for producing encrypted stream")
 	}
@@ -428,12 +643,12 @@ func (p *fileProducer) initCrypterWriter(filename string, writer io.WriteCloser)
 		ModTime:  time.Now(),
 	}
 
-	writer, err = openpgp.Encrypt(writer, []*openpgp.Entity{encEntity}, signEntity, hints, nil)
+	w, err := openpgp.EncryptSplit(keyWriter, writer, []*openpgp.Entity{encEntity}, signEntity, hints, nil)
 	if log.E(err) {
 		return nil, err
 	}
 
-	return writer, nil
+	return w, nil
 }
 
 func listInsert(p *fileProducer, n *file) {
@@ -459,12 +674,20 @@ func listRemove(p *fileProducer, d *file) {
 	}
 }
 
-func (p *fileProducer) newFile(key string) error {
+func (p *fileProducer) newFile(key string, slot int) error {
+	if err := p.checkOCFConfig(); err != nil {
+		return err
+	}
+
+	if err := checkSortConfig(p.cfg.SortMode); err != nil {
+		return err
+	}
+
 	if err := p.fs.MkdirAll(filepath.Dir(p.topicPath(p.topic)), dirPerm); err != nil {
 		return err
 	}
 
-	n := p.newFileName(key)
+	n := p.newFileName(key, slot)
 	w, seeker, err := p.fs.OpenWrite(n)
 	if err != nil {
 		return err
@@ -483,41 +706,107 @@ func (p *fileProducer) newFile(key string) error {
 	hw := &hashWriter{w, h, p.metrics, nil}
 	var writer flushWriteCloser = hw
 
-	if p.cfg.Encryption.Enabled {
-		w, err := p.initCrypterWriter(n, writer)
-		if err != nil {
+	var crypter io.WriteCloser
+	var keys string
+	var dw *deferredWriter
+	if p.encrypted {
+		if crypter, keys, dw, err = p.initFileCrypter(n, writer); err != nil {
 			return err
 		}
-		writer = &chainer{&noopFlusher{w}, writer}
+	}
+
+	if offset == 0 && hasHeader(&p.cfg) {
+		if err := p.writeFileHeader(hw, keys); err != nil {
+			return err
+		}
+	}
+
+	if err := dw.release(); err != nil {
+		return err
+	}
+
+	if p.encrypted {
+		writer = &chainer{&noopFlusher{crypter}, writer}
 	}
 
 	writer = &chainer{&flushClose{bufio.NewWriter(writer)}, writer}
 	if p.cfg.Compression {
-		writer = &chainer{gzip.NewWriter(writer), writer}
+		cw, err := newCompressWriter(&p.cfg, writer)
+		if err != nil {
+			return err
+		}
+		writer = &chainer{cw, writer}
+	}
+
+	if writer, err = p.ocfWriter(offset, writer); err != nil {
+		return err
 	}
 
-	_ = p.closeFile(p.files[key], true)
+	id := fileID(key, slot)
+	_ = p.closeFile(p.files[id], true)
 
 	log.Debugf("Opened: %v, %v compression: %v", key, n, p.cfg.Compression)
 
-	f := &file{n, key, w, seeker, h, offset, 0, writer, p.flast, nil, offset}
+	f := &file{n, key, w, seeker, h, offset, 0, writer, p.flast, nil, offset, timeNow(), p.newSorter(), 0, 0, 0, slot}
 	hw.f = f
 
 	listInsert(p, f)
-	p.files[key] = f
+	p.files[id] = f
 
 	p.metrics.FilesOpened.Inc(1)
 
 	return nil
 }
 
-func (p *fileProducer) getFile(key string) (*file, error) {
-	f := p.files[key]
+func (p *fileProducer) writeFileHeader(w io.Writer, keys string) error {
+	h := p.header
+	h.Keys = keys
+	p.fileSeq++
+	h.ID = fmt.Sprintf("%s.%d", p.producerID(), p.fileSeq)
+	if keys != "" {
+		key, err := wrappingKey(&p.cfg)
+		if err != nil {
+			return err
+		}
+		h.KeyID = wrappingKeyID(key)
+	}
+	h.Delimited = p.cfg.FileDelimited
+	h.Filters = make([]string, 0)
+	if p.cfg.Compression {
+		h.Filters = append(h.Filters, compressionType(&p.cfg))
+	}
+	if p.encrypted {
+		h.Filters = append(h.Filters, "pgp")
+	}
+	if p.cfg.FileGeneration {
+		g, err := p.nextGeneration()
+		if err != nil {
+			return err
+		}
+		h.Generation = g
+	}
+	if p.cfg.BinaryHeader {
+		return writeBinaryHeader(&h, w)
+	}
+	return writeHeader(&h, w)
+}
+
+//fileID returns the key of the file in the files map
+func fileID(key string, slot int) string {
+	if slot < 0 {
+		return key
+	}
+	return fmt.Sprintf("%s\x00%d", key, slot)
+}
+
+func (p *fileProducer) getFile(key string, slot int) (*file, error) {
+	id := fileID(key, slot)
+	f := p.files[id]
 	if f == nil {
-		if err := p.newFile(key); err != nil {
+		if err := p.newFile(key, slot); err != nil {
 			return nil, err
 		}
-		f = p.files[key]
+		f = p.files[id]
 	}
 	return f, nil
 }
@@ -542,6 +831,17 @@ func syncFsMetadata() error {
 	return nil
 }
 
+//syncDir makes rename of the file durable by syncing its parent directory
+func (p *fileProducer) syncDir(name string) error {
+	if !p.cfg.SyncOnRotate {
+		return nil
+	}
+	if d, ok := p.fs.(dirSyncer); ok {
+		return d.SyncDir(filepath.Dir(name))
+	}
+	return nil
+}
+
 func (p *fileProducer) closeFile(f *file, graceful bool) error {
 	log.Debugf("Closed: %v", f)
 	if f == nil {
@@ -550,29 +850,61 @@ func (p *fileProducer) closeFile(f *file, graceful bool) error {
 	var rerr error
 	defer func() {
 		listRemove(p, f)
-		delete(p.files, f.key)
+		delete(p.files, fileID(f.key, f.slot))
 		if rerr != nil || !graceful {
 			p.cancel(f)
 		}
 	}()
-	if err := f.writer.Close(); log.E(err) {
+	start := time.Now()
+	if f.sorter != nil {
+		if graceful {
+			if err := f.sorter.merge(func(b []byte) error { return p.writeRecord(f, b) }); log.E(err) {
+				rerr = err
+			}
+		}
+		f.sorter.close()
+	}
+	if err := f.writer.Close(); log.E(err) && rerr == nil {
 		rerr = err
 	}
 	fn := strings.TrimSuffix(f.name, ".open")
 	if graceful && rerr == nil {
 		if err := p.fs.Rename(f.name, fn); log.E(err) {
 			rerr = err
+		} else if err := p.syncDir(fn); log.E(err) {
+			rerr = err
 		}
 	}
-	p.stats[fn] = &stat{NumRecs: f.nRecs, Hash: fmt.Sprintf("%x", f.hash.Sum(nil)), FileName: fn}
+	if graceful {
+		p.finalized(f, fn, time.Since(start), rerr)
+	}
+	st := &stat{NumRecs: f.nRecs, Hash: fmt.Sprintf("%x", f.hash.Sum(nil)), FileName: fn, Text: atomic.LoadInt64(&p.text) == 1, MinTimestamp: f.created.Unix(), MaxTimestamp: timeNow().Unix(), MinEventTime: f.minEvent, MaxEventTime: f.maxEvent}
+	p.stats[fn] = st
 	p.metrics.FilesClosed.Inc(1)
 	log.E(syncFsMetadata())
 	log.Debugf("Closed: %v", f.name)
-	return rerr
+	if rerr != nil {
+		return rerr
+	}
+	if graceful {
+		p.partitionWritten(f.key, st)
+	}
+	//Failure to update the manifests doesn't invalidate already finalized file
+	var err error
+	if graceful && p.cfg.PartitionChecksums {
+		err = p.updateChecksums(st)
+		log.E(err)
+	}
+	if graceful && p.cfg.PartitionManifest {
+		if e := p.updateManifest(f.key, st); log.E(e) && err == nil {
+			err = e
+		}
+	}
+	return err
 }
 
 func (p *fileProducer) writeBinaryMsgLength(f *file, len int) error {
-	if atomic.LoadInt64(&p.text) == 1 || !p.cfg.FileDelimited {
+	if atomic.LoadInt64(&p.text) == 1 || !p.cfg.FileDelimited || p.cfg.AvroOCF {
 		return nil
 	}
 
@@ -584,7 +916,7 @@ func (p *fileProducer) writeBinaryMsgLength(f *file, len int) error {
 }
 
 func (p *fileProducer) writeTextMsgDelimiter(f *file) error {
-	if atomic.LoadInt64(&p.text) == 0 || !p.cfg.FileDelimited {
+	if atomic.LoadInt64(&p.text) == 0 || !p.cfg.FileDelimited || p.cfg.AvroOCF {
 		return nil
 	}
 
@@ -595,14 +927,66 @@ func (p *fileProducer) writeTextMsgDelimiter(f *file) error {
 	return err
 }
 
-func (p *fileProducer) rotateOnSizeLimit(key string, f *file) {
-	if (p.cfg.MaxFileDataSize != 0 && f.offset >= p.cfg.MaxFileDataSize) || (p.cfg.MaxFileSize != 0 && f.compressedSize > p.cfg.MaxFileSize) {
-		_ = p.closeFile(p.files[key], true)
+func (p *fileProducer) writeMsg(f *file, b []byte) error {
+	//Prepend message with size in the case of binary delimited format
+	if err := p.writeBinaryMsgLength(f, len(b)); err != nil {
+		return err
 	}
+
+	_, err := f.writer.Write(b)
+	return err
+}
+
+//writeRecord writes the record with the framing required by the format
+func (p *fileProducer) writeRecord(f *file, b []byte) error {
+	var err error
+	if p.chunked(len(b)) {
+		err = p.writeFragments(f, b)
+	} else {
+		err = p.writeMsg(f, b)
+	}
+	if err != nil {
+		return err
+	}
+
+	//In the case of text format apppend delimiter after the message
+	return p.writeTextMsgDelimiter(f)
+}
+
+func (p *fileProducer) rotateOnSizeLimit(f *file) error {
+	size := f.compressedSize
+	//Sorted records are written out on finalize, so the file is rotated on
+	//the size of the buffered records
+	if f.sorter != nil {
+		size += f.sorter.total
+	}
+	//Same for the current block of the Avro container file
+	if o, ok := f.writer.(*ocfWriter); ok {
+		size += int64(o.block.Len())
+	}
+	if (p.cfg.MaxFileDataSize != 0 && f.offset >= p.cfg.MaxFileDataSize) || (p.cfg.MaxFileSize != 0 && size > p.cfg.MaxFileSize) {
+		return p.closeFile(f, true)
+	}
+	return nil
 }
 
-//Push produces message to File topic
 func (p *fileProducer) push(key string, in interface{}, batch bool) error {
+	//Invalid record is not a backend failure, so it's checked outside of the
+	//circuit breaker
+	if b, ok := in.([]byte); ok {
+		var err error
+		if in, err = sanitizeUTF8(p.cfg.UTF8Validation, b); err != nil {
+			return err
+		}
+		if ok, err := p.validateSchema(key, in.([]byte)); !ok {
+			return err
+		}
+	}
+	return p.guard(func() error { return p.pushLow(key, in, batch) })
+}
+
+//Push produces message to File topic
+func (p *fileProducer) pushLow(key string, in interface{}, batch bool) error {
 	var bytes []byte
 	switch b := in.(type) {
 	case []byte:
@@ -611,7 +995,15 @@ func (p *fileProducer) push(key string, in interface{}, batch bool) error {
 		return fmt.Errorf("file pipe can handle binary arrays only")
 	}
 
-	f, err := p.getFile(key)
+	et, hasTime := p.recordTime(p.topic, bytes)
+	key = p.timePartition(key, et, hasTime)
+	if err := p.rollover(key); err != nil {
+		return err
+	}
+	if err := p.registerWriter(key); err != nil {
+		return err
+	}
+	f, err := p.getFile(key, p.roundRobin(key))
 	if err != nil {
 		return err
 	}
@@ -622,31 +1014,55 @@ func (p *fileProducer) push(key string, in interface{}, batch bool) error {
 		}
 	}()
 
-	//Prepend message with size in the case of binary delimited format
-	if err = p.writeBinaryMsgLength(f, len(bytes)); err != nil {
-		return err
-	}
-
-	if _, err = f.writer.Write(bytes); err != nil {
-		return err
+	if f.sorter != nil {
+		err = f.sorter.add(bytes)
+	} else {
+		err = p.writeRecord(f, bytes)
 	}
-
-	//In the case of text format apppend delimiter after the message
-	if err = p.writeTextMsgDelimiter(f); err != nil {
+	if err != nil {
 		return err
 	}
 
 	f.offset += int64(len(bytes)) + 1
 	f.nRecs++
+	p.eventWritten(f, et, hasTime)
+
+	if p.cfg.OneRecordPerFile {
+		return p.closeFile(f, true)
+	}
 
 	if !batch {
-		if err = f.writer.Flush(); err != nil {
+		if err = p.flush(f); err != nil {
 			return err
 		}
-		p.rotateOnSizeLimit(key, f)
+		return p.rotateOnSizeLimit(f)
 	}
 
-	return nil
+	f.unflushed++
+	if p.cfg.FlushEveryRecords != 0 && f.unflushed >= p.cfg.FlushEveryRecords && f.sorter == nil {
+		err = p.flush(f)
+	}
+
+	return err
+}
+
+//roundRobin returns the index of the next of the ConcurrentFiles of the key.
+//Returns -1 if ConcurrentFiles is disabled
+func (p *fileProducer) roundRobin(key string) int {
+	if p.cfg.ConcurrentFiles <= 1 {
+		return -1
+	}
+	if p.nextFile == nil {
+		p.nextFile = make(map[string]int)
+	}
+	i := p.nextFile[key]
+	p.nextFile[key] = (i + 1) % p.cfg.ConcurrentFiles
+	return i
+}
+
+func (p *fileProducer) flush(f *file) error {
+	f.unflushed = 0
+	return f.writer.Flush()
 }
 
 //PushK sends a keyed message to File
@@ -667,15 +1083,22 @@ func (p *fileProducer) PushBatch(key string, in interface{}) error {
 
 //PushBatchCommit commits currently queued messages in the producer
 func (p *fileProducer) PushBatchCommit() error {
+	return p.guard(p.commit)
+}
+
+func (p *fileProducer) commit() error {
 	//Flush and may be close in open order
 	f := p.ffirst
 	for f != nil {
-		if err := f.writer.Flush(); err != nil {
+		if err := p.flush(f); err != nil {
 			p.cancel(f)
 			return err
 		}
-		p.rotateOnSizeLimit(f.key, f)
-		f = f.next
+		next := f.next
+		if err := p.rotateOnSizeLimit(f); err != nil {
+			return err
+		}
+		f = next
 	}
 	return nil
 }
@@ -684,10 +1107,20 @@ func (p *fileProducer) PushSchema(key string, data []byte) error {
 	if err := p.PushBatchCommit(); err != nil {
 		return err
 	}
+	//Avro container files carry the schema in the file metadata
+	if p.cfg.AvroOCF {
+		return p.setAvroSchema(data)
+	}
 	if key == "" {
 		key = "schema"
 	}
-	_ = p.closeFile(p.files[key], true)
+	for f := p.ffirst; f != nil; {
+		next := f.next
+		if f.key == key {
+			_ = p.closeFile(f, true)
+		}
+		f = next
+	}
 
 	if len(data) == 0 {
 		return nil
@@ -740,6 +1173,10 @@ func (p *fileProducer) Close() error {
 		return err
 	}
 
+	if err := p.closeDeadLetter(true); err != nil {
+		return err
+	}
+
 	if p.cfg.EndOfStreamMark {
 		if err := p.fs.MkdirAll(filepath.Dir(p.topicPath(p.topic)), dirPerm); err != nil {
 			return err
@@ -756,12 +1193,22 @@ func (p *fileProducer) Close() error {
 			return err
 		}
 	}
+
+	p.notifyCompleted(func(string) bool { return true })
+
 	return nil
 }
 
 // CloseOnFailure removes unfinished files
 func (p *fileProducer) CloseOnFailure() error {
-	return p.close(false)
+	err := p.close(false)
+	if p.notifier != nil {
+		p.leavePartitions()
+	}
+	if e := p.closeDeadLetter(false); err == nil {
+		err = e
+	}
+	return err
 }
 
 //PartitionKey transforms input row key into partition key
@@ -807,8 +1254,18 @@ func (p *fileConsumer) checkForNextFile(watcher *fsnotify.Watcher) bool {
 func (p *fileConsumer) waitForNextFile(watcher *fsnotify.Watcher) (bool, error) {
 	log.Debugf("Waiting for directory events %v", p.topic)
 
+	//Skipped leases are rechecked, the leases expire without directory events
+	var recheck <-chan time.Time
+	if len(p.leased) != 0 {
+		t := time.NewTimer(p.cfg.ConsumerLease / 2)
+		defer t.Stop()
+		recheck = t.C
+	}
+
 	for {
 		select {
+		case <-recheck:
+			return false, nil
 		case event := <-watcher.Events:
 			if event.Op&(fsnotify.Create|fsnotify.Rename) != 0 {
 				log.Debugf("modified file: %v", event.Name)
@@ -831,6 +1288,10 @@ func (p *fileConsumer) waitAndOpenNextFile() bool {
 			return true
 		}
 
+		if p.openReleasedLease() {
+			return true
+		}
+
 		nextFn, err := p.nextFile(p.topic, p.name)
 		if log.E(p.err) {
 			return true
@@ -844,9 +1305,17 @@ func (p *fileConsumer) waitAndOpenNextFile() bool {
 			continue
 		}
 
+		if p.pastCurrentEnd(nextFn) {
+			return false
+		}
+
 		if nextFn != "" && !strings.HasSuffix(nextFn, ".open") {
 			p.openFile(nextFn, p.offset)
 			p.offset = 0
+			if p.skipRemovedOnOpen(nextFn) {
+				log.E(p.waitForNextFileFinish(p.watcher))
+				continue
+			}
 			return true
 		}
 
@@ -869,17 +1338,28 @@ func (p *fileConsumer) waitAndOpenNextFile() bool {
 }
 
 func (p *fileConsumer) waitAndOpenNextFilePoll() bool {
-	ticker := time.NewTicker(200 * time.Millisecond)
-	defer ticker.Stop()
+	b := newPollBackoff(&p.cfg)
 	for {
+		if p.openReleasedLease() {
+			return true
+		}
+
 		nextFn, err := p.nextFile(p.topic, p.name)
 		if log.E(err) {
 			p.err = err
 			return true
 		}
 
+		if p.pastCurrentEnd(nextFn) {
+			return false
+		}
+
 		if nextFn != "" && !strings.HasSuffix(nextFn, ".open") {
-			p.openFile(nextFn, 0)
+			p.openFile(nextFn, p.offset)
+			p.offset = 0
+			if p.skipRemovedOnOpen(nextFn) {
+				continue
+			}
 			return true
 		}
 
@@ -888,7 +1368,7 @@ func (p *fileConsumer) waitAndOpenNextFilePoll() bool {
 		}
 
 		select {
-		case <-ticker.C:
+		case <-pollAfter(b.next()):
 		case <-p.ctx.Done():
 			return false
 		}
@@ -921,6 +1401,10 @@ func (p *fileConsumer) initCrypterReader(reader io.Reader) (io.Reader, *openpgp.
 		return nil, nil, err
 	}
 
+	if reader, err = p.unsealKeys(reader); log.E(err) {
+		return nil, nil, err
+	}
+
 	md, err := openpgp.ReadMessage(reader, openpgp.EntityList{privEntity}, nil, &packet.Config{DefaultHash: crypto.SHA256})
 	if log.E(err) {
 		return nil, nil, err
@@ -929,38 +1413,89 @@ func (p *fileConsumer) initCrypterReader(reader io.Reader) (io.Reader, *openpgp.
 }
 
 func (p *fileConsumer) openFileInitFilter() (err error) {
-	//TODO: Get encryption and compression type from Filters field of the header
-	if p.cfg.Encryption.Enabled || p.cfg.Compression {
+	var codec func(io.Reader) (io.Reader, error)
+	if p.cfg.ExternalIngest {
+		codec = hadoopCodec(p.name)
+	}
+
+	encrypted := p.fileEncrypted()
+	if encrypted || p.rcfg.Compression || codec != nil {
 		//Header reader cached more then just a header, so need to reopen
 		log.E(p.file.Close())
-		p.file, err = p.fs.OpenRead(p.name, 0)
+		p.file, err = p.fs.OpenRead(p.name, p.headerLen)
 		if log.E(err) {
 			return
 		}
 
 		var reader io.Reader = p.file
-		if p.cfg.Encryption.Enabled {
+		if encrypted {
 			reader, p.pgpMD, err = p.initCrypterReader(reader)
 			if err != nil {
 				return
 			}
 		}
 
-		if p.cfg.Compression {
-			reader, err = gzip.NewReader(reader)
+		if codec != nil {
+			reader, err = codec(reader)
+			if log.E(err) {
+				return
+			}
+		} else if p.rcfg.Compression {
+			reader, p.file, err = newDecompressReader(&p.rcfg, reader, p.file)
 			if log.E(err) {
 				return
 			}
 		}
 
-		p.reader = bufio.NewReader(reader)
+		p.reader = p.newReader(p.countReader(reader, p.headerLen))
 	}
 
 	return
 }
 
+func (p *fileConsumer) newReader(r io.Reader) *bufio.Reader {
+	if p.rcfg.DecompressBufferSize != 0 {
+		return bufio.NewReaderSize(r, p.rcfg.DecompressBufferSize)
+	}
+	return bufio.NewReader(r)
+}
+
+//skipFile moves consumer position past the file without opening it
+func (p *fileConsumer) skipFile(dir string, nextFn string) {
+	p.name = dir + nextFn
+	if p.cfg.FileGeneration {
+		p.generation, p.err = p.fileGeneration(filepath.Dir(dir), nextFn)
+	}
+	p.fileOpened()
+}
+
 func (p *fileConsumer) openFile(nextFn string, offset int64) {
 	dir := filepath.Dir(p.topicPath(p.topic)) + "/"
+
+	if p.hooks != nil {
+		if err := p.hooks.OnFileOpen(dir + nextFn); err != nil {
+			log.Debugf("Skipping file %v: %v", dir+nextFn, err)
+			p.skipFile(dir, nextFn)
+			return
+		}
+	}
+
+	var claimed bool
+	if claimed, p.err = p.claimLease(dir + nextFn); log.E(p.err) {
+		return
+	}
+	if !claimed {
+		log.Debugf("Skipping file %v: leased by other consumer", dir+nextFn)
+		p.skipLeased(dir + nextFn)
+		p.skipFile(dir, nextFn)
+		return
+	}
+
+	if p.rcfg, p.recLimit, p.err = boundedConfig(p.cfg); log.E(p.err) {
+		return
+	}
+	p.frags.limit = p.recLimit
+
 	p.file, p.err = p.fs.OpenRead(dir+nextFn, 0)
 	if log.E(p.err) {
 		return
@@ -976,7 +1511,26 @@ func (p *fileConsumer) openFile(nextFn string, offset int64) {
 		}
 	}()
 
-	p.reader = bufio.NewReader(p.file)
+	p.reader = p.newFileReader(p.countReader(p.file, 0))
+
+	p.headerLen = 0
+	if hasHeader(&p.cfg) || hasBinaryHeader(p.reader) {
+		var h *Header
+		h, p.headerLen, p.err = readHeader(p.reader)
+		if log.E(p.err) {
+			return
+		}
+		if h.Format == "" {
+			h.Format = p.header.Format
+		} else {
+			atomic.StoreInt64(&p.text, 0)
+		}
+		p.header = *h
+		p.generation = h.Generation
+		if p.err = p.headerCompression(h); log.E(p.err) {
+			return
+		}
+	}
 
 	p.header.Delimited = p.cfg.FileDelimited
 
@@ -996,38 +1550,55 @@ func (p *fileConsumer) openFile(nextFn string, offset int64) {
 		if log.E(p.err) {
 			return
 		}
-		p.reader = bufio.NewReader(p.file)
+		p.reader = p.newFileReader(p.countReader(p.file, offset))
 	}
 
 	p.name = dir + nextFn
+	p.recs = 0
 
 	p.err = p.openFileInitFilter()
 	if p.err != nil {
 		return
 	}
 
+	p.fileOpened()
+
 	p.metrics.FilesOpened.Inc(1)
 	log.Debugf("Consumer opened: %v, header: %+v", p.name, p.header)
 }
 
 func (p *fileConsumer) writeMessage() {
+	defer func() {
+		if e, ok := p.err.(*RecordTooLargeError); ok {
+			e.File = p.name
+		}
+	}()
 	if atomic.LoadInt64(&p.text) == 0 {
-		msg := make([]byte, 4)
-		_, p.err = p.reader.Read(msg)
-		if p.err == nil {
-			p.msg = make([]byte, binary.LittleEndian.Uint32(msg))
-			_, p.err = io.ReadFull(p.reader, p.msg)
-			/*
-				if p.err == nil {
-					log.Debugf("Consumed message: %x %p", p.msg, &p.baseConsumer)
-				}
-			*/
+		for {
+			var b []byte
+			var idx, total uint32
+			b, idx, total, p.err = readFrameLimit(p.reader, p.recLimit)
+			if p.err == io.EOF && p.frags.pending() {
+				p.err = fmt.Errorf("file ended in the middle of fragmented record: %v", p.name)
+				p.frags.reset()
+			}
+			if p.err != nil {
+				return
+			}
+			p.msg, p.err = p.frags.add(b, idx, total)
+			if p.err == nil && p.msg != nil {
+				p.validateUTF8()
+			}
+			if p.err != nil || p.msg != nil {
+				return
+			}
 		}
 	} else {
-		p.msg, p.err = p.reader.ReadBytes(delimiter)
+		p.msg, p.err = readDelimited(p.reader, p.recLimit)
 		if p.err == nil {
 			p.msg = p.msg[:len(p.msg)-1]
 			//log.Debugf("Consumed message: %x %p", p.msg, &p.baseConsumer)
+			p.validateUTF8()
 		}
 	}
 }
@@ -1036,12 +1607,23 @@ func (p *fileConsumer) fetchNextLow() bool {
 	//reader and file can be nil when directory is empty during
 	//NewConsumer
 	if p.reader != nil {
+		if p.err = p.checkLease(); p.err != nil {
+			return true
+		}
+		start := p.position()
 		p.writeMessage()
 		if p.err == nil {
+			p.loc = RecordLocation{File: filepath.Base(p.name), Offset: start, Index: p.recs}
+			p.recordRead()
 			return true
 		}
 
-		if p.err != io.EOF && (!p.cfg.Compression || p.err != io.ErrUnexpectedEOF) {
+		//Only the end of the compressed stream can be unexpected
+		compressed := p.rcfg.Compression || (p.cfg.ExternalIngest && hadoopCodec(p.name) != nil)
+		if p.err != io.EOF && (!compressed || p.err != io.ErrUnexpectedEOF) {
+			if p.skipRemovedFile() {
+				return false
+			}
 			log.E(p.err)
 			return true
 		}
@@ -1062,6 +1644,12 @@ func (p *fileConsumer) fetchNextLow() bool {
 
 		log.Debugf("Consumer closed: %v", p.name)
 
+		p.completeLease()
+
+		if p.hooks != nil {
+			p.hooks.OnFileClose(p.name)
+		}
+
 		if atomic.LoadInt64(&p.text) == 1 && p.cfg.FileDelimited && len(p.msg) != 0 {
 			p.err = fmt.Errorf("corrupted file. Not ending with delimiter: %v %v", p.name, string(p.msg))
 			return true
@@ -1076,13 +1664,16 @@ func (p *fileConsumer) fetchNextLow() bool {
 func (p *fileConsumer) fetchNext() (interface{}, error) {
 	for {
 		if p.fetchNextLow() {
-			return p.msg, p.err
+			if p.beforeSeekTime() {
+				continue
+			}
+			return p.message(), p.err
 		}
 		if !p.waitAndOpenNextFile() {
 			return nil, p.err
 		}
 		if p.err != nil {
-			return p.msg, p.err
+			return p.message(), p.err
 		}
 	}
 }
@@ -1091,13 +1682,16 @@ func (p *fileConsumer) fetchNext() (interface{}, error) {
 func (p *fileConsumer) fetchNextPoll() (interface{}, error) {
 	for {
 		if p.fetchNextLow() {
-			return p.msg, p.err
+			if p.beforeSeekTime() {
+				continue
+			}
+			return p.message(), p.err
 		}
 		if !p.waitAndOpenNextFilePoll() {
 			return nil, p.err
 		}
 		if p.err != nil {
-			return p.msg, p.err
+			return p.message(), p.err
 		}
 	}
 }
@@ -1114,6 +1708,18 @@ func (p *fileConsumer) close(graceful bool) (err error) {
 	if p.file != nil {
 		err = p.file.Close()
 		log.E(err)
+		if p.hooks != nil {
+			p.hooks.OnFileClose(p.name)
+		}
+	}
+	p.releaseLease()
+	if e := p.deadLetter.close(graceful); log.E(e) {
+		err = e
+	}
+	if graceful {
+		if e := p.commitOffset(); log.E(e) {
+			err = e
+		}
 	}
 	return err
 }
@@ -1128,8 +1734,10 @@ func (p *fileConsumer) CloseOnFailure() error {
 	return p.close(false)
 }
 
+//SaveOffset commits the position after the last delivered record, if start
+//policy is configured
 func (p *fileConsumer) SaveOffset() error {
-	return nil
+	return p.commitOffset()
 }
 
 func (p *fileConsumer) SetFormat(format string) {
@@ -1186,6 +1794,34 @@ func (p *fileFS) OpenWrite(name string) (flushWriteCloser, io.Seeker, error) {
 	return &fileWriter{f}, f, err
 }
 
+func (p *fileFS) SyncDir(name string) error {
+	d, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if err1 := d.Close(); err == nil {
+		err = err1
+	}
+	return err
+}
+
+func (p *fileFS) CreateExclusive(name string) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func (p *fileFS) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (p *fileFS) Chtimes(name string, mtime time.Time) error {
+	return os.Chtimes(name, mtime, mtime)
+}
+
 func (p *fileFS) Remove(path string) error {
 	return os.Remove(path)
 }
@@ -6,7 +6,9 @@ import (
 	"io/ioutil"
 	"os"
 	"regexp"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/ProtonMail/go-crypto/openpgp"
 	"github.com/ProtonMail/go-crypto/openpgp/armor"
@@ -28,7 +30,7 @@ func deleteTestTopics(t *testing.T) {
 }
 
 func initTestFilePipe(pcfg *config.PipeConfig, encryption bool, t *testing.T) *filePipe {
-	fp := &filePipe{datadir: baseDir, cfg: *pcfg}
+	fp := &filePipe{datadir: baseDir, cfg: *pcfg, fs: &fileFS{}}
 	if encryption {
 		fp.cfg.Encryption.Enabled = true
 		fp.cfg.Encryption.PublicKey, fp.cfg.Encryption.PrivateKey = genTestKeys(t)
@@ -64,11 +66,11 @@ func TestFileSmall(t *testing.T) {
 	testFileBasic(&pcfg, false, t)
 }
 
-/*
 func TestFileHeader(t *testing.T) {
 	deleteTestTopics(t)
 
 	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	fp.cfg.FileHeader = true
 
 	p, err := fp.NewProducer("header-test-topic")
 	require.NoError(t, err)
@@ -101,12 +103,10 @@ func TestFileHeader(t *testing.T) {
 	h := c.(*fileConsumer).header
 	require.Equal(t, "json", h.Format)
 	require.Equal(t, "schema-to-test-header", string(h.Schema))
-	//	require.Equal(t, "79272b31d679f9ff72457002da87e985dad203a281ac84de6b5420631f9cb17c", h.HMAC)
-	require.Equal(t, "0000000000000000000000000000000000000000000000000000000000000000", h.HMAC)
+	require.Empty(t, h.Filters)
 
 	require.NoError(t, c.Close())
 }
-*/
 
 func TestFileBinary(t *testing.T) {
 	deleteTestTopics(t)
@@ -349,6 +349,14 @@ func TestFileCompressionAndEncryption(t *testing.T) {
 	testFileBasic(&pcfg, true, t)
 }
 
+func TestFileHeaderCompressionAndEncryption(t *testing.T) {
+	pcfg := cfg.Pipe
+	pcfg.MaxFileSize = 1
+	pcfg.Compression = true
+	pcfg.FileHeader = true
+	testFileBasic(&pcfg, true, t)
+}
+
 func TestFileNoHeader(t *testing.T) {
 	pcfg := cfg.Pipe
 	pcfg.MaxFileSize = 1
@@ -368,6 +376,9 @@ func TestFilePartitionKey(t *testing.T) {
 func TestFileDumpStat(t *testing.T) {
 	deleteTestTopics(t)
 
+	timeNow = func() time.Time { return time.Unix(1568094981, 0) }
+	defer func() { timeNow = time.Now }()
+
 	fp := initTestFilePipe(&cfg.Pipe, false, t)
 	fp.cfg.EndOfStreamMark = true
 	fp.cfg.MaxFileSize = 1
@@ -387,5 +398,82 @@ func TestFileDumpStat(t *testing.T) {
 	require.NoError(t, err)
 	re := regexp.MustCompile(`/(.\d+)\.`)
 	s := re.ReplaceAllString(string(b), "/1568094981.")
-	require.Equal(t, `[{"NumRecs":1,"Hash":"1659724ce4460a14d8ddb1d370191fc73efeac3ba7a0ce067998e25c35c2aab4","FileName":"/tmp/storagetapper/file_pipe_test/header-test-topic/1568094981.001.default"},{"NumRecs":1,"Hash":"cadc2e6510f196d15b82a777ca85cd639ab54002bf10bb90606fc2be0129358a","FileName":"/tmp/storagetapper/file_pipe_test/header-test-topic/1568094981.002.default"}]`, s)
+	require.Equal(t, `[{"NumRecs":1,"Hash":"1659724ce4460a14d8ddb1d370191fc73efeac3ba7a0ce067998e25c35c2aab4","FileName":"/tmp/storagetapper/file_pipe_test/header-test-topic/1568094981.001.default","MinTimestamp":1568094981,"MaxTimestamp":1568094981},{"NumRecs":1,"Hash":"cadc2e6510f196d15b82a777ca85cd639ab54002bf10bb90606fc2be0129358a","FileName":"/tmp/storagetapper/file_pipe_test/header-test-topic/1568094981.002.default","MinTimestamp":1568094981,"MaxTimestamp":1568094981}]`, s)
+}
+
+func produceTestMsgs(t testing.TB, p Pipe, topic string, msgs []string) {
+	pr, err := p.NewProducer(topic)
+	require.NoError(t, err)
+	for _, m := range msgs {
+		require.NoError(t, pr.Push([]byte(m)))
+	}
+	require.NoError(t, pr.Close())
+}
+
+//consumeTestMsgs reads the topic from the beginning till the end.
+//Requires NonBlocking pipe config.
+//Helpers use default binary format, because consumer starts reading existing
+//files before SetFormat can be called
+func consumeTestMsgs(t testing.TB, p Pipe, topic string) []string {
+	saveOffset := InitialOffset
+	InitialOffset = OffsetOldest
+	defer func() { InitialOffset = saveOffset }()
+
+	c, err := p.NewConsumer(topic)
+	require.NoError(t, err)
+
+	res := make([]string, 0)
+	for {
+		m, err := c.FetchNext()
+		require.NoError(t, err)
+		if m == nil {
+			break
+		}
+		res = append(res, string(m.([]byte)))
+	}
+	require.NoError(t, c.Close())
+
+	return res
+}
+
+//syncRecordingFS records renames and directory syncs
+type syncRecordingFS struct {
+	fileFS
+	ops []string
+}
+
+func (f *syncRecordingFS) Rename(oldpath, newpath string) error {
+	f.ops = append(f.ops, "rename "+newpath)
+	return f.fileFS.Rename(oldpath, newpath)
+}
+
+func (f *syncRecordingFS) SyncDir(name string) error {
+	f.ops = append(f.ops, "sync "+name)
+	return f.fileFS.SyncDir(name)
+}
+
+func TestFileSyncOnRotate(t *testing.T) {
+	deleteTestTopics(t)
+
+	topic := "sync-test-topic/"
+
+	for _, sync := range []bool{false, true} {
+		fp := initTestFilePipe(&cfg.Pipe, false, t)
+		fs := &syncRecordingFS{}
+		fp.fs = fs
+		fp.cfg.SyncOnRotate = sync
+
+		p, err := fp.NewProducer(topic)
+		require.NoError(t, err)
+		require.NoError(t, p.Push([]byte("msg")))
+		require.NoError(t, p.Close())
+
+		require.NotEmpty(t, fs.ops)
+		require.True(t, strings.HasPrefix(fs.ops[0], "rename "+baseDir+"/"+topic))
+		if sync {
+			require.Equal(t, []string{fs.ops[0], "sync " + baseDir + "/sync-test-topic"}, fs.ops)
+		} else {
+			require.Equal(t, 1, len(fs.ops))
+		}
+	}
 }
@@ -0,0 +1,26 @@
+package pipe
+
+import (
+	"time"
+
+	"github.com/uber/storagetapper/log"
+)
+
+//finalizeLog is the logger of the file finalize outcome
+var finalizeLog = log.WithFields
+
+//finalized reports metrics and logs the outcome of the file finalize, which
+//closes the file and renames it from .open, making it visible to consumers
+func (p *fileProducer) finalized(f *file, name string, d time.Duration, err error) {
+	p.metrics.FinalizeDuration.Record(d)
+
+	l := finalizeLog(log.Fields{"topic": p.topic, "file": name, "size": f.compressedSize, "records": f.nRecs, "duration": d.String()})
+	if err != nil {
+		p.metrics.FinalizeErrors.Inc(1)
+		l.Errorf("Finalize failed: %v", err)
+		return
+	}
+
+	p.metrics.FilesFinalized.Inc(1)
+	l.Infof("Finalized")
+}
@@ -0,0 +1,100 @@
+package pipe
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/uber/storagetapper/log"
+)
+
+//failingRenameFS fails finalize rename
+type failingRenameFS struct {
+	fileFS
+}
+
+func (f *failingRenameFS) Rename(oldpath, newpath string) error {
+	return fmt.Errorf("rename failure")
+}
+
+//finalizeTestLog captures fields of the finalize log lines
+type finalizeTestLog struct {
+	log.Logger
+	fields []log.Fields
+	errors int
+}
+
+func (l *finalizeTestLog) capture(f log.Fields) log.Logger {
+	l.fields = append(l.fields, f)
+	return l
+}
+
+func (l *finalizeTestLog) Infof(format string, args ...interface{}) {}
+
+func (l *finalizeTestLog) Errorf(format string, args ...interface{}) {
+	l.errors++
+}
+
+func TestFinalizeMetrics(t *testing.T) {
+	deleteTestTopics(t)
+
+	l := &finalizeTestLog{}
+	finalizeLog = l.capture
+	defer func() { finalizeLog = log.WithFields }()
+
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	fp.cfg.MaxFileDataSize = 10
+
+	pr, err := fp.NewProducer("finalize-test-topic/")
+	require.NoError(t, err)
+	p := pr.(*fileProducer)
+
+	require.NoError(t, p.PushBatch("key", []byte("0123456")))
+	require.NoError(t, p.PushBatch("key", []byte("789")))
+	//File is rotated on size limit
+	require.NoError(t, p.PushBatchCommit())
+
+	require.Equal(t, int64(1), p.metrics.FilesFinalized.Get())
+	require.Equal(t, int64(0), p.metrics.FinalizeErrors.Get())
+	require.True(t, p.metrics.FinalizeDuration.Last() > 0)
+
+	require.Equal(t, 1, len(l.fields))
+	f := l.fields[0]
+	require.Equal(t, "finalize-test-topic/", f["topic"])
+	require.Regexp(t, `finalize-test-topic/[0-9]+\.[0-9]+\.key$`, f["file"])
+	require.Equal(t, int64(2*4+10), f["size"])
+	require.Equal(t, int64(2), f["records"])
+	require.NotEmpty(t, f["duration"])
+	require.Equal(t, 0, l.errors)
+
+	require.NoError(t, p.Close())
+}
+
+func TestFinalizeFailure(t *testing.T) {
+	deleteTestTopics(t)
+
+	l := &finalizeTestLog{}
+	finalizeLog = l.capture
+	defer func() { finalizeLog = log.WithFields }()
+
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	fp.fs = &failingRenameFS{}
+	fp.cfg.MaxFileDataSize = 1
+	fp.cfg.CircuitBreakerThreshold = 2
+
+	pr, err := fp.NewProducer("finalize-failure-test-topic/")
+	require.NoError(t, err)
+	p := pr.(*fileProducer)
+
+	//Finalize failures are returned from the push and trip the breaker
+	require.Error(t, p.Push([]byte("msg1")))
+	require.Error(t, p.Push([]byte("msg2")))
+	require.Equal(t, ErrCircuitOpen, p.Push([]byte("msg3")))
+
+	require.Equal(t, int64(0), p.metrics.FilesFinalized.Get())
+	require.Equal(t, int64(2), p.metrics.FinalizeErrors.Get())
+	require.Equal(t, 2, l.errors)
+	require.Equal(t, int64(1), l.fields[0]["records"])
+
+	require.NoError(t, p.CloseOnFailure())
+}
@@ -0,0 +1,55 @@
+package pipe
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFlushEveryRecords(t *testing.T) {
+	deleteTestTopics(t)
+
+	topic := "flush-records-test/"
+
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	fp.cfg.NonBlocking = true
+	fp.cfg.FlushEveryRecords = 3
+
+	p, err := fp.NewProducer(topic)
+	require.NoError(t, err)
+
+	size := func() int64 {
+		names := oneRecordTestFiles(t, fp, topic)
+		require.Equal(t, 1, len(names))
+		require.Regexp(t, `\.open$`, names[0])
+		st, err := os.Stat(names[0])
+		require.NoError(t, err)
+		return st.Size()
+	}
+
+	msgs := []string{"first", "second", "third", "fourth", "fifth", "sixth", "seventh"}
+
+	require.NoError(t, p.PushBatch("default", []byte(msgs[0])))
+	initial := size()
+	require.NoError(t, p.PushBatch("default", []byte(msgs[1])))
+	require.Equal(t, initial, size())
+
+	//Third record flushes the buffer, without rotating the file
+	require.NoError(t, p.PushBatch("default", []byte(msgs[2])))
+	flushed := size()
+	require.Equal(t, initial+int64(4*3+len(msgs[0])+len(msgs[1])+len(msgs[2])), flushed)
+
+	//Counter restarts after the flush
+	require.NoError(t, p.PushBatch("default", []byte(msgs[3])))
+	require.NoError(t, p.PushBatch("default", []byte(msgs[4])))
+	require.Equal(t, flushed, size())
+	require.NoError(t, p.PushBatch("default", []byte(msgs[5])))
+	require.Equal(t, flushed+int64(4*3+len(msgs[3])+len(msgs[4])+len(msgs[5])), size())
+
+	require.NoError(t, p.PushBatch("default", []byte(msgs[6])))
+	require.NoError(t, p.PushBatchCommit())
+	require.NoError(t, p.Close())
+
+	require.Equal(t, msgs, consumeTestMsgs(t, fp, topic))
+}
@@ -0,0 +1,122 @@
+package pipe
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+//topicGeneration is persisted in the state of the topic, so as generations
+//keep increasing across producer restarts
+type topicGeneration struct {
+	Generation uint64
+}
+
+func generationFileName(tp string) string {
+	return metaFileName(tp, "generation")
+}
+
+//nextGeneration returns generation for the new file of the topic.
+//Generation is allocated atomically, so as concurrent producers of the topic
+//never get the same generation. Generation is persisted before it's used, so
+//it's never reused even if producer crashes
+func (p *fileProducer) nextGeneration() (uint64, error) {
+	var g topicGeneration
+	err := p.updateState(p.fs, generationFileName(p.topicPath(p.topic)), &g, func() { g.Generation++ })
+	if err != nil {
+		return 0, err
+	}
+	return g.Generation, nil
+}
+
+//fileGeneration returns generation from the file header. Generations of
+//finalized files are cached. Files which header can't be read yet are ordered
+//last
+func (p *fileConsumer) fileGeneration(dir string, name string) (uint64, error) {
+	if g, ok := p.generations[name]; ok {
+		return g, nil
+	}
+
+	h, err := readFileHeader(p.fs, dir+"/"+name)
+	if err != nil {
+		if strings.HasSuffix(name, ".open") {
+			return math.MaxUint64, nil
+		}
+		return 0, err
+	}
+
+	if !strings.HasSuffix(name, ".open") {
+		if p.generations == nil {
+			p.generations = make(map[string]uint64)
+		}
+		p.generations[name] = h.Generation
+	}
+
+	return h.Generation, nil
+}
+
+//sortByGeneration returns data files of the topic ordered by generation
+func (p *fileConsumer) sortByGeneration(tp string, files []os.FileInfo) ([]os.FileInfo, error) {
+	dir := filepath.Dir(tp)
+	res := make([]os.FileInfo, 0, len(files))
+	for _, f := range files {
+		if strings.HasPrefix(dir+"/"+f.Name(), tp) && !f.IsDir() && !isMetaFile(f.Name()) {
+			res = append(res, f)
+		}
+	}
+
+	g := make([]uint64, len(res))
+	for i, f := range res {
+		var err error
+		if g[i], err = p.fileGeneration(dir, f.Name()); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Sort(&generationSort{res, g})
+
+	return res, nil
+}
+
+type generationSort struct {
+	files []os.FileInfo
+	gens  []uint64
+}
+
+func (s *generationSort) Len() int {
+	return len(s.files)
+}
+
+func (s *generationSort) Less(i, j int) bool {
+	if s.gens[i] != s.gens[j] {
+		return s.gens[i] < s.gens[j]
+	}
+	return s.files[i].Name() < s.files[j].Name()
+}
+
+func (s *generationSort) Swap(i, j int) {
+	s.files[i], s.files[j] = s.files[j], s.files[i]
+	s.gens[i], s.gens[j] = s.gens[j], s.gens[i]
+}
+
+//nextFileByGeneration returns the file with the smallest generation greater
+//than generation of the current file
+func (p *fileConsumer) nextFileByGeneration(tp string, files []os.FileInfo, restart bool) (string, error) {
+	files, err := p.sortByGeneration(tp, files)
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Dir(tp)
+	for _, f := range files {
+		g, err := p.fileGeneration(dir, f.Name())
+		if err != nil {
+			return "", err
+		}
+		if restart || g > p.generation {
+			return f.Name(), nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,138 @@
+package pipe
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/uber/storagetapper/state"
+	"github.com/uber/storagetapper/util"
+)
+
+func TestFileGenerationClockRegression(t *testing.T) {
+	deleteTestTopics(t)
+
+	topic := "generation-test-topic/"
+
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	fp.cfg.FileGeneration = true
+	fp.cfg.NonBlocking = true
+	fp.cfg.MaxFileSize = 1 //rotate on every message
+
+	defer func() { timeNow = time.Now }()
+
+	//Clock goes backwards after the first file and once again on restart
+	clock := []int64{2000, 1000, 1000}
+	msgs := []string{"first", "second", "third", "fourth"}
+
+	p, err := fp.NewProducer(topic)
+	require.NoError(t, err)
+	for i, ts := range clock {
+		ts := ts
+		timeNow = func() time.Time { return time.Unix(ts, 0) }
+		require.NoError(t, p.Push([]byte(msgs[i])))
+	}
+	require.NoError(t, p.Close())
+
+	timeNow = func() time.Time { return time.Unix(500, 0) }
+	produceTestMsgs(t, fp, topic, msgs[3:])
+
+	files, err := ioutil.ReadDir(baseDir + "/" + topic)
+	require.NoError(t, err)
+	gens := make([]uint64, 0)
+	for _, f := range files {
+		if !isMetaFile(f.Name()) {
+			h, err := readFileHeader(fp.fs, baseDir+"/"+topic+f.Name())
+			require.NoError(t, err)
+			gens = append(gens, h.Generation)
+		}
+	}
+	//Order of the file names doesn't match production order
+	require.Equal(t, []uint64{4, 2, 3, 1}, gens)
+
+	require.Equal(t, msgs, consumeTestMsgs(t, fp, topic))
+
+	//Without generations files are consumed in the file names order
+	fp.cfg.FileGeneration = false
+	fp.cfg.FileHeader = true
+	require.Equal(t, []string{"fourth", "second", "third", "first"}, consumeTestMsgs(t, fp, topic))
+}
+
+func TestFileGenerationConcurrentProducers(t *testing.T) {
+	deleteTestTopics(t)
+
+	topic := "generation-test-topic/"
+
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	fp.cfg.FileGeneration = true
+	fp.cfg.NonBlocking = true
+	fp.cfg.OneRecordPerFile = true
+
+	var wg sync.WaitGroup
+	errCh := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			p, err := fp.NewProducer(topic)
+			if err != nil {
+				errCh <- err
+				return
+			}
+			p.(*fileProducer).seqno = i * 100
+			for j := 0; j < 5 && err == nil; j++ {
+				err = p.PushK("key", []byte(fmt.Sprintf("producer %v record %v", i, j)))
+			}
+			if err == nil {
+				err = p.Close()
+			}
+			errCh <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errCh)
+	for err := range errCh {
+		require.NoError(t, err)
+	}
+
+	//Every file has got its own generation
+	gens := make(map[uint64]bool)
+	for _, n := range oneRecordTestFiles(t, fp, topic) {
+		h, err := readFileHeader(fp.fs, n)
+		require.NoError(t, err)
+		require.False(t, gens[h.Generation], n)
+		gens[h.Generation] = true
+	}
+	require.Equal(t, 20, len(gens))
+	for g := uint64(1); g <= 20; g++ {
+		require.True(t, gens[g])
+	}
+
+	_, err := os.Stat(generationFileName(topicPath(fp.datadir, topic)) + ".lock")
+	require.True(t, os.IsNotExist(err))
+
+	//File which header can't be read fails the consumer
+	require.NoError(t, ioutil.WriteFile(baseDir+"/"+topic+"0000000001.broken.gz", []byte("broken"), 0644))
+	_, err = fp.NewConsumer(topic)
+	require.Error(t, err)
+}
+
+func TestFileGenerationStateSQL(t *testing.T) {
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	fp.db, fp.statePrefix = state.GetDB(), "file://"
+	require.NoError(t, initState(fp.db))
+
+	p := &fileProducer{filePipe: fp, topic: "generation-test-topic/", fs: fp.fs}
+	name := fp.stateKey(generationFileName(p.topicPath(p.topic)))
+	require.NoError(t, util.ExecSQL(fp.db, "DELETE FROM file_pipe_state WHERE name=?", name))
+
+	for i := uint64(1); i <= 3; i++ {
+		g, err := p.nextGeneration()
+		require.NoError(t, err)
+		require.Equal(t, i, g)
+	}
+}
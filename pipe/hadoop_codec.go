@@ -0,0 +1,114 @@
+package pipe
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/golang/snappy"
+)
+
+//hadoopCodecs maps file extensions to the decompressors of the data produced
+//by Hadoop codecs, the same way Hadoop's CompressionCodecFactory does
+var hadoopCodecs = map[string]func(io.Reader) (io.Reader, error){
+	//GzipCodec. Gzip reader also handles concatenated gzip members
+	".gz": func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+	//DefaultCodec
+	".deflate": func(r io.Reader) (io.Reader, error) { return zlib.NewReader(r) },
+	//SnappyCodec uses block compressor stream framing
+	".snappy": func(r io.Reader) (io.Reader, error) {
+		return &hadoopBlockReader{r: r, decode: func(src []byte) ([]byte, error) { return snappy.Decode(nil, src) }}, nil
+	},
+}
+
+//unsupportedHadoopCodecs maps file extensions of the Hadoop codecs, which
+//can't be decompressed, to the codec names. Such files fail to open instead
+//of being read as uncompressed data
+var unsupportedHadoopCodecs = map[string]string{
+	".lzo":         "LzopCodec",
+	".lzo_deflate": "LzoCodec",
+	".bz2":         "BZip2Codec",
+	".lz4":         "Lz4Codec",
+}
+
+//hadoopCodec returns the decompressor for the externally produced file or nil
+//if file extension is not recognized
+func hadoopCodec(name string) func(io.Reader) (io.Reader, error) {
+	ext := filepath.Ext(name)
+	if codec, ok := unsupportedHadoopCodecs[ext]; ok {
+		return func(io.Reader) (io.Reader, error) {
+			return nil, fmt.Errorf("unsupported Hadoop codec %v of the file: %v", codec, name)
+		}
+	}
+	return hadoopCodecs[ext]
+}
+
+//hadoopBlockReader decodes Hadoop's BlockCompressorStream framing:
+//the stream is a sequence of blocks, each block is 4 bytes big endian
+//uncompressed block length followed by one or more compressed chunks, each
+//prepended by its 4 bytes big endian compressed length
+type hadoopBlockReader struct {
+	r         io.Reader
+	decode    func(src []byte) ([]byte, error)
+	buf       []byte
+	remaining int //uncompressed bytes remaining in current block
+}
+
+func (h *hadoopBlockReader) readLen() (int, error) {
+	var b [4]byte
+	if _, err := io.ReadFull(h.r, b[:]); err != nil {
+		return 0, err
+	}
+	return int(binary.BigEndian.Uint32(b[:])), nil
+}
+
+func (h *hadoopBlockReader) nextChunk() error {
+	for h.remaining == 0 {
+		n, err := h.readLen()
+		if err != nil {
+			return err //clean io.EOF on block boundary is the end of stream
+		}
+		h.remaining = n
+	}
+
+	n, err := h.readLen()
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return err
+	}
+
+	src := make([]byte, n)
+	if _, err := io.ReadFull(h.r, src); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	if h.buf, err = h.decode(src); err != nil {
+		return err
+	}
+
+	if len(h.buf) > h.remaining {
+		return fmt.Errorf("corrupted block: chunk decompressed size %v exceeds remaining block size %v", len(h.buf), h.remaining)
+	}
+	h.remaining -= len(h.buf)
+
+	return nil
+}
+
+func (h *hadoopBlockReader) Read(b []byte) (int, error) {
+	for len(h.buf) == 0 {
+		if err := h.nextChunk(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(b, h.buf)
+	h.buf = h.buf[n:]
+	return n, nil
+}
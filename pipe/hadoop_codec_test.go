@@ -0,0 +1,164 @@
+package pipe
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/stretchr/testify/require"
+)
+
+//hadoopGzip produces the output of Hadoop GzipCodec, where every output
+//of the reducer is separate gzip member
+func hadoopGzip(t *testing.T, parts ...string) []byte {
+	var b bytes.Buffer
+	for _, p := range parts {
+		w := gzip.NewWriter(&b)
+		_, err := w.Write([]byte(p))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+	}
+	return b.Bytes()
+}
+
+//hadoopSnappy produces block compressor stream framing with one block per
+//part, every block split into chunks of chunkSize
+func hadoopSnappy(parts []string, chunkSize int) []byte {
+	var b bytes.Buffer
+	l := make([]byte, 4)
+	for _, p := range parts {
+		binary.BigEndian.PutUint32(l, uint32(len(p)))
+		b.Write(l)
+		for i := 0; i < len(p); i += chunkSize {
+			e := i + chunkSize
+			if e > len(p) {
+				e = len(p)
+			}
+			c := snappy.Encode(nil, []byte(p[i:e]))
+			binary.BigEndian.PutUint32(l, uint32(len(c)))
+			b.Write(l)
+			b.Write(c)
+		}
+	}
+	return b.Bytes()
+}
+
+func writeExternalFile(t *testing.T, name string, data []byte) {
+	require.NoError(t, ioutil.WriteFile(name+".open", data, 0644))
+	require.NoError(t, os.Rename(name+".open", name))
+}
+
+func TestHadoopCodecConsume(t *testing.T) {
+	deleteTestTopics(t)
+
+	topic := "external-test-topic/"
+	require.NoError(t, os.MkdirAll(baseDir+"/"+topic, 0770))
+
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	fp.cfg.ExternalIngest = true
+
+	saveOffset := InitialOffset
+	InitialOffset = OffsetOldest
+	defer func() { InitialOffset = saveOffset }()
+
+	c, err := fp.NewConsumer(topic)
+	require.NoError(t, err)
+	c.SetFormat("json")
+
+	writeExternalFile(t, baseDir+"/"+topic+"part-00000.gz", hadoopGzip(t, "{\"a\":1}\n{\"a\":2}\n", "{\"a\":3}\n"))
+	writeExternalFile(t, baseDir+"/"+topic+"part-00001.snappy", hadoopSnappy([]string{"{\"b\":1}\n{\"b\":2}\n", "{\"b\":3}\n"}, 5))
+	writeExternalFile(t, baseDir+"/"+topic+"part-00002", []byte("{\"c\":1}\n"))
+
+	for _, m := range []string{`{"a":1}`, `{"a":2}`, `{"a":3}`, `{"b":1}`, `{"b":2}`, `{"b":3}`, `{"c":1}`} {
+		consumeAndCheck(t, c, m)
+	}
+
+	require.NoError(t, c.Close())
+}
+
+func TestExternalIngestTruncated(t *testing.T) {
+	deleteTestTopics(t)
+
+	topic := "external-truncated-test/"
+	require.NoError(t, os.MkdirAll(baseDir+"/"+topic, 0770))
+
+	//Record frame of 100 bytes, truncated after 10 bytes
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, 100)
+	writeExternalFile(t, baseDir+"/"+topic+"part-00000", append(b, []byte(strings.Repeat("x", 10))...))
+
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	fp.cfg.ExternalIngest = true
+	fp.cfg.NonBlocking = true
+
+	saveOffset := InitialOffset
+	InitialOffset = OffsetOldest
+	defer func() { InitialOffset = saveOffset }()
+
+	//Uncompressed file is not taken for the end of compressed stream
+	c, err := fp.NewConsumer(topic)
+	require.NoError(t, err)
+	_, err = c.FetchNext()
+	require.Equal(t, io.ErrUnexpectedEOF, err)
+	require.NoError(t, c.CloseOnFailure())
+}
+
+func TestHadoopBlockReaderCorrupted(t *testing.T) {
+	b := hadoopSnappy([]string{strings.Repeat("data", 100)}, 64)
+
+	fn := hadoopCodec("part-00000.snappy")
+	require.NotNil(t, fn)
+
+	r, err := fn(bytes.NewReader(b))
+	require.NoError(t, err)
+	d, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, strings.Repeat("data", 100), string(d))
+
+	r, err = fn(bytes.NewReader(b[:len(b)-10]))
+	require.NoError(t, err)
+	_, err = ioutil.ReadAll(r)
+	require.Equal(t, io.ErrUnexpectedEOF, err)
+
+	require.Nil(t, hadoopCodec("part-00000"))
+}
+
+func TestHadoopCodecUnsupported(t *testing.T) {
+	//Not taken for DefaultCodec by the suffix
+	for _, n := range []string{"part-00000.lzo", "part-00000.lzo_deflate", "part-00000.bz2"} {
+		fn := hadoopCodec(n)
+		require.NotNil(t, fn, n)
+		_, err := fn(bytes.NewReader(nil))
+		require.Error(t, err, n)
+		require.Contains(t, err.Error(), "unsupported Hadoop codec")
+	}
+
+	deleteTestTopics(t)
+
+	topic := "external-unsupported-test/"
+	require.NoError(t, os.MkdirAll(baseDir+"/"+topic, 0770))
+
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	fp.cfg.ExternalIngest = true
+
+	saveOffset := InitialOffset
+	InitialOffset = OffsetOldest
+	defer func() { InitialOffset = saveOffset }()
+
+	c, err := fp.NewConsumer(topic)
+	require.NoError(t, err)
+	c.SetFormat("json")
+
+	//Not read as uncompressed data
+	writeExternalFile(t, baseDir+"/"+topic+"part-00000.lzo", []byte("{\"a\":1}\n"))
+	_, err = c.FetchNext()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "LzopCodec")
+	require.NoError(t, c.CloseOnFailure())
+}
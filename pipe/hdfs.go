@@ -22,6 +22,7 @@ package pipe
 
 import (
 	"database/sql"
+	"fmt"
 	"io"
 	"os"
 	"strings"
@@ -35,37 +36,100 @@ import (
 )
 
 type hdfsClient struct {
-	*hdfs.Client
+	conn    *hdfsConn
+	backoff BackoffStrategy
+	limiter namenodeLimiter
+	trash   string //removed files are moved to the trash directory, if set
 }
 
 type hdfsWriter struct {
 	*hdfs.FileWriter
+	backoff BackoffStrategy
+	release func() //releases the client the file is opened with
+}
+
+type hdfsReader struct {
+	*hdfs.FileReader
+	release func()
+}
+
+func (p *hdfsReader) Close() error {
+	defer p.release()
+	return p.FileReader.Close()
+}
+
+//namenodeLimiter bounds the number of concurrent namenode (metadata)
+//operations. nil limiter doesn't limit
+type namenodeLimiter chan struct{}
+
+func newNamenodeLimiter(n int) namenodeLimiter {
+	if n <= 0 {
+		return nil
+	}
+	return make(namenodeLimiter, n)
+}
+
+func (l namenodeLimiter) do(fn func() error) error {
+	if l != nil {
+		l <- struct{}{}
+		defer func() { <-l }()
+	}
+	return fn()
+}
+
+func newHdfsClient(conn *hdfsConn, limiter namenodeLimiter) *hdfsClient {
+	return &hdfsClient{conn, hdfsBackoff, limiter, ""}
+}
+
+//do runs namenode operation with the current client of the connection
+func (p *hdfsClient) do(fn func(c *hdfs.Client) error) error {
+	return p.limiter.do(func() error {
+		c, release := p.conn.acquire()
+		defer release()
+		return fn(c)
+	})
 }
 
 func (p *hdfsClient) OpenRead(name string, offset int64) (io.ReadCloser, error) {
-	f, err := p.Client.Open(name)
+	c, release := p.conn.acquire()
+
+	var f *hdfs.FileReader
+	err := p.limiter.do(func() (err error) { f, err = c.Open(name); return })
 	if err != nil {
+		release()
 		return nil, err
 	}
 
 	_, err = f.Seek(offset, io.SeekStart)
 	if err != nil {
+		_ = f.Close()
+		release()
 		return nil, err
 	}
 
-	return f, nil
+	return &hdfsReader{f, release}, nil
 }
 
 func (p *hdfsClient) openWriteLow(name string) (flushWriteCloser, io.Seeker, error) {
-	f, err := p.Client.Append(name)
+	c, release := p.conn.acquire()
+
+	var f *hdfs.FileWriter
+	err := p.limiter.do(func() (err error) {
+		f, err = c.Append(name)
+		if err != nil {
+			f, err = c.Create(name)
+		}
+		return
+	})
 	if err != nil {
-		f, err = p.Client.Create(name)
+		release()
+		return nil, nil, err
 	}
-	return &hdfsWriter{f}, nil, err
+	return &hdfsWriter{f, p.backoff, release}, nil, nil
 }
 
 func (p *hdfsClient) OpenWrite(name string) (fc flushWriteCloser, sc io.Seeker, err error) {
-	return fc, sc, withRetry(func() error { fc, sc, err = p.openWriteLow(name); return err })
+	return fc, sc, withRetry(p.backoff, func() error { fc, sc, err = p.openWriteLow(name); return err })
 }
 
 var retryTimeout = 10 //seconds
@@ -75,33 +139,93 @@ func retriable(err error) bool {
 		strings.Contains(err.Error(), "org.apache.hadoop.ipc.RetriableException")
 }
 
-func withRetry(fn func() error) error {
-	err := fn()
-	for i := 0; err != nil && retriable(err) && i < retryTimeout*10; i++ {
-		time.Sleep(100 * time.Millisecond)
-		err = fn()
-	}
-	return err
-}
+//hdfsBackoff retries namenode failovers every 100ms for retryTimeout seconds
+var hdfsBackoff BackoffStrategy = &FixedBackoff{Delay: 100 * time.Millisecond, MaxAttempts: retryTimeout * 10, Retriable: retriable}
 
 func (p *hdfsClient) MkdirAll(path string, perm os.FileMode) error {
-	return withRetry(func() error { return p.Client.MkdirAll(path, perm) })
+	return withRetry(p.backoff, func() error { return p.do(func(c *hdfs.Client) error { return c.MkdirAll(path, perm) }) })
 }
 
 func (p *hdfsClient) Rename(oldpath, newpath string) error {
-	return withRetry(func() error { return p.Client.Rename(oldpath, newpath) })
+	return retryRename(p.backoff, func() error {
+		return p.do(func(c *hdfs.Client) error { return c.Rename(oldpath, newpath) })
+	}, func() (bool, error) {
+		return p.exists(newpath)
+	})
+}
+
+func (p *hdfsClient) exists(name string) (bool, error) {
+	err := p.do(func(c *hdfs.Client) error { _, err := c.Stat(name); return err })
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+//CreateExclusive is not retried, retry after lost response would fail with
+//os.ErrExist
+func (p *hdfsClient) CreateExclusive(name string) error {
+	return p.do(func(c *hdfs.Client) error { return c.CreateEmptyFile(name) })
+}
+
+func (p *hdfsClient) Stat(name string) (fi os.FileInfo, err error) {
+	return fi, p.do(func(c *hdfs.Client) error { fi, err = c.Stat(name); return err })
+}
+
+func (p *hdfsClient) Chtimes(name string, mtime time.Time) error {
+	return withRetry(p.backoff, func() error {
+		return p.do(func(c *hdfs.Client) error { return c.Chtimes(name, mtime, mtime) })
+	})
 }
 
 func (p *hdfsClient) Remove(path string) error {
-	return withRetry(func() error { return p.Client.Remove(path) })
+	if p.trash != "" {
+		return trashRemove(p, p.trash, path, p.remove)
+	}
+	return p.remove(path)
+}
+
+func (p *hdfsClient) remove(path string) error {
+	return withRetry(p.backoff, func() error { return p.do(func(c *hdfs.Client) error { return c.Remove(path) }) })
+}
+
+//BlockSize returns the block size of the file
+func (p *hdfsClient) BlockSize(name string) (int64, error) {
+	fi, err := p.Stat(name)
+	if err != nil {
+		return 0, err
+	}
+	s, ok := fi.Sys().(*hdfs.FileStatus)
+	if !ok {
+		return 0, fmt.Errorf("no file status of %v", name)
+	}
+	return int64(s.GetBlocksize()), nil
+}
+
+//hdfsConcatClient is implemented by the HDFS client versions exposing the
+//namenode concat
+type hdfsConcatClient interface {
+	Concat(target string, sources []string) error
+}
+
+//Concat merges the files by the namenode concat. Fails if the client doesn't
+//expose concat, so as the files are rewritten instead
+func (p *hdfsClient) Concat(target string, sources []string) error {
+	return p.do(func(c *hdfs.Client) error {
+		cc, ok := interface{}(c).(hdfsConcatClient)
+		if !ok {
+			return fmt.Errorf("concat is not supported by the HDFS client")
+		}
+		return cc.Concat(target, sources)
+	})
 }
 
 func (p *hdfsClient) Cancel(f io.Closer) error {
 	return nil
 }
 
-func (p *hdfsClient) ReadDir(dir string, _ string) ([]os.FileInfo, error) {
-	return p.Client.ReadDir(dir)
+func (p *hdfsClient) ReadDir(dir string, _ string) (files []os.FileInfo, err error) {
+	return files, p.do(func(c *hdfs.Client) error { files, err = c.ReadDir(dir); return err })
 }
 
 func (p *hdfsWriter) Write(b []byte) (int, error) {
@@ -111,8 +235,12 @@ func (p *hdfsWriter) Write(b []byte) (int, error) {
 	for off < len(b) && err == nil {
 		n, err = p.FileWriter.Write(b[off:])
 		off += n
-		for i := 0; err != nil && retriable(err) && i < retryTimeout*10 && off < len(b); i++ {
-			time.Sleep(100 * time.Millisecond)
+		for attempt := 1; err != nil && off < len(b); attempt++ {
+			d, ok := p.backoff.NextDelay(attempt, err)
+			if !ok {
+				break
+			}
+			time.Sleep(d)
 			n, err = p.FileWriter.Write(b[off:])
 			off += n
 		}
@@ -122,16 +250,19 @@ func (p *hdfsWriter) Write(b []byte) (int, error) {
 
 func (p *hdfsWriter) Flush() error {
 	return nil
-	//	return withRetry(func() error { return p.FileWriter.Flush() })
+	//	return withRetry(p.backoff, func() error { return p.FileWriter.Flush() })
 }
 
 func (p *hdfsWriter) Close() error {
-	return withRetry(func() error { return p.FileWriter.Close() })
+	defer p.release()
+	return withRetry(p.backoff, func() error { return p.FileWriter.Close() })
 }
 
 type hdfsPipe struct {
 	filePipe
-	hdfs *hdfs.Client
+	conn *hdfsConn
+	//limiter is shared by all producers and consumers of the pipe
+	limiter namenodeLimiter
 }
 
 // hdfsConsumer consumes messages from Hdfs using topic and partition specified during consumer creation
@@ -144,15 +275,43 @@ func init() {
 }
 
 func initHdfsPipe(cfg *config.PipeConfig, db *sql.DB) (Pipe, error) {
+	if err := validateCompressionConfig(cfg); err != nil {
+		return nil, err
+	}
+
 	cp := hdfs.ClientOptions{User: cfg.Hadoop.User, Addresses: cfg.Hadoop.Addresses}
-	client, err := hdfs.NewClient(cp)
+	conn, err := newHdfsConn(func() (*hdfs.Client, error) { return hdfs.NewClient(cp) })
 	if log.E(err) {
 		return nil, err
 	}
 
 	log.Infof("Connected to HDFS cluster at: %v", cfg.Hadoop.Addresses)
 
-	return &hdfsPipe{filePipe{cfg.Hadoop.BaseDir, *cfg}, client}, nil
+	limiter := newNamenodeLimiter(cfg.Hadoop.MaxConcurrentNamenodeOps)
+
+	conn.limiter = limiter
+	conn.keepAlive(cfg.Hadoop.KeepAliveInterval)
+
+	p := &hdfsPipe{filePipe{datadir: cfg.Hadoop.BaseDir, cfg: *cfg, headers: newHeaderCache(), schemas: newSchemaCache(), db: db, statePrefix: "hdfs://"}, conn, limiter}
+	p.fs = p.newClient()
+
+	return p, nil
+}
+
+//newClient returns a client of the pipe, retrying according to the config
+func (p *hdfsPipe) newClient() *hdfsClient {
+	c := newHdfsClient(p.conn, p.limiter)
+	if p.cfg.Hadoop.NoRetry {
+		c.backoff = NoRetry
+	}
+	if p.cfg.Hadoop.UseTrash {
+		user := p.cfg.Hadoop.User
+		if user == "" {
+			user = p.conn.get().User()
+		}
+		c.trash = hdfsTrashDir(user)
+	}
+	return c
 }
 
 // Type returns Pipe type as Hdfs
@@ -162,19 +321,38 @@ func (p *hdfsPipe) Type() string {
 
 // Close releases resources associated with the pipe
 func (p *hdfsPipe) Close() error {
-	return p.hdfs.Close()
+	return p.conn.close()
 }
 
 //NewProducer registers a new sync producer
 func (p *hdfsPipe) NewProducer(topic string) (Producer, error) {
+	fp, err := p.withTopicCompression(topic)
+	if err != nil {
+		return nil, err
+	}
+	enc, err := fp.encryptTopic(topic)
+	if err != nil {
+		return nil, err
+	}
+	schema, err := p.topicSchema(topic)
+	if err != nil {
+		return nil, err
+	}
+	c := p.newClient()
+	if err := p.checkNotifier(c); err != nil {
+		return nil, err
+	}
 	m := metrics.NewFilePipeMetrics("pipe_producer", map[string]string{"topic": topic, "pipeType": "hdfs"})
-	return &fileProducer{filePipe: &p.filePipe, topic: topic, files: make(map[string]*file), fs: &hdfsClient{p.hdfs}, metrics: m, stats: make(map[string]*stat)}, nil
+	return &fileProducer{filePipe: fp, topic: topic, files: make(map[string]*file), fs: c, metrics: m, stats: make(map[string]*stat), encrypted: enc, schema: schema}, nil
 }
 
 //NewConsumer registers a new hdfs consumer with context
 func (p *hdfsPipe) NewConsumer(topic string) (Consumer, error) {
 	m := metrics.NewFilePipeMetrics("pipe_consumer", map[string]string{"topic": topic, "pipeType": "hdfs"})
-	c := &hdfsConsumer{fileConsumer{filePipe: &p.filePipe, topic: topic, fs: &hdfsClient{p.hdfs}, metrics: m}}
+	if p.cfg.ParallelReaders > 1 {
+		return p.newParallelConsumer(topic, p.newClient(), m)
+	}
+	c := &hdfsConsumer{fileConsumer{filePipe: &p.filePipe, topic: topic, fs: p.newClient(), metrics: m}}
 	_, err := p.initConsumer(&c.fileConsumer, c.fetchNextPoll)
 	return c, err
 }
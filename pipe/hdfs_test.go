@@ -2,9 +2,13 @@ package pipe
 
 import (
 	"os"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/efirs/hdfs/v2"
+	"github.com/stretchr/testify/require"
 	"github.com/uber/storagetapper/test"
 )
 
@@ -74,3 +78,39 @@ func TestHdfsCompressionAndEncryption(t *testing.T) {
 	pubKey, privKey := genTestKeys(t)
 	testHdfsBasic(1, pubKey, privKey, privKey, t)
 }
+
+func TestNamenodeOpsLimit(t *testing.T) {
+	limit := 3
+	l := newNamenodeLimiter(limit)
+	//Clients of all producers and consumers of the pipe share the limiter
+	clients := []*hdfsClient{newHdfsClient(nil, l), newHdfsClient(nil, l)}
+
+	var cur, max int64
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func(c *hdfsClient) {
+			defer wg.Done()
+			err := c.limiter.do(func() error {
+				n := atomic.AddInt64(&cur, 1)
+				for {
+					m := atomic.LoadInt64(&max)
+					if n <= m || atomic.CompareAndSwapInt64(&max, m, n) {
+						break
+					}
+				}
+				time.Sleep(time.Millisecond)
+				atomic.AddInt64(&cur, -1)
+				return nil
+			})
+			require.NoError(t, err)
+		}(clients[i%len(clients)])
+	}
+	wg.Wait()
+
+	require.True(t, max <= int64(limit), "concurrent namenode ops %v exceeded limit %v", max, limit)
+
+	//Zero limit means unlimited
+	require.Nil(t, newNamenodeLimiter(0))
+	require.NoError(t, newNamenodeLimiter(0).do(func() error { return nil }))
+}
@@ -1,5 +1,43 @@
 package pipe
 
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/uber/storagetapper/config"
+)
+
+//Binary header is the magic, the version byte, uvarint length of the
+//payload and the payload. First byte of the magic is not valid UTF-8, so it
+//can't be confused with the text records of headerless files.
+//
+//Payload is the flags byte, codec byte, cipher byte, followed by the key
+//id, generation and the user metadata: format, schema, HMAC, IV, sealed key
+//packets and the file id. Variable length fields are prefixed by uvarint
+//length. Fields appended to the payload by the later versions are skipped by
+//the readers
+const (
+	headerMagic         = "\x89STH"
+	binaryHeaderVersion = 2
+	maxBinaryHeaderLen  = 1 << 20
+)
+
+//Binary header flags
+const (
+	headerFlagDelimited = 1 << iota
+)
+
+//Codecs and ciphers of the binary header, 0 - none
+var (
+	headerCodecs  = []string{"", CompressionGzip, CompressionZstd}
+	headerCiphers = []string{"", "pgp"}
+)
+
 //Header represent file metadata in the beginning of the file
 type Header struct {
 	Format    string
@@ -8,14 +46,30 @@ type Header struct {
 	Delimited bool     `json:",omitempty"`
 	HMAC      string   `json:"HMAC-SHA256,omitempty"`
 	IV        string   `json:"AES256-CFB-IV,omitempty"`
+	//Generation is monotonically increasing file number of the topic,
+	//independent of the clock
+	Generation uint64 `json:",omitempty"`
+	//Keys are PGP key packets of the encrypted file, sealed with the
+	//wrapping key, so as the recipient key ids are not revealed
+	Keys string `json:",omitempty"`
+	//KeyID identifies the wrapping key, which sealed the Keys
+	KeyID string `json:",omitempty"`
+	//ID is the unique id of the file: id of the producer and the number of
+	//the file written by it. Tells the copy of the file from the different
+	//file with the same name
+	ID string `json:",omitempty"`
+
+	version int //version of the header read from the file
 }
 
-/*
-func writeHeader(header *Header, hash []byte, f io.Writer) error {
-	if len(hash) != 0 {
-		header.HMAC = fmt.Sprintf("%x", hash)
-	}
+//hasHeader returns true if files written with this config start with Header
+func hasHeader(cfg *config.PipeConfig) bool {
+	return cfg.FileHeader || cfg.FileGeneration || cfg.BinaryHeader
+}
 
+//writeHeader writes header as a delimited JSON line, which precedes filtered
+//(compressed, encrypted) file data
+func writeHeader(header *Header, f io.Writer) error {
 	h, err := json.Marshal(header)
 	if err != nil {
 		return err
@@ -28,19 +82,237 @@ func writeHeader(header *Header, hash []byte, f io.Writer) error {
 	return err
 }
 
-func readHeader(r *bufio.Reader) (Header, error) {
+//writeBinaryHeader writes self-framing binary header, which precedes filtered
+//file data
+func writeBinaryHeader(header *Header, f io.Writer) error {
+	h, err := encodeBinaryHeader(header)
+	if err != nil {
+		return err
+	}
+
+	b := make([]byte, 0, len(headerMagic)+1+binary.MaxVarintLen64+len(h))
+	b = append(b, headerMagic...)
+	b = append(b, binaryHeaderVersion)
+	b = b[:len(b)+binary.PutUvarint(b[len(b):cap(b)], uint64(len(h)))]
+	b = append(b, h...)
+
+	_, err = f.Write(b)
+
+	return err
+}
+
+func headerCode(codes []string, name string) (byte, bool) {
+	for i, v := range codes {
+		if v == name {
+			return byte(i), true
+		}
+	}
+	return 0, false
+}
+
+func encodeBinaryHeader(h *Header) ([]byte, error) {
+	var flags, codec, cipher byte
+	if h.Delimited {
+		flags |= headerFlagDelimited
+	}
+	for _, f := range h.Filters {
+		if c, ok := headerCode(headerCiphers, f); ok {
+			cipher = c
+		} else if c, ok := headerCode(headerCodecs, f); ok {
+			codec = c
+		} else {
+			return nil, fmt.Errorf("unsupported file filter: %v", f)
+		}
+	}
+	keyID, err := hex.DecodeString(h.KeyID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key id: %v", err)
+	}
+
+	b := []byte{flags, codec, cipher}
+	b = appendHeaderField(b, keyID)
+	b = appendUvarint(b, h.Generation)
+	for _, v := range [][]byte{[]byte(h.Format), h.Schema, []byte(h.HMAC), []byte(h.IV), []byte(h.Keys), []byte(h.ID)} {
+		b = appendHeaderField(b, v)
+	}
+
+	return b, nil
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var l [binary.MaxVarintLen64]byte
+	return append(b, l[:binary.PutUvarint(l[:], v)]...)
+}
+
+func appendHeaderField(b []byte, v []byte) []byte {
+	return append(appendUvarint(b, uint64(len(v))), v...)
+}
+
+//headerPayload decodes the fields of the binary header payload. First
+//decoding error is kept in err, the fields after it are zero
+type headerPayload struct {
+	b   []byte
+	err error
+}
+
+func (p *headerPayload) readByte() byte {
+	if p.err != nil {
+		return 0
+	}
+	if len(p.b) == 0 {
+		p.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	v := p.b[0]
+	p.b = p.b[1:]
+	return v
+}
+
+func (p *headerPayload) readUvarint() uint64 {
+	if p.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(p.b)
+	if n <= 0 {
+		p.err = fmt.Errorf("invalid varint")
+		return 0
+	}
+	p.b = p.b[n:]
+	return v
+}
+
+func (p *headerPayload) readField() []byte {
+	n := p.readUvarint()
+	if p.err != nil {
+		return nil
+	}
+	if n > uint64(len(p.b)) {
+		p.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	if n == 0 {
+		return nil
+	}
+	v := p.b[:n]
+	p.b = p.b[n:]
+	return v
+}
+
+func (p *headerPayload) readCode(codes []string, kind string) string {
+	c := p.readByte()
+	if p.err != nil {
+		return ""
+	}
+	if int(c) >= len(codes) {
+		p.err = fmt.Errorf("unknown %v: %v", kind, c)
+		return ""
+	}
+	return codes[c]
+}
+
+func decodeBinaryHeader(b []byte) (*Header, error) {
+	p := &headerPayload{b: b}
+	h := &Header{}
+
+	flags := p.readByte()
+	h.Delimited = flags&headerFlagDelimited != 0
+	for _, f := range []string{p.readCode(headerCodecs, "codec"), p.readCode(headerCiphers, "cipher")} {
+		if f != "" {
+			h.Filters = append(h.Filters, f)
+		}
+	}
+	if keyID := p.readField(); len(keyID) != 0 {
+		h.KeyID = hex.EncodeToString(keyID)
+	}
+	h.Generation = p.readUvarint()
+	h.Format = string(p.readField())
+	h.Schema = p.readField()
+	h.HMAC = string(p.readField())
+	h.IV = string(p.readField())
+	h.Keys = string(p.readField())
+	//Appended to the version 2 payload, absent in the earlier files
+	if p.err == nil && len(p.b) != 0 {
+		h.ID = string(p.readField())
+	}
+
+	return h, p.err
+}
+
+//hasBinaryHeader returns true if the file starts with the binary header magic
+func hasBinaryHeader(r *bufio.Reader) bool {
+	m, _ := r.Peek(len(headerMagic))
+	return bytes.Equal(m, []byte(headerMagic))
+}
+
+//readBinaryHeader returns the binary header and its length in bytes
+func readBinaryHeader(r *bufio.Reader) (*Header, int64, error) {
+	if _, err := r.Discard(len(headerMagic)); err != nil {
+		return nil, 0, err
+	}
+
+	v, err := r.ReadByte()
+	if err != nil {
+		return nil, 0, fmt.Errorf("corrupted file header: %v", err)
+	}
+	//Later versions only append fields to the payload
+	if v < binaryHeaderVersion {
+		return nil, 0, fmt.Errorf("unsupported file header version: %v", v)
+	}
+
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, 0, fmt.Errorf("corrupted file header: %v", err)
+	}
+	if n > maxBinaryHeaderLen {
+		return nil, 0, fmt.Errorf("corrupted file header: length %v exceeds %v", n, maxBinaryHeaderLen)
+	}
+
+	h := make([]byte, n)
+	if _, err := io.ReadFull(r, h); err != nil {
+		return nil, 0, fmt.Errorf("corrupted file header: %v", err)
+	}
+
+	u, err := decodeBinaryHeader(h)
+	if err != nil {
+		return nil, 0, fmt.Errorf("corrupted file header: %v", err)
+	}
+	u.version = int(v)
+
+	var l [binary.MaxVarintLen64]byte
+	return u, int64(len(headerMagic)+1+binary.PutUvarint(l[:], n)) + int64(n), nil
+}
+
+//readHeader returns the header and its length in bytes. Binary header is
+//detected by the magic, JSON line header is read otherwise
+func readHeader(r *bufio.Reader) (*Header, int64, error) {
+	if hasBinaryHeader(r) {
+		return readBinaryHeader(r)
+	}
+
 	u := &Header{}
 
 	h, err := r.ReadBytes(delimiter)
 	if err != nil {
-		return *u, err
+		return nil, 0, err
 	}
 
 	err = json.Unmarshal(h, u)
 	if err != nil {
-		return *u, err
+		return nil, 0, err
+	}
+	u.version = jsonHeaderVersion
+
+	return u, int64(len(h)), nil
+}
+
+//readFileHeader reads the header of the given file
+func readFileHeader(f fs, name string) (*Header, error) {
+	r, err := f.OpenRead(name, 0)
+	if err != nil {
+		return nil, err
 	}
+	defer func() { _ = r.Close() }()
 
-	return *u, nil
+	h, _, err := readHeader(bufio.NewReader(r))
+	return h, err
 }
-*/
@@ -0,0 +1,137 @@
+package pipe
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBinaryHeaderRoundTrip(t *testing.T) {
+	h := &Header{Format: "msgpack", Filters: []string{"gzip", "pgp"}, Schema: []byte("schema"), Delimited: true, HMAC: "hmac", IV: "iv", Generation: 300, Keys: "sealed", KeyID: "0102030405060708"}
+
+	var b bytes.Buffer
+	require.NoError(t, writeBinaryHeader(h, &b))
+	b.WriteString("data")
+	l := b.Len() - len("data")
+
+	//Payload is binary: flags, codec, cipher, then the key id
+	require.Equal(t, []byte{headerFlagDelimited, 1, 1, 8, 1, 2, 3, 4, 5, 6, 7, 8}, b.Bytes()[len(headerMagic)+2:len(headerMagic)+14])
+
+	r := bufio.NewReader(&b)
+	require.True(t, hasBinaryHeader(r))
+	u, n, err := readHeader(r)
+	require.NoError(t, err)
+	require.Equal(t, int64(l), n)
+	require.Equal(t, binaryHeaderVersion, u.version)
+	u.version = 0
+	require.Equal(t, h, u)
+
+	rest, err := r.ReadString(0)
+	require.Error(t, err)
+	require.Equal(t, "data", rest)
+
+	//Empty header
+	b.Reset()
+	require.NoError(t, writeBinaryHeader(&Header{}, &b))
+	u, _, err = readHeader(bufio.NewReader(&b))
+	require.NoError(t, err)
+	require.Equal(t, &Header{version: binaryHeaderVersion}, u)
+
+	//Fields appended by the later versions are skipped
+	p, err := encodeBinaryHeader(&Header{Format: "json", Generation: 7})
+	require.NoError(t, err)
+	p = append(p, 3, 'n', 'e', 'w')
+	u, err = decodeBinaryHeader(p)
+	require.NoError(t, err)
+	require.Equal(t, &Header{Format: "json", Generation: 7}, u)
+
+	//Header of the later version with extra payload fields is read
+	b.Reset()
+	b.WriteString(headerMagic)
+	b.WriteByte(binaryHeaderVersion + 1)
+	b.Write(appendUvarint(nil, uint64(len(p))))
+	b.Write(p)
+	b.WriteString("data")
+	r = bufio.NewReader(&b)
+	u, n, err = readHeader(r)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(headerMagic)+2+len(p)), n)
+	require.Equal(t, binaryHeaderVersion+1, u.version)
+	require.Equal(t, "json", u.Format)
+	require.Equal(t, uint64(7), u.Generation)
+	rest, err = r.ReadString(0)
+	require.Error(t, err)
+	require.Equal(t, "data", rest)
+
+	require.Error(t, writeBinaryHeader(&Header{Filters: []string{"lz4"}}, &b))
+	require.Error(t, writeBinaryHeader(&Header{KeyID: "xyz"}, &b))
+}
+
+func TestBinaryHeaderCorrupted(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"version", headerMagic + "\x01\x03\x00\x00\x00"},
+		{"no version", headerMagic},
+		{"length", headerMagic + "\x02\xff\xff\xff\xff\x7f\x00"},
+		{"truncated", headerMagic + "\x02\x10\x00\x00"},
+		{"codec", headerMagic + "\x02\x03\x00\x09\x00"},
+		{"cipher", headerMagic + "\x02\x03\x00\x00\x09"},
+		{"fields", headerMagic + "\x02\x03\x00\x00\x00"},
+		{"field length", headerMagic + "\x02\x05\x00\x00\x00\x08\x01"},
+	}
+
+	for _, v := range tests {
+		t.Run(v.name, func(t *testing.T) {
+			_, _, err := readHeader(bufio.NewReader(bytes.NewReader([]byte(v.data))))
+			require.Error(t, err)
+		})
+	}
+
+	//Absent or damaged magic is not a binary header
+	for _, v := range []string{"", "\x89S", "\x89STX\x02\x03\x00\x00\x00", `{"Format":"json"}`} {
+		require.False(t, hasBinaryHeader(bufio.NewReader(bytes.NewReader([]byte(v)))))
+	}
+}
+
+func TestBinaryHeaderAutoDetect(t *testing.T) {
+	deleteTestTopics(t)
+
+	topic := "binary-header-test/"
+
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	fp.cfg.NonBlocking = true
+	fp.cfg.BinaryHeader = true
+
+	msgs := make([]string, 0)
+	for i := 0; i < 5; i++ {
+		msgs = append(msgs, fmt.Sprintf(`{"record":%v}`, i))
+	}
+
+	p, err := fp.NewProducer(topic)
+	require.NoError(t, err)
+	p.SetFormat("json")
+	for _, m := range msgs {
+		require.NoError(t, p.Push([]byte(m)))
+	}
+	require.NoError(t, p.Close())
+
+	d, err := fp.DescribeTopic(topic)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(d))
+	require.Equal(t, binaryHeaderVersion, d[0].Version)
+	require.Equal(t, "json", d[0].Format)
+
+	//Header is detected by the consumers configured with and without header
+	for _, c := range []struct{ header, binary bool }{{false, false}, {true, false}, {false, true}} {
+		r := initTestFilePipe(&cfg.Pipe, false, t)
+		r.cfg.NonBlocking = true
+		r.cfg.FileHeader = c.header
+		r.cfg.BinaryHeader = c.binary
+		require.Equal(t, msgs, consumeToEnd(t, r, topic))
+	}
+}
@@ -0,0 +1,17 @@
+package pipe
+
+//FileHooks allow external coordination of file processing by multiple
+//consumers, for example through external locks
+type FileHooks interface {
+	//OnFileOpen is called before the consumer opens the file. Returning an
+	//error skips the file, for example because another worker owns it
+	OnFileOpen(name string) error
+	//OnFileClose is called when the consumer has finished reading the file
+	//or the consumer is closed
+	OnFileClose(name string)
+}
+
+//SetFileHooks sets hooks for the consumers created by the pipe afterwards
+func (p *filePipe) SetFileHooks(h FileHooks) {
+	p.hooks = h
+}
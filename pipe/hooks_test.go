@@ -0,0 +1,60 @@
+package pipe
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testFileHooks struct {
+	opened  []string
+	closed  []string
+	skipped []string
+}
+
+func (h *testFileHooks) OnFileOpen(name string) error {
+	if strings.HasSuffix(name, ".other") {
+		h.skipped = append(h.skipped, name)
+		return fmt.Errorf("owned by other worker")
+	}
+	h.opened = append(h.opened, name)
+	return nil
+}
+
+func (h *testFileHooks) OnFileClose(name string) {
+	h.closed = append(h.closed, name)
+}
+
+func TestFileHooks(t *testing.T) {
+	deleteTestTopics(t)
+
+	topic := "hooks-test-topic/"
+
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	fp.cfg.NonBlocking = true
+	fp.cfg.MaxFileSize = 1 //rotate on every message
+
+	p, err := fp.NewProducer(topic)
+	require.NoError(t, err)
+	require.NoError(t, p.PushK("mine", []byte("first")))
+	require.NoError(t, p.PushK("other", []byte("second")))
+	require.NoError(t, p.PushK("other", []byte("third")))
+	require.NoError(t, p.PushK("mine", []byte("fourth")))
+	require.NoError(t, p.PushK("other", []byte("fifth")))
+	require.NoError(t, p.Close())
+
+	h := &testFileHooks{}
+	fp.SetFileHooks(h)
+
+	require.Equal(t, []string{"first", "fourth"}, consumeTestMsgs(t, fp, topic))
+
+	require.Equal(t, 2, len(h.opened))
+	require.Equal(t, 3, len(h.skipped))
+	require.Equal(t, h.opened, h.closed)
+	for _, n := range h.opened {
+		require.True(t, strings.HasPrefix(n, baseDir+"/"+topic))
+		require.True(t, strings.HasSuffix(n, ".mine"))
+	}
+}
@@ -0,0 +1,228 @@
+package pipe
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/uber/storagetapper/config"
+	"github.com/uber/storagetapper/log"
+	"github.com/uber/storagetapper/metrics"
+)
+
+var errHTTPReadOnly = fmt.Errorf("http pipe is read-only")
+
+var hrefRe = regexp.MustCompile(`href="([^"?#]+)"`)
+
+//httpClient implements read side of fs interface over HTTP(S)
+type httpClient struct {
+	client *http.Client
+	base   string //scheme://host:port
+}
+
+//httpFileInfo is built from directory listing, so only name is known. Size is
+//requested separately by FileSize
+type httpFileInfo struct {
+	name string
+	dir  bool
+}
+
+func (f *httpFileInfo) Name() string       { return f.name }
+func (f *httpFileInfo) Size() int64        { return 0 }
+func (f *httpFileInfo) Mode() os.FileMode  { return 0 }
+func (f *httpFileInfo) ModTime() time.Time { return time.Time{} }
+func (f *httpFileInfo) IsDir() bool        { return f.dir }
+func (f *httpFileInfo) Sys() interface{}   { return nil }
+
+func (p *httpClient) url(name string) string {
+	return p.base + strings.TrimSuffix(path.Clean("/"+name), "/")
+}
+
+func (p *httpClient) get(u string, offset int64) (*http.Response, error) {
+	return p.do("GET", u, offset)
+}
+
+func (p *httpClient) do(method string, u string, offset int64) (*http.Response, error) {
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if offset != 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	//Offset is at the end of the file
+	if offset != 0 && resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		log.E(resp.Body.Close())
+		resp.Body = ioutil.NopCloser(strings.NewReader(""))
+		return resp, nil
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		err = &os.PathError{Op: "open", Path: u, Err: os.ErrNotExist}
+	case offset != 0 && resp.StatusCode != http.StatusPartialContent:
+		err = fmt.Errorf("ranged read is not supported by server: %v, status: %v", u, resp.Status)
+	case resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent:
+		err = fmt.Errorf("http %v %v failed, status: %v", strings.ToLower(method), u, resp.Status)
+	}
+	if err != nil {
+		log.E(resp.Body.Close())
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+func (p *httpClient) OpenRead(name string, offset int64) (io.ReadCloser, error) {
+	log.Debugf("OpenRead: %v offset=%v", name, offset)
+	resp, err := p.get(p.url(name), offset)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+//FileSize returns Content-Length of the file, as the listing page doesn't
+//have the sizes
+func (p *httpClient) FileSize(name string) (int64, error) {
+	resp, err := p.do("HEAD", p.url(name), 0)
+	if err != nil {
+		return 0, err
+	}
+	log.E(resp.Body.Close())
+	if resp.ContentLength < 0 {
+		return 0, fmt.Errorf("http server didn't return size of %v", name)
+	}
+	return resp.ContentLength, nil
+}
+
+//ReadDir parses the links of directory listing page returned by the server.
+//Entries with the path before listFrom are skipped, except metadata files
+func (p *httpClient) ReadDir(dir string, listFrom string) ([]os.FileInfo, error) {
+	resp, err := p.get(p.url(dir)+"/", 0)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { log.E(resp.Body.Close()) }()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]os.FileInfo, 0)
+	for _, m := range hrefRe.FindAllStringSubmatch(string(b), -1) {
+		n, err := url.PathUnescape(m[1])
+		if err != nil || strings.Contains(n, ":") || strings.HasPrefix(n, "/") || strings.HasPrefix(n, ".") {
+			continue
+		}
+		d := strings.HasSuffix(n, "/")
+		n = strings.TrimSuffix(n, "/")
+		if strings.Contains(n, "/") {
+			continue
+		}
+		if !isMetaFile(n) && dir+"/"+n < listFrom {
+			continue
+		}
+		res = append(res, &httpFileInfo{n, d})
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].Name() < res[j].Name() })
+
+	return res, nil
+}
+
+func (p *httpClient) MkdirAll(path string, perm os.FileMode) error {
+	return errHTTPReadOnly
+}
+
+func (p *httpClient) Rename(oldpath, newpath string) error {
+	return errHTTPReadOnly
+}
+
+func (p *httpClient) OpenWrite(name string) (flushWriteCloser, io.Seeker, error) {
+	return nil, nil, errHTTPReadOnly
+}
+
+func (p *httpClient) Remove(name string) error {
+	return errHTTPReadOnly
+}
+
+func (p *httpClient) Cancel(f io.Closer) error {
+	return nil
+}
+
+type httpPipe struct {
+	filePipe
+	client *httpClient
+}
+
+// httpConsumer consumes messages from files on HTTP server
+type httpConsumer struct {
+	fileConsumer
+}
+
+func init() {
+	registerPlugin("http", initHTTPPipe)
+}
+
+func initHTTPPipe(cfg *config.PipeConfig, db *sql.DB) (Pipe, error) {
+	if err := validateCompressionConfig(cfg); err != nil {
+		return nil, err
+	}
+
+	u, err := url.Parse(cfg.HTTP.BaseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported url scheme: %v", cfg.HTTP.BaseURL)
+	}
+
+	c := &httpClient{&http.Client{Timeout: cfg.HTTP.Timeout}, u.Scheme + "://" + u.Host}
+
+	dir := strings.TrimSuffix(u.Path, "/")
+	if dir == "" {
+		dir = "/"
+	}
+
+	return &httpPipe{filePipe{datadir: dir, cfg: *cfg, fs: c, headers: newHeaderCache(), schemas: newSchemaCache(), db: db, statePrefix: c.base, readOnly: true}, c}, nil
+}
+
+// Type returns Pipe type as HTTP
+func (p *httpPipe) Type() string {
+	return "http"
+}
+
+//NewProducer returns error, because the pipe is read-only
+func (p *httpPipe) NewProducer(topic string) (Producer, error) {
+	return nil, errHTTPReadOnly
+}
+
+//NewConsumer registers a new HTTP consumer
+func (p *httpPipe) NewConsumer(topic string) (Consumer, error) {
+	m := metrics.NewFilePipeMetrics("pipe_consumer", map[string]string{"topic": topic, "pipeType": "http"})
+	if p.cfg.ParallelReaders > 1 {
+		return p.newParallelConsumer(topic, p.client, m)
+	}
+	c := &httpConsumer{fileConsumer{filePipe: &p.filePipe, topic: topic, fs: p.client, metrics: m}}
+	_, err := p.initConsumer(&c.fileConsumer, c.fetchNextPoll)
+	return c, err
+}
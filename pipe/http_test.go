@@ -0,0 +1,102 @@
+package pipe
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPPipe(t *testing.T) {
+	deleteTestTopics(t)
+
+	topic := "http-test-topic/"
+
+	pcfg := cfg.Pipe
+	pcfg.Compression = true
+	pcfg.FileHeader = true //consumer reopens file after the header with ranged read
+	pcfg.NonBlocking = true
+	pcfg.MaxFileSize = 1
+
+	fp := initTestFilePipe(&pcfg, false, t)
+	msgs := []string{"first", "second", "third"}
+	produceTestMsgs(t, fp, topic, msgs)
+
+	srv := httptest.NewServer(http.FileServer(http.Dir("/")))
+	defer srv.Close()
+
+	pcfg.HTTP.BaseURL = srv.URL + baseDir
+	p, err := initHTTPPipe(&pcfg, nil)
+	require.NoError(t, err)
+	require.Equal(t, "http", p.Type())
+
+	require.Equal(t, msgs, consumeTestMsgs(t, p, topic))
+
+	//Ranged reads
+	files, err := ioutil.ReadDir(baseDir + "/" + topic)
+	require.NoError(t, err)
+	name := baseDir + "/" + topic + files[0].Name()
+	b, err := ioutil.ReadFile(name)
+	require.NoError(t, err)
+
+	c := p.(*httpPipe).client
+	for _, off := range []int64{0, 1, 7, int64(len(b) - 1)} {
+		r, err := c.OpenRead(name, off)
+		require.NoError(t, err)
+		d, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+		require.Equal(t, b[off:], d)
+	}
+
+	_, err = c.OpenRead(baseDir+"/"+topic+"nonexistent", 0)
+	require.True(t, os.IsNotExist(err))
+
+	size, err := c.FileSize(name)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(b)), size)
+
+	//Listing skips the files before listFrom
+	l, err := c.ReadDir(filepath.Dir(name), baseDir+"/"+topic+files[1].Name())
+	require.NoError(t, err)
+	require.Equal(t, len(files)-1, len(l))
+	require.Equal(t, files[1].Name(), l[0].Name())
+
+
+	//Writes are not supported
+	_, err = p.NewProducer(topic)
+	require.Equal(t, errHTTPReadOnly, err)
+	_, _, err = c.OpenWrite(name)
+	require.Equal(t, errHTTPReadOnly, err)
+	require.Equal(t, errHTTPReadOnly, c.Rename(name, name+".renamed"))
+
+	//Offsets of the read-only pipe aren't committed without the state DB
+	p.(*httpPipe).cfg.StartPolicy = StartPolicyEarliest
+	require.Equal(t, msgs, consumeTestMsgs(t, p, topic))
+	require.Equal(t, msgs, consumeTestMsgs(t, p, topic))
+
+	//Newest offset starts after the end of the last file
+	ucfg := cfg.Pipe
+	ucfg.NonBlocking = true
+	produceTestMsgs(t, initTestFilePipe(&ucfg, false, t), "http-test-newest/", msgs)
+	ucfg.HTTP.BaseURL = pcfg.HTTP.BaseURL
+	up, err := initHTTPPipe(&ucfg, nil)
+	require.NoError(t, err)
+	saveOffset := InitialOffset
+	InitialOffset = OffsetNewest
+	defer func() { InitialOffset = saveOffset }()
+	cn, err := up.NewConsumer("http-test-newest/")
+	require.NoError(t, err)
+	m, err := cn.FetchNext()
+	require.NoError(t, err)
+	require.Nil(t, m)
+	require.NoError(t, cn.Close())
+
+	pcfg.HTTP.BaseURL = "ftp://localhost/"
+	_, err = initHTTPPipe(&pcfg, nil)
+	require.Error(t, err)
+}
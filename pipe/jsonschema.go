@@ -0,0 +1,152 @@
+package pipe
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xeipuuv/gojsonschema"
+)
+
+//Schema validation policies
+const (
+	SchemaReject     = "reject"
+	SchemaDeadLetter = "dead_letter"
+	SchemaPass       = "pass"
+)
+
+//SchemaViolationError is returned for the record, which doesn't match the
+//JSON Schema of the topic, when schema validation policy is reject
+type SchemaViolationError struct {
+	Topic  string
+	Path   string //location of the violation in the record, like $.a[1]
+	Reason string
+}
+
+func (e *SchemaViolationError) Error() string {
+	return fmt.Sprintf("record of topic %v doesn't match the schema: %v: %v", e.Topic, e.Path, e.Reason)
+}
+
+//jsonSchema is compiled JSON Schema. Drafts 4, 6 and 7 are supported, $ref
+//is resolved within the schema document
+type jsonSchema struct {
+	schema *gojsonschema.Schema
+}
+
+//schemaCache caches compiled schemas of the topics, so as the schema source
+//is loaded once, not by every producer of the topic
+type schemaCache struct {
+	lock    sync.Mutex
+	schemas map[string]*jsonSchema
+}
+
+func newSchemaCache() *schemaCache {
+	return &schemaCache{schemas: make(map[string]*jsonSchema)}
+}
+
+func (c *schemaCache) get(topic string) *jsonSchema {
+	if c == nil {
+		return nil
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.schemas[topic]
+}
+
+func (c *schemaCache) put(topic string, s *jsonSchema) {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.schemas[topic] = s
+}
+
+//loadJSONSchema loads the schema from the source, which is either inline
+//schema, http(s) URL or path of the schema file
+func loadJSONSchema(source string) (*jsonSchema, error) {
+	var b []byte
+	var err error
+
+	s := strings.TrimSpace(source)
+	switch {
+	case strings.HasPrefix(s, "{"):
+		b = []byte(s)
+	case strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://"):
+		b, err = fetchJSONSchema(s)
+	default:
+		b, err = ioutil.ReadFile(strings.TrimPrefix(s, "file://"))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	schema, err := gojsonschema.NewSchema(gojsonschema.NewBytesLoader(b))
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON Schema %v: %v", source, err)
+	}
+
+	return &jsonSchema{schema}, nil
+}
+
+func fetchJSONSchema(url string) ([]byte, error) {
+	c := &http.Client{Timeout: 30 * time.Second}
+	resp, err := c.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching JSON Schema %v: %v", url, resp.Status)
+	}
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+//validateRecord parses the record and validates it against the schema
+func (s *jsonSchema) validateRecord(topic string, b []byte) error {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return &SchemaViolationError{Topic: topic, Path: "$", Reason: fmt.Sprintf("invalid JSON: %v", err)}
+	}
+	if d.More() {
+		return &SchemaViolationError{Topic: topic, Path: "$", Reason: "invalid JSON: trailing data"}
+	}
+
+	res, err := s.schema.Validate(gojsonschema.NewGoLoader(v))
+	if err != nil {
+		return &SchemaViolationError{Topic: topic, Path: "$", Reason: err.Error()}
+	}
+	if res.Valid() {
+		return nil
+	}
+
+	e := res.Errors()[0]
+	return &SchemaViolationError{Topic: topic, Path: jsonPath(e.Context()), Reason: e.Description()}
+}
+
+//jsonPath converts validation context, like (root).a.1, to the path, like
+//$.a[1]
+func jsonPath(c *gojsonschema.JsonContext) string {
+	l := strings.Split(c.String(), ".")
+
+	path := "$"
+	for _, f := range l[1:] {
+		if _, err := strconv.Atoi(f); err == nil {
+			path += "[" + f + "]"
+		} else {
+			path += "." + f
+		}
+	}
+
+	return path
+}
@@ -0,0 +1,149 @@
+package pipe
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testJSONSchema = `{
+	"type": "object",
+	"properties": {
+		"id": {"type": "integer", "minimum": 1},
+		"name": {"type": "string", "minLength": 1, "maxLength": 8, "pattern": "^[a-z]+$"},
+		"kind": {"enum": ["a", "b"]},
+		"tags": {"type": "array", "items": {"type": "string"}, "maxItems": 2},
+		"score": {"type": ["number", "null"], "exclusiveMaximum": 100},
+		"ref": {"oneOf": [{"type": "string"}, {"type": "integer"}]},
+		"meta": {"type": "object", "additionalProperties": {"type": "boolean"}}
+	},
+	"required": ["id", "name"],
+	"additionalProperties": false
+}`
+
+func TestJSONSchema(t *testing.T) {
+	s, err := loadJSONSchema(testJSONSchema)
+	require.NoError(t, err)
+
+	valid := []string{
+		`{"id":1,"name":"abc"}`,
+		`{"id":2,"name":"x","kind":"b","tags":["t1","t2"],"score":99.5,"ref":"r","meta":{"f":true}}`,
+		`{"id":3,"name":"y","score":null,"ref":5}`,
+	}
+	for _, v := range valid {
+		require.NoError(t, s.validateRecord("topic1", []byte(v)), v)
+	}
+
+	invalid := map[string]string{
+		`{"id":1}`:                                   "$",
+		`{"id":0,"name":"abc"}`:                      "$.id",
+		`{"id":1.5,"name":"abc"}`:                    "$.id",
+		`{"id":1,"name":""}`:                         "$.name",
+		`{"id":1,"name":"abcdefghi"}`:                "$.name",
+		`{"id":1,"name":"ABC"}`:                      "$.name",
+		`{"id":1,"name":"abc","kind":"c"}`:           "$.kind",
+		`{"id":1,"name":"abc","tags":["a",1]}`:       "$.tags[1]",
+		`{"id":1,"name":"abc","tags":["a","b","c"]}`: "$.tags",
+		`{"id":1,"name":"abc","score":100}`:          "$.score",
+		`{"id":1,"name":"abc","ref":true}`:           "$.ref",
+		`{"id":1,"name":"abc","meta":{"f":1}}`:       "$.meta.f",
+		`{"id":1,"name":"abc","other":1}`:            "$",
+		`[1]`:                                        "$",
+		`{"id":1,"name":"abc"} {}`:                   "$",
+		`not json`:                                   "$",
+	}
+	for v, path := range invalid {
+		err := s.validateRecord("topic1", []byte(v))
+		require.Error(t, err, v)
+		e, ok := err.(*SchemaViolationError)
+		require.True(t, ok)
+		require.Equal(t, "topic1", e.Topic)
+		require.Equal(t, path, e.Path, v)
+	}
+
+	for _, v := range []string{`{"$ref": "#/definitions/a"}`, `{"type": 1}`, `{"pattern": "("}`, `[]`, `{"minLength": -1}`} {
+		_, err := loadJSONSchema(v)
+		require.Error(t, err, v)
+	}
+}
+
+func TestJSONSchemaKeywords(t *testing.T) {
+	s, err := loadJSONSchema(`{
+		"definitions": {"id": {"type": "integer", "multipleOf": 2}},
+		"type": "object",
+		"properties": {
+			"id": {"$ref": "#/definitions/id"},
+			"tags": {"type": "array", "uniqueItems": true},
+			"date": {"type": "string", "format": "date"}
+		},
+		"propertyNames": {"maxLength": 4},
+		"dependencies": {"date": ["id"]}
+	}`)
+	require.NoError(t, err)
+
+	require.NoError(t, s.validateRecord("topic1", []byte(`{"id":2,"tags":["a","b"],"date":"2020-01-02"}`)))
+
+	invalid := map[string]string{
+		`{"id":3}`:              "$.id",
+		`{"tags":["a","a"]}`:    "$.tags",
+		`{"id":2,"date":"x"}`:   "$.date",
+		`{"date":"2020-01-02"}`: "$",
+		`{"other":1}`:           "$",
+	}
+	for v, path := range invalid {
+		err := s.validateRecord("topic1", []byte(v))
+		e, ok := err.(*SchemaViolationError)
+		require.True(t, ok, v)
+		require.Equal(t, path, e.Path, v)
+	}
+}
+
+func TestJSONSchemaSource(t *testing.T) {
+	deleteTestTopics(t)
+
+	name := baseDir + "/schema.json"
+	require.NoError(t, ioutil.WriteFile(name, []byte(testJSONSchema), 0644))
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/schema.json" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(testJSONSchema))
+	}))
+	defer srv.Close()
+
+	for _, src := range []string{name, "file://" + name, srv.URL + "/schema.json"} {
+		s, err := loadJSONSchema(src)
+		require.NoError(t, err, src)
+		require.NoError(t, s.validateRecord("topic1", []byte(`{"id":1,"name":"abc"}`)))
+		require.Error(t, s.validateRecord("topic1", []byte(`{"id":1}`)))
+	}
+
+	//Schema is loaded once per topic
+	var fetches int
+	cached := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		_, _ = w.Write([]byte(testJSONSchema))
+	}))
+	defer cached.Close()
+
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	fp.schemas = newSchemaCache()
+	fp.cfg.SchemaValidation.Schemas = map[string]string{"schema-cache-test/": cached.URL}
+	for i := 0; i < 2; i++ {
+		p, err := fp.NewProducer("schema-cache-test/")
+		require.NoError(t, err)
+		require.Error(t, p.Push([]byte(`{"id":1}`)))
+		require.NoError(t, p.Close())
+	}
+	require.Equal(t, 1, fetches)
+
+	_, err := loadJSONSchema(srv.URL + "/missing.json")
+	require.Error(t, err)
+	_, err = loadJSONSchema(baseDir + "/missing.json")
+	require.Error(t, err)
+}
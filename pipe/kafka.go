@@ -360,6 +360,7 @@ func (p *KafkaPipe) NewConsumer(topic string) (Consumer, error) {
 
 	kc := &kafkaConsumer{pipe: p, topic: topic, ch: ch, log: l}
 
+	kc.transformPolicy = p.cfg.TransformErrorPolicy
 	kc.initBaseConsumer(kc.fetchNext)
 
 	log.Debugf("Registered consumer %v", topic)
@@ -0,0 +1,158 @@
+package pipe
+
+import (
+	"sync"
+	"time"
+
+	"github.com/efirs/hdfs/v2"
+	"github.com/uber/storagetapper/log"
+)
+
+//hdfsConn is the namenode connection shared by all the clients of the pipe.
+//Idle connection can be silently dropped by firewalls and load balancers,
+//so keepalive pings the namenode periodically and reconnects if the ping
+//fails
+type hdfsConn struct {
+	lock   sync.Mutex
+	client *hdfsRef
+
+	dial        func() (*hdfs.Client, error)
+	ping        func(c *hdfs.Client) error
+	closeClient func(c *hdfs.Client) error
+
+	limiter namenodeLimiter //pings are limited as all namenode operations
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+//hdfsRef counts the users of the client: operations in progress and open
+//files. Client replaced on reconnect is closed, when the last file opened
+//with it is closed
+type hdfsRef struct {
+	client  *hdfs.Client
+	refs    int
+	retired bool
+}
+
+func newHdfsConn(dial func() (*hdfs.Client, error)) (*hdfsConn, error) {
+	client, err := dial()
+	if err != nil {
+		return nil, err
+	}
+
+	return &hdfsConn{
+		client:      &hdfsRef{client: client},
+		dial:        dial,
+		ping:        pingNamenode,
+		closeClient: func(c *hdfs.Client) error { return c.Close() },
+		done:        make(chan struct{}),
+	}, nil
+}
+
+//pingNamenode is the cheap metadata operation, which keeps the connection
+//warm
+func pingNamenode(c *hdfs.Client) error {
+	_, err := c.Stat("/")
+	return err
+}
+
+//get returns current client for the operations, which don't outlive the
+//connection
+func (c *hdfsConn) get() *hdfs.Client {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.client.client
+}
+
+//acquire returns current client and the function, which must be called when
+//the client is not used anymore
+func (c *hdfsConn) acquire() (*hdfs.Client, func()) {
+	c.lock.Lock()
+	r := c.client
+	r.refs++
+	c.lock.Unlock()
+
+	var once sync.Once
+	return r.client, func() { once.Do(func() { c.release(r) }) }
+}
+
+func (c *hdfsConn) release(r *hdfsRef) {
+	c.lock.Lock()
+	r.refs--
+	unused := r.retired && r.refs == 0
+	c.lock.Unlock()
+
+	if unused {
+		log.E(c.closeClient(r.client))
+	}
+}
+
+//retire replaces the client by the new one, the old client is closed when
+//it's not used anymore
+func (c *hdfsConn) retire(client *hdfs.Client) error {
+	c.lock.Lock()
+	old := c.client
+	old.retired = true
+	if client != nil {
+		c.client = &hdfsRef{client: client}
+	}
+	unused := old.refs == 0
+	c.lock.Unlock()
+
+	if unused {
+		return c.closeClient(old.client)
+	}
+	return nil
+}
+
+//keepAlive starts pinging the namenode every interval. 0 - disabled
+func (c *hdfsConn) keepAlive(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				c.check()
+			case <-c.done:
+				return
+			}
+		}
+	}()
+}
+
+//check pings the namenode and reconnects if the ping fails
+func (c *hdfsConn) check() {
+	err := c.limiter.do(func() error {
+		client, release := c.acquire()
+		defer release()
+		return c.ping(client)
+	})
+	if err == nil {
+		return
+	}
+
+	log.Warnf("HDFS namenode ping failed, reconnecting: %v", err)
+
+	client, err := c.dial()
+	if log.E(err) {
+		return
+	}
+
+	log.E(c.retire(client))
+}
+
+//close stops keepalive and closes the connection. Connection is closed when
+//the files opened with it are closed, if any
+func (c *hdfsConn) close() error {
+	close(c.done)
+	c.wg.Wait()
+	return c.retire(nil)
+}
@@ -0,0 +1,77 @@
+package pipe
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/efirs/hdfs/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHdfsKeepAlive(t *testing.T) {
+	var dials, pings, closes, fail int64
+
+	conn, err := newHdfsConn(func() (*hdfs.Client, error) {
+		atomic.AddInt64(&dials, 1)
+		return &hdfs.Client{}, nil
+	})
+	require.NoError(t, err)
+	conn.ping = func(c *hdfs.Client) error {
+		atomic.AddInt64(&pings, 1)
+		if atomic.CompareAndSwapInt64(&fail, 1, 0) {
+			return fmt.Errorf("connection reset by peer")
+		}
+		return nil
+	}
+	conn.closeClient = func(c *hdfs.Client) error {
+		atomic.AddInt64(&closes, 1)
+		return nil
+	}
+
+	conn.limiter = newNamenodeLimiter(1)
+
+	first := conn.get()
+	c := newHdfsClient(conn, nil)
+
+	//Disabled
+	conn.keepAlive(0)
+	time.Sleep(30 * time.Millisecond)
+	require.Equal(t, int64(0), atomic.LoadInt64(&pings))
+
+	conn.keepAlive(10 * time.Millisecond)
+
+	//Ping fires on the interval, healthy connection is kept
+	require.Eventually(t, func() bool { return atomic.LoadInt64(&pings) >= 3 }, 5*time.Second, time.Millisecond)
+	require.Equal(t, int64(1), atomic.LoadInt64(&dials))
+	require.True(t, first == c.conn.get())
+
+	//Ping is limited as other namenode operations
+	conn.limiter <- struct{}{}
+	n := atomic.LoadInt64(&pings)
+	time.Sleep(30 * time.Millisecond)
+	require.Equal(t, n, atomic.LoadInt64(&pings))
+	<-conn.limiter
+
+	//File opened with the old client keeps it open after reconnect
+	_, release := conn.acquire()
+
+	//Failed ping reconnects, clients switch to the new connection
+	atomic.StoreInt64(&fail, 1)
+	require.Eventually(t, func() bool { return atomic.LoadInt64(&dials) >= 2 }, 5*time.Second, time.Millisecond)
+	require.False(t, first == c.conn.get())
+	time.Sleep(30 * time.Millisecond)
+	require.Equal(t, int64(0), atomic.LoadInt64(&closes))
+
+	//Old client is closed when it's not used anymore
+	release()
+	release()
+	require.Equal(t, int64(1), atomic.LoadInt64(&closes))
+
+	require.NoError(t, conn.close())
+	require.Equal(t, int64(2), atomic.LoadInt64(&closes))
+	n = atomic.LoadInt64(&pings)
+	time.Sleep(30 * time.Millisecond)
+	require.Equal(t, n, atomic.LoadInt64(&pings))
+}
@@ -0,0 +1,411 @@
+package pipe
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/uber/storagetapper/log"
+)
+
+//leaseFS is implemented by the filesystems, which support consumer leases
+type leaseFS interface {
+	//CreateExclusive creates an empty file, fails with os.ErrExist if the
+	//file exists
+	CreateExclusive(name string) error
+	Stat(name string) (os.FileInfo, error)
+	Chtimes(name string, mtime time.Time) error
+}
+
+//removeExpired removes the expired marker. Of the processes, which have found
+//the marker expired, only one removes it, and the marker created anew after
+//the removal isn't removed. fi is the stat of the marker found expired.
+//Returns false if the marker is taken over by the other process
+func removeExpired(l leaseFS, f fs, name string, fi os.FileInfo, ttl time.Duration) (bool, error) {
+	t := fmt.Sprintf("%s.takeover.%d", name, fi.ModTime().UnixNano())
+	err := l.CreateExclusive(t)
+	if os.IsExist(err) {
+		//Takeover marker of the process died during the takeover
+		if expired, err := markerExpired(l, t, ttl); err != nil || !expired {
+			return false, err
+		}
+		log.Warnf("Removing expired takeover marker %v", t)
+		if err := f.Remove(t); err != nil && !os.IsNotExist(err) {
+			return false, err
+		}
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if err := f.Remove(t); !os.IsNotExist(err) {
+			log.E(err)
+		}
+	}()
+
+	//The marker could have been removed and created anew, before the
+	//takeover marker has been created
+	cur, err := l.Stat(name)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !cur.ModTime().Equal(fi.ModTime()) {
+		return false, nil
+	}
+
+	if err := f.Remove(name); err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+
+	return true, nil
+}
+
+//markerExpired returns true if the marker hasn't been updated within ttl
+func markerExpired(l leaseFS, name string, ttl time.Duration) (bool, error) {
+	fi, err := l.Stat(name)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return timeNow().Sub(fi.ModTime()) > ttl, nil
+}
+
+//leaseFileName returns the name of the marker of the file claimed by the
+//consumer
+func leaseFileName(name string) string {
+	return filepath.Dir(name) + "/_" + filepath.Base(name) + ".lease"
+}
+
+//doneFileName returns the name of the marker of the file, which has been
+//read to the end by the consumer holding the lease
+func doneFileName(name string) string {
+	return filepath.Dir(name) + "/_" + filepath.Base(name) + ".done"
+}
+
+//leaseCleanupInterval is the interval of the removal of the markers of the
+//files, which no longer exist
+var leaseCleanupInterval = time.Hour
+
+//leaseResume is the consumer position to resume from, after the file skipped
+//earlier has been read
+type leaseResume struct {
+	name       string
+	generation uint64
+}
+
+//leaseBase returns the filesystem the leases are taken in. Leases of the
+//sharded topic are taken in the pipe base directory
+func (p *fileConsumer) leaseBase() fs {
+	if s, ok := p.fs.(*shardFS); ok {
+		return s.fs
+	}
+	return p.fs
+}
+
+//leases returns the filesystem the leases are taken in
+func (p *fileConsumer) leases() (leaseFS, error) {
+	l, ok := p.leaseBase().(leaseFS)
+	if !ok {
+		return nil, fmt.Errorf("consumer leases are not supported by the pipe")
+	}
+	return l, nil
+}
+
+func (p *fileConsumer) leasesEnabled() bool {
+	return p.cfg.ConsumerLease != 0 && p.cfg.ParallelReaders <= 1
+}
+
+//claimLease takes the lease of the file before the consumer opens it.
+//Returns false if the file is leased by the other live consumer or has been
+//read to the end under the lease already
+func (p *fileConsumer) claimLease(name string) (bool, error) {
+	if !p.leasesEnabled() {
+		return true, nil
+	}
+
+	p.releaseLease()
+
+	l, err := p.leases()
+	if err != nil {
+		return false, err
+	}
+
+	if done, err := p.leaseMarkerExists(l, doneFileName(name)); err != nil || done {
+		return false, err
+	}
+
+	ln := leaseFileName(name)
+	err = l.CreateExclusive(ln)
+	if os.IsExist(err) {
+		var removed bool
+		if removed, err = p.removeExpiredLease(l, ln); err != nil || !removed {
+			return false, err
+		}
+		log.Warnf("%v: taking over expired lease of %v", p.topic, filepath.Base(name))
+		err = l.CreateExclusive(ln)
+		if os.IsExist(err) {
+			return false, nil
+		}
+	}
+	if err != nil {
+		return false, err
+	}
+
+	//The file could have been read to the end by the other consumer, after
+	//the done marker has been checked
+	if done, err := p.leaseMarkerExists(l, doneFileName(name)); err != nil || done {
+		log.E(p.leaseBase().Remove(ln))
+		return false, err
+	}
+
+	p.lease = name
+	p.renewLease(l, ln)
+
+	return true, nil
+}
+
+func (p *fileConsumer) leaseMarkerExists(l leaseFS, name string) (bool, error) {
+	_, err := l.Stat(name)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+//removeExpiredLease removes the lease of the dead consumer. Returns true if
+//the lease doesn't exist anymore
+func (p *fileConsumer) removeExpiredLease(l leaseFS, ln string) (bool, error) {
+	fi, err := l.Stat(ln)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if timeNow().Sub(fi.ModTime()) <= p.cfg.ConsumerLease {
+		return false, nil
+	}
+	return removeExpired(l, p.leaseBase(), ln, fi, p.cfg.ConsumerLease)
+}
+
+//renewLease moves the expiration of the held lease at half of the lease
+//time, until the lease is completed or released. Failed renewal loses the
+//lease, because the other consumer can take it over once it expires
+func (p *fileConsumer) renewLease(l leaseFS, ln string) {
+	stop, done, lost := make(chan struct{}), make(chan struct{}), make(chan struct{})
+	p.leaseStop = func() {
+		close(stop)
+		<-done
+	}
+
+	var lostErr error
+	p.leaseLost = func() error {
+		select {
+		case <-lost:
+			return lostErr
+		default:
+			return nil
+		}
+	}
+
+	go func() {
+		defer close(done)
+		t := time.NewTicker(p.cfg.ConsumerLease / 2)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				if err := l.Chtimes(ln, timeNow()); err != nil {
+					lostErr = fmt.Errorf("lease of %v is lost, renewal failed: %v", filepath.Base(p.lease), err)
+					close(lost)
+					return
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+//checkLease returns the error if the lease of the file being read is lost,
+//so as no more records of the file are delivered
+func (p *fileConsumer) checkLease() error {
+	if p.lease == "" {
+		return nil
+	}
+	return p.leaseLost()
+}
+
+//completeLease marks the file, which has been read to the end, so as no
+//other consumer claims it again
+func (p *fileConsumer) completeLease() {
+	if p.lease == "" {
+		return
+	}
+	p.leaseStop()
+	//Marker of the lost lease can be held by the other consumer already
+	if !log.E(p.leaseLost()) {
+		log.E(p.leaseBase().Rename(leaseFileName(p.lease), doneFileName(p.lease)))
+	}
+	p.lease = ""
+}
+
+//releaseLease gives up the held lease of the file, which hasn't been read to
+//the end
+func (p *fileConsumer) releaseLease() {
+	if p.lease == "" {
+		return
+	}
+	p.leaseStop()
+	if p.leaseLost() == nil {
+		if err := p.leaseBase().Remove(leaseFileName(p.lease)); !os.IsNotExist(err) {
+			log.E(err)
+		}
+	}
+	p.lease = ""
+}
+
+//skipLeased remembers the file leased by the other consumer, so as it's
+//read by this consumer if the other consumer gives up the lease or dies
+func (p *fileConsumer) skipLeased(name string) {
+	p.leased = append(p.leased, name)
+}
+
+//releasedLease returns the skipped file, which lease has been released
+//without reading the file to the end or has expired
+func (p *fileConsumer) releasedLease() (string, error) {
+	l, err := p.leases()
+	if err != nil {
+		return "", err
+	}
+
+	var res string
+	leased := make([]string, 0, len(p.leased))
+	for _, name := range p.leased {
+		if res != "" {
+			leased = append(leased, name)
+			continue
+		}
+		done, err := p.leaseMarkerExists(l, doneFileName(name))
+		if err != nil {
+			return "", err
+		}
+		//Read to the end by the other consumer
+		if done {
+			continue
+		}
+		expired, err := markerExpired(l, leaseFileName(name), p.cfg.ConsumerLease)
+		if err != nil {
+			return "", err
+		}
+		if expired {
+			res = name
+			continue
+		}
+		leased = append(leased, name)
+	}
+	p.leased = leased
+
+	return res, nil
+}
+
+//openReleasedLease opens the skipped file, which lease has been released or
+//has expired since. Consumer position is restored on the next call after the
+//file has been read. Returns true if the file has been opened
+func (p *fileConsumer) openReleasedLease() bool {
+	if p.resume.name != "" {
+		p.name, p.generation = p.resume.name, p.resume.generation
+		p.resume = leaseResume{}
+	}
+
+	if !p.leasesEnabled() {
+		return false
+	}
+
+	p.cleanupLeases()
+
+	for {
+		name, err := p.releasedLease()
+		if err != nil {
+			p.err = err
+			return true
+		}
+		if name == "" {
+			return false
+		}
+
+		resume := leaseResume{p.name, p.generation}
+		p.openFile(filepath.Base(name), 0)
+		if p.reader != nil || (p.err != nil && !os.IsNotExist(p.err)) {
+			p.resume = resume
+			return true
+		}
+		//Leased by the other consumer again or removed by retention
+		p.name, p.generation, p.err = resume.name, resume.generation, nil
+	}
+}
+
+//cleanupLeases removes the markers of the files, which have been removed by
+//retention. Lease markers are removed only when expired, so as the live
+//consumer, reading the removed file, keeps its lease
+func (p *fileConsumer) cleanupLeases() {
+	if timeNow().Sub(p.cleaned) < leaseCleanupInterval {
+		return
+	}
+	p.cleaned = timeNow()
+
+	l, err := p.leases()
+	if log.E(err) {
+		return
+	}
+
+	tp := p.topicPath(p.topic)
+	dir := filepath.Dir(tp)
+
+	markers, err := p.leaseBase().ReadDir(dir, dir+"/_")
+	if os.IsNotExist(err) || log.E(err) {
+		return
+	}
+	files, err := p.fs.ReadDir(dir, tp)
+	if os.IsNotExist(err) || log.E(err) {
+		return
+	}
+
+	exists := make(map[string]bool, len(files))
+	for _, f := range files {
+		exists[f.Name()] = true
+	}
+
+	for _, m := range markers {
+		n := m.Name()
+		if !strings.HasPrefix(n, "_") {
+			continue
+		}
+		var data string
+		switch {
+		case strings.HasSuffix(n, ".done"):
+			data = strings.TrimSuffix(n[1:], ".done")
+		case strings.HasSuffix(n, ".lease"):
+			data = strings.TrimSuffix(n[1:], ".lease")
+		default:
+			continue
+		}
+		if !strings.HasPrefix(dir+"/"+data, tp) || exists[data] {
+			continue
+		}
+		if strings.HasSuffix(n, ".lease") {
+			_, err = p.removeExpiredLease(l, dir+"/"+n)
+		} else if err = p.leaseBase().Remove(dir + "/" + n); os.IsNotExist(err) {
+			err = nil
+		}
+		log.E(err)
+	}
+}
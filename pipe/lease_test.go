@@ -0,0 +1,340 @@
+package pipe
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func prepareLeaseTest(t *testing.T, topic string) (*filePipe, []string) {
+	deleteTestTopics(t)
+
+	saveTimeNow := timeNow
+	defer func() { timeNow = saveTimeNow }()
+	now := time.Now()
+	timeNow = func() time.Time { return now }
+
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	fp.cfg.NonBlocking = true
+	fp.cfg.ConsumerLease = time.Minute
+
+	msgs := make([]string, 0)
+	for i := 0; i < 6; i++ {
+		file := make([]string, 0)
+		for j := 0; j < 3; j++ {
+			file = append(file, fmt.Sprintf("file %v record %v", i, j))
+		}
+		produceTestMsgs(t, fp, topic, file)
+		now = now.Add(time.Second)
+		msgs = append(msgs, file...)
+	}
+
+	return fp, msgs
+}
+
+func TestConsumerLease(t *testing.T) {
+	topic := "consumer-lease-test/"
+	fp, msgs := prepareLeaseTest(t, topic)
+
+	saveOffset := InitialOffset
+	InitialOffset = OffsetOldest
+	defer func() { InitialOffset = saveOffset }()
+
+	type result struct {
+		msgs []string
+		err  error
+	}
+
+	consumers := make([]Consumer, 2)
+	for i := range consumers {
+		c, err := fp.NewConsumer(topic)
+		require.NoError(t, err)
+		consumers[i] = c
+	}
+
+	resCh := make(chan result, len(consumers))
+	for _, c := range consumers {
+		go func(c Consumer) {
+			var r result
+			for {
+				var m interface{}
+				if m, r.err = c.FetchNext(); r.err != nil || m == nil {
+					break
+				}
+				r.msgs = append(r.msgs, string(m.([]byte)))
+			}
+			if r.err == nil {
+				r.err = c.Close()
+			}
+			resCh <- r
+		}(c)
+	}
+
+	all := make([]string, 0)
+	files := make(map[string]int)
+	for i := range consumers {
+		r := <-resCh
+		require.NoError(t, r.err)
+		all = append(all, r.msgs...)
+		for _, m := range r.msgs {
+			f := m[:strings.Index(m, " record")]
+			//Records of the file are delivered by the same consumer
+			if c, ok := files[f]; ok {
+				require.Equal(t, i, c, f)
+			}
+			files[f] = i
+		}
+	}
+
+	//Every file has been processed by exactly one consumer
+	sort.Strings(all)
+	require.Equal(t, msgs, all)
+
+	//Files read to the end aren't claimed again
+	require.Equal(t, 0, len(consumeToEnd(t, fp, topic)))
+}
+
+func TestConsumerLeaseExpiration(t *testing.T) {
+	topic := "consumer-lease-test/"
+	fp, msgs := prepareLeaseTest(t, topic)
+
+	names := oneRecordTestFiles(t, fp, topic)
+	require.Equal(t, 6, len(names))
+
+	l := &fileFS{}
+
+	//File leased by the live consumer is skipped
+	require.NoError(t, l.CreateExclusive(leaseFileName(names[0])))
+	require.Equal(t, msgs[3:], consumeToEnd(t, fp, topic))
+
+	require.NoError(t, os.Remove(leaseFileName(names[0])))
+	for _, n := range names[1:] {
+		_, err := os.Stat(doneFileName(n))
+		require.NoError(t, err)
+		require.NoError(t, os.Remove(doneFileName(n)))
+	}
+
+	//Expired lease is taken over
+	require.NoError(t, l.CreateExclusive(leaseFileName(names[0])))
+	require.NoError(t, l.Chtimes(leaseFileName(names[0]), time.Now().Add(-2*fp.cfg.ConsumerLease)))
+
+	saveOffset := InitialOffset
+	InitialOffset = OffsetOldest
+	defer func() { InitialOffset = saveOffset }()
+
+	c, err := fp.NewConsumer(topic)
+	require.NoError(t, err)
+	m, err := c.FetchNext()
+	require.NoError(t, err)
+	require.Equal(t, msgs[0], string(m.([]byte)))
+
+	//Lease of the file, which hasn't been read to the end, is released on
+	//close
+	require.NoError(t, c.Close())
+	_, err = os.Stat(leaseFileName(names[0]))
+	require.True(t, os.IsNotExist(err))
+	_, err = os.Stat(doneFileName(names[0]))
+	require.True(t, os.IsNotExist(err))
+
+	require.Equal(t, msgs, consumeToEnd(t, fp, topic))
+}
+
+func TestConsumerLeaseRecheck(t *testing.T) {
+	topic := "consumer-lease-test/"
+	fp, msgs := prepareLeaseTest(t, topic)
+
+	names := oneRecordTestFiles(t, fp, topic)
+	l := &fileFS{}
+	require.NoError(t, l.CreateExclusive(leaseFileName(names[1])))
+
+	saveOffset := InitialOffset
+	InitialOffset = OffsetOldest
+	defer func() { InitialOffset = saveOffset }()
+
+	c, err := fp.NewConsumer(topic)
+	require.NoError(t, err)
+
+	res := make([]string, 0)
+	for {
+		m, err := c.FetchNext()
+		require.NoError(t, err)
+		if m == nil {
+			break
+		}
+		res = append(res, string(m.([]byte)))
+		//Lease of the other consumer expires, while the consumer reads the
+		//next file
+		if len(res) == 4 {
+			require.NoError(t, l.Chtimes(leaseFileName(names[1]), time.Now().Add(-2*fp.cfg.ConsumerLease)))
+		}
+	}
+	require.NoError(t, c.Close())
+
+	//File skipped earlier is read after the current file, and the consumer
+	//continues from the position after the current file
+	exp := append(append(append(append([]string{}, msgs[:3]...), msgs[6:9]...), msgs[3:6]...), msgs[9:]...)
+	require.Equal(t, exp, res)
+
+	_, err = os.Stat(doneFileName(names[1]))
+	require.NoError(t, err)
+}
+
+func TestConsumerLeaseRenewal(t *testing.T) {
+	topic := "consumer-lease-test/"
+	fp, msgs := prepareLeaseTest(t, topic)
+	fp.cfg.ConsumerLease = 200 * time.Millisecond
+
+	names := oneRecordTestFiles(t, fp, topic)
+
+	saveOffset := InitialOffset
+	InitialOffset = OffsetOldest
+	defer func() { InitialOffset = saveOffset }()
+
+	c, err := fp.NewConsumer(topic)
+	require.NoError(t, err)
+	m, err := c.FetchNext()
+	require.NoError(t, err)
+	require.Equal(t, msgs[0], string(m.([]byte)))
+
+	//Lease is renewed while the consumer doesn't read
+	time.Sleep(3 * fp.cfg.ConsumerLease)
+	fi, err := os.Stat(leaseFileName(names[0]))
+	require.NoError(t, err)
+	require.True(t, time.Since(fi.ModTime()) < fp.cfg.ConsumerLease)
+
+	c1 := &fileConsumer{filePipe: fp, topic: topic, fs: fp.fs}
+	claimed, err := c1.claimLease(names[0])
+	require.NoError(t, err)
+	require.False(t, claimed)
+
+	require.NoError(t, c.Close())
+	_, err = os.Stat(leaseFileName(names[0]))
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestConsumerLeaseTakeover(t *testing.T) {
+	topic := "consumer-lease-test/"
+	fp, _ := prepareLeaseTest(t, topic)
+
+	names := oneRecordTestFiles(t, fp, topic)
+	l := &fileFS{}
+
+	consumers := make([]*fileConsumer, 4)
+	for i := range consumers {
+		consumers[i] = &fileConsumer{filePipe: fp, topic: topic, fs: fp.fs}
+	}
+
+	for _, n := range names {
+		require.NoError(t, l.CreateExclusive(leaseFileName(n)))
+		require.NoError(t, l.Chtimes(leaseFileName(n), time.Now().Add(-2*fp.cfg.ConsumerLease)))
+
+		//Only one of the consumers takes over the expired lease
+		var wg sync.WaitGroup
+		var claims int32
+		for _, c := range consumers {
+			wg.Add(1)
+			go func(c *fileConsumer) {
+				defer wg.Done()
+				claimed, err := c.claimLease(n)
+				require.NoError(t, err)
+				if claimed {
+					atomic.AddInt32(&claims, 1)
+				}
+			}(c)
+		}
+		wg.Wait()
+		require.Equal(t, int32(1), claims, n)
+
+		for _, c := range consumers {
+			c.releaseLease()
+		}
+	}
+}
+
+func TestConsumerLeaseCleanup(t *testing.T) {
+	topic := "consumer-lease-test/"
+	fp, msgs := prepareLeaseTest(t, topic)
+
+	names := oneRecordTestFiles(t, fp, topic)
+	require.Equal(t, msgs, consumeToEnd(t, fp, topic))
+
+	l := &fileFS{}
+	require.NoError(t, l.CreateExclusive(leaseFileName(names[1])))
+	require.NoError(t, l.Chtimes(leaseFileName(names[1]), time.Now().Add(-2*fp.cfg.ConsumerLease)))
+	require.NoError(t, l.CreateExclusive(leaseFileName(names[2])))
+
+	//Files removed by retention
+	for _, n := range names[:3] {
+		require.NoError(t, os.Remove(n))
+	}
+
+	require.Equal(t, 0, len(consumeToEnd(t, fp, topic)))
+
+	//Markers of the removed files are removed, except the live lease
+	for i, n := range names {
+		_, err := os.Stat(doneFileName(n))
+		require.Equal(t, i < 3, os.IsNotExist(err), n)
+	}
+	_, err := os.Stat(leaseFileName(names[1]))
+	require.True(t, os.IsNotExist(err))
+	_, err = os.Stat(leaseFileName(names[2]))
+	require.NoError(t, err)
+}
+
+//chtimesFailingFS fails the lease renewals, once fail is set
+type chtimesFailingFS struct {
+	fileFS
+	fail int32
+}
+
+func (f *chtimesFailingFS) Chtimes(name string, mtime time.Time) error {
+	if atomic.LoadInt32(&f.fail) != 0 {
+		return fmt.Errorf("renewal failure")
+	}
+	return f.fileFS.Chtimes(name, mtime)
+}
+
+func TestConsumerLeaseRenewalFailure(t *testing.T) {
+	topic := "consumer-lease-test/"
+	fp, msgs := prepareLeaseTest(t, topic)
+	fp.cfg.ConsumerLease = 200 * time.Millisecond
+	ffs := &chtimesFailingFS{}
+	fp.fs = ffs
+
+	names := oneRecordTestFiles(t, fp, topic)
+
+	saveOffset := InitialOffset
+	InitialOffset = OffsetOldest
+	defer func() { InitialOffset = saveOffset }()
+
+	c, err := fp.NewConsumer(topic)
+	require.NoError(t, err)
+	m, err := c.FetchNext()
+	require.NoError(t, err)
+	require.Equal(t, msgs[0], string(m.([]byte)))
+
+	atomic.StoreInt32(&ffs.fail, 1)
+	time.Sleep(fp.cfg.ConsumerLease)
+
+	//Record read ahead before the renewal failure is delivered, but no more
+	//records of the file
+	m, err = c.FetchNext()
+	if err == nil {
+		require.Equal(t, msgs[1], string(m.([]byte)))
+		_, err = c.FetchNext()
+	}
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "lease")
+	require.NoError(t, c.CloseOnFailure())
+
+	//Lost lease is not released, it can be held by the other consumer
+	_, err = os.Stat(leaseFileName(names[0]))
+	require.NoError(t, err)
+}
@@ -76,6 +76,7 @@ func (p *localPipe) registerProducerConsumer(key string, consumer bool) (*localP
 	p.mutex.Unlock()
 	l := &localProducerConsumer{ch: ch}
 	if consumer {
+		l.transformPolicy = p.cfg.TransformErrorPolicy
 		l.initBaseConsumer(l.fetchNext)
 	} else {
 		l.ctx, l.cancel = context.WithCancel(context.Background())
@@ -0,0 +1,116 @@
+package pipe
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/uber/storagetapper/log"
+	"github.com/uber/storagetapper/metrics"
+)
+
+//RecordLocation is the position of the record in the topic.
+//Offset is the byte offset of the record in the file. In compressed or
+//encrypted files it's the offset in the decoded data plus the length of the
+//header. Index is the number of the records preceding the record in the file
+type RecordLocation struct {
+	File   string
+	Offset int64
+	Index  int64
+}
+
+//LocatedMessage is delivered by the consumer instead of the record payload
+//when AttachLocation is enabled
+type LocatedMessage struct {
+	Data     []byte
+	Location RecordLocation
+}
+
+//countingReader counts the bytes read from the file or decoded stream
+type countingReader struct {
+	io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(b []byte) (int, error) {
+	n, err := r.Reader.Read(b)
+	r.n += int64(n)
+	return n, err
+}
+
+//countReader tracks position of the reader, which starts at the start
+//offset, if AttachLocation is enabled
+func (p *fileConsumer) countReader(r io.Reader, start int64) io.Reader {
+	if !p.cfg.AttachLocation {
+		return r
+	}
+	p.counter = &countingReader{Reader: r, n: start}
+	return p.counter
+}
+
+//position returns the offset of the next record in the current file
+func (p *fileConsumer) position() int64 {
+	if p.counter == nil || p.reader == nil {
+		return 0
+	}
+	return p.counter.n - int64(p.reader.Buffered())
+}
+
+//message returns the last read record, with its location attached if
+//AttachLocation is enabled
+func (p *fileConsumer) message() interface{} {
+	if p.err != nil || p.msg == nil {
+		return p.msg
+	}
+	var msg interface{} = p.msg
+	if p.cfg.AttachLocation {
+		msg = &LocatedMessage{Data: p.msg, Location: p.loc}
+	}
+	return p.order.attach(msg, p.loc.File, p.loc.Index, p.msg)
+}
+
+//filtered returns true if the current file is read through decompressor or
+//decryptor, so as records can't be read at their byte offset
+func (p *fileConsumer) filtered() bool {
+	return p.fileEncrypted() || p.rcfg.Compression || (p.cfg.ExternalIngest && hadoopCodec(p.name) != nil)
+}
+
+//ReadAt reads the record at the location attached by the consumer. The record
+//of the plain file is read at its byte offset, compressed and encrypted files
+//are read from the beginning up to the record index
+func (p *filePipe) ReadAt(topic string, loc RecordLocation) ([]byte, error) {
+	fp := *p
+	fp.cfg.AttachLocation = false
+	fp.hooks = nil
+
+	m := metrics.NewFilePipeMetrics("pipe_read_at", map[string]string{"topic": topic, "pipeType": "file"})
+	c := &fileConsumer{filePipe: &fp, topic: topic, fs: fp.fs, metrics: m}
+
+	c.openFile(loc.File, 0)
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	skip := loc.Index
+	if !c.filtered() && loc.Offset > c.headerLen {
+		log.E(c.file.Close())
+		c.openFile(loc.File, loc.Offset)
+		if c.err != nil {
+			return nil, c.err
+		}
+		skip = 0
+	}
+	defer func() { log.E(c.file.Close()) }()
+
+	for i := int64(0); i <= skip; i++ {
+		c.writeMessage()
+		if c.err == io.EOF {
+			return nil, fmt.Errorf("record %+v is beyond the end of the file %v", loc, filepath.Base(c.name))
+		}
+		if c.err != nil {
+			return nil, c.err
+		}
+	}
+
+	return c.msg, nil
+}
@@ -0,0 +1,89 @@
+package pipe
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testAttachLocation(t *testing.T, header bool, compression bool, format string) {
+	deleteTestTopics(t)
+
+	topic := "attach-location-test/"
+
+	pcfg := cfg.Pipe
+	pcfg.NonBlocking = true
+	pcfg.FileHeader = header
+	pcfg.Compression = compression
+	pcfg.MaxFileSize = 128
+	fp := initTestFilePipe(&pcfg, false, t)
+
+	msgs := make([]string, 0)
+	for i := 0; i < 30; i++ {
+		msgs = append(msgs, fmt.Sprintf(`{"record":%v}`, i))
+	}
+
+	p, err := fp.NewProducer(topic)
+	require.NoError(t, err)
+	p.SetFormat(format)
+	for _, m := range msgs {
+		require.NoError(t, p.Push([]byte(m)))
+	}
+	require.NoError(t, p.Close())
+
+	saveOffset := InitialOffset
+	InitialOffset = OffsetOldest
+	defer func() { InitialOffset = saveOffset }()
+
+	fp.cfg.AttachLocation = true
+	c, err := fp.NewConsumer(topic)
+	require.NoError(t, err)
+	c.SetFormat(format)
+
+	files := make(map[string]bool)
+	var prev RecordLocation
+	for _, m := range msgs {
+		msg, err := c.FetchNext()
+		require.NoError(t, err)
+		lm, ok := msg.(*LocatedMessage)
+		require.True(t, ok)
+		require.Equal(t, m, string(lm.Data))
+
+		loc := lm.Location
+		if loc.File == prev.File {
+			require.Equal(t, prev.Index+1, loc.Index)
+			require.True(t, loc.Offset > prev.Offset)
+		} else {
+			require.Equal(t, int64(0), loc.Index)
+		}
+		prev = loc
+		files[loc.File] = true
+
+		b, err := fp.ReadAt(topic, loc)
+		require.NoError(t, err)
+		require.Equal(t, m, string(b))
+	}
+	require.NoError(t, c.CloseOnFailure())
+
+	require.True(t, len(files) > 1)
+
+	_, err = fp.ReadAt(topic, RecordLocation{File: prev.File, Offset: 1 << 20, Index: 1 << 20})
+	require.Error(t, err)
+}
+
+func TestAttachLocation(t *testing.T) {
+	for _, format := range []string{"json", "msgpack"} {
+		for _, header := range []bool{false, true} {
+			for _, compression := range []bool{false, true} {
+				//Format of the files without header is binary
+				if !header && format == "json" {
+					continue
+				}
+				t.Run(fmt.Sprintf("%v/header=%v/compression=%v", format, header, compression), func(t *testing.T) {
+					testAttachLocation(t, header, compression, format)
+				})
+			}
+		}
+	}
+}
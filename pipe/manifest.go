@@ -0,0 +1,186 @@
+package pipe
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/uber/storagetapper/log"
+)
+
+//partitionManifest is maintained per topic partition (producer key) and
+//updated every time a file of the partition is finalized
+type partitionManifest struct {
+	Partition string
+	NumRecs   int64
+	Files     []stat
+	//Records of the files removed from the topic, which are compacted out of
+	//the Files list
+	RemovedRecs int64 `json:",omitempty"`
+}
+
+//manifestCompactFiles is the number of the files listed in the partition
+//manifest, every time it's reached the files removed from the topic are
+//compacted out of the list
+var manifestCompactFiles = 1000
+
+//metaFileName returns the name of topic metadata file of given kind.
+//Metadata files live in the topic directory and their names start with
+//underscore, so they are skipped by consumers
+func metaFileName(tp string, kind string) string {
+	prefix := tp[strings.LastIndex(tp, "/")+1:]
+	if prefix != "" {
+		prefix += "."
+	}
+	return filepath.Dir(tp) + "/_" + prefix + kind
+}
+
+func isMetaFile(name string) bool {
+	return strings.HasPrefix(filepath.Base(name), "_")
+}
+
+func manifestFileName(tp string, partition string) string {
+	return metaFileName(tp, partition+".manifest")
+}
+
+//readMetaFile reads and unmarshals metadata file. Returns false if the file
+//doesn't exist
+func readMetaFile(f fs, name string, v interface{}) (bool, error) {
+	r, err := f.OpenRead(name, 0)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = r.Close() }()
+
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return false, err
+	}
+
+	return true, json.Unmarshal(b, v)
+}
+
+//writeMetaFile replaces the content of the metadata file. The content is
+//written to the .open file and then renamed, so readers never see partially
+//written metadata
+func writeMetaFile(f fs, name string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	if err := f.Remove(name + ".open"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	w, _, err := f.OpenWrite(name + ".open")
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(b); err != nil {
+		_ = w.Close()
+		return err
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return f.Rename(name+".open", name)
+}
+
+//updateManifest adds the finalized file to the manifest of the partition.
+//Manifest is shared by the producers of all the processes, so it's updated
+//under the lock
+func (p *fileProducer) updateManifest(partition string, s *stat) error {
+	tp := p.topicPath(p.topic)
+	name := manifestFileName(tp, partition)
+
+	unlock, err := p.lockMetaFile(p.fs, name)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	var m partitionManifest
+	if _, err := readMetaFile(p.fs, name, &m); err != nil {
+		return err
+	}
+
+	m.Partition = partition
+	m.NumRecs += s.NumRecs
+	m.Files = append(m.Files, *s)
+
+	if len(m.Files)%manifestCompactFiles == 0 {
+		if err := p.compactManifest(tp, &m); err != nil {
+			return err
+		}
+	}
+
+	return writeMetaFile(p.fs, name, &m)
+}
+
+//compactManifest drops the files removed from the topic from the manifest
+//file list, keeping the number of their records
+func (p *filePipe) compactManifest(tp string, m *partitionManifest) error {
+	files, err := p.readTopicDir(tp)
+	if err != nil {
+		return err
+	}
+
+	present := make(map[string]bool)
+	for _, f := range files {
+		present[filepath.Dir(tp)+"/"+f.Name()] = true
+	}
+
+	j := 0
+	for _, s := range m.Files {
+		if present[s.FileName] {
+			m.Files[j] = s
+			j++
+		} else {
+			m.RemovedRecs += s.NumRecs
+		}
+	}
+
+	log.Debugf("Compacted %v removed files out of the manifest of %v", len(m.Files)-j, m.Partition)
+	m.Files = m.Files[:j]
+
+	return nil
+}
+
+//removedRecs returns the number of records of the removed files compacted
+//out of the manifests of the topic
+func (p *filePipe) removedRecs(tp string, files []os.FileInfo) (int64, error) {
+	mprefix := filepath.Base(metaFileName(tp, ""))
+
+	var n int64
+	for _, f := range files {
+		if f.IsDir() || !strings.HasPrefix(f.Name(), mprefix) || !strings.HasSuffix(f.Name(), ".manifest") {
+			continue
+		}
+		var m partitionManifest
+		if _, err := readMetaFile(p.fs, filepath.Dir(tp)+"/"+f.Name(), &m); err != nil {
+			return 0, err
+		}
+		n += m.RemovedRecs
+	}
+
+	return n, nil
+}
+
+//PartitionRowCount returns number of records in finalized files of the given
+//topic partition. Requires PartitionManifest to be enabled in the producer
+func (p *filePipe) PartitionRowCount(topic string, partition string) (int64, error) {
+	var m partitionManifest
+	if _, err := readMetaFile(p.fs, manifestFileName(topicPath(p.datadir, topic), partition), &m); err != nil {
+		return 0, err
+	}
+	return m.NumRecs, nil
+}
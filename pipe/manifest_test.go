@@ -0,0 +1,162 @@
+package pipe
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPartitionRowCount(t *testing.T) {
+	deleteTestTopics(t)
+
+	topic := "manifest-test-topic/"
+
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	fp.cfg.PartitionManifest = true
+	fp.cfg.MaxFileSize = 1 //rotate on every message
+	fp.cfg.NonBlocking = true
+
+	p, err := fp.NewProducer(topic)
+	require.NoError(t, err)
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, p.PushK("log", []byte(fmt.Sprintf(`{"log":%v}`, i))))
+	}
+	for i := 0; i < 3; i++ {
+		require.NoError(t, p.PushK("snapshot", []byte(fmt.Sprintf(`{"snapshot":%v}`, i))))
+	}
+	require.NoError(t, p.Close())
+
+	n, err := fp.PartitionRowCount(topic, "log")
+	require.NoError(t, err)
+	require.Equal(t, int64(5), n)
+
+	n, err = fp.PartitionRowCount(topic, "snapshot")
+	require.NoError(t, err)
+	require.Equal(t, int64(3), n)
+
+	n, err = fp.PartitionRowCount(topic, "nonexistent")
+	require.NoError(t, err)
+	require.Equal(t, int64(0), n)
+
+	//Counts must survive producer restarts.
+	//File names are unique only within the second of the producer lifetime
+	time.Sleep(time.Second)
+	p, err = fp.NewProducer(topic)
+	require.NoError(t, err)
+	require.NoError(t, p.PushK("log", []byte(`{"log":5}`)))
+	require.NoError(t, p.Close())
+
+	n, err = fp.PartitionRowCount(topic, "log")
+	require.NoError(t, err)
+	require.Equal(t, int64(6), n)
+
+	//Consumer should skip manifest files
+	saveOffset := InitialOffset
+	InitialOffset = OffsetOldest
+	defer func() { InitialOffset = saveOffset }()
+
+	c, err := fp.NewConsumer(topic)
+	require.NoError(t, err)
+	for i := 0; i < 9; i++ {
+		m, err := c.FetchNext()
+		require.NoError(t, err)
+		require.NotNil(t, m)
+	}
+	m, err := c.FetchNext()
+	require.NoError(t, err)
+	require.Nil(t, m)
+	require.NoError(t, c.Close())
+}
+
+func TestPartitionManifestConcurrentUpdates(t *testing.T) {
+	deleteTestTopics(t)
+
+	topic := "manifest-concurrent-test/"
+
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	tp := topicPath(fp.datadir, topic)
+	require.NoError(t, fp.fs.MkdirAll(filepath.Dir(tp), 0770))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			pr := &fileProducer{filePipe: fp, topic: topic, fs: fp.fs}
+			require.NoError(t, pr.updateManifest("log", &stat{NumRecs: 1, FileName: fmt.Sprintf("%vfile%v", tp, i)}))
+		}(i)
+	}
+	wg.Wait()
+
+	var m partitionManifest
+	_, err := readMetaFile(fp.fs, manifestFileName(tp, "log"), &m)
+	require.NoError(t, err)
+	require.Equal(t, int64(10), m.NumRecs)
+	require.Equal(t, 10, len(m.Files))
+}
+
+func TestPartitionManifestCompaction(t *testing.T) {
+	deleteTestTopics(t)
+
+	topic := "manifest-compact-test/"
+
+	saveCompact := manifestCompactFiles
+	defer func() { manifestCompactFiles = saveCompact }()
+	manifestCompactFiles = 3
+
+	saveTimeNow := timeNow
+	defer func() { timeNow = saveTimeNow }()
+	now := time.Unix(time.Now().Unix(), 0)
+	timeNow = func() time.Time { return now }
+
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	fp.cfg.PartitionManifest = true
+	fp.cfg.MaxFileSize = 1 //rotate on every message
+
+	p, err := fp.NewProducer(topic)
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		require.NoError(t, p.PushK("log", []byte(fmt.Sprintf(`{"log":%v}`, i))))
+		now = now.Add(time.Second)
+	}
+	require.NoError(t, p.Close())
+
+	//Retention removes the first two files
+	tp := topicPath(fp.datadir, topic)
+	var m partitionManifest
+	_, err = readMetaFile(fp.fs, manifestFileName(tp, "log"), &m)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(m.Files))
+	for _, s := range m.Files {
+		require.NoError(t, os.Remove(s.FileName))
+	}
+
+	p, err = fp.NewProducer(topic)
+	require.NoError(t, err)
+	for i := 2; i < 4; i++ {
+		require.NoError(t, p.PushK("log", []byte(fmt.Sprintf(`{"log":%v}`, i))))
+		now = now.Add(time.Second)
+	}
+	require.NoError(t, p.Close())
+
+	m = partitionManifest{}
+	_, err = readMetaFile(fp.fs, manifestFileName(tp, "log"), &m)
+	require.NoError(t, err)
+	require.Equal(t, int64(4), m.NumRecs)
+	require.Equal(t, int64(2), m.RemovedRecs)
+	require.Equal(t, 2, len(m.Files))
+
+	n, err := fp.PartitionRowCount(topic, "log")
+	require.NoError(t, err)
+	require.Equal(t, int64(4), n)
+
+	n, err = fp.EarliestOffset(topic)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), n)
+}
@@ -0,0 +1,107 @@
+package pipe
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/uber/storagetapper/config"
+)
+
+const (
+	defaultReadBufferSize = 4096
+	minReadBufferSize     = 16
+	gzipWindowSize        = 32 * 1024
+)
+
+//RecordTooLargeError is returned by the consumer when the record doesn't fit
+//into MaxConsumerMemory
+type RecordTooLargeError struct {
+	File  string
+	Size  int64 //Size of the record, lower bound of the size for text records
+	Limit int64
+}
+
+func (e *RecordTooLargeError) Error() string {
+	return fmt.Sprintf("record of %v bytes in %v exceeds consumer memory limit, max record size is %v bytes", e.Size, e.File, e.Limit)
+}
+
+//boundedConfig returns consumer config with read and decompression buffers
+//bounded by MaxConsumerMemory and the maximum size of the record, which fits
+//into the rest of the budget. Returns 0 if memory is not bounded.
+//Budget is split evenly across ParallelReaders, which read files concurrently
+func boundedConfig(cfg config.PipeConfig) (config.PipeConfig, int64, error) {
+	m := cfg.MaxConsumerMemory
+	if m <= 0 {
+		return cfg, 0, nil
+	}
+	if cfg.ParallelReaders > 1 {
+		m /= int64(cfg.ParallelReaders)
+	}
+
+	buf := int64(defaultReadBufferSize)
+	if cfg.DecompressBufferSize != 0 {
+		buf = int64(cfg.DecompressBufferSize)
+	}
+	if buf > m/16 {
+		buf = m / 16
+	}
+	if buf < minReadBufferSize {
+		return cfg, 0, fmt.Errorf("max consumer memory is too small: %v", m)
+	}
+	cfg.DecompressBufferSize = int(buf)
+
+	//file read buffer, decompressor input and output buffers
+	used := 3 * buf
+
+	if cfg.Compression && compressionType(&cfg) == CompressionZstd {
+		w := int64(zstd.MinWindowSize)
+		for w*2 <= m/4 {
+			w *= 2
+		}
+		if cfg.ZstdWindowSize != 0 && int64(cfg.ZstdWindowSize) < w {
+			w = int64(cfg.ZstdWindowSize)
+		}
+		cfg.ZstdWindowSize = int(w)
+		used += w
+	} else if cfg.Compression {
+		used += gzipWindowSize
+	}
+
+	if used >= m {
+		return cfg, 0, fmt.Errorf("max consumer memory is too small: %v", m)
+	}
+
+	return cfg, m - used, nil
+}
+
+//newFileReader returns buffered reader of the raw file data
+func (p *fileConsumer) newFileReader(r io.Reader) *bufio.Reader {
+	if p.recLimit != 0 {
+		return bufio.NewReaderSize(r, p.rcfg.DecompressBufferSize)
+	}
+	return bufio.NewReader(r)
+}
+
+//readDelimited reads delimiter terminated record, failing if the record is
+//longer than the limit, 0 - unlimited
+func readDelimited(r *bufio.Reader, limit int64) ([]byte, error) {
+	if limit == 0 {
+		return r.ReadBytes(delimiter)
+	}
+
+	var res []byte
+	for {
+		b, err := r.ReadSlice(delimiter)
+		//Delimiter is not a part of the record
+		if sz := int64(len(res) + len(b)); sz > limit+1 || (sz == limit+1 && b[len(b)-1] != delimiter) {
+			return nil, &RecordTooLargeError{Size: sz, Limit: limit}
+		}
+		res = append(res, b...)
+		if err != bufio.ErrBufferFull {
+			return res, err
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package pipe
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func consumeTestMsgsLimited(t *testing.T, fp *filePipe, topic string, format string) ([]string, error) {
+	saveOffset := InitialOffset
+	InitialOffset = OffsetOldest
+	defer func() { InitialOffset = saveOffset }()
+
+	c, err := fp.NewConsumer(topic)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, c.CloseOnFailure()) }()
+	if format != "" {
+		c.SetFormat(format)
+	}
+
+	res := make([]string, 0)
+	for {
+		m, err := c.FetchNext()
+		if err != nil || m == nil {
+			return res, err
+		}
+		res = append(res, string(m.([]byte)))
+	}
+}
+
+func TestMaxConsumerMemory(t *testing.T) {
+	big := strings.Repeat("b", 64*1024)
+	tests := []struct {
+		name        string
+		format      string
+		compression string
+		chunkSize   int
+	}{
+		{"binary", "", "", 0},
+		{"text", "json", "", 0},
+		{"fragmented", "", "", 1024},
+		{"gzip", "", CompressionGzip, 0},
+		{"zstd", "json", CompressionZstd, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deleteTestTopics(t)
+
+			topic := "max-consumer-memory-" + tt.name + "/"
+
+			fp := initTestFilePipe(&cfg.Pipe, false, t)
+			fp.cfg.NonBlocking = true
+			fp.cfg.FileDelimited = true
+			fp.cfg.ChunkSize = tt.chunkSize
+			fp.cfg.Compression = tt.compression != ""
+			fp.cfg.CompressionType = tt.compression
+			fp.cfg.MaxConsumerMemory = 32 * 1024
+			if tt.compression != "" {
+				fp.cfg.MaxConsumerMemory = 80 * 1024
+				//Decompression window should fit into the budget
+				fp.cfg.ZstdWindowSize = 16 * 1024
+			}
+
+			p, err := fp.NewProducer(topic)
+			require.NoError(t, err)
+			if tt.format != "" {
+				p.SetFormat(tt.format)
+			}
+			require.NoError(t, p.Push([]byte("small")))
+			require.NoError(t, p.Push([]byte(big)))
+			require.NoError(t, p.Close())
+
+			saveOffset := InitialOffset
+			InitialOffset = OffsetOldest
+			defer func() { InitialOffset = saveOffset }()
+
+			c, err := fp.NewConsumer(topic)
+			require.NoError(t, err)
+			if tt.format != "" {
+				c.SetFormat(tt.format)
+			}
+			m, err := c.FetchNext()
+			require.NoError(t, err)
+			require.Equal(t, "small", string(m.([]byte)))
+
+			_, err = c.FetchNext()
+			require.Error(t, err)
+			e, ok := err.(*RecordTooLargeError)
+			require.True(t, ok, "%T %v", err, err)
+			require.True(t, e.Size > e.Limit)
+			require.True(t, e.Limit < fp.cfg.MaxConsumerMemory)
+			require.Contains(t, e.File, topic)
+			require.NoError(t, c.CloseOnFailure())
+
+			//Record fits into the budget
+			fp.cfg.MaxConsumerMemory = 1024 * 1024
+			res, err := consumeTestMsgsLimited(t, fp, topic, tt.format)
+			require.NoError(t, err)
+			require.Equal(t, []string{"small", big}, res)
+		})
+	}
+}
+
+func TestMaxConsumerMemoryTooSmall(t *testing.T) {
+	c := cfg.Pipe
+	c.MaxConsumerMemory = 100
+	_, _, err := boundedConfig(c)
+	require.Error(t, err)
+
+	c.MaxConsumerMemory = 64 * 1024
+	c.Compression = true
+	c.CompressionType = CompressionZstd
+	b, limit, err := boundedConfig(c)
+	require.NoError(t, err)
+	require.Equal(t, 4096, b.DecompressBufferSize)
+	require.Equal(t, 16*1024, b.ZstdWindowSize)
+	require.Equal(t, int64(64*1024-3*4096-16*1024), limit)
+}
+
+func TestMaxConsumerMemoryParallelReaders(t *testing.T) {
+	c := cfg.Pipe
+	c.MaxConsumerMemory = 64 * 1024
+	c.ParallelReaders = 4
+	b, limit, err := boundedConfig(c)
+	require.NoError(t, err)
+	require.Equal(t, 1024, b.DecompressBufferSize)
+	require.Equal(t, int64(16*1024-3*1024), limit)
+
+	//Budget of each reader is too small
+	c.ParallelReaders = 512
+	_, _, err = boundedConfig(c)
+	require.Error(t, err)
+}
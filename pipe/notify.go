@@ -0,0 +1,308 @@
+package pipe
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/uber/storagetapper/log"
+)
+
+//CompletedPartition describes the data written to the topic partition
+//(producer key) by the producer
+type CompletedPartition struct {
+	Topic     string
+	Partition string
+	NumRecs   int64
+	//Time range of the records of the partition
+	MinTimestamp time.Time
+	MaxTimestamp time.Time
+}
+
+//PartitionCompleteNotifier signals external systems, such as workflow
+//orchestrators, that the partition is complete. It's called once the
+//partition is rolled out by all the producers writing it: when the producer
+//starts the next time partition, see TimePartitionFormat, or is closed
+//gracefully. Partition which receives late records after it's been rolled out
+//is signaled again
+type PartitionCompleteNotifier interface {
+	PartitionComplete(p *CompletedPartition) error
+}
+
+type noopNotifier struct{}
+
+//NoopNotifier is the default notifier, which doesn't signal anything
+var NoopNotifier PartitionCompleteNotifier = noopNotifier{}
+
+//PartitionComplete implements PartitionCompleteNotifier
+func (noopNotifier) PartitionComplete(*CompletedPartition) error {
+	return nil
+}
+
+//HTTPNotifier posts completed partition as JSON to the URL
+type HTTPNotifier struct {
+	URL    string
+	Client *http.Client
+}
+
+//NewHTTPNotifier creates notifier posting to the url with the given request
+//timeout
+func NewHTTPNotifier(url string, timeout time.Duration) *HTTPNotifier {
+	return &HTTPNotifier{URL: url, Client: &http.Client{Timeout: timeout}}
+}
+
+//PartitionComplete implements PartitionCompleteNotifier
+func (n *HTTPNotifier) PartitionComplete(p *CompletedPartition) error {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	resp, err := n.Client.Post(n.URL, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("partition complete notification %v: %v", n.URL, resp.Status)
+	}
+
+	return nil
+}
+
+//SetPartitionCompleteNotifier sets the notifier for the producers created by
+//the pipe afterwards. Producers share the partition state in the state DB,
+//without the DB the storage should support locking of the metadata files,
+//otherwise the producers fail to be created
+func (p *filePipe) SetPartitionCompleteNotifier(n PartitionCompleteNotifier) {
+	p.notifier = n
+}
+
+//producerSeq makes ids of the producers of the process unique
+var producerSeq int64
+
+//producerID returns the id of the producer, unique across the processes
+func (p *fileProducer) producerID() string {
+	if p.id == "" {
+		h, _ := os.Hostname()
+		p.id = fmt.Sprintf("%s-%d-%d", h, os.Getpid(), atomic.AddInt64(&producerSeq, 1))
+	}
+	return p.id
+}
+
+//defaultPartitionWriterTTL is the default of PartitionWriterTTL
+var defaultPartitionWriterTTL = time.Hour
+
+//checkNotifier verifies that the partition writers state can be updated
+//atomically: in the state DB or in the metadata file, locked by the
+//filesystem
+func (p *filePipe) checkNotifier(f fs) error {
+	if p.notifier == nil || p.db != nil {
+		return nil
+	}
+	if _, ok := f.(leaseFS); !ok {
+		return fmt.Errorf("partition complete notifier requires state DB with this storage")
+	}
+	return nil
+}
+
+//partitionWriters is the state of the partition shared by the producers of
+//all the processes. Completion is signaled by the last producer of the
+//partition, with the data written by all of them. Writers map the producer
+//id to its last heartbeat
+type partitionWriters struct {
+	Writers   map[string]time.Time
+	Completed *CompletedPartition
+}
+
+func partitionWritersName(tp string, partition string) string {
+	return metaFileName(tp, partition+".writers")
+}
+
+func (p *fileProducer) writerTTL() time.Duration {
+	if p.cfg.PartitionWriterTTL != 0 {
+		return p.cfg.PartitionWriterTTL
+	}
+	return defaultPartitionWriterTTL
+}
+
+//expireWriters removes the writers, which haven't sent the heartbeat within
+//the TTL. Those are the producers, which crashed without unregistering
+func (w *partitionWriters) expireWriters(ttl time.Duration) {
+	for id, t := range w.Writers {
+		if timeNow().Sub(t) > ttl {
+			log.Warnf("Partition writer %v expired, last heartbeat at %v", id, t)
+			delete(w.Writers, id)
+		}
+	}
+}
+
+//registerWriter adds the producer to the writers of the partition, before
+//the producer opens the first file of the partition. Registration of the
+//producer writing the partition is renewed by the heartbeat every quarter
+//of the TTL
+func (p *fileProducer) registerWriter(partition string) error {
+	if p.notifier == nil {
+		return nil
+	}
+	if t, ok := p.writing[partition]; ok && timeNow().Sub(t) < p.writerTTL()/4 {
+		return nil
+	}
+
+	name := partitionWritersName(p.topicPath(p.topic), partition)
+	if err := p.fs.MkdirAll(filepath.Dir(name), dirPerm); err != nil {
+		return err
+	}
+
+	now := timeNow()
+	var w partitionWriters
+	err := p.updateState(p.fs, name, &w, func() {
+		if w.Writers == nil {
+			w.Writers = make(map[string]time.Time)
+		}
+		w.expireWriters(p.writerTTL())
+		w.Writers[p.producerID()] = now
+	})
+	if err != nil {
+		return err
+	}
+
+	if p.writing == nil {
+		p.writing = make(map[string]time.Time)
+	}
+	p.writing[partition] = now
+
+	return nil
+}
+
+//unregisterWriter removes the producer from the writers of the partition and
+//adds the data written by the producer, if any, to the partition. Returns
+//the data of all the producers, when the producer is the last writer and
+//completes the partition. Partition left by the failed producer is completed
+//by the next producer writing it, once the registration of the failed
+//producer expires
+func (p *fileProducer) unregisterWriter(partition string, c *CompletedPartition, complete bool) (*CompletedPartition, error) {
+	delete(p.writing, partition)
+
+	var w partitionWriters
+	var res *CompletedPartition
+	err := p.updateState(p.fs, partitionWritersName(p.topicPath(p.topic), partition), &w, func() {
+		delete(w.Writers, p.id)
+		w.expireWriters(p.writerTTL())
+		if c != nil {
+			if w.Completed == nil {
+				w.Completed = &CompletedPartition{Topic: c.Topic, Partition: c.Partition, MinTimestamp: c.MinTimestamp, MaxTimestamp: c.MaxTimestamp}
+			}
+			w.Completed.add(c.NumRecs, c.MinTimestamp, c.MaxTimestamp)
+		}
+		if complete && len(w.Writers) == 0 {
+			res, w.Completed = w.Completed, nil
+		}
+	})
+
+	return res, err
+}
+
+func (c *CompletedPartition) add(numRecs int64, min time.Time, max time.Time) {
+	c.NumRecs += numRecs
+	if min.Before(c.MinTimestamp) {
+		c.MinTimestamp = min
+	}
+	if max.After(c.MaxTimestamp) {
+		c.MaxTimestamp = max
+	}
+}
+
+//partitionWritten accounts finalized file in the partition it belongs to
+func (p *fileProducer) partitionWritten(partition string, s *stat) {
+	if p.completed == nil {
+		p.completed = make(map[string]*CompletedPartition)
+	}
+
+	min, max := time.Unix(s.MinTimestamp, 0), time.Unix(s.MaxTimestamp, 0)
+
+	c := p.completed[partition]
+	if c == nil {
+		c = &CompletedPartition{Topic: p.topic, Partition: partition, MinTimestamp: min, MaxTimestamp: max}
+		p.completed[partition] = c
+	}
+
+	c.add(s.NumRecs, min, max)
+}
+
+//rollover completes the time partitions older than the partition of the
+//record, when the record starts the newer partition. Records are expected to
+//arrive in event time order mostly, so the older partitions are not written
+//anymore
+func (p *fileProducer) rollover(partition string) error {
+	if p.cfg.TimePartitionFormat == "" || partition <= p.partition {
+		return nil
+	}
+	p.partition = partition
+
+	older := func(k string) bool { return k < partition }
+
+	for f := p.ffirst; f != nil; {
+		next := f.next
+		if older(f.key) {
+			if err := p.closeFile(f, true); err != nil {
+				return err
+			}
+		}
+		f = next
+	}
+
+	p.notifyCompleted(older)
+
+	return nil
+}
+
+//notifyCompleted signals completion of the partitions written by the
+//producer, in partition order. Only the partitions for which complete
+//returns true are completed. Partition isn't signaled until it's completed
+//by all the producers writing it. Notification failure doesn't fail the
+//producer, as the data has been finalized already
+func (p *fileProducer) notifyCompleted(complete func(partition string) bool) {
+	if p.notifier == nil {
+		p.completed = nil
+		return
+	}
+
+	keys := make([]string, 0, len(p.writing))
+	for k := range p.writing {
+		if complete(k) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		c := p.completed[k]
+		delete(p.completed, k)
+		res, err := p.unregisterWriter(k, c, true)
+		if log.E(err) || res == nil {
+			continue
+		}
+		log.E(p.notifier.PartitionComplete(res))
+	}
+}
+
+//leavePartitions unregisters the failed producer from the writers of the
+//partitions without completing them
+func (p *fileProducer) leavePartitions() {
+	for k := range p.writing {
+		_, err := p.unregisterWriter(k, p.completed[k], false)
+		log.E(err)
+	}
+	p.completed = nil
+}
@@ -0,0 +1,261 @@
+package pipe
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeNotifier struct {
+	completed []CompletedPartition
+}
+
+func (n *fakeNotifier) PartitionComplete(p *CompletedPartition) error {
+	n.completed = append(n.completed, *p)
+	return nil
+}
+
+func TestPartitionCompleteNotifier(t *testing.T) {
+	deleteTestTopics(t)
+
+	topic := "partition-complete-test/"
+
+	saveTimeNow := timeNow
+	defer func() { timeNow = saveTimeNow }()
+	start := time.Unix(time.Now().Unix(), 0)
+	now := start
+	timeNow = func() time.Time { return now }
+
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	fp.cfg.NonBlocking = true
+	fp.cfg.MaxFileSize = 1 //rotate on every message
+
+	n := &fakeNotifier{}
+	fp.SetPartitionCompleteNotifier(n)
+
+	p, err := fp.NewProducer(topic)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, p.PushK("b", []byte("b record")))
+		now = now.Add(time.Second)
+		require.NoError(t, p.PushK("a", []byte("a record")))
+		now = now.Add(time.Second)
+	}
+	require.NoError(t, p.PushBatch("b", []byte("b batch record")))
+	require.NoError(t, p.PushBatchCommit())
+
+	//Rotation doesn't complete the partition
+	require.Equal(t, 0, len(n.completed))
+
+	require.NoError(t, p.Close())
+
+	require.Equal(t, []CompletedPartition{
+		{Topic: topic, Partition: "a", NumRecs: 3, MinTimestamp: start.Add(time.Second), MaxTimestamp: start.Add(5 * time.Second)},
+		{Topic: topic, Partition: "b", NumRecs: 4, MinTimestamp: start, MaxTimestamp: start.Add(6 * time.Second)},
+	}, n.completed)
+
+	//Producer failure doesn't complete the partition
+	n.completed = nil
+	p, err = fp.NewProducer(topic)
+	require.NoError(t, err)
+	require.NoError(t, p.PushBatch("a", []byte("failed record")))
+	require.NoError(t, p.CloseOnFailure())
+	require.Equal(t, 0, len(n.completed))
+
+	//Producer without data completes nothing
+	p, err = fp.NewProducer(topic)
+	require.NoError(t, err)
+	require.NoError(t, p.Close())
+	require.Equal(t, 0, len(n.completed))
+}
+
+func TestHTTPNotifier(t *testing.T) {
+	var got []CompletedPartition
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "POST", r.Method)
+		b, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		var c CompletedPartition
+		require.NoError(t, json.Unmarshal(b, &c))
+		got = append(got, c)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	n := NewHTTPNotifier(srv.URL+"/complete", time.Second)
+
+	ts := time.Unix(1600000000, 0).UTC()
+	c := CompletedPartition{Topic: "topic1", Partition: "key1", NumRecs: 10, MinTimestamp: ts, MaxTimestamp: ts.Add(time.Minute)}
+	require.NoError(t, n.PartitionComplete(&c))
+	require.Equal(t, 1, len(got))
+	require.True(t, c.MinTimestamp.Equal(got[0].MinTimestamp))
+	require.True(t, c.MaxTimestamp.Equal(got[0].MaxTimestamp))
+	got[0].MinTimestamp, got[0].MaxTimestamp = c.MinTimestamp, c.MaxTimestamp
+	require.Equal(t, c, got[0])
+
+	status = http.StatusInternalServerError
+	require.Error(t, n.PartitionComplete(&c))
+
+	require.NoError(t, NoopNotifier.PartitionComplete(&c))
+}
+
+type failingNotifier struct {
+	fakeNotifier
+}
+
+func (n *failingNotifier) PartitionComplete(p *CompletedPartition) error {
+	_ = n.fakeNotifier.PartitionComplete(p)
+	return fmt.Errorf("notifier failure")
+}
+
+func TestPartitionCompleteRollover(t *testing.T) {
+	deleteTestTopics(t)
+
+	topic := "partition-rollover-test/"
+
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	fp.cfg.NonBlocking = true
+	fp.cfg.EventTimeField = "ts"
+	fp.cfg.TimePartitionFormat = "2006-01-02"
+
+	n := &failingNotifier{}
+	fp.SetPartitionCompleteNotifier(n)
+
+	day := int64(1600000000)
+	rec := func(ts int64) []byte { return []byte(fmt.Sprintf(`{"ts":%v}`, ts)) }
+
+	p, err := fp.NewProducer(topic)
+	require.NoError(t, err)
+	require.NoError(t, p.PushBatch("", rec(day)))
+	require.NoError(t, p.PushBatch("", rec(day+1)))
+	require.NoError(t, p.PushBatchCommit())
+	require.Equal(t, 0, len(n.completed))
+
+	//First record of the next day completes the previous day
+	require.NoError(t, p.PushBatch("", rec(day+86400)))
+	require.Equal(t, 1, len(n.completed))
+	require.Equal(t, "2020-09-13", n.completed[0].Partition)
+	require.Equal(t, int64(2), n.completed[0].NumRecs)
+
+	//Late record doesn't roll out the current day
+	require.NoError(t, p.PushBatch("", rec(day+2)))
+	require.NoError(t, p.PushBatch("", rec(day+86401)))
+	require.NoError(t, p.PushBatchCommit())
+	require.Equal(t, 1, len(n.completed))
+
+	//Notification failure doesn't fail the producer
+	require.NoError(t, p.Close())
+	require.Equal(t, 3, len(n.completed))
+	require.Equal(t, CompletedPartition{Topic: topic, Partition: "2020-09-13", NumRecs: 1, MinTimestamp: n.completed[1].MinTimestamp, MaxTimestamp: n.completed[1].MaxTimestamp}, n.completed[1])
+	require.Equal(t, "2020-09-14", n.completed[2].Partition)
+	require.Equal(t, int64(2), n.completed[2].NumRecs)
+}
+
+func TestPartitionCompleteProducers(t *testing.T) {
+	deleteTestTopics(t)
+
+	topic := "partition-producers-test/"
+
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	fp.cfg.NonBlocking = true
+
+	n := &fakeNotifier{}
+	fp.SetPartitionCompleteNotifier(n)
+
+	producers := make([]Producer, 3)
+	for i := range producers {
+		p, err := fp.NewProducer(topic)
+		require.NoError(t, err)
+		p.(*fileProducer).seqno = i * 100
+		require.NoError(t, p.PushK("a", []byte("record")))
+		producers[i] = p
+	}
+
+	//Partition is completed once by the last producer, with the data of all
+	//the producers
+	require.NoError(t, producers[0].Close())
+	require.NoError(t, producers[1].CloseOnFailure())
+	require.Equal(t, 0, len(n.completed))
+	require.NoError(t, producers[2].Close())
+	require.Equal(t, 1, len(n.completed))
+	require.Equal(t, "a", n.completed[0].Partition)
+	require.Equal(t, int64(2), n.completed[0].NumRecs)
+
+	//Partition left by the failed producer is completed by the next one
+	p, err := fp.NewProducer(topic)
+	require.NoError(t, err)
+	p.(*fileProducer).seqno = 1000
+	require.NoError(t, p.PushK("a", []byte("record")))
+	require.NoError(t, p.CloseOnFailure())
+	require.Equal(t, 1, len(n.completed))
+
+	p, err = fp.NewProducer(topic)
+	require.NoError(t, err)
+	p.(*fileProducer).seqno = 2000
+	require.NoError(t, p.PushK("a", []byte("record")))
+	require.NoError(t, p.Close())
+	require.Equal(t, 2, len(n.completed))
+	require.Equal(t, int64(1), n.completed[1].NumRecs)
+}
+
+func TestPartitionWriterExpiry(t *testing.T) {
+	deleteTestTopics(t)
+
+	topic := "partition-writer-expiry-test/"
+
+	saveTimeNow := timeNow
+	defer func() { timeNow = saveTimeNow }()
+	now := time.Now()
+	timeNow = func() time.Time { return now }
+
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	fp.cfg.NonBlocking = true
+	fp.cfg.PartitionWriterTTL = time.Minute
+
+	n := &fakeNotifier{}
+	fp.SetPartitionCompleteNotifier(n)
+
+	//Producer crashes without unregistering
+	crashed, err := fp.NewProducer(topic)
+	require.NoError(t, err)
+	require.NoError(t, crashed.PushK("a", []byte("record")))
+
+	p, err := fp.NewProducer(topic)
+	require.NoError(t, err)
+	p.(*fileProducer).seqno = 100
+	require.NoError(t, p.PushK("a", []byte("record")))
+
+	//Registration of the crashed producer expires, while the live producer
+	//renews its own
+	for i := 0; i < 4; i++ {
+		now = now.Add(20 * time.Second)
+		require.NoError(t, p.PushK("a", []byte("record")))
+	}
+	require.NoError(t, p.Close())
+	require.Equal(t, 1, len(n.completed))
+	require.Equal(t, int64(5), n.completed[0].NumRecs)
+
+	var w partitionWriters
+	_, err = fp.readState(fp.fs, partitionWritersName(topicPath(fp.datadir, topic), "a"), &w)
+	require.NoError(t, err)
+	require.Empty(t, w.Writers)
+}
+
+func TestPartitionNotifierLocking(t *testing.T) {
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	fp.fs = nonLeaseFS{fp.fs}
+
+	_, err := fp.NewProducer("partition-notifier-locking-test/")
+	require.NoError(t, err)
+
+	fp.SetPartitionCompleteNotifier(&fakeNotifier{})
+	_, err = fp.NewProducer("partition-notifier-locking-test/")
+	require.Error(t, err)
+}
@@ -0,0 +1,162 @@
+package pipe
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//Consumer start policies, which are applied when there is no committed offset
+//or committed offset is out of range, because the file has been removed
+const (
+	StartPolicyEarliest = "earliest"
+	StartPolicyLatest   = "latest"
+	StartPolicyError    = "error"
+)
+
+//ErrNoCommittedOffset is returned by NewConsumer if start policy is "error"
+//and the consumer has no committed offset
+var ErrNoCommittedOffset = errors.New("no committed offset")
+
+//ErrOffsetOutOfRange is returned by NewConsumer if start policy is "error"
+//and the file of the committed offset doesn't exist anymore
+var ErrOffsetOutOfRange = errors.New("committed offset is out of range")
+
+//consumerOffset is the position of the consumer in the topic, number of
+//records consumed from the file
+type consumerOffset struct {
+	File    string
+	NumRecs int64
+}
+
+//committedOffset is the offset record of the consumer group
+type committedOffset struct {
+	consumerOffset
+	Scan *scanCursor `json:",omitempty"` //scan position, see ScanLookBack
+}
+
+//offsetFileName returns the name of the offset of the consumer group
+func offsetFileName(tp string, group string) string {
+	if group != "" {
+		return metaFileName(tp, group+".offset")
+	}
+	return metaFileName(tp, "offset")
+}
+
+//commitsEnabled returns true if the consumer commits the offsets. Offsets of
+//the read-only pipe can only be committed to the state DB
+func (p *fileConsumer) commitsEnabled() bool {
+	return p.cfg.StartPolicy != "" && (!p.readOnly || p.db != nil)
+}
+
+//startPosition returns the file and offset the consumer starts from and the
+//number of records to skip in the file.
+//Committed offsets are only maintained if start policy is configured,
+//otherwise consumer starts from InitialOffset
+func (p *fileConsumer) startPosition() (string, int64, int64, error) {
+	policy := p.cfg.StartPolicy
+	if policy == "" {
+		fn, offset, err := p.seek(p.topic, InitialOffset)
+		return fn, offset, 0, err
+	}
+
+	var init int64
+	switch policy {
+	case StartPolicyEarliest:
+		init = OffsetOldest
+	case StartPolicyLatest:
+		init = OffsetNewest
+	case StartPolicyError:
+	default:
+		return "", 0, 0, fmt.Errorf("unknown start policy: %v", policy)
+	}
+
+	tp := p.topicPath(p.topic)
+	var o committedOffset
+	ok, err := p.readState(p.fs, offsetFileName(tp, p.cfg.ConsumerGroup), &o)
+	if err != nil {
+		return "", 0, 0, err
+	}
+
+	p.loadCursor(o.Scan)
+
+	rerr := ErrNoCommittedOffset
+	if ok {
+		//Resume listing near the cursor, if there is one
+		var files []os.FileInfo
+		if p.lookBackEnabled() && p.scan.Cursor != "" {
+			files, err = p.listFrom(filepath.Dir(tp), p.lookBackFrom(tp, &p.scan))
+			if os.IsNotExist(err) {
+				err = nil
+			}
+		} else {
+			files, err = p.readTopicDir(tp)
+		}
+		if err != nil {
+			return "", 0, 0, err
+		}
+		for _, f := range files {
+			if f.Name() == o.File {
+				return o.File, 0, o.NumRecs, nil
+			}
+		}
+		rerr = ErrOffsetOutOfRange
+	}
+
+	if policy == StartPolicyError {
+		return "", 0, 0, rerr
+	}
+
+	fn, offset, err := p.seek(p.topic, init)
+	return fn, offset, 0, err
+}
+
+//skipRecords moves the consumer past the records consumed before the restart
+func (p *fileConsumer) skipRecords(n int64) {
+	var i int64
+	for ; i < n && p.reader != nil; i++ {
+		p.writeMessage()
+		if p.err != nil {
+			//Error will be returned by the next read
+			p.err = nil
+			break
+		}
+	}
+	p.recs = i
+	p.readPos = consumerOffset{filepath.Base(p.name), i}
+	p.sentPos = p.readPos
+}
+
+//recordRead advances the read position after the record is read from the file
+func (p *fileConsumer) recordRead() {
+	p.recs++
+	p.readPos = consumerOffset{filepath.Base(p.name), p.recs}
+}
+
+//recordSent is called after the record has been delivered to the consumer
+func (p *fileConsumer) recordSent() {
+	p.posLock.Lock()
+	p.sentPos = p.readPos
+	if p.lookBackEnabled() {
+		p.addToCursor(&p.sentScan, p.sentPos.File)
+	}
+	p.posLock.Unlock()
+}
+
+func (p *fileConsumer) commitOffset() error {
+	if !p.commitsEnabled() {
+		return nil
+	}
+
+	p.posLock.Lock()
+	o := committedOffset{p.sentPos, p.sentCursor()}
+	p.posLock.Unlock()
+
+	if o.File == "" || strings.HasSuffix(o.File, ".open") {
+		return nil
+	}
+
+	return p.writeState(p.fs, offsetFileName(p.topicPath(p.topic), p.cfg.ConsumerGroup), &o)
+}
@@ -0,0 +1,195 @@
+package pipe
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/uber/storagetapper/state"
+	"github.com/uber/storagetapper/util"
+)
+
+func prepareStartPolicyTest(t *testing.T, topic string, policy string) (*filePipe, []string, []string) {
+	deleteTestTopics(t)
+
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	fp.cfg.NonBlocking = true
+	fp.cfg.MaxFileSize = 1 //rotate on every message
+	fp.cfg.StartPolicy = policy
+
+	msgs := make([]string, 0)
+	for i := 0; i < 4; i++ {
+		msgs = append(msgs, fmt.Sprintf(`{"offset":%v}`, i))
+	}
+	produceTestMsgs(t, fp, topic, msgs)
+
+	files, err := fp.readTopicDir(topicPath(fp.datadir, topic))
+	require.NoError(t, err)
+	names := make([]string, 0)
+	for _, f := range files {
+		if !isMetaFile(f.Name()) {
+			names = append(names, baseDir+"/"+topic+f.Name())
+		}
+	}
+	require.Equal(t, 4, len(names))
+
+	return fp, msgs, names
+}
+
+//consumeNumMsgs consumes n messages and closes the consumer gracefully, which
+//commits the offset
+func consumeNumMsgs(t *testing.T, fp *filePipe, topic string, n int) []string {
+	c, err := fp.NewConsumer(topic)
+	require.NoError(t, err)
+	res := make([]string, 0)
+	for i := 0; i < n; i++ {
+		m, err := c.FetchNext()
+		require.NoError(t, err)
+		require.NotNil(t, m)
+		res = append(res, string(m.([]byte)))
+	}
+	require.NoError(t, c.Close())
+	return res
+}
+
+//consumeAll consumes till the end of the topic, starting according to start
+//policy only, regardless of InitialOffset
+func consumeAll(t *testing.T, fp *filePipe, topic string) []string {
+	saveOffset := InitialOffset
+	InitialOffset = OffsetNewest
+	defer func() { InitialOffset = saveOffset }()
+
+	c, err := fp.NewConsumer(topic)
+	require.NoError(t, err)
+	res := make([]string, 0)
+	for {
+		m, err := c.FetchNext()
+		require.NoError(t, err)
+		if m == nil {
+			break
+		}
+		res = append(res, string(m.([]byte)))
+	}
+	require.NoError(t, c.Close())
+	return res
+}
+
+func TestStartPolicyEarliest(t *testing.T) {
+	topic := "start-policy-test-topic/"
+	fp, msgs, names := prepareStartPolicyTest(t, topic, StartPolicyEarliest)
+
+	require.Equal(t, msgs[:2], consumeNumMsgs(t, fp, topic, 2))
+	//Resume from committed offset
+	require.Equal(t, msgs[2:3], consumeNumMsgs(t, fp, topic, 1))
+	require.Equal(t, msgs[3:], consumeAll(t, fp, topic))
+
+	//Committed offset is out of range, start from the earliest file
+	require.NoError(t, writeMetaFile(fp.fs, offsetFileName(topicPath(fp.datadir, topic), ""), &consumerOffset{"removed", 1}))
+	require.NoError(t, os.Remove(names[0]))
+	require.Equal(t, msgs[1:], consumeAll(t, fp, topic))
+}
+
+func TestStartPolicyLatest(t *testing.T) {
+	topic := "start-policy-test-topic/"
+	fp, msgs, names := prepareStartPolicyTest(t, topic, StartPolicyLatest)
+
+	//No committed offset, start from the end
+	require.Equal(t, []string{}, consumeAll(t, fp, topic))
+
+	require.NoError(t, writeMetaFile(fp.fs, offsetFileName(topicPath(fp.datadir, topic), ""), &consumerOffset{filepath.Base(names[1]), 1}))
+	require.Equal(t, msgs[2:], consumeAll(t, fp, topic))
+
+	//Committed file has been removed
+	require.NoError(t, writeMetaFile(fp.fs, offsetFileName(topicPath(fp.datadir, topic), ""), &consumerOffset{filepath.Base(names[0]), 1}))
+	require.NoError(t, os.Remove(names[0]))
+	require.Equal(t, []string{}, consumeAll(t, fp, topic))
+}
+
+func TestConsumerGroup(t *testing.T) {
+	topic := "start-policy-test-topic/"
+	fp, msgs, _ := prepareStartPolicyTest(t, topic, StartPolicyEarliest)
+
+	require.Equal(t, msgs[:2], consumeNumMsgs(t, fp, topic, 2))
+
+	//Consumers of the other group commit their own offset
+	fp.cfg.ConsumerGroup = "other"
+	require.Equal(t, msgs[:1], consumeNumMsgs(t, fp, topic, 1))
+	require.Equal(t, msgs[1:], consumeAll(t, fp, topic))
+
+	fp.cfg.ConsumerGroup = ""
+	require.Equal(t, msgs[2:], consumeAll(t, fp, topic))
+
+	var o consumerOffset
+	ok, err := readMetaFile(fp.fs, offsetFileName(topicPath(fp.datadir, topic), "other"), &o)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, int64(1), o.NumRecs)
+}
+
+func TestStartPolicyError(t *testing.T) {
+	topic := "start-policy-test-topic/"
+	fp, msgs, names := prepareStartPolicyTest(t, topic, StartPolicyError)
+
+	_, err := fp.NewConsumer(topic)
+	require.Equal(t, ErrNoCommittedOffset, err)
+
+	require.NoError(t, writeMetaFile(fp.fs, offsetFileName(topicPath(fp.datadir, topic), ""), &consumerOffset{filepath.Base(names[0]), 1}))
+	require.Equal(t, msgs[1:], consumeAll(t, fp, topic))
+
+	require.NoError(t, writeMetaFile(fp.fs, offsetFileName(topicPath(fp.datadir, topic), ""), &consumerOffset{filepath.Base(names[0]), 1}))
+	require.NoError(t, os.Remove(names[0]))
+	_, err = fp.NewConsumer(topic)
+	require.Equal(t, ErrOffsetOutOfRange, err)
+
+	fp.cfg.StartPolicy = "unknown"
+	_, err = fp.NewConsumer(topic)
+	require.Error(t, err)
+}
+
+func TestStartPolicyStateSQL(t *testing.T) {
+	topic := "start-policy-test-topic/"
+	fp, msgs, names := prepareStartPolicyTest(t, topic, StartPolicyEarliest)
+
+	fp.db, fp.statePrefix = state.GetDB(), "file://"
+	require.NoError(t, initState(fp.db))
+	name := fp.stateKey(offsetFileName(topicPath(fp.datadir, topic), ""))
+	require.NoError(t, util.ExecSQL(fp.db, "DELETE FROM file_pipe_state WHERE name=?", name))
+
+	require.Equal(t, msgs[:2], consumeNumMsgs(t, fp, topic, 2))
+	require.Equal(t, msgs[2:], consumeAll(t, fp, topic))
+
+	//Offset is committed to the DB only
+	_, err := os.Stat(offsetFileName(topicPath(fp.datadir, topic), ""))
+	require.True(t, os.IsNotExist(err))
+
+	var o consumerOffset
+	ok, err := fp.readState(fp.fs, offsetFileName(topicPath(fp.datadir, topic), ""), &o)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, consumerOffset{filepath.Base(names[3]), 1}, o)
+}
+
+func TestStateTableLazySQL(t *testing.T) {
+	db := state.GetDB()
+	stateTables.Lock()
+	delete(stateTables.m, db)
+	stateTables.Unlock()
+
+	//Pipe, which doesn't use the state, doesn't create the table
+	c := cfg.Pipe
+	c.BaseDir = baseDir
+	p, err := initFilePipe(&c, db)
+	require.NoError(t, err)
+	stateTables.Lock()
+	require.False(t, stateTables.m[db])
+	stateTables.Unlock()
+
+	var o consumerOffset
+	_, err = p.(*filePipe).readState(nil, "state-table-lazy-test", &o)
+	require.NoError(t, err)
+	stateTables.Lock()
+	require.True(t, stateTables.m[db])
+	stateTables.Unlock()
+}
@@ -0,0 +1,76 @@
+package pipe
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func oneRecordTestFiles(t *testing.T, fp *filePipe, topic string) []string {
+	files, err := fp.readTopicDir(topicPath(fp.datadir, topic))
+	require.NoError(t, err)
+	names := make([]string, 0)
+	for _, f := range files {
+		if !isMetaFile(f.Name()) {
+			names = append(names, baseDir+"/"+topic+f.Name())
+		}
+	}
+	return names
+}
+
+func TestOneRecordPerFile(t *testing.T) {
+	deleteTestTopics(t)
+
+	topic := "one-record-test/"
+
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	fp.cfg.NonBlocking = true
+	fp.cfg.OneRecordPerFile = true
+
+	msgs := []string{"first", "second", "third", "fourth"}
+
+	p, err := fp.NewProducer(topic)
+	require.NoError(t, err)
+	require.NoError(t, p.Push([]byte(msgs[0])))
+	require.NoError(t, p.PushBatch("default", []byte(msgs[1])))
+	require.NoError(t, p.PushBatch("default", []byte(msgs[2])))
+	require.NoError(t, p.PushBatchCommit())
+	require.NoError(t, p.PushK("default", []byte(msgs[3])))
+
+	//Files are finalized without closing the producer
+	names := oneRecordTestFiles(t, fp, topic)
+	require.Equal(t, len(msgs), len(names))
+	for i, n := range names {
+		b, err := ioutil.ReadFile(n)
+		require.NoError(t, err)
+		require.Equal(t, uint32(len(msgs[i])), binary.LittleEndian.Uint32(b))
+		require.Equal(t, msgs[i], string(b[4:]))
+	}
+	require.NoError(t, p.Close())
+
+	require.Equal(t, msgs, consumeTestMsgs(t, fp, topic))
+}
+
+func TestOneRecordPerFileCompressionAndEncryption(t *testing.T) {
+	deleteTestTopics(t)
+
+	topic := "one-record-enc-test/"
+
+	fp := initTestFilePipe(&cfg.Pipe, true, t)
+	fp.cfg.NonBlocking = true
+	fp.cfg.OneRecordPerFile = true
+	fp.cfg.Compression = true
+
+	msgs := []string{"first", "second", "third"}
+	produceTestMsgs(t, fp, topic, msgs)
+
+	names := oneRecordTestFiles(t, fp, topic)
+	require.Equal(t, len(msgs), len(names))
+	for _, n := range names {
+		require.Regexp(t, `\.gz\.gpg$`, n)
+	}
+
+	require.Equal(t, msgs, consumeTestMsgs(t, fp, topic))
+}
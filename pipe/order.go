@@ -0,0 +1,136 @@
+package pipe
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+
+	"github.com/uber/storagetapper/log"
+)
+
+//SequenceFunc extracts the sequence number from the record. Returns false
+//if the record doesn't carry sequence number
+type SequenceFunc func(record []byte) (uint64, bool)
+
+//SetSequenceFunc sets the function extracting sequence number of the records
+//verified by DebugOrderCheck, for the consumers created by the pipe
+//afterwards
+func (p *filePipe) SetSequenceFunc(fn SequenceFunc) {
+	p.sequence = fn
+}
+
+//OrderError is returned by the consumer with DebugOrderCheck, when the
+//record is delivered out of order
+type OrderError struct {
+	File   string
+	Index  int64
+	Reason string
+}
+
+func (e *OrderError) Error() string {
+	return fmt.Sprintf("out of order delivery of record %v of %v: %v", e.Index, e.File, e.Reason)
+}
+
+//orderChecker verifies that the records of every file are delivered one by
+//one in the file order, and the sequence numbers of the records increase.
+//Sequence numbers of the sequential consumer increase across the files
+type orderChecker struct {
+	lock       sync.Mutex
+	sequence   SequenceFunc
+	sequential bool
+
+	next    map[string]int64  //index of the next record of the file
+	lastSeq map[string]uint64 //last sequence number of the file, "" - of the stream
+}
+
+func newOrderChecker(p *filePipe, sequential bool) *orderChecker {
+	if !p.cfg.DebugOrderCheck {
+		return nil
+	}
+	c := &orderChecker{sequence: p.sequence, sequential: sequential && p.cfg.ScanLookBack == 0}
+	c.reset()
+	return c
+}
+
+//orderedMessage carries the location of the record along with the message
+//to the delivery point, where the order is verified
+type orderedMessage struct {
+	msg    interface{}
+	file   string
+	index  int64
+	record []byte
+}
+
+//attach wraps the message of the record read from the file at the index, so
+//as the order is verified when it's delivered
+func (c *orderChecker) attach(msg interface{}, name string, index int64, record []byte) interface{} {
+	if c == nil {
+		return msg
+	}
+	return &orderedMessage{msg: msg, file: name, index: index, record: record}
+}
+
+//deliver verifies the order of the message being delivered and returns the
+//message unwrapped
+func (c *orderChecker) deliver(msg interface{}) (interface{}, error) {
+	m, ok := msg.(*orderedMessage)
+	if !ok {
+		return msg, nil
+	}
+	return m.msg, c.check(m.file, m.index, m.record)
+}
+
+//reset forgets delivered records, when the consumer starts over
+func (c *orderChecker) reset() {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	c.next = make(map[string]int64)
+	c.lastSeq = make(map[string]uint64)
+	c.lock.Unlock()
+}
+
+//check verifies the record delivered from the file at the index. Consumer can
+//start in the middle of the file, so the first index of the file is not
+//verified
+func (c *orderChecker) check(name string, index int64, record []byte) error {
+	if c == nil {
+		return nil
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	file := filepath.Base(name)
+	if n, ok := c.next[file]; ok && n != index {
+		return c.violation(file, index, fmt.Sprintf("expected record %v", n))
+	}
+	c.next[file] = index + 1
+
+	if c.sequence == nil {
+		return nil
+	}
+
+	seq, ok := c.sequence(record)
+	if !ok {
+		return nil
+	}
+
+	key := file
+	if c.sequential {
+		key = ""
+	}
+	if last, ok := c.lastSeq[key]; ok && seq <= last {
+		return c.violation(file, index, fmt.Sprintf("sequence number %v is not greater than %v", seq, last))
+	}
+	c.lastSeq[key] = seq
+
+	return nil
+}
+
+func (c *orderChecker) violation(file string, index int64, reason string) error {
+	err := &OrderError{File: file, Index: index, Reason: reason}
+	log.Errorf("Order check failed: %v", err)
+	return err
+}
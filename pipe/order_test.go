@@ -0,0 +1,149 @@
+package pipe
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func jsonSequence(record []byte) (uint64, bool) {
+	var r struct{ Seq *uint64 }
+	if json.Unmarshal(record, &r) != nil || r.Seq == nil {
+		return 0, false
+	}
+	return *r.Seq, true
+}
+
+func TestOrderChecker(t *testing.T) {
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	require.Nil(t, newOrderChecker(fp, true))
+
+	fp.cfg.DebugOrderCheck = true
+	c := newOrderChecker(fp, true)
+	//Consumer can start in the middle of the file
+	require.NoError(t, c.check("dir/f1", 5, nil))
+	require.NoError(t, c.check("dir/f1", 6, nil))
+	require.NoError(t, c.check("dir/f2", 0, nil))
+
+	err := c.check("dir/f1", 8, nil)
+	require.Equal(t, &OrderError{File: "f1", Index: 8, Reason: "expected record 7"}, err)
+	//Duplicate delivery
+	require.Error(t, c.check("dir/f2", 0, nil))
+
+	c.reset()
+	require.NoError(t, c.check("dir/f2", 0, nil))
+}
+
+func TestOrderCheckDelivery(t *testing.T) {
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	fp.cfg.DebugOrderCheck = true
+	fp.SetSequenceFunc(jsonSequence)
+
+	//Records are read in the file order, but the second and the third are
+	//delivered swapped
+	recs := []struct {
+		index int64
+		seq   int
+	}{{0, 1}, {2, 3}, {1, 2}}
+
+	c := &baseConsumer{order: newOrderChecker(fp, true)}
+	var i int
+	c.initBaseConsumer(func() (interface{}, error) {
+		if i == len(recs) {
+			return nil, nil
+		}
+		b := []byte(fmt.Sprintf(`{"Seq":%v}`, recs[i].seq))
+		msg := c.order.attach(b, "f1", recs[i].index, b)
+		i++
+		return msg, nil
+	})
+	defer func() {
+		c.cancel()
+		c.wg.Wait()
+	}()
+
+	m, err := c.FetchNext()
+	require.NoError(t, err)
+	require.Equal(t, []byte(`{"Seq":1}`), m)
+
+	_, err = c.FetchNext()
+	require.Equal(t, &OrderError{File: "f1", Index: 2, Reason: "expected record 1"}, err)
+}
+
+func produceSequences(t *testing.T, fp *filePipe, topic string, files ...[]int) {
+	p, err := fp.NewProducer(topic)
+	require.NoError(t, err)
+	for i, seqs := range files {
+		for _, s := range seqs {
+			require.NoError(t, p.PushK(fmt.Sprintf("key%v", i), []byte(fmt.Sprintf(`{"Seq":%v}`, s))))
+		}
+	}
+	require.NoError(t, p.Close())
+}
+
+//consumeUntilError returns the number of records delivered before the end
+//of the topic or the error
+func consumeUntilError(t *testing.T, fp *filePipe, topic string) (int, error) {
+	saveOffset := InitialOffset
+	InitialOffset = OffsetOldest
+	defer func() { InitialOffset = saveOffset }()
+
+	c, err := fp.NewConsumer(topic)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, c.CloseOnFailure()) }()
+
+	var n int
+	for {
+		m, err := c.FetchNext()
+		if err != nil || m == nil {
+			return n, err
+		}
+		n++
+	}
+}
+
+func TestDebugOrderCheck(t *testing.T) {
+	deleteTestTopics(t)
+
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	fp.cfg.NonBlocking = true
+	fp.cfg.StopAtCurrentEnd = true
+	fp.SetSequenceFunc(jsonSequence)
+
+	//Out of order within the file
+	produceSequences(t, fp, "order-file-test/", []int{1, 2, 3}, []int{5, 4})
+	//Out of order across the files
+	produceSequences(t, fp, "order-topic-test/", []int{10, 11}, []int{1, 2})
+
+	//Disabled
+	n, err := consumeUntilError(t, fp, "order-file-test/")
+	require.NoError(t, err)
+	require.Equal(t, 5, n)
+
+	fp.cfg.DebugOrderCheck = true
+
+	n, err = consumeUntilError(t, fp, "order-file-test/")
+	require.Equal(t, 4, n)
+	oerr, ok := err.(*OrderError)
+	require.True(t, ok, "%v", err)
+	require.Equal(t, int64(1), oerr.Index)
+
+	n, err = consumeUntilError(t, fp, "order-topic-test/")
+	require.Equal(t, 2, n)
+	oerr, ok = err.(*OrderError)
+	require.True(t, ok, "%v", err)
+	require.Equal(t, int64(0), oerr.Index)
+
+	//Parallel readers deliver files unordered, sequence is verified within
+	//the file only
+	fp.cfg.ParallelReaders = 2
+	n, err = consumeUntilError(t, fp, "order-topic-test/")
+	require.NoError(t, err)
+	require.Equal(t, 4, n)
+
+	_, err = consumeUntilError(t, fp, "order-file-test/")
+	_, ok = err.(*OrderError)
+	require.True(t, ok, "%v", err)
+}
@@ -0,0 +1,237 @@
+package pipe
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/uber/storagetapper/metrics"
+)
+
+//finalizedFiles returns names of the finalized data files of the topic in
+//the consuming order
+func (p *fileConsumer) finalizedFiles() ([]string, error) {
+	tp := p.topicPath(p.topic)
+	dir := filepath.Dir(tp)
+
+	files, err := p.readTopicDir(tp)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.cfg.FileGeneration {
+		if files, err = p.sortByGeneration(tp, files); err != nil {
+			return nil, err
+		}
+	}
+
+	res := make([]string, 0, len(files))
+	for _, f := range files {
+		if strings.HasPrefix(dir+"/"+f.Name(), tp) && !f.IsDir() && !isMetaFile(f.Name()) && !strings.HasSuffix(f.Name(), ".open") {
+			res = append(res, f.Name())
+		}
+	}
+
+	return res, nil
+}
+
+//initCurrentEnd remembers the last file finalized at the consumer creation,
+//if StopAtCurrentEnd is enabled
+func (p *fileConsumer) initCurrentEnd() error {
+	if !p.cfg.StopAtCurrentEnd {
+		return nil
+	}
+
+	files, err := p.finalizedFiles()
+	if err != nil {
+		return err
+	}
+
+	if len(files) != 0 {
+		p.stopAt = filepath.Dir(p.topicPath(p.topic)) + "/" + files[len(files)-1]
+	}
+
+	return nil
+}
+
+//pastCurrentEnd returns true if the consumer with StopAtCurrentEnd has read
+//the last file finalized at its creation, so as it reports the end of the
+//stream instead of opening nextFn or waiting for new files
+func (p *fileConsumer) pastCurrentEnd(nextFn string) bool {
+	if !p.cfg.StopAtCurrentEnd {
+		return false
+	}
+
+	if p.stopAt == "" || p.name == p.stopAt || nextFn == "" || strings.HasSuffix(nextFn, ".open") {
+		return true
+	}
+
+	return !p.cfg.FileGeneration && filepath.Dir(p.topicPath(p.topic))+"/"+nextFn > p.stopAt
+}
+
+//parallelConsumer reads files finalized at its creation by ParallelReaders
+//concurrent readers. Records of the different files are delivered in no
+//particular order, records of the same file are delivered in the file order.
+//Offsets are not committed
+type parallelConsumer struct {
+	*filePipe
+	baseConsumer
+	topic   string
+	fs      fs
+	metrics *metrics.FilePipeMetrics
+
+	records chan interface{}
+	errs    chan error
+	readers sync.WaitGroup
+
+	format     string
+	formatLock sync.Mutex
+}
+
+//newParallelConsumer starts reading all the finalized files of the topic,
+//from the earliest one, with ParallelReaders concurrent readers
+func (p *filePipe) newParallelConsumer(topic string, f fs, m *metrics.FilePipeMetrics) (Consumer, error) {
+	if !p.cfg.StopAtCurrentEnd {
+		return nil, fmt.Errorf("parallel readers require stop at current end")
+	}
+
+	p, f = p.withShards(f)
+
+	p, err := p.withTopicCompression(topic)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &parallelConsumer{filePipe: p, topic: topic, fs: f, metrics: m, records: make(chan interface{}), errs: make(chan error, p.cfg.ParallelReaders)}
+
+	r := &fileConsumer{filePipe: p, topic: topic, fs: f, metrics: m}
+	files, err := r.finalizedFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(chan string, len(files))
+	for _, n := range files {
+		names <- n
+	}
+	close(names)
+
+	c.order = newOrderChecker(p, false)
+	if err := p.initTransformPolicy(&c.baseConsumer, m); err != nil {
+		return nil, err
+	}
+	c.initBaseConsumer(c.fetchNext)
+
+	c.readers.Add(p.cfg.ParallelReaders)
+	for i := 0; i < p.cfg.ParallelReaders; i++ {
+		go c.readFiles(names)
+	}
+
+	go func() {
+		c.readers.Wait()
+		close(c.records)
+	}()
+
+	return c, nil
+}
+
+//readFiles reads files from the names channel till it's exhausted
+func (p *parallelConsumer) readFiles(names chan string) {
+	defer p.readers.Done()
+	for n := range names {
+		if err := p.readFile(n); err != nil {
+			p.errs <- err
+			return
+		}
+		if p.ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+func (p *parallelConsumer) readFile(name string) error {
+	r := &fileConsumer{filePipe: p.filePipe, topic: p.topic, fs: p.fs, metrics: p.metrics}
+	r.order = p.order
+	p.formatLock.Lock()
+	r.SetFormat(p.format)
+	p.formatLock.Unlock()
+
+	r.openFile(name, 0)
+	if os.IsNotExist(r.err) {
+		//Removed by retention after the directory was listed
+		return nil
+	}
+	if r.err != nil {
+		return r.err
+	}
+
+	defer func() {
+		if r.file != nil {
+			_ = r.file.Close()
+		}
+	}()
+
+	for r.fetchNextLow() {
+		if r.err != nil {
+			return r.err
+		}
+		select {
+		case p.records <- r.message():
+		case <-p.ctx.Done():
+			return nil
+		}
+	}
+
+	return r.err
+}
+
+func (p *parallelConsumer) fetchNext() (interface{}, error) {
+	select {
+	case msg, ok := <-p.records:
+		if !ok {
+			//Readers can fail after the last record has been delivered
+			select {
+			case err := <-p.errs:
+				return nil, err
+			default:
+			}
+			return nil, nil
+		}
+		return msg, nil
+	case err := <-p.errs:
+		return nil, err
+	case <-p.ctx.Done():
+		return nil, nil
+	}
+}
+
+func (p *parallelConsumer) close(graceful bool) error {
+	p.cancel()
+	p.wg.Wait()
+	p.readers.Wait()
+	return p.deadLetter.close(graceful)
+}
+
+//Close stops the readers
+func (p *parallelConsumer) Close() error {
+	return p.close(true)
+}
+
+//CloseOnFailure stops the readers
+func (p *parallelConsumer) CloseOnFailure() error {
+	return p.close(false)
+}
+
+//SaveOffset is a no-op, parallel consumer doesn't commit offsets
+func (p *parallelConsumer) SaveOffset() error {
+	return nil
+}
+
+//SetFormat sets the format of the files without header
+func (p *parallelConsumer) SetFormat(format string) {
+	p.formatLock.Lock()
+	p.format = format
+	p.formatLock.Unlock()
+}
@@ -0,0 +1,136 @@
+package pipe
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func consumeToEnd(t *testing.T, fp *filePipe, topic string) []string {
+	saveOffset := InitialOffset
+	InitialOffset = OffsetOldest
+	defer func() { InitialOffset = saveOffset }()
+
+	c, err := fp.NewConsumer(topic)
+	require.NoError(t, err)
+
+	res := make([]string, 0)
+	for {
+		m, err := c.FetchNext()
+		require.NoError(t, err)
+		if m == nil {
+			break
+		}
+		res = append(res, string(m.([]byte)))
+	}
+	require.NoError(t, c.Close())
+
+	return res
+}
+
+func TestStopAtCurrentEnd(t *testing.T) {
+	deleteTestTopics(t)
+
+	topic := "stop-at-end-test/"
+
+	saveTimeNow := timeNow
+	defer func() { timeNow = saveTimeNow }()
+	now := time.Now()
+	timeNow = func() time.Time { return now }
+
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	fp.cfg.StopAtCurrentEnd = true
+
+	//Empty topic ends immediately
+	require.Equal(t, []string{}, consumeToEnd(t, fp, topic))
+
+	produceTestMsgs(t, fp, topic, []string{"first", "second"})
+	now = now.Add(time.Second)
+	produceTestMsgs(t, fp, topic, []string{"third"})
+	now = now.Add(time.Second)
+
+	saveOffset := InitialOffset
+	InitialOffset = OffsetOldest
+	defer func() { InitialOffset = saveOffset }()
+
+	c, err := fp.NewConsumer(topic)
+	require.NoError(t, err)
+
+	//Files finalized after consumer creation are not consumed
+	produceTestMsgs(t, fp, topic, []string{"fourth"})
+
+	res := make([]string, 0)
+	for {
+		m, err := c.FetchNext()
+		require.NoError(t, err)
+		if m == nil {
+			break
+		}
+		res = append(res, string(m.([]byte)))
+	}
+	require.NoError(t, c.Close())
+	require.Equal(t, []string{"first", "second", "third"}, res)
+
+	require.Equal(t, []string{"first", "second", "third", "fourth"}, consumeToEnd(t, fp, topic))
+}
+
+func TestParallelReaders(t *testing.T) {
+	deleteTestTopics(t)
+
+	topic := "parallel-readers-test/"
+
+	saveTimeNow := timeNow
+	defer func() { timeNow = saveTimeNow }()
+	now := time.Now()
+	timeNow = func() time.Time { return now }
+
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	fp.cfg.StopAtCurrentEnd = true
+	fp.cfg.ParallelReaders = 4
+
+	const nFiles, nRecs = 8, 50
+	msgs := make([]string, 0)
+	for i := 0; i < nFiles; i++ {
+		m := make([]string, 0)
+		for j := 0; j < nRecs; j++ {
+			m = append(m, fmt.Sprintf("file %v record %03d", i, j))
+		}
+		produceTestMsgs(t, fp, topic, m)
+		msgs = append(msgs, m...)
+		now = now.Add(time.Second)
+	}
+
+	res := consumeToEnd(t, fp, topic)
+
+	//Records of the same file keep the file order
+	last := make(map[int]int)
+	for _, r := range res {
+		var i, j int
+		_, err := fmt.Sscanf(r, "file %d record %d", &i, &j)
+		require.NoError(t, err)
+		if l, ok := last[i]; ok {
+			require.True(t, j > l, "%v after record %v", r, l)
+		}
+		last[i] = j
+	}
+
+	//Every record is delivered exactly once
+	sort.Strings(res)
+	require.Equal(t, msgs, res)
+
+	//Closing in the middle stops the readers
+	c, err := fp.NewConsumer(topic)
+	require.NoError(t, err)
+	m, err := c.FetchNext()
+	require.NoError(t, err)
+	require.NotNil(t, m)
+	require.NoError(t, c.Close())
+
+	//Parallel readers are only supported in bounded mode
+	fp.cfg.StopAtCurrentEnd = false
+	_, err = fp.NewConsumer(topic)
+	require.Error(t, err)
+}
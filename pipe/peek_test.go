@@ -0,0 +1,81 @@
+package pipe
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPeek(t *testing.T) {
+	topic := "peek-test/"
+	fp, msgs, _ := prepareStartPolicyTest(t, topic, StartPolicyEarliest)
+
+	c, err := fp.NewConsumer(topic)
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		m, err := c.Peek()
+		require.NoError(t, err)
+		require.Equal(t, msgs[0], string(m.Data.([]byte)))
+	}
+	//Peeked message is not committed
+	require.NoError(t, c.Close())
+
+	c, err = fp.NewConsumer(topic)
+	require.NoError(t, err)
+	m, err := c.Peek()
+	require.NoError(t, err)
+	require.Equal(t, msgs[0], string(m.Data.([]byte)))
+	d, err := c.FetchNext()
+	require.NoError(t, err)
+	require.Equal(t, msgs[0], string(d.([]byte)))
+	m, err = c.Peek()
+	require.NoError(t, err)
+	require.Equal(t, msgs[1], string(m.Data.([]byte)))
+	require.NoError(t, c.Close())
+
+	//Offset advanced once, by the fetched message
+	require.Equal(t, msgs[1:], consumeAll(t, fp, topic))
+
+	//End of the topic
+	c, err = fp.NewConsumer(topic)
+	require.NoError(t, err)
+	m, err = c.Peek()
+	require.NoError(t, err)
+	require.Nil(t, m.Data)
+	d, err = c.FetchNext()
+	require.NoError(t, err)
+	require.Nil(t, d)
+	require.NoError(t, c.Close())
+}
+
+func TestPeekDelivery(t *testing.T) {
+	topic := "peek-delivery-test/"
+	fp, msgs, _ := prepareStartPolicyTest(t, topic, StartPolicyEarliest)
+
+	c, err := fp.NewConsumer(topic)
+	require.NoError(t, err)
+
+	//Peeked message is delivered by the message channel
+	m, err := c.Peek()
+	require.NoError(t, err)
+	require.Equal(t, msgs[0], string(m.Data.([]byte)))
+	require.Equal(t, msgs[0], string((<-c.Message()).([]byte)))
+
+	//and by the stream, created after the peek
+	m, err = c.Peek()
+	require.NoError(t, err)
+	require.Equal(t, msgs[1], string(m.Data.([]byte)))
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := c.Stream(ctx)
+	require.Equal(t, msgs[1], string((<-ch).Data.([]byte)))
+
+	//Message pending in the stream can be peeked
+	m, err = c.Peek()
+	require.NoError(t, err)
+	require.Equal(t, msgs[2], string(m.Data.([]byte)))
+	require.Equal(t, msgs[2], string((<-ch).Data.([]byte)))
+	cancel()
+
+	require.NoError(t, c.Close())
+}
@@ -30,6 +30,7 @@ import (
 	//"context"
 
 	"github.com/uber/storagetapper/config"
+	"github.com/uber/storagetapper/log"
 )
 
 //Consumer consumer interface for the pipe
@@ -40,12 +41,23 @@ type Consumer interface {
 	Message() chan interface{}
 	Error() chan error
 	FetchNext() (interface{}, error)
+	//Peek returns the next message without consuming it, the following
+	//FetchNext, Message channel or stream delivers the same message
+	Peek() (Message, error)
 	//Allows to explicitly persists current consumer position
 	SaveOffset() error
 
 	//SetFormat allow to tell consumer the format of the file when there is no
 	//header
 	SetFormat(format string)
+
+	//SetTransform sets the function applied to every message before the
+	//delivery
+	SetTransform(fn TransformFunc)
+
+	//Stream returns the channel delivering consumed messages instead of
+	//FetchNext
+	Stream(ctx context.Context) <-chan Message
 }
 
 //Producer producer interface for pipe
@@ -106,12 +118,39 @@ func Create(pipeType string, cfg *config.PipeConfig, db *sql.DB) (Pipe, error) {
 	return pipe, nil
 }
 
+//Transform error policies
+const (
+	TransformErrorFail       = "fail"
+	TransformErrorSkip       = "skip"
+	TransformErrorDeadLetter = "dead_letter"
+)
+
+//TransformFunc transforms decoded message before the delivery, for example to
+//redact sensitive fields
+type TransformFunc func(msg interface{}) (interface{}, error)
+
 type baseConsumer struct {
 	ctx    context.Context
 	cancel context.CancelFunc
 	wg     sync.WaitGroup
 	msgCh  chan interface{}
 	errCh  chan error
+	//sent is called after the message is received by the consumer
+	sent func()
+
+	transformPolicy string              //one of TransformError*, set before initBaseConsumer
+	deadLetter      *deadLetterProducer //nil - dead_letter policy is not supported by the pipe
+	transform       TransformFunc
+	stream          *stream
+	finished        bool          //fetch loop has exited
+	changed         chan struct{} //closed when transform or stream is replaced
+	deliveryLock    sync.Mutex
+	streamLock      sync.Mutex //serializes sends to the stream and its close
+	fetch           fetchFunc
+
+	order *orderChecker //nil - delivery order is not verified
+
+	peekCh chan Message //delivers a copy of the pending message to Peek
 }
 
 type fetchFunc func() (interface{}, error)
@@ -120,11 +159,27 @@ func (p *baseConsumer) initBaseConsumer(fn fetchFunc) {
 	p.ctx, p.cancel = context.WithCancel(context.Background())
 	p.msgCh = make(chan interface{})
 	p.errCh = make(chan error)
+	p.changed = make(chan struct{})
+	p.peekCh = make(chan Message)
+	p.fetch = fn
 
 	p.wg.Add(1)
 	go p.fetchLoop(fn)
 }
 
+//restartFetchLoop starts the fetch loop again after it has been stopped by
+//cancel
+func (p *baseConsumer) restartFetchLoop() {
+	p.ctx, p.cancel = context.WithCancel(context.Background())
+
+	p.deliveryLock.Lock()
+	p.finished = false
+	p.deliveryLock.Unlock()
+
+	p.wg.Add(1)
+	go p.fetchLoop(p.fetch)
+}
+
 func (p *baseConsumer) Message() chan interface{} {
 	return p.msgCh
 }
@@ -143,32 +198,191 @@ func (p *baseConsumer) FetchNext() (interface{}, error) {
 	return nil, nil
 }
 
+//Peek returns the next message, which stays pending in the fetch loop until
+//it's delivered by FetchNext, the message channel or the stream. The
+//message is not reported as sent, so as its offset is not committed, until
+//it's delivered
+func (p *baseConsumer) Peek() (Message, error) {
+	select {
+	case m := <-p.peekCh:
+		if m.Err != nil {
+			return Message{}, m.Err
+		}
+		return m, nil
+	case <-p.ctx.Done():
+	}
+	return Message{}, nil
+}
+
+//SetTransform sets the function applied to the messages before the delivery.
+//Messages which are not yet received by the consumer are transformed as well.
+//Transform failures are handled according to the TransformErrorPolicy: the
+//error is returned from the consumer and the message is transformed again on
+//the next fetch, or the message is skipped, or written to the dead letter
+//topic
+func (p *baseConsumer) SetTransform(fn TransformFunc) {
+	p.deliveryLock.Lock()
+	defer p.deliveryLock.Unlock()
+	p.transform = fn
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+func (p *baseConsumer) deliveryState() (TransformFunc, *stream, chan struct{}) {
+	p.deliveryLock.Lock()
+	defer p.deliveryLock.Unlock()
+	return p.transform, p.stream, p.changed
+}
+
 func (p *baseConsumer) fetchLoop(fn fetchFunc) {
 	defer p.wg.Done()
+	defer p.finishStream()
 	for {
 		msg, err := fn()
 		if err != nil {
 			p.sendErr(err)
 			return
 		}
-		if !p.sendMsg(msg) || msg == nil {
+		sent, err := p.sendMsg(msg)
+		if err != nil {
+			p.sendErr(err)
 			return
 		}
+		if !sent || msg == nil {
+			return
+		}
+		if p.sent != nil {
+			p.sent()
+		}
 	}
 }
 
-func (p *baseConsumer) sendMsg(msg interface{}) bool {
-	select {
-	case p.msgCh <- msg:
+//sendMsg delivers transformed message to the stream, if there is one, or to
+//the message channel. Message is transformed again if the transform is
+//replaced while waiting for the delivery or after the transform error is
+//delivered. Skipped messages are reported as sent
+func (p *baseConsumer) sendMsg(msg interface{}) (bool, error) {
+	msg, err := p.order.deliver(msg)
+	if err != nil {
+		return false, err
+	}
+
+	for {
+		out := msg
+		tr, s, changed := p.deliveryState()
+		if tr != nil && msg != nil {
+			var err error
+			if out, err = tr(msg); err != nil {
+				if p.skipFailed(msg, err) {
+					return true, nil
+				}
+				if !p.sendTransformErr(fmt.Errorf("transform failed: %v", err), s, changed) {
+					return false, nil
+				}
+				continue
+			}
+		}
+
+		if s != nil {
+			//End of the stream
+			if msg == nil {
+				p.closeStream(s)
+				return true, nil
+			}
+			if sent, retry := p.sendStream(s, Message{Data: out}, changed); !retry {
+				return sent, nil
+			}
+			continue
+		}
+
+		if sent, retry := p.deliverMsg(out, changed); !retry {
+			return sent, nil
+		}
+	}
+}
+
+//skipFailed applies the transform error policy to the failed message.
+//Returns false if the error should be returned from the consumer
+func (p *baseConsumer) skipFailed(msg interface{}, err error) bool {
+	switch p.transformPolicy {
+	case TransformErrorSkip:
+		log.Warnf("Skipping message, transform failed: %v", err)
 		return true
-	case <-p.ctx.Done():
+	case TransformErrorDeadLetter:
+		if p.deadLetter == nil {
+			log.Errorf("Dead letter transform error policy is not supported by the pipe")
+			return false
+		}
+		log.Warnf("Writing message to dead letter topic %v, transform failed: %v", p.deadLetter.topic, err)
+		return !log.E(p.deadLetter.pushMsg(msg))
 	}
 	return false
 }
 
-func (p *baseConsumer) sendErr(err error) {
+//sendTransformErr delivers the transform error without stopping the fetch
+//loop, so as the consumer can proceed once the transform is fixed. Returns
+//false if the consumer is closed
+func (p *baseConsumer) sendTransformErr(err error, s *stream, changed chan struct{}) bool {
+	//Transform replaced while the message was transformed, error is stale
 	select {
-	case p.errCh <- err:
-	case <-p.ctx.Done():
+	case <-changed:
+		return true
+	default:
+	}
+
+	if s != nil {
+		sent, retry := p.sendStream(s, Message{Err: err}, changed)
+		return sent || retry
+	}
+
+	for {
+		select {
+		case p.errCh <- err:
+			return true
+		case p.peekCh <- Message{Err: err}:
+		case <-changed:
+			return true
+		case <-p.ctx.Done():
+			return false
+		}
+	}
+}
+
+//deliverMsg sends the message to the message channel. Meanwhile the message
+//can be peeked any number of times. Delivery is retried if the transform or
+//the stream is replaced
+func (p *baseConsumer) deliverMsg(msg interface{}, changed chan struct{}) (sent bool, retry bool) {
+	for {
+		select {
+		case p.msgCh <- msg:
+			return true, false
+		case p.peekCh <- Message{Data: msg}:
+		case <-changed:
+			return false, true
+		case <-p.ctx.Done():
+			return false, false
+		}
+	}
+}
+
+func (p *baseConsumer) sendErr(err error) {
+	for {
+		_, s, changed := p.deliveryState()
+		if s != nil {
+			if _, retry := p.sendStream(s, Message{Err: err}, changed); !retry {
+				p.closeStream(s)
+				return
+			}
+			continue
+		}
+
+		select {
+		case p.errCh <- err:
+			return
+		case p.peekCh <- Message{Err: err}:
+		case <-changed:
+		case <-p.ctx.Done():
+			return
+		}
 	}
 }
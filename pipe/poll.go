@@ -0,0 +1,40 @@
+package pipe
+
+import (
+	"time"
+
+	"github.com/uber/storagetapper/config"
+)
+
+const defaultPollInterval = 200 * time.Millisecond
+
+//pollAfter waits for the next poll of the topic
+var pollAfter = time.After
+
+//pollBackoff is the interval of polling of the topic without new files. It
+//starts from PollIntervalMin and doubles after every empty poll up to
+//PollIntervalMax
+type pollBackoff struct {
+	cur time.Duration
+	max time.Duration
+}
+
+func newPollBackoff(cfg *config.PipeConfig) *pollBackoff {
+	b := &pollBackoff{cur: cfg.PollIntervalMin, max: cfg.PollIntervalMax}
+	if b.cur <= 0 {
+		b.cur = defaultPollInterval
+	}
+	if b.max < b.cur {
+		b.max = b.cur
+	}
+	return b
+}
+
+//next returns the interval before the next poll and grows the interval
+func (b *pollBackoff) next() time.Duration {
+	d := b.cur
+	if b.cur *= 2; b.cur > b.max {
+		b.cur = b.max
+	}
+	return d
+}
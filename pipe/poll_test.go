@@ -0,0 +1,69 @@
+package pipe
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/uber/storagetapper/metrics"
+)
+
+func TestPollBackoff(t *testing.T) {
+	deleteTestTopics(t)
+
+	topic := "poll-backoff-test/"
+
+	saveTimeNow := timeNow
+	defer func() { timeNow = saveTimeNow }()
+	now := time.Now()
+	timeNow = func() time.Time { return now }
+
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	fp.cfg.PollIntervalMin = 100 * time.Millisecond
+	fp.cfg.PollIntervalMax = time.Second
+
+	//Fake clock fires immediately and produces the file after given number
+	//of polls
+	var polls []time.Duration
+	var produceAt int
+	savePollAfter := pollAfter
+	defer func() { pollAfter = savePollAfter }()
+	pollAfter = func(d time.Duration) <-chan time.Time {
+		polls = append(polls, d)
+		if len(polls) == produceAt {
+			produceTestMsgs(t, fp, topic, []string{"record"})
+			now = now.Add(time.Second)
+		}
+		ch := make(chan time.Time, 1)
+		ch <- time.Time{}
+		return ch
+	}
+
+	m := metrics.NewFilePipeMetrics("pipe_consumer", map[string]string{"topic": topic, "pipeType": "file"})
+	c := &fileConsumer{filePipe: fp, topic: topic, fs: fp.fs, metrics: m}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	defer c.cancel()
+
+	//Interval grows while the topic is idle
+	produceAt = 6
+	require.True(t, c.waitAndOpenNextFilePoll())
+	require.NoError(t, c.err)
+	ms := time.Millisecond
+	require.Equal(t, []time.Duration{100 * ms, 200 * ms, 400 * ms, 800 * ms, 1000 * ms, 1000 * ms}, polls)
+
+	require.True(t, c.fetchNextLow())
+	require.Equal(t, "record", string(c.msg))
+	require.False(t, c.fetchNextLow())
+
+	//Interval is reset after the file has been found
+	polls, produceAt = nil, 3
+	require.True(t, c.waitAndOpenNextFilePoll())
+	require.Equal(t, []time.Duration{100 * ms, 200 * ms, 400 * ms}, polls)
+
+	//Fixed interval by default
+	b := newPollBackoff(&cfg.Pipe)
+	for i := 0; i < 3; i++ {
+		require.Equal(t, defaultPollInterval, b.next())
+	}
+}
@@ -0,0 +1,84 @@
+package pipe
+
+import (
+	"io"
+
+	"github.com/uber/storagetapper/log"
+	"github.com/uber/storagetapper/metrics"
+)
+
+//topicReader streams decoded record payloads of the topic, separated by the
+//configured separator
+type topicReader struct {
+	c   *fileConsumer
+	sep []byte
+	buf []byte
+	n   int64 //number of records read
+	err error
+}
+
+//TopicReader returns the reader of the payloads of all the records of the
+//topic, decoded and concatenated with the RecordSeparator in between, like
+//`cat` of the topic. Files are read in the consuming order, starting from the
+//earliest one. Reader returns io.EOF at the current end of the topic, records
+//of the files which are not finalized yet are not included.
+//Record format is taken from the file headers, binary framing is assumed for
+//files without header
+func (p *filePipe) TopicReader(topic string) (io.ReadCloser, error) {
+	fp := *p
+	fp.cfg.NonBlocking = true
+	fp.cfg.AttachLocation = false
+
+	sep := "\n"
+	if p.cfg.RecordSeparator != "" {
+		sep = p.cfg.RecordSeparator
+	}
+
+	m := metrics.NewFilePipeMetrics("pipe_topic_reader", map[string]string{"topic": topic, "pipeType": "file"})
+	c := &fileConsumer{filePipe: &fp, topic: topic, fs: fp.fs, metrics: m}
+
+	return &topicReader{c: c, sep: []byte(sep)}, nil
+}
+
+func (r *topicReader) Read(b []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+
+		msg, err := r.c.fetchNextPoll()
+		if err != nil {
+			r.err = err
+			return 0, err
+		}
+		if msg == nil {
+			r.err = io.EOF
+			return 0, io.EOF
+		}
+
+		if r.n != 0 {
+			r.buf = append(r.buf, r.sep...)
+		}
+		r.buf = append(r.buf, msg.([]byte)...)
+		r.n++
+	}
+
+	n := copy(b, r.buf)
+	r.buf = r.buf[n:]
+
+	return n, nil
+}
+
+//Close closes currently open file of the topic
+func (r *topicReader) Close() error {
+	r.err = io.ErrClosedPipe
+	r.buf = nil
+	if r.c.file == nil {
+		return nil
+	}
+	err := r.c.file.Close()
+	r.c.file = nil
+	r.c.reader = nil
+	log.E(err)
+	return err
+}
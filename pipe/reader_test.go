@@ -0,0 +1,73 @@
+package pipe
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTopicReader(t *testing.T) {
+	deleteTestTopics(t)
+
+	topic := "topic-reader-test/"
+
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	fp.cfg.FileDelimited = true
+
+	saveTimeNow := timeNow
+	defer func() { timeNow = saveTimeNow }()
+	now := time.Now()
+	timeNow = func() time.Time { return now }
+
+	msgs := []string{"first", "second", "third"}
+	produceTestMsgs(t, fp, topic, msgs[:2])
+	now = now.Add(time.Second)
+	produceTestMsgs(t, fp, topic, msgs[2:])
+
+	//Records of not finalized file are not included
+	now = now.Add(time.Second)
+	p, err := fp.NewProducer(topic)
+	require.NoError(t, err)
+	require.NoError(t, p.Push([]byte("open")))
+	require.NoError(t, p.PushBatchCommit())
+	defer func() { require.NoError(t, p.Close()) }()
+
+	r, err := fp.TopicReader(topic)
+	require.NoError(t, err)
+	b, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	require.Equal(t, strings.Join(msgs, "\n"), string(b))
+
+	fp.cfg.RecordSeparator = "|"
+	r, err = fp.TopicReader(topic)
+	require.NoError(t, err)
+	//Small reads spanning records boundaries
+	res := make([]byte, 0)
+	buf := make([]byte, 3)
+	for {
+		n, err := r.Read(buf)
+		res = append(res, buf[:n]...)
+		if err != nil {
+			break
+		}
+	}
+	require.NoError(t, r.Close())
+	require.Equal(t, strings.Join(msgs, "|"), string(res))
+}
+
+func TestTopicReaderEmpty(t *testing.T) {
+	deleteTestTopics(t)
+
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+
+	r, err := fp.TopicReader("topic-reader-no-such-topic/")
+	require.NoError(t, err)
+	b, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	require.Empty(t, b)
+	require.NoError(t, r.Close())
+}
@@ -0,0 +1,138 @@
+package pipe
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/uber/storagetapper/log"
+)
+
+//RebuildReport is the result of manifests rebuild
+type RebuildReport struct {
+	Topic        string
+	FilesScanned int64
+	NumRecs      int64
+	Skipped      int64 //Files already present in the manifests
+}
+
+//parseFileName returns partition and creation timestamp of the file with the
+//name produced by newFileName
+func parseFileName(tp string, name string) (string, int64, error) {
+	base := strings.TrimPrefix(name, tp)
+	parts := strings.SplitN(base, ".", 3)
+	if base == name || len(parts) != 3 {
+		return "", 0, fmt.Errorf("unexpected file name: %v", name)
+	}
+
+	ts, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return "", 0, fmt.Errorf("unexpected file name: %v: %v", name, err)
+	}
+
+	partition := strings.TrimSuffix(parts[2], ".gpg")
+	for _, s := range []string{".gz", ".zst"} {
+		partition = strings.TrimSuffix(partition, s)
+	}
+
+	return partition, ts, nil
+}
+
+//scanFileStat computes the stats of the file. Record framing is determined
+//by the file header, if present, otherwise binary framing is tried first
+func (p *filePipe) scanFileStat(name string) (*stat, error) {
+	var formats = []bool{false, true}
+	if hasHeader(&p.cfg) {
+		h, err := readFileHeader(p.fs, name)
+		if err != nil {
+			return nil, err
+		}
+		formats = []bool{h.Format == "json" || h.Format == "text"}
+	}
+
+	var verr *VerifyError
+	for _, text := range formats {
+		n, hash, e := p.scanFile(name, text, true, nil)
+		if e == nil {
+			return &stat{NumRecs: n, Hash: hash, FileName: name, Text: text}, nil
+		}
+		verr = e
+	}
+
+	return nil, fmt.Errorf("%v: %v", verr.File, verr.Err)
+}
+
+//RebuildManifests adds finalized files of the topic, which are missing from
+//partition manifests, to the manifests of their partitions. This is needed
+//for the files produced before PartitionManifest has been enabled.
+//Files are scanned to compute the number of records and the hash.
+//Time range of the records is approximated by the creation timestamp from
+//the file name and the file modification time.
+//Manifest is updated after every file, so interrupted rebuild resumes by
+//running it again.
+func (p *filePipe) RebuildManifests(topic string) (*RebuildReport, error) {
+	if !p.cfg.FileDelimited {
+		return nil, fmt.Errorf("manifests can be rebuilt for delimited files only")
+	}
+
+	tp := topicPath(p.datadir, topic)
+	dir := filepath.Dir(tp)
+
+	files, err := p.readTopicDir(tp)
+	if err != nil {
+		return nil, err
+	}
+
+	mprefix := filepath.Base(metaFileName(tp, ""))
+	known := make(map[string]bool)
+	for _, f := range files {
+		if f.IsDir() || !strings.HasPrefix(f.Name(), mprefix) || !strings.HasSuffix(f.Name(), ".manifest") {
+			continue
+		}
+		var m partitionManifest
+		if _, err := readMetaFile(p.fs, dir+"/"+f.Name(), &m); err != nil {
+			return nil, err
+		}
+		for _, s := range m.Files {
+			known[s.FileName] = true
+		}
+	}
+
+	r := &RebuildReport{Topic: topic}
+	pr := &fileProducer{filePipe: p, topic: topic, fs: p.fs}
+
+	for _, f := range files {
+		fn := dir + "/" + f.Name()
+		if !strings.HasPrefix(fn, tp) || f.IsDir() || isMetaFile(f.Name()) || strings.HasSuffix(f.Name(), ".open") {
+			continue
+		}
+		if known[fn] {
+			r.Skipped++
+			continue
+		}
+
+		partition, ts, err := parseFileName(tp, fn)
+		if err != nil {
+			return r, err
+		}
+
+		st, err := p.scanFileStat(fn)
+		if err != nil {
+			return r, err
+		}
+		st.MinTimestamp = ts
+		st.MaxTimestamp = f.ModTime().Unix()
+
+		if err := pr.updateManifest(partition, st); err != nil {
+			return r, err
+		}
+
+		log.Debugf("Rebuilt manifest of %v: %+v", fn, st)
+
+		r.FilesScanned++
+		r.NumRecs += st.NumRecs
+	}
+
+	return r, nil
+}
@@ -0,0 +1,90 @@
+package pipe
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRebuildManifests(t *testing.T) {
+	deleteTestTopics(t)
+
+	topic := "rebuild-test-topic/"
+
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	fp.cfg.FileDelimited = true
+	fp.cfg.Compression = true
+	fp.cfg.MaxFileSize = 1 //rotate on every message
+
+	//Producers started in the same second would produce the same file names
+	ts := int64(1000)
+	timeNow = func() time.Time { ts++; return time.Unix(ts, 0) }
+	defer func() { timeNow = time.Now }()
+
+	//Files produced before manifests were enabled
+	msgs := make([]string, 0)
+	for i := 0; i < 3; i++ {
+		msgs = append(msgs, fmt.Sprintf(`{"rebuild":%v}`, i))
+	}
+	produceTestMsgs(t, fp, topic, msgs[:2])
+
+	p, err := fp.NewProducer(topic)
+	require.NoError(t, err)
+	p.SetFormat("json")
+	require.NoError(t, p.Push([]byte(msgs[2])))
+	require.NoError(t, p.Close())
+
+	fp.cfg.PartitionManifest = true
+	produceTestMsgs(t, fp, topic, []string{"with manifest"})
+
+	n, err := fp.PartitionRowCount(topic, "default")
+	require.NoError(t, err)
+	require.Equal(t, int64(1), n)
+
+	r, err := fp.RebuildManifests(topic)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), r.FilesScanned)
+	require.Equal(t, int64(3), r.NumRecs)
+	require.Equal(t, int64(1), r.Skipped)
+
+	var m partitionManifest
+	ok, err := readMetaFile(fp.fs, manifestFileName(topicPath(fp.datadir, topic), "default"), &m)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, int64(4), m.NumRecs)
+	require.Equal(t, 4, len(m.Files))
+	text := 0
+	for _, s := range m.Files {
+		require.Equal(t, int64(1), s.NumRecs)
+		require.NotZero(t, s.MinTimestamp)
+		require.True(t, s.MinTimestamp <= s.MaxTimestamp)
+		if s.Text {
+			text++
+		}
+	}
+	require.Equal(t, 1, text)
+
+	//Rebuilt stats match the data
+	v, err := fp.VerifyTopic(topic)
+	require.NoError(t, err)
+	require.Empty(t, v.Errors)
+	require.Equal(t, int64(4), v.NumRecs)
+
+	//Files which are already in the manifests are skipped
+	r, err = fp.RebuildManifests(topic)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), r.FilesScanned)
+	require.Equal(t, int64(4), r.Skipped)
+}
+
+func TestParseFileName(t *testing.T) {
+	p, ts, err := parseFileName("/dir/topic", "/dir/topic1568094981.001.some.key.zst.gpg")
+	require.NoError(t, err)
+	require.Equal(t, "some.key", p)
+	require.Equal(t, int64(1568094981), ts)
+
+	_, _, err = parseFileName("/dir/topic", "/dir/topic_meta")
+	require.Error(t, err)
+}
@@ -0,0 +1,67 @@
+package pipe
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/uber/storagetapper/log"
+)
+
+//fileRemoved checks whether the file has been removed from the topic
+//directory, after the read has failed with err
+func (p *fileConsumer) fileRemoved(name string, err error) bool {
+	if os.IsNotExist(err) {
+		return true
+	}
+
+	files, lerr := p.fs.ReadDir(filepath.Dir(name), name)
+	if lerr != nil {
+		return os.IsNotExist(lerr)
+	}
+	for _, f := range files {
+		if f.Name() == filepath.Base(name) {
+			return false
+		}
+	}
+
+	return true
+}
+
+//skipRemovedFile closes current file if it has been removed or renamed away
+//by retention or compaction while being consumed. Consumer position is kept
+//at the removed file, so as consuming continues from the next available
+//file. Records of the removed file, which have not been read yet, are lost
+func (p *fileConsumer) skipRemovedFile() bool {
+	if !p.fileRemoved(p.name, p.err) {
+		return false
+	}
+
+	log.Warnf("%v has been removed while being consumed, continuing from the next file: %v", p.name, p.err)
+
+	log.E(p.file.Close())
+	p.reader = nil
+	p.file = nil
+	p.frags.reset()
+	p.err = nil
+
+	if p.hooks != nil {
+		p.hooks.OnFileClose(p.name)
+	}
+
+	return true
+}
+
+//skipRemovedOnOpen moves consumer position past the file, which has been
+//removed after the directory was listed
+func (p *fileConsumer) skipRemovedOnOpen(nextFn string) bool {
+	if !os.IsNotExist(p.err) {
+		return false
+	}
+
+	log.Debugf("%v has been removed before opening, continuing from the next file", nextFn)
+	p.name = filepath.Dir(p.topicPath(p.topic)) + "/" + nextFn
+	p.err = nil
+	p.fileOpened()
+
+	return true
+}
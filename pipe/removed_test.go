@@ -0,0 +1,99 @@
+package pipe
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+//remoteReader fails reads once the file is removed, like remote storage
+//streams do, and returns data byte by byte, so as consumer doesn't buffer
+//the file ahead
+type remoteReader struct {
+	io.ReadCloser
+	name string
+}
+
+func (r *remoteReader) Read(b []byte) (int, error) {
+	if _, err := os.Stat(r.name); err != nil {
+		return 0, fmt.Errorf("read %v: stream is gone", r.name)
+	}
+	if len(b) > 1 {
+		b = b[:1]
+	}
+	return r.ReadCloser.Read(b)
+}
+
+type remoteFS struct {
+	fileFS
+}
+
+func (f *remoteFS) OpenRead(name string, offset int64) (io.ReadCloser, error) {
+	r, err := f.fileFS.OpenRead(name, offset)
+	if err != nil {
+		return nil, err
+	}
+	return &remoteReader{r, name}, nil
+}
+
+func TestConsumeRemovedFile(t *testing.T) {
+	deleteTestTopics(t)
+
+	topic := "removed-file-test/"
+
+	saveTimeNow := timeNow
+	defer func() { timeNow = saveTimeNow }()
+	now := time.Now()
+	timeNow = func() time.Time { return now }
+
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	fp.cfg.NonBlocking = true
+	fp.fs = &remoteFS{}
+
+	first := make([]string, 0)
+	for i := 0; i < 10; i++ {
+		first = append(first, fmt.Sprintf("first file record %v", i))
+	}
+	produceTestMsgs(t, fp, topic, first)
+	now = now.Add(time.Second)
+	second := []string{"second file record 0", "second file record 1"}
+	produceTestMsgs(t, fp, topic, second)
+
+	names := oneRecordTestFiles(t, fp, topic)
+	require.Equal(t, 2, len(names))
+
+	saveOffset := InitialOffset
+	InitialOffset = OffsetOldest
+	defer func() { InitialOffset = saveOffset }()
+
+	c, err := fp.NewConsumer(topic)
+	require.NoError(t, err)
+
+	m, err := c.FetchNext()
+	require.NoError(t, err)
+	res := []string{string(m.([]byte))}
+
+	//Retention removes the file being consumed
+	require.NoError(t, os.Remove(names[0]))
+
+	for {
+		m, err := c.FetchNext()
+		require.NoError(t, err)
+		if m == nil {
+			break
+		}
+		res = append(res, string(m.([]byte)))
+	}
+	require.NoError(t, c.Close())
+
+	//Records read ahead before the removal are delivered, the rest of the
+	//removed file is lost
+	n := len(res) - len(second)
+	require.True(t, n >= 1 && n <= 2, "%v", res)
+	require.Equal(t, first[:n], res[:n])
+	require.Equal(t, second, res[n:])
+}
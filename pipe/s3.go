@@ -30,9 +30,9 @@ import (
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 
 	"github.com/uber/storagetapper/config"
@@ -42,7 +42,7 @@ import (
 )
 
 type s3Client struct {
-	client     *s3.S3
+	client     s3iface.S3API
 	uploader   *s3manager.Uploader
 	downloader *s3manager.Downloader
 	bucket     string
@@ -153,6 +153,16 @@ func (fs *s3Stat) Name() string      { return *fs.Object.Key }
 func (fs *s3Stat) IsDir() bool       { return false }
 
 func (p *s3Client) ReadDir(dirname string, _ string) (res []os.FileInfo, err error) {
+	return p.readDir(dirname, nil)
+}
+
+//ReadDirFrom lists the keys of the directory after from
+func (p *s3Client) ReadDirFrom(dirname string, from string) (res []os.FileInfo, err error) {
+	from = strings.TrimPrefix(from, "/")
+	return p.readDir(dirname, &from)
+}
+
+func (p *s3Client) readDir(dirname string, marker *string) (res []os.FileInfo, err error) {
 	log.Debugf("ReadDir: %v", dirname)
 
 	if len(dirname) != 0 && dirname[len(dirname)-1] != '/' {
@@ -163,14 +173,21 @@ func (p *s3Client) ReadDir(dirname string, _ string) (res []os.FileInfo, err err
 		dirname = dirname[1:]
 	}
 
+	//NextMarker is only returned when the delimiter is set, so the next page
+	//starts after the last key of the previous one
 	resp := &s3.ListObjectsOutput{IsTruncated: aws.Bool(true)}
 
 	for aws.BoolValue(resp.IsTruncated) {
-		resp, err = p.client.ListObjects(&s3.ListObjectsInput{Bucket: &p.bucket, Prefix: &dirname, Marker: resp.Marker})
+		resp, err = p.client.ListObjects(&s3.ListObjectsInput{Bucket: &p.bucket, Prefix: &dirname, Marker: marker})
 		if err != nil {
 			return nil, err
 		}
 
+		if len(resp.Contents) == 0 {
+			break
+		}
+		marker = aws.String(*resp.Contents[len(resp.Contents)-1].Key)
+
 		for _, v := range resp.Contents {
 			*v.Key = strings.TrimPrefix(*v.Key, dirname)
 			res = append(res, &s3Stat{v})
@@ -217,9 +234,17 @@ func init() {
 }
 
 func initS3Pipe(cfg *config.PipeConfig, db *sql.DB) (Pipe, error) {
+	if err := validateCompressionConfig(cfg); err != nil {
+		return nil, err
+	}
+
 	w := &aws.Config{Region: &cfg.S3.Region, Endpoint: &cfg.S3.Endpoint, S3ForcePathStyle: aws.Bool(true)}
-	if cfg.S3.AccessKeyID != "" {
-		w.Credentials = credentials.NewStaticCredentials(cfg.S3.AccessKeyID, cfg.S3.SecretAccessKey, cfg.S3.SessionToken)
+	cp, err := newCredentialProvider(cfg)
+	if log.E(err) {
+		return nil, err
+	}
+	if cp != nil {
+		w.Credentials = newAWSCredentials(cp)
 	}
 	s, err := session.NewSession(w)
 	if log.E(err) {
@@ -245,7 +270,9 @@ func initS3Pipe(cfg *config.PipeConfig, db *sql.DB) (Pipe, error) {
 		d.Concurrency = 1
 	})
 
-	return &s3Pipe{filePipe{cfg.S3.BaseDir, *cfg}, &s3Client{client, uploader, downloader, cfg.S3.Bucket, cfg.S3.Timeout}}, nil
+	c := &s3Client{client, uploader, downloader, cfg.S3.Bucket, cfg.S3.Timeout}
+
+	return &s3Pipe{filePipe{datadir: cfg.S3.BaseDir, cfg: *cfg, fs: c, headers: newHeaderCache(), schemas: newSchemaCache(), db: db, statePrefix: "s3://" + cfg.S3.Bucket}, c}, nil
 }
 
 // Type returns Pipe type as Terrablob
@@ -255,13 +282,31 @@ func (p *s3Pipe) Type() string {
 
 //NewProducer registers a new Terrablob producer
 func (p *s3Pipe) NewProducer(topic string) (Producer, error) {
+	fp, err := p.withTopicCompression(topic)
+	if err != nil {
+		return nil, err
+	}
+	enc, err := fp.encryptTopic(topic)
+	if err != nil {
+		return nil, err
+	}
+	schema, err := p.topicSchema(topic)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.checkNotifier(p.client); err != nil {
+		return nil, err
+	}
 	m := metrics.NewFilePipeMetrics("pipe_producer", map[string]string{"topic": topic, "pipeType": "s3"})
-	return &fileProducer{filePipe: &p.filePipe, topic: topic, files: make(map[string]*file), fs: p.client, metrics: m, stats: make(map[string]*stat)}, nil
+	return &fileProducer{filePipe: fp, topic: topic, files: make(map[string]*file), fs: p.client, metrics: m, stats: make(map[string]*stat), encrypted: enc, schema: schema}, nil
 }
 
 //NewConsumer registers a new Terrablob consumer
 func (p *s3Pipe) NewConsumer(topic string) (Consumer, error) {
 	m := metrics.NewFilePipeMetrics("pipe_consumer", map[string]string{"topic": topic, "pipeType": "s3"})
+	if p.cfg.ParallelReaders > 1 {
+		return p.newParallelConsumer(topic, p.client, m)
+	}
 	c := &s3Consumer{fileConsumer{filePipe: &p.filePipe, topic: topic, fs: p.client, metrics: m}}
 	_, err := p.initConsumer(&c.fileConsumer, c.fetchNextPoll)
 	return c, err
@@ -1,9 +1,16 @@
 package pipe
 
 import (
+	"fmt"
+	"os"
+	"strings"
 	"testing"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/require"
 	"github.com/uber/storagetapper/log"
 	"github.com/uber/storagetapper/test"
 )
@@ -43,3 +50,56 @@ func TestS3Type(t *testing.T) {
 	p, _ := initS3Pipe(&cfg.Pipe, nil)
 	test.Assert(t, p.Type() == pt, "type should be "+pt)
 }
+
+//pagedS3 lists the keys by pages of the given size. NextMarker isn't
+//returned, same as by S3 when listing without the delimiter
+type pagedS3 struct {
+	s3iface.S3API
+	keys  []string
+	page  int
+	calls int
+}
+
+func (c *pagedS3) ListObjects(in *s3.ListObjectsInput) (*s3.ListObjectsOutput, error) {
+	c.calls++
+	out := &s3.ListObjectsOutput{IsTruncated: aws.Bool(false)}
+	for _, k := range c.keys {
+		if !strings.HasPrefix(k, aws.StringValue(in.Prefix)) || k <= aws.StringValue(in.Marker) {
+			continue
+		}
+		if len(out.Contents) == c.page {
+			out.IsTruncated = aws.Bool(true)
+			break
+		}
+		out.Contents = append(out.Contents, &s3.Object{Key: aws.String(k)})
+	}
+	return out, nil
+}
+
+func TestObjectListPagination(t *testing.T) {
+	c := &pagedS3{page: 3}
+	for i := 0; i < 10; i++ {
+		c.keys = append(c.keys, fmt.Sprintf("dir/topic-%02d", i))
+	}
+	p := &s3Client{client: c}
+
+	list := func(files []os.FileInfo, err error) []string {
+		require.NoError(t, err)
+		res := make([]string, 0)
+		for _, f := range files {
+			res = append(res, f.Name())
+		}
+		return res
+	}
+
+	res := list(p.ReadDir("/dir", ""))
+	require.Equal(t, 10, len(res))
+	require.Equal(t, "topic-00", res[0])
+	require.Equal(t, "topic-09", res[9])
+	require.Equal(t, 4, c.calls)
+
+	//Listing from the name skips the preceding pages
+	c.calls = 0
+	require.Equal(t, []string{"topic-07", "topic-08", "topic-09"}, list(p.ReadDirFrom("/dir", "/dir/topic-06")))
+	require.Equal(t, 1, c.calls)
+}
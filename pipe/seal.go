@@ -0,0 +1,160 @@
+package pipe
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+
+	"github.com/uber/storagetapper/config"
+)
+
+//wrappingKey returns the key sealing the PGP key packets of the encrypted
+//files into the header. nil - key packets precede the encrypted data in the
+//clear
+func wrappingKey(cfg *config.PipeConfig) ([]byte, error) {
+	if cfg.Encryption.WrappingKey == "" {
+		return nil, nil
+	}
+	k, err := hex.DecodeString(cfg.Encryption.WrappingKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid wrapping key: %v", err)
+	}
+	if len(k) != 32 {
+		return nil, fmt.Errorf("wrapping key must be 32 bytes, got: %v", len(k))
+	}
+	return k, nil
+}
+
+//wrappingKeyID returns the id of the wrapping key, recorded in the header of
+//the files sealed by the key, so as the key rotation can be tracked
+func wrappingKeyID(key []byte) string {
+	h := sha256.Sum256(key)
+	return hex.EncodeToString(h[:8])
+}
+
+func wrappingCipher(key []byte) (cipher.AEAD, error) {
+	b, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(b)
+}
+
+//sealKeys encrypts key packets with AES-256-GCM. Returns base64 encoded
+//nonce followed by the ciphertext
+func sealKeys(key []byte, packets []byte) (string, error) {
+	c, err := wrappingCipher(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, c.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(c.Seal(nonce, nonce, packets, nil)), nil
+}
+
+//openKeys decrypts key packets sealed by sealKeys
+func openKeys(key []byte, sealed string) ([]byte, error) {
+	if key == nil {
+		return nil, fmt.Errorf("file key packets are sealed, wrapping key is required")
+	}
+	b, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return nil, fmt.Errorf("corrupted sealed keys: %v", err)
+	}
+	c, err := wrappingCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < c.NonceSize() {
+		return nil, fmt.Errorf("corrupted sealed keys: too short")
+	}
+	packets, err := c.Open(nil, b[:c.NonceSize()], b[c.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("can't open sealed keys: %v", err)
+	}
+	return packets, nil
+}
+
+//deferredWriter holds back the writes until release, so as the data packets
+//of the encrypted file follow the header with the sealed key packets
+type deferredWriter struct {
+	w   io.Writer
+	buf *bytes.Buffer
+}
+
+func (d *deferredWriter) Write(b []byte) (int, error) {
+	if d.buf != nil {
+		return d.buf.Write(b)
+	}
+	return d.w.Write(b)
+}
+
+func (d *deferredWriter) release() error {
+	if d == nil || d.buf == nil {
+		return nil
+	}
+	_, err := d.w.Write(d.buf.Bytes())
+	d.buf = nil
+	return err
+}
+
+//initFileCrypter returns the writer encrypting the file data to w, which
+//holds back the data until the header has been written. With the wrapping
+//key it also returns the sealed key packets, which go to the header
+func (p *fileProducer) initFileCrypter(filename string, w io.Writer) (io.WriteCloser, string, *deferredWriter, error) {
+	key, err := wrappingKey(&p.cfg)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	dw := &deferredWriter{w: w, buf: &bytes.Buffer{}}
+
+	if key == nil {
+		crypter, err := p.initCrypterWriter(filename, dw, dw)
+		return crypter, "", dw, err
+	}
+
+	if !hasHeader(&p.cfg) {
+		return nil, "", nil, fmt.Errorf("wrapping key requires file header")
+	}
+
+	var packets bytes.Buffer
+	crypter, err := p.initCrypterWriter(filename, &packets, dw)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	sealed, err := sealKeys(key, packets.Bytes())
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	return crypter, sealed, dw, nil
+}
+
+//unsealKeys prepends key packets sealed in the header to the encrypted data
+func (p *fileConsumer) unsealKeys(r io.Reader) (io.Reader, error) {
+	if p.header.Keys == "" {
+		return r, nil
+	}
+	key, err := wrappingKey(&p.cfg)
+	if err != nil {
+		return nil, err
+	}
+	if key != nil && p.header.KeyID != "" && p.header.KeyID != wrappingKeyID(key) {
+		return nil, fmt.Errorf("file key packets are sealed with wrapping key %v, configured key is %v", p.header.KeyID, wrappingKeyID(key))
+	}
+	packets, err := openKeys(key, p.header.Keys)
+	if err != nil {
+		return nil, err
+	}
+	return io.MultiReader(bytes.NewReader(packets), r), nil
+}
@@ -0,0 +1,120 @@
+package pipe
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/stretchr/testify/require"
+)
+
+//testKeyIDs returns the ids of the keys of the armored key as they appear in
+//PGP key packets
+func testKeyIDs(t *testing.T, armored string) [][]byte {
+	el, err := openpgp.ReadArmoredKeyRing(strings.NewReader(armored))
+	require.NoError(t, err)
+	ids := make([][]byte, 0)
+	add := func(id uint64) {
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint64(b, id)
+		ids = append(ids, b)
+	}
+	for _, e := range el {
+		add(e.PrimaryKey.KeyId)
+		for _, s := range e.Subkeys {
+			add(s.PublicKey.KeyId)
+		}
+	}
+	return ids
+}
+
+func topicFileBytes(t *testing.T, fp *filePipe, topic string) []byte {
+	names := oneRecordTestFiles(t, fp, topic)
+	require.Equal(t, 1, len(names))
+	b, err := ioutil.ReadFile(names[0])
+	require.NoError(t, err)
+	return b
+}
+
+func TestWrappingKey(t *testing.T) {
+	deleteTestTopics(t)
+
+	topic := "wrapping-key-test/"
+	msgs := []string{"first", "second", "third"}
+
+	fp := initTestFilePipe(&cfg.Pipe, true, t)
+	fp.cfg.NonBlocking = true
+	fp.cfg.FileHeader = true
+	fp.cfg.PartitionManifest = true
+	ids := testKeyIDs(t, fp.cfg.Encryption.PublicKey)
+
+	contains := func(b []byte) bool {
+		for _, id := range ids {
+			if bytes.Contains(b, id) {
+				return true
+			}
+		}
+		return false
+	}
+
+	//Key packets in the clear reveal the recipient key id
+	produceTestMsgs(t, fp, topic, msgs)
+	require.True(t, contains(topicFileBytes(t, fp, topic)))
+	deleteTestTopics(t)
+
+	fp.cfg.Encryption.WrappingKey = hex.EncodeToString(bytes.Repeat([]byte{0x5a}, 32))
+	produceTestMsgs(t, fp, topic, msgs)
+	b := topicFileBytes(t, fp, topic)
+	require.False(t, contains(b))
+
+	h, _, err := readHeader(bufio.NewReader(bytes.NewReader(b)))
+	require.NoError(t, err)
+	require.NotEmpty(t, h.Keys)
+	require.Equal(t, wrappingKeyID(bytes.Repeat([]byte{0x5a}, 32)), h.KeyID)
+
+	require.Equal(t, msgs, consumeTestMsgs(t, fp, topic))
+
+	r, err := fp.VerifyTopic(topic)
+	require.NoError(t, err)
+	require.Empty(t, r.Errors)
+	require.Equal(t, int64(len(msgs)), r.NumRecs)
+
+	//Consumer without or with the other wrapping key can't resolve the data key
+	for _, k := range []string{"", hex.EncodeToString(bytes.Repeat([]byte{0xa5}, 32))} {
+		fp.cfg.Encryption.WrappingKey = k
+		saveOffset := InitialOffset
+		InitialOffset = OffsetOldest
+		c, err := fp.NewConsumer(topic)
+		InitialOffset = saveOffset
+		require.NoError(t, err)
+		m, err := c.FetchNext()
+		require.True(t, m == nil || err != nil)
+		require.NoError(t, c.CloseOnFailure())
+
+		_, err = (&fileConsumer{filePipe: fp, header: *h}).unsealKeys(bytes.NewReader(nil))
+		require.Error(t, err)
+	}
+}
+
+func TestWrappingKeyConfig(t *testing.T) {
+	fp := initTestFilePipe(&cfg.Pipe, true, t)
+
+	//Sealed keys are stored in the header
+	fp.cfg.Encryption.WrappingKey = hex.EncodeToString(bytes.Repeat([]byte{0x5a}, 32))
+	p, err := fp.NewProducer("wrapping-key-test/")
+	require.NoError(t, err)
+	require.Error(t, p.Push([]byte("record")))
+	require.NoError(t, p.CloseOnFailure())
+
+	fp.cfg.FileHeader = true
+	fp.cfg.Encryption.WrappingKey = "5a5a"
+	p, err = fp.NewProducer("wrapping-key-test/")
+	require.NoError(t, err)
+	require.Error(t, p.Push([]byte("record")))
+	require.NoError(t, p.CloseOnFailure())
+}
@@ -0,0 +1,125 @@
+package pipe
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/uber/storagetapper/log"
+)
+
+//TimestampSeeker is implemented by the consumers, which can be positioned by
+//the event time of the records
+type TimestampSeeker interface {
+	SeekToTimestamp(t time.Time) error
+}
+
+//before returns true if all the records of the file are before t.
+//Files produced without event time are bounded by the finalize time, as
+//records are written after their event time
+func (s *stat) before(t time.Time) bool {
+	if s.MaxEventTime != 0 {
+		return time.Unix(s.MaxEventTime, 0).Before(t)
+	}
+	if s.MaxTimestamp != 0 {
+		return time.Unix(s.MaxTimestamp+1, 0).Before(t)
+	}
+	return false
+}
+
+//seekStart returns the last file of the longest prefix of the topic files,
+//which have all the records before t, and the number of its records
+func (p *fileConsumer) seekStart(t time.Time) (string, int64, error) {
+	tp := p.topicPath(p.topic)
+
+	files, err := p.readTopicDir(tp)
+	if err != nil {
+		return "", 0, err
+	}
+
+	stats, err := p.readStats(tp, files)
+	if err != nil {
+		return "", 0, err
+	}
+
+	dir := filepath.Dir(tp)
+	var last string
+	var n int64
+	for _, f := range files {
+		fn := dir + "/" + f.Name()
+		if !strings.HasPrefix(fn, tp) || f.IsDir() || isMetaFile(f.Name()) {
+			continue
+		}
+		s := stats[fn]
+		if s == nil || !s.before(t) {
+			break
+		}
+		last, n = fn, s.NumRecs
+	}
+
+	return last, n, nil
+}
+
+//SeekToTimestamp positions the consumer at the first record with event time
+//at or after t. Files, which have all the records before t according to the
+//partition manifests, are skipped without reading, then the records before t
+//are skipped. Records without event time are not skipped.
+//Messages not yet received from the consumer are discarded and the channel
+//returned by Stream is closed. Must not be called concurrently with FetchNext
+func (p *fileConsumer) SeekToTimestamp(t time.Time) error {
+	if p.cfg.FileGeneration {
+		return fmt.Errorf("seek to timestamp is not supported with file generation")
+	}
+
+	last, n, err := p.seekStart(t)
+	if err != nil {
+		return err
+	}
+
+	p.cancel()
+	p.wg.Wait()
+
+	if p.file != nil {
+		log.E(p.file.Close())
+		if p.hooks != nil {
+			p.hooks.OnFileClose(p.name)
+		}
+	}
+	p.file, p.reader, p.msg, p.err = nil, nil, nil, nil
+	p.frags.reset()
+
+	//nextFile continues after the last skipped file
+	p.name, p.offset, p.recs = last, 0, 0
+	p.scan = scanCursor{}
+	p.seekTime = t
+	p.order.reset()
+
+	var pos consumerOffset
+	if last != "" {
+		pos = consumerOffset{filepath.Base(last), n}
+	}
+	p.posLock.Lock()
+	p.readPos, p.sentPos = pos, pos
+	p.sentScan = scanCursor{}
+	p.posLock.Unlock()
+
+	log.Debugf("%v seek to %v, starting after: %v", p.topic, t, last)
+
+	p.restartFetchLoop()
+
+	return nil
+}
+
+//beforeSeekTime returns true if the record read by the consumer needs to be
+//skipped, because it's before the time of SeekToTimestamp
+func (p *fileConsumer) beforeSeekTime() bool {
+	if p.seekTime.IsZero() || p.err != nil || p.msg == nil {
+		return false
+	}
+	if t, ok := p.recordTime(p.topic, p.msg); ok && t.Before(p.seekTime) {
+		return true
+	}
+	p.seekTime = time.Time{}
+	return false
+}
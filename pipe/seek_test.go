@@ -0,0 +1,103 @@
+package pipe
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type openCounter struct {
+	opened []string
+}
+
+func (h *openCounter) OnFileOpen(name string) error {
+	h.opened = append(h.opened, name)
+	return nil
+}
+
+func (h *openCounter) OnFileClose(name string) {}
+
+func TestSeekToTimestamp(t *testing.T) {
+	deleteTestTopics(t)
+
+	topic := "seek-timestamp-test/"
+
+	saveTimeNow := timeNow
+	defer func() { timeNow = saveTimeNow }()
+	now := time.Unix(time.Now().Unix(), 0)
+	timeNow = func() time.Time { return now }
+
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	fp.cfg.NonBlocking = true
+	fp.cfg.PartitionManifest = true
+	fp.cfg.MaxFileSize = 100
+	fp.cfg.EventTimeField = "ts"
+
+	base := time.Unix(1600000000, 0).UTC()
+	msgs := make([]string, 0)
+	for i := 0; i < 20; i++ {
+		ts := base.Add(time.Duration(i) * time.Second)
+		if i%2 == 0 {
+			msgs = append(msgs, fmt.Sprintf(`{"ts":%v,"i":%v}`, ts.Unix(), i))
+		} else {
+			msgs = append(msgs, fmt.Sprintf(`{"ts":"%v","i":%v}`, ts.Format(time.RFC3339), i))
+		}
+	}
+
+	p, err := fp.NewProducer(topic)
+	require.NoError(t, err)
+	for _, m := range msgs {
+		require.NoError(t, p.Push([]byte(m)))
+		now = now.Add(time.Second)
+	}
+	require.NoError(t, p.Close())
+
+	saveOffset := InitialOffset
+	InitialOffset = OffsetOldest
+	defer func() { InitialOffset = saveOffset }()
+
+	h := &openCounter{}
+	fp.SetFileHooks(h)
+
+	c, err := fp.NewConsumer(topic)
+	require.NoError(t, err)
+
+	fetch := func() []string {
+		res := make([]string, 0)
+		for {
+			m, err := c.FetchNext()
+			require.NoError(t, err)
+			if m == nil {
+				return res
+			}
+			res = append(res, string(m.([]byte)))
+		}
+	}
+
+	s, ok := c.(TimestampSeeker)
+	require.True(t, ok)
+
+	//Target in the middle of the file
+	h.opened = nil
+	require.NoError(t, s.SeekToTimestamp(base.Add(9*time.Second+time.Millisecond)))
+	require.Equal(t, msgs[10:], fetch())
+	//Files before the target are not opened
+	all, err := fp.DescribeTopic(topic)
+	require.NoError(t, err)
+	require.True(t, len(h.opened) < len(all))
+
+	//Seek backwards
+	require.NoError(t, s.SeekToTimestamp(base.Add(3*time.Second)))
+	require.Equal(t, msgs[3:], fetch())
+
+	require.NoError(t, s.SeekToTimestamp(base.Add(-time.Hour)))
+	require.Equal(t, msgs, fetch())
+
+	//Past the end
+	require.NoError(t, s.SeekToTimestamp(base.Add(time.Hour)))
+	require.Equal(t, []string{}, fetch())
+
+	require.NoError(t, c.Close())
+}
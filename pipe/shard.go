@@ -0,0 +1,257 @@
+package pipe
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/uber/storagetapper/log"
+)
+
+//shardFileRe splits the data file name after the timestamp, the producer
+//sequence number and the index of the concurrent file, where the shard
+//qualifier is inserted
+var shardFileRe = regexp.MustCompile(`^(.*\d{10}\.\d+(?:-\d+)?)(~\d+)?(\..*)$`)
+
+//shardFS presents the topic directory in the base directories of all the
+//shards, the topic may live in, as one directory. Files produced before the
+//topic has been reassigned to another shard remain in the old shard or are
+//copied to the new one, so the same file can exist in multiple shards.
+//Sequence number in the file name is unique per producer only, so the
+//producers of different shards can create different files with the same
+//name. File found in multiple shards is consumed once, from the first shard,
+//if it's the copy of the same file, see sameFile. Different file is listed
+//with the name qualified by the shard index, like
+//<timestamp>.<seqno>~<shard>.<key>. Writes go to the pipe base directory
+type shardFS struct {
+	fs
+	shards []string //base directories, the first is the pipe base directory
+
+	mu     sync.Mutex
+	copies map[string]bool //finalized files compared with the file of the preceding shard
+}
+
+//shardFileInfo is the file listed with the name qualified by the shard
+type shardFileInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (f *shardFileInfo) Name() string {
+	return f.name
+}
+
+//shardFileName returns the name of the file qualified by the shard index.
+//Returns empty string if the name doesn't have the sequence number
+func shardFileName(name string, shard int) string {
+	m := shardFileRe.FindStringSubmatch(name)
+	if m == nil || m[2] != "" {
+		return ""
+	}
+	return fmt.Sprintf("%s~%d%s", m[1], shard, m[3])
+}
+
+//parseShardFileName returns the name of the file in the shard and the shard
+//index. Index is 0 if the name is not qualified
+func parseShardFileName(name string) (string, int) {
+	m := shardFileRe.FindStringSubmatch(name)
+	if m == nil || m[2] == "" {
+		return name, 0
+	}
+	i, err := strconv.Atoi(m[2][1:])
+	if err != nil {
+		return name, 0
+	}
+	return m[1] + m[3], i
+}
+
+//withShards returns the pipe and the filesystem, which consumer uses to read
+//the topic from all the shards in ShardDirs
+func (p *filePipe) withShards(f fs) (*filePipe, fs) {
+	if len(p.cfg.ShardDirs) == 0 || p.datadir == "" {
+		return p, f
+	}
+
+	fp := *p
+	fp.fs = &shardFS{fs: f, shards: append([]string{p.datadir}, p.cfg.ShardDirs...)}
+
+	return &fp, fp.fs
+}
+
+//inShard maps the path in the pipe base directory to the same path in the
+//shard
+func (s *shardFS) inShard(name string, shard string) string {
+	base := s.shards[0]
+	if name != base && !strings.HasPrefix(name, base+"/") {
+		return name
+	}
+	return shard + strings.TrimPrefix(name, base)
+}
+
+//ReadDir lists the directory in all the shards, files with the same name
+//are listed once
+func (s *shardFS) ReadDir(dirname string, listFrom string) ([]os.FileInfo, error) {
+	return s.union(dirname, func(shard string) ([]os.FileInfo, error) {
+		return s.fs.ReadDir(s.inShard(dirname, shard), s.inShard(listFrom, shard))
+	})
+}
+
+//ReadDirFrom implements rangeLister, if the underlying filesystem does
+func (s *shardFS) ReadDirFrom(dirname string, from string) ([]os.FileInfo, error) {
+	l, ok := s.fs.(rangeLister)
+	if !ok {
+		return s.ReadDir(dirname, from)
+	}
+	return s.union(dirname, func(shard string) ([]os.FileInfo, error) {
+		return l.ReadDirFrom(s.inShard(dirname, shard), s.inShard(from, shard))
+	})
+}
+
+func (s *shardFS) union(dirname string, list func(shard string) ([]os.FileInfo, error)) ([]os.FileInfo, error) {
+	var res []os.FileInfo
+	var notExist error
+	seen := make(map[string]int) //name to the index of the first shard it's found in
+
+	for i, shard := range s.shards {
+		if i > 0 && s.inShard(dirname, shard) == dirname {
+			break //Directory is outside of the base directory
+		}
+		files, err := list(shard)
+		if os.IsNotExist(err) {
+			if notExist == nil {
+				notExist = err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			j, ok := seen[f.Name()]
+			if !ok {
+				seen[f.Name()] = i
+				res = append(res, f)
+				continue
+			}
+			if f.IsDir() || isMetaFile(f.Name()) || strings.HasSuffix(f.Name(), ".open") {
+				continue
+			}
+			same, err := s.sameFile(s.inShard(dirname, s.shards[j])+"/"+f.Name(), s.inShard(dirname, shard)+"/"+f.Name())
+			if err != nil {
+				return nil, err
+			}
+			if same {
+				continue
+			}
+			name := shardFileName(f.Name(), i)
+			if name == "" {
+				log.Warnf("Different files with the same name %v in the shards %v and %v", f.Name(), s.shards[j], shard)
+				continue
+			}
+			res = append(res, &shardFileInfo{f, name})
+		}
+	}
+
+	if res == nil && notExist != nil {
+		return nil, notExist
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].Name() < res[j].Name() })
+
+	return res, nil
+}
+
+//sameFile returns true if the finalized files are the copies of the same
+//file. Files are told by the id in the header, files without the id are
+//compared by the content. The result is cached, because finalized files
+//don't change
+func (s *shardFS) sameFile(first string, name string) (bool, error) {
+	s.mu.Lock()
+	same, ok := s.copies[name]
+	s.mu.Unlock()
+	if ok {
+		return same, nil
+	}
+
+	id1, h1, err := s.fileIdentity(first)
+	if err != nil {
+		return false, err
+	}
+	id2, h2, err := s.fileIdentity(name)
+	if err != nil {
+		return false, err
+	}
+	if id1 != "" || id2 != "" {
+		same = id1 == id2
+	} else {
+		same = bytes.Equal(h1, h2)
+	}
+
+	s.mu.Lock()
+	if s.copies == nil {
+		s.copies = make(map[string]bool)
+	}
+	s.copies[name] = same
+	s.mu.Unlock()
+
+	return same, nil
+}
+
+//fileIdentity returns the id of the file from its header. Content hash is
+//returned for the file without the id. File removed during the listing is
+//reported as the error, so as it's not taken for the copy
+func (s *shardFS) fileIdentity(name string) (string, []byte, error) {
+	r, err := s.fs.OpenRead(name, 0)
+	if err != nil {
+		return "", nil, err
+	}
+	defer func() { _ = r.Close() }()
+
+	h := sha256.New()
+	raw := newHeaderReader(io.TeeReader(r, h))
+	header, err := detectHeader(raw)
+	if err != nil {
+		return "", nil, err
+	}
+	if header != nil && header.ID != "" {
+		return header.ID, nil, nil
+	}
+
+	if _, err := io.Copy(ioutil.Discard, raw); err != nil {
+		return "", nil, err
+	}
+
+	return "", h.Sum(nil), nil
+}
+
+//OpenRead opens the file from the first shard it exists in. File with the
+//name qualified by the shard is opened from that shard
+func (s *shardFS) OpenRead(name string, offset int64) (io.ReadCloser, error) {
+	if n, i := parseShardFileName(name); i > 0 && i < len(s.shards) {
+		return s.fs.OpenRead(s.inShard(n, s.shards[i]), offset)
+	}
+
+	var rerr error
+	for i, shard := range s.shards {
+		n := s.inShard(name, shard)
+		if i > 0 && n == name {
+			break
+		}
+		r, err := s.fs.OpenRead(n, offset)
+		if !os.IsNotExist(err) {
+			return r, err
+		}
+		if rerr == nil {
+			rerr = err
+		}
+	}
+	return nil, rerr
+}
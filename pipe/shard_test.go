@@ -0,0 +1,146 @@
+package pipe
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestShardReassignment(t *testing.T) {
+	deleteTestTopics(t)
+
+	topic := "shard-reassignment-test/"
+	oldShard := baseDir + "/shard0"
+	newShard := baseDir + "/shard1"
+
+	saveTimeNow := timeNow
+	defer func() { timeNow = saveTimeNow }()
+	now := time.Unix(time.Now().Unix(), 0)
+	timeNow = func() time.Time { return now }
+
+	msgs := make([]string, 0)
+	produce := func(fp *filePipe, from int, to int) {
+		p, err := fp.NewProducer(topic)
+		require.NoError(t, err)
+		for i := from; i < to; i++ {
+			m := fmt.Sprintf("record %v", i)
+			msgs = append(msgs, m)
+			require.NoError(t, p.Push([]byte(m)))
+			now = now.Add(time.Second)
+		}
+		require.NoError(t, p.Close())
+	}
+
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	fp.cfg.NonBlocking = true
+	fp.cfg.MaxFileSize = 1 //rotate on every message
+
+	old := *fp
+	old.datadir = oldShard
+	produce(&old, 0, 5)
+
+	//Topic is reassigned to the new shard, the last files are copied there
+	files, err := ioutil.ReadDir(oldShard + "/" + topic)
+	require.NoError(t, err)
+	require.Equal(t, 5, len(files))
+	require.NoError(t, os.MkdirAll(newShard+"/"+topic, 0770))
+	for _, f := range files[3:] {
+		b, err := ioutil.ReadFile(filepath.Join(oldShard, topic, f.Name()))
+		require.NoError(t, err)
+		require.NoError(t, ioutil.WriteFile(filepath.Join(newShard, topic, f.Name()), b, 0640))
+	}
+
+	cur := *fp
+	cur.datadir = newShard
+	produce(&cur, 5, 10)
+
+	//Without the old shard only the files in the new one are consumed
+	require.Equal(t, append(msgs[3:5:5], msgs[5:]...), consumeToEnd(t, &cur, topic))
+
+	cur.cfg.ShardDirs = []string{oldShard}
+	require.Equal(t, msgs, consumeToEnd(t, &cur, topic))
+
+	cur.cfg.ParallelReaders = 3
+	cur.cfg.StopAtCurrentEnd = true
+	require.ElementsMatch(t, msgs, consumeToEnd(t, &cur, topic))
+	cur.cfg.ParallelReaders = 0
+	cur.cfg.StopAtCurrentEnd = false
+
+	//Producer of the old shard creates different file with the same name as
+	//the file in the new shard
+	now = time.Unix(now.Unix()-5, 0)
+	p, err := old.NewProducer(topic)
+	require.NoError(t, err)
+	require.NoError(t, p.Push([]byte("same name")))
+	require.NoError(t, p.Close())
+
+	exp := append(append(append([]string{}, msgs[:6]...), "same name"), msgs[6:]...)
+	require.Equal(t, exp, consumeToEnd(t, &cur, topic))
+}
+
+func TestShardFileName(t *testing.T) {
+	name := shardFileName("topic-1600000000.001.key.gz", 2)
+	require.Equal(t, "topic-1600000000.001~2.key.gz", name)
+	n, i := parseShardFileName("/dir/" + name)
+	require.Equal(t, "/dir/topic-1600000000.001.key.gz", n)
+	require.Equal(t, 2, i)
+
+	n, i = parseShardFileName("/dir/topic-1600000000.001.key.gz")
+	require.Equal(t, "/dir/topic-1600000000.001.key.gz", n)
+	require.Equal(t, 0, i)
+
+	//Shard qualifier goes after the index of the concurrent file
+	require.Equal(t, "topic-1600000000.001-1~2.key-1", shardFileName("topic-1600000000.001-1.key-1", 2))
+
+	require.Equal(t, "", shardFileName("unexpected", 1))
+	require.Equal(t, "", shardFileName(name, 1))
+}
+
+func TestShardSameFile(t *testing.T) {
+	deleteTestTopics(t)
+
+	dir := baseDir + "/shard-same-file-test"
+	require.NoError(t, os.MkdirAll(dir, 0770))
+	write := func(name string, h *Header, data string) string {
+		var b bytes.Buffer
+		if h != nil {
+			require.NoError(t, writeHeader(h, &b))
+		}
+		b.WriteString(data)
+		n := filepath.Join(dir, name)
+		require.NoError(t, ioutil.WriteFile(n, b.Bytes(), 0640))
+		return n
+	}
+
+	s := &shardFS{fs: &fileFS{}}
+	tests := []struct {
+		name   string
+		h1, h2 *Header
+		d1, d2 string
+		same   bool
+	}{
+		{"copy", &Header{Format: "json", ID: "producer.1"}, &Header{Format: "json", ID: "producer.1"}, "data", "data", true},
+		{"same content", &Header{Format: "json", ID: "producer.1"}, &Header{Format: "json", ID: "producer.2"}, "data", "data", false},
+		{"no id", nil, nil, "data", "data", true},
+		{"no id different content", nil, nil, "data", "other", false},
+	}
+	for _, v := range tests {
+		t.Run(v.name, func(t *testing.T) {
+			a := write("a", v.h1, v.d1)
+			b := write("b-"+v.name, v.h2, v.d2)
+			same, err := s.sameFile(a, b)
+			require.NoError(t, err)
+			require.Equal(t, v.same, same)
+		})
+	}
+
+	//File removed during the check is not taken for the copy
+	_, err := s.sameFile(filepath.Join(dir, "a"), filepath.Join(dir, "missing"))
+	require.Error(t, err)
+}
@@ -0,0 +1,225 @@
+package pipe
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/uber/storagetapper/log"
+)
+
+//Sort modes of the records within the file
+const (
+	SortModeMemory   = "memory"
+	SortModeExternal = "external"
+)
+
+var defaultSortMemoryBudget int64 = 64 * 1024 * 1024
+
+type sortRecord struct {
+	key []byte
+	rec []byte
+}
+
+//sorter accumulates records of the file and writes them out sorted by the
+//key when the file is finalized. In external mode sorted runs are spilled to
+//temporary files when buffered records exceed memory budget, the runs are
+//merged on finalize
+type sorter struct {
+	key    func([]byte) []byte
+	budget int64 //0 - unlimited
+	recs   []sortRecord
+	size   int64 //size of the records buffered in memory
+	total  int64 //size of all the records added, including spilled
+	runs   []*os.File
+}
+
+//SetSortKey sets the function extracting sort key from the record for the
+//producers created by the pipe afterwards. By default records are sorted by
+//their content
+func (p *filePipe) SetSortKey(fn func(record []byte) []byte) {
+	p.sortKey = fn
+}
+
+func checkSortConfig(mode string) error {
+	switch mode {
+	case "", SortModeMemory, SortModeExternal:
+		return nil
+	}
+	return fmt.Errorf("unknown sort mode: %v", mode)
+}
+
+//newSorter returns nil if sorting is disabled
+func (p *fileProducer) newSorter() *sorter {
+	if p.cfg.SortMode == "" {
+		return nil
+	}
+	s := &sorter{key: p.sortKey}
+	if s.key == nil {
+		s.key = func(b []byte) []byte { return b }
+	}
+	if p.cfg.SortMode == SortModeExternal {
+		s.budget = p.cfg.SortMemoryBudget
+		if s.budget <= 0 {
+			s.budget = defaultSortMemoryBudget
+		}
+	}
+	return s
+}
+
+func (s *sorter) add(b []byte) error {
+	r := make([]byte, len(b))
+	copy(r, b)
+	s.recs = append(s.recs, sortRecord{s.key(r), r})
+	s.size += int64(len(r))
+	s.total += int64(len(r))
+	if s.budget != 0 && s.size >= s.budget {
+		return s.spill()
+	}
+	return nil
+}
+
+func (s *sorter) sort() {
+	sort.SliceStable(s.recs, func(i, j int) bool { return bytes.Compare(s.recs[i].key, s.recs[j].key) < 0 })
+}
+
+//spill writes sorted run of buffered records to temporary file
+func (s *sorter) spill() error {
+	s.sort()
+
+	f, err := ioutil.TempFile("", "storagetapper-sort-")
+	if err != nil {
+		return err
+	}
+	s.runs = append(s.runs, f)
+
+	w := bufio.NewWriter(f)
+	sz := make([]byte, 4)
+	for _, r := range s.recs {
+		binary.LittleEndian.PutUint32(sz, uint32(len(r.rec)))
+		if _, err := w.Write(sz); err != nil {
+			return err
+		}
+		if _, err := w.Write(r.rec); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	log.Debugf("Spilled sorted run of %v records, %v bytes to %v", len(s.recs), s.size, f.Name())
+
+	s.recs = nil
+	s.size = 0
+
+	return nil
+}
+
+//mergeSource is sorted run, either spilled to temporary file or in memory
+type mergeSource struct {
+	cur  sortRecord
+	r    *bufio.Reader
+	recs []sortRecord
+	idx  int //order of the run, makes merge stable
+}
+
+func (m *mergeSource) next(key func([]byte) []byte) (bool, error) {
+	if m.r == nil {
+		if len(m.recs) == 0 {
+			return false, nil
+		}
+		m.cur, m.recs = m.recs[0], m.recs[1:]
+		return true, nil
+	}
+
+	sz := make([]byte, 4)
+	if _, err := io.ReadFull(m.r, sz); err == io.EOF {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	b := make([]byte, binary.LittleEndian.Uint32(sz))
+	if _, err := io.ReadFull(m.r, b); err != nil {
+		return false, err
+	}
+	m.cur = sortRecord{key(b), b}
+
+	return true, nil
+}
+
+type mergeHeap []*mergeSource
+
+func (h mergeHeap) Len() int { return len(h) }
+func (h mergeHeap) Less(i, j int) bool {
+	c := bytes.Compare(h[i].cur.key, h[j].cur.key)
+	return c < 0 || (c == 0 && h[i].idx < h[j].idx)
+}
+func (h mergeHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *mergeHeap) Push(x interface{}) { *h = append(*h, x.(*mergeSource)) }
+func (h *mergeHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+//merge calls fn for every record in the sorted order
+func (s *sorter) merge(fn func([]byte) error) error {
+	s.sort()
+
+	h := make(mergeHeap, 0, len(s.runs)+1)
+	add := func(m *mergeSource) error {
+		ok, err := m.next(s.key)
+		if ok {
+			heap.Push(&h, m)
+		}
+		return err
+	}
+
+	for i, f := range s.runs {
+		if err := add(&mergeSource{r: bufio.NewReader(f), idx: i}); err != nil {
+			return err
+		}
+	}
+	if err := add(&mergeSource{recs: s.recs, idx: len(s.runs)}); err != nil {
+		return err
+	}
+
+	for len(h) != 0 {
+		m := h[0]
+		if err := fn(m.cur.rec); err != nil {
+			return err
+		}
+		ok, err := m.next(s.key)
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Fix(&h, 0)
+		} else {
+			heap.Pop(&h)
+		}
+	}
+
+	return nil
+}
+
+//close removes temporary files of the sorted runs
+func (s *sorter) close() {
+	for _, f := range s.runs {
+		log.E(f.Close())
+		log.E(os.Remove(f.Name()))
+	}
+	s.runs = nil
+	s.recs = nil
+}
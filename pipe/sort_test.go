@@ -0,0 +1,126 @@
+package pipe
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func sortTempFiles(t *testing.T) []string {
+	m, err := filepath.Glob(filepath.Join(os.TempDir(), "storagetapper-sort-*"))
+	require.NoError(t, err)
+	return m
+}
+
+func TestSortExternal(t *testing.T) {
+	deleteTestTopics(t)
+
+	topic := "sort-external-test/"
+
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	fp.cfg.FileDelimited = true
+	fp.cfg.NonBlocking = true
+	fp.cfg.SortMode = SortModeExternal
+	fp.cfg.SortMemoryBudget = 64
+
+	tmp := sortTempFiles(t)
+
+	msgs := make([]string, 0)
+	for i := 0; i < 200; i++ {
+		msgs = append(msgs, fmt.Sprintf("key%06d", rand.Intn(1000000)))
+	}
+
+	p, err := fp.NewProducer(topic)
+	require.NoError(t, err)
+	for _, m := range msgs {
+		require.NoError(t, p.PushBatch("key", []byte(m)))
+	}
+	require.NoError(t, p.PushBatchCommit())
+
+	//Records over the memory budget are spilled to the sorted runs
+	f := p.(*fileProducer).files["key"]
+	require.True(t, len(f.sorter.runs) > 1)
+
+	require.NoError(t, p.Close())
+	require.Equal(t, tmp, sortTempFiles(t))
+
+	sort.Strings(msgs)
+	require.Equal(t, msgs, consumeTestMsgs(t, fp, topic))
+
+	v, err := fp.VerifyTopic(topic)
+	require.NoError(t, err)
+	require.Empty(t, v.Errors)
+}
+
+func TestSortStableKey(t *testing.T) {
+	for _, mode := range []string{SortModeMemory, SortModeExternal} {
+		t.Run(mode, func(t *testing.T) {
+			deleteTestTopics(t)
+
+			topic := "sort-stable-test/"
+
+			fp := initTestFilePipe(&cfg.Pipe, false, t)
+			fp.cfg.FileDelimited = true
+			fp.cfg.NonBlocking = true
+			fp.cfg.SortMode = mode
+			fp.cfg.SortMemoryBudget = 5
+			fp.SetSortKey(func(b []byte) []byte { return b[:1] })
+
+			produceTestMsgs(t, fp, topic, []string{"c1", "b1", "a1", "b2", "c2", "a2", "b3"})
+			require.Equal(t, []string{"a1", "a2", "b1", "b2", "b3", "c1", "c2"}, consumeTestMsgs(t, fp, topic))
+		})
+	}
+}
+
+func TestSortRotation(t *testing.T) {
+	deleteTestTopics(t)
+
+	topic := "sort-rotation-test/"
+
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	fp.cfg.FileDelimited = true
+	fp.cfg.NonBlocking = true
+	fp.cfg.SortMode = SortModeMemory
+	fp.cfg.MaxFileSize = 5 //rotate after 3 records
+
+	//Buffered records count toward the file size
+	produceTestMsgs(t, fp, topic, []string{"c1", "b1", "a1", "b2", "c2", "a2"})
+
+	files, err := fp.readTopicDir(topicPath(fp.datadir, topic))
+	require.NoError(t, err)
+	require.Equal(t, 2, len(files))
+	require.Equal(t, []string{"a1", "b1", "c1", "a2", "b2", "c2"}, consumeTestMsgs(t, fp, topic))
+}
+
+func TestSortCancel(t *testing.T) {
+	deleteTestTopics(t)
+
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	fp.cfg.SortMode = SortModeExternal
+	fp.cfg.SortMemoryBudget = 1
+
+	tmp := sortTempFiles(t)
+
+	p, err := fp.NewProducer("sort-cancel-test/")
+	require.NoError(t, err)
+	require.NoError(t, p.Push([]byte("rec1")))
+	require.NoError(t, p.Push([]byte("rec2")))
+	require.NoError(t, p.CloseOnFailure())
+
+	require.Equal(t, tmp, sortTempFiles(t))
+}
+
+func TestSortUnknownMode(t *testing.T) {
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	fp.cfg.SortMode = "unknown"
+
+	p, err := fp.NewProducer("sort-unknown-test/")
+	require.NoError(t, err)
+	require.Error(t, p.Push([]byte("rec1")))
+	require.NoError(t, p.Close())
+}
@@ -144,6 +144,7 @@ func (p *sqlPipe) NewConsumer(topic string) (Consumer, error) {
 	}
 	c := &sqlConsumer{sqlPipe: p, conn: conn, topic: topic}
 
+	c.transformPolicy = p.cfg.TransformErrorPolicy
 	c.initBaseConsumer(c.fetchNext)
 
 	return c, nil
@@ -0,0 +1,292 @@
+package pipe
+
+import (
+	"context"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/uber/storagetapper/log"
+	"github.com/uber/storagetapper/types"
+	"github.com/uber/storagetapper/util"
+)
+
+//Consumer offsets and topic generations are kept in the state DB, when the
+//pipe is created with the DB connection, so as the consumers don't need write
+//access to the data directory. Without the DB they are kept in the metadata
+//files of the topic
+
+//metaLockTimeout is the age of the lock of the metadata file, after which the
+//lock is considered left by the dead process
+var metaLockTimeout = time.Minute
+
+var metaLockRetry = 10 * time.Millisecond
+
+//metaLocks serialize updates of the metadata files by the producers of the
+//process
+var metaLocks = struct {
+	sync.Mutex
+	m map[string]*metaLock
+}{m: make(map[string]*metaLock)}
+
+type metaLock struct {
+	sync.Mutex
+	refs int
+}
+
+//stateTables are the state DBs, the table of the file pipes state has been
+//created in by the process
+var stateTables = struct {
+	sync.Mutex
+	m map[*sql.DB]bool
+}{m: make(map[*sql.DB]bool)}
+
+//initState creates the table of the file pipes state. The table is created
+//on first use of the state, so as the pipes, which don't keep the state,
+//don't require CREATE permission
+func initState(db *sql.DB) error {
+	if db == nil {
+		return nil
+	}
+
+	stateTables.Lock()
+	defer stateTables.Unlock()
+	if stateTables.m[db] {
+		return nil
+	}
+
+	err := util.ExecSQL(db, `CREATE TABLE IF NOT EXISTS `+types.MyDBName+`.file_pipe_state (
+		name VARCHAR(255) CHARACTER SET utf8 NOT NULL,
+		value TEXT NOT NULL,
+		PRIMARY KEY(name))`)
+	if err != nil {
+		return err
+	}
+	stateTables.m[db] = true
+
+	return nil
+}
+
+//stateKey is the name of the state in the DB. Metadata file name is prefixed
+//by the storage, so as the pipes sharing the DB don't clash
+func (p *filePipe) stateKey(name string) string {
+	return p.statePrefix + name
+}
+
+//readState reads the state saved by writeState. Returns false if there is no
+//saved state
+func (p *filePipe) readState(f fs, name string, v interface{}) (bool, error) {
+	if p.db == nil {
+		return readMetaFile(f, name, v)
+	}
+
+	if err := initState(p.db); err != nil {
+		return false, err
+	}
+
+	var b []byte
+	err := util.QueryRowSQL(p.db, "SELECT value FROM file_pipe_state WHERE name=?", p.stateKey(name)).Scan(&b)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, json.Unmarshal(b, v)
+}
+
+func (p *filePipe) writeState(f fs, name string, v interface{}) error {
+	if p.db == nil {
+		return writeMetaFile(f, name, v)
+	}
+
+	if err := initState(p.db); err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return util.ExecSQL(p.db, "INSERT INTO file_pipe_state VALUES(?,?) ON DUPLICATE KEY UPDATE value=?", p.stateKey(name), b, b)
+}
+
+//updateState atomically reads the state, modifies it by calling update and
+//writes it back
+func (p *filePipe) updateState(f fs, name string, v interface{}, update func()) error {
+	if p.db == nil {
+		unlock, err := p.lockMetaFile(f, name)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+
+		if _, err := readMetaFile(f, name, v); err != nil {
+			return err
+		}
+		update()
+		return writeMetaFile(f, name, v)
+	}
+
+	if err := initState(p.db); err != nil {
+		return err
+	}
+
+	tx, err := p.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := p.updateStateTx(tx, name, v, update); err != nil {
+		log.E(tx.Rollback())
+		return err
+	}
+	return tx.Commit()
+}
+
+func (p *filePipe) updateStateTx(tx *sql.Tx, name string, v interface{}, update func()) error {
+	key := p.stateKey(name)
+
+	//Row is created if it doesn't exist yet, so as it can be locked
+	if _, err := tx.Exec("INSERT IGNORE INTO file_pipe_state VALUES(?, 'null')", key); err != nil {
+		return err
+	}
+
+	var b []byte
+	if err := tx.QueryRow("SELECT value FROM file_pipe_state WHERE name=? FOR UPDATE", key).Scan(&b); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return err
+	}
+
+	update()
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE file_pipe_state SET value=? WHERE name=?", b, key)
+	return err
+}
+
+//lockMetaFile takes the lock of the metadata file shared by the producers of
+//all the processes. The lock is taken in the state DB, if the pipe has one,
+//otherwise by exclusive creation of the lock file. Returns the function
+//releasing the lock
+func (p *filePipe) lockMetaFile(f fs, name string) (func(), error) {
+	metaLocks.Lock()
+	l := metaLocks.m[name]
+	if l == nil {
+		l = &metaLock{}
+		metaLocks.m[name] = l
+	}
+	l.refs++
+	metaLocks.Unlock()
+
+	l.Lock()
+
+	release := func() {
+		l.Unlock()
+		metaLocks.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(metaLocks.m, name)
+		}
+		metaLocks.Unlock()
+	}
+
+	var unlock func()
+	var err error
+	if p.db != nil {
+		unlock, err = p.lockState(name)
+	} else {
+		unlock, err = lockFile(f, name)
+	}
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	return func() {
+		unlock()
+		release()
+	}, nil
+}
+
+//lockState takes the named lock in the state DB. The lock is held by the
+//connection, so the connection is reserved until the lock is released
+func (p *filePipe) lockState(name string) (func(), error) {
+	conn, err := p.db.Conn(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	//Lock names are limited to 64 characters
+	ln := fmt.Sprintf("file_pipe_%x", sha1.Sum([]byte(p.stateKey(name))))
+
+	var res sql.NullInt64
+	err = conn.QueryRowContext(context.Background(), "SELECT GET_LOCK(?, ?)", ln, int64(metaLockTimeout.Seconds())).Scan(&res)
+	if err == nil && res.Int64 != 1 {
+		err = fmt.Errorf("timeout taking the lock of %v", name)
+	}
+	if err != nil {
+		log.E(conn.Close())
+		return nil, err
+	}
+
+	return func() {
+		_, err := conn.ExecContext(context.Background(), "SELECT RELEASE_LOCK(?)", ln)
+		log.E(err)
+		log.E(conn.Close())
+	}, nil
+}
+
+//lockFile takes the lock by exclusive creation of the lock file. Lock of the
+//dead process is taken over after metaLockTimeout
+func lockFile(f fs, name string) (func(), error) {
+	l, ok := f.(leaseFS)
+	if !ok {
+		return nil, fmt.Errorf("storage doesn't support locking of %v, state DB is required", name)
+	}
+
+	ln := name + ".lock"
+	for {
+		err := l.CreateExclusive(ln)
+		if err == nil {
+			return func() {
+				if err := f.Remove(ln); !os.IsNotExist(err) {
+					log.E(err)
+				}
+			}, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+
+		fi, err := l.Stat(ln)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if timeNow().Sub(fi.ModTime()) > metaLockTimeout {
+			log.Warnf("Taking over stale lock %v", ln)
+			removed, err := removeExpired(l, f, ln, fi, metaLockTimeout)
+			if err != nil {
+				return nil, err
+			}
+			if removed {
+				continue
+			}
+		}
+
+		time.Sleep(metaLockRetry)
+	}
+}
@@ -0,0 +1,283 @@
+package pipe
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/uber/storagetapper/config"
+	"github.com/uber/storagetapper/metrics"
+)
+
+//ioStreamKey is the key of the only file of the stream
+const ioStreamKey = "stream"
+
+var errIOStreamWritten = fmt.Errorf("io pipe stream is a single file, which has already been written")
+var errIOStreamRead = fmt.Errorf("io pipe stream has already been consumed")
+
+//ioReplayBufferSize is the minimum size of the consumer read buffer kept to
+//be replayed in addition to the header
+const ioReplayBufferSize = 64 * 1024
+
+//ioReplayLimit returns the size of the beginning of the stream, which is kept
+//to be read again. Consumer reads the header and then reopens the compressed
+//or encrypted file at the end of the header, while the header reader has
+//buffered more than the header, up to the read buffer size
+func ioReplayLimit(cfg *config.PipeConfig) int {
+	buf := ioReplayBufferSize
+	if cfg.DecompressBufferSize > buf {
+		buf = cfg.DecompressBufferSize
+	}
+	return maxBinaryHeaderLen + buf
+}
+
+//ioPipe writes the records to the writer and reads them from the reader,
+//framed, compressed and encrypted the same way the file pipe does, but
+//without filesystem. The stream is a single file, so the records of all the
+//keys and topics are written to it, the files are not rotated and the meta
+//files (manifests, offsets, _DONE) are not persisted. Consumer reports the
+//end of the stream, when it has read the reader to the end
+type ioPipe struct {
+	filePipe
+	r io.Reader
+	w io.Writer
+
+	lock    sync.Mutex
+	stream  *ioReplayReader
+	written bool
+}
+
+//ioReplayReader records the beginning of the stream, so as it can be opened
+//once more at the offset within the recorded part
+type ioReplayReader struct {
+	r         io.Reader
+	buf       []byte
+	limit     int
+	recording bool
+}
+
+func (r *ioReplayReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	if r.recording {
+		if len(r.buf)+n > r.limit {
+			r.recording, r.buf = false, nil
+		} else {
+			r.buf = append(r.buf, b[:n]...)
+		}
+	}
+	return n, err
+}
+
+//reopen returns the reader of the stream starting at the offset
+func (r *ioReplayReader) reopen(offset int64) (io.Reader, error) {
+	if !r.recording || offset > int64(len(r.buf)) {
+		return nil, errIOStreamRead
+	}
+	res := io.MultiReader(bytes.NewReader(r.buf[offset:]), r.r)
+	r.recording, r.buf = false, nil
+	return res, nil
+}
+
+//ioProducer writes all the records to the stream file
+type ioProducer struct {
+	*fileProducer
+}
+
+//ioFS presents the stream as the only file of the topic directory
+type ioFS struct {
+	p    *ioPipe
+	name string //name of the stream file, "" - producer
+}
+
+//ioFileInfo describes the stream file, only the name of which is known
+type ioFileInfo struct {
+	name string
+}
+
+func (f *ioFileInfo) Name() string       { return f.name }
+func (f *ioFileInfo) Size() int64        { return 0 }
+func (f *ioFileInfo) Mode() os.FileMode  { return 0 }
+func (f *ioFileInfo) ModTime() time.Time { return time.Time{} }
+func (f *ioFileInfo) IsDir() bool        { return false }
+func (f *ioFileInfo) Sys() interface{}   { return nil }
+
+type ioWriter struct {
+	io.Writer
+}
+
+func (w *ioWriter) Flush() error {
+	return nil
+}
+
+//Close doesn't close the writer, it's owned by the caller
+func (w *ioWriter) Close() error {
+	return nil
+}
+
+func init() {
+	registerPlugin("stdio", initStdioPipe)
+}
+
+func initStdioPipe(cfg *config.PipeConfig, db *sql.DB) (Pipe, error) {
+	return NewIOPipe(cfg, os.Stdin, os.Stdout)
+}
+
+//NewIOPipe creates the pipe, which producer writes the framed stream of the
+//records to w, and consumer reads it from r. Either can be nil if only
+//produce or consume is needed
+func NewIOPipe(cfg *config.PipeConfig, r io.Reader, w io.Writer) (Pipe, error) {
+	if err := validateCompressionConfig(cfg); err != nil {
+		return nil, err
+	}
+	p := &ioPipe{filePipe: filePipe{cfg: *cfg}, r: r, w: w}
+	p.cfg.MaxFileSize = 0
+	p.cfg.MaxFileDataSize = 0
+	p.fs = &ioFS{p: p}
+	return p, nil
+}
+
+// Type returns Pipe type as stdio
+func (p *ioPipe) Type() string {
+	return "stdio"
+}
+
+//NewProducer creates the producer writing to the pipe writer
+func (p *ioPipe) NewProducer(topic string) (Producer, error) {
+	if p.w == nil {
+		return nil, fmt.Errorf("io pipe doesn't have the writer")
+	}
+	fp, err := p.withTopicCompression(topic)
+	if err != nil {
+		return nil, err
+	}
+	enc, err := fp.encryptTopic(topic)
+	if err != nil {
+		return nil, err
+	}
+	schema, err := p.topicSchema(topic)
+	if err != nil {
+		return nil, err
+	}
+	m := metrics.NewFilePipeMetrics("pipe_producer", map[string]string{"topic": topic, "pipeType": "stdio"})
+	return &ioProducer{&fileProducer{filePipe: fp, topic: topic, files: make(map[string]*file), fs: p.fs, metrics: m, stats: make(map[string]*stat), encrypted: enc, schema: schema}}, nil
+}
+
+//NewConsumer creates the consumer reading from the pipe reader
+func (p *ioPipe) NewConsumer(topic string) (Consumer, error) {
+	if p.r == nil {
+		return nil, fmt.Errorf("io pipe doesn't have the reader")
+	}
+	m := metrics.NewFilePipeMetrics("pipe_consumer", map[string]string{"topic": topic, "pipeType": "stdio"})
+	fp := p.filePipe
+	fp.cfg.NonBlocking = true
+	fp.cfg.ParallelReaders = 0
+	//Consumer doesn't open .open files, so the stream is listed as finalized
+	fp.fs = &ioFS{p: p, name: topicPath("", topic) + ioStreamKey}
+	c := &fileConsumer{filePipe: &fp, topic: topic, fs: fp.fs, metrics: m}
+	return fp.initConsumer(c, c.fetchNextPoll)
+}
+
+//PushK writes the record to the stream regardless of the key
+func (p *ioProducer) PushK(key string, in interface{}) error {
+	return p.push(ioStreamKey, in, false)
+}
+
+//Push writes the record to the stream
+func (p *ioProducer) Push(in interface{}) error {
+	return p.push(ioStreamKey, in, false)
+}
+
+//PushBatch stashes the record to be written to the stream by PushBatchCommit
+func (p *ioProducer) PushBatch(key string, in interface{}) error {
+	return p.push(ioStreamKey, in, true)
+}
+
+//PushSchema writes the schema to the stream. The schema is recorded in the
+//header if it's pushed before the first record
+func (p *ioProducer) PushSchema(key string, data []byte) error {
+	if p.cfg.AvroOCF || len(data) == 0 {
+		return p.fileProducer.PushSchema(key, data)
+	}
+	if err := p.PushBatchCommit(); err != nil {
+		return err
+	}
+	if p.files[ioStreamKey] == nil {
+		p.header.Schema = data
+	}
+	return p.push(ioStreamKey, data, false)
+}
+
+//isStream returns true if the name is the stream file, other names are meta
+//files
+func (f *ioFS) isStream(name string) bool {
+	return !isMetaFile(filepath.Base(name))
+}
+
+func (f *ioFS) MkdirAll(path string, perm os.FileMode) error {
+	return nil
+}
+
+func (f *ioFS) Rename(oldpath, newpath string) error {
+	return nil
+}
+
+func (f *ioFS) ReadDir(dirname string, _ string) ([]os.FileInfo, error) {
+	if f.name == "" || filepath.Dir(f.name) != filepath.Clean(dirname) {
+		return nil, nil
+	}
+	return []os.FileInfo{&ioFileInfo{name: filepath.Base(f.name)}}, nil
+}
+
+func (f *ioFS) OpenRead(name string, offset int64) (io.ReadCloser, error) {
+	if !f.isStream(name) {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+
+	f.p.lock.Lock()
+	defer f.p.lock.Unlock()
+
+	if f.p.stream != nil {
+		r, err := f.p.stream.reopen(offset)
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.NopCloser(r), nil
+	}
+
+	f.p.stream = &ioReplayReader{r: f.p.r, limit: ioReplayLimit(&f.p.cfg), recording: true}
+	if _, err := io.CopyN(ioutil.Discard, f.p.stream, offset); err != nil {
+		return nil, err
+	}
+
+	return ioutil.NopCloser(f.p.stream), nil
+}
+
+func (f *ioFS) OpenWrite(name string) (flushWriteCloser, io.Seeker, error) {
+	if !f.isStream(name) {
+		return &ioWriter{ioutil.Discard}, nil, nil
+	}
+
+	f.p.lock.Lock()
+	defer f.p.lock.Unlock()
+
+	if f.p.written {
+		return nil, nil, errIOStreamWritten
+	}
+	f.p.written = true
+
+	return &ioWriter{f.p.w}, nil, nil
+}
+
+func (f *ioFS) Remove(name string) error {
+	return nil
+}
+
+func (f *ioFS) Cancel(c io.Closer) error {
+	return nil
+}
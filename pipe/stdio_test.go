@@ -0,0 +1,150 @@
+package pipe
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testIOPipe(t *testing.T, modify func(p *ioPipe)) {
+	r, w := io.Pipe()
+	pi, err := NewIOPipe(&cfg.Pipe, r, w)
+	require.NoError(t, err)
+	p := pi.(*ioPipe)
+	p.cfg.FileDelimited = true
+	modify(p)
+
+	msgs := make([]string, 0)
+	for i := 0; i < 10; i++ {
+		msgs = append(msgs, fmt.Sprintf("record %v", i))
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		prod, err := p.NewProducer("io-test/")
+		if err == nil {
+			for i, m := range msgs {
+				//Records of all the keys go to the same stream
+				if err = prod.PushK(fmt.Sprintf("key%v", i%3), []byte(m)); err != nil {
+					break
+				}
+			}
+		}
+		if err == nil {
+			err = prod.Close()
+		}
+		errCh <- err
+		_ = w.CloseWithError(err)
+	}()
+
+	c, err := p.NewConsumer("io-test/")
+	require.NoError(t, err)
+
+	res := make([]string, 0)
+	for {
+		m, err := c.FetchNext()
+		require.NoError(t, err)
+		if m == nil {
+			break
+		}
+		res = append(res, string(m.([]byte)))
+	}
+	require.NoError(t, c.Close())
+	require.NoError(t, <-errCh)
+
+	require.Equal(t, msgs, res)
+}
+
+func TestIOPipe(t *testing.T) {
+	t.Run("plain", func(t *testing.T) {
+		testIOPipe(t, func(p *ioPipe) {})
+	})
+	t.Run("header", func(t *testing.T) {
+		testIOPipe(t, func(p *ioPipe) { p.cfg.FileHeader = true })
+	})
+	t.Run("compressed_encrypted", func(t *testing.T) {
+		testIOPipe(t, func(p *ioPipe) {
+			p.cfg.Compression = true
+			p.cfg.Encryption.Enabled = true
+			p.cfg.Encryption.PublicKey, p.cfg.Encryption.PrivateKey = genTestKeys(t)
+			p.cfg.Encryption.SigningKey = p.cfg.Encryption.PrivateKey
+		})
+	})
+}
+
+//Replayed part of the stream covers the read buffer of the consumer, so as
+//the stream can be reopened after the header
+func TestIOPipeLargeDecompressBuffer(t *testing.T) {
+	pc := cfg.Pipe
+	pc.FileDelimited = true
+	pc.FileHeader = true
+	pc.Compression = true
+	pc.MaxConsumerMemory = 1 << 30
+	pc.DecompressBufferSize = 8 << 20
+
+	var buf bytes.Buffer
+	p, err := NewIOPipe(&pc, &buf, &buf)
+	require.NoError(t, err)
+
+	rnd := rand.New(rand.NewSource(1))
+	msgs := make([]string, 0)
+	for i := 0; i < 2048; i++ {
+		b := make([]byte, 512)
+		_, _ = rnd.Read(b)
+		msgs = append(msgs, hex.EncodeToString(b))
+	}
+
+	prod, err := p.NewProducer("io-test/")
+	require.NoError(t, err)
+	for _, m := range msgs {
+		require.NoError(t, prod.Push([]byte(m)))
+	}
+	require.NoError(t, prod.Close())
+	//Header reader buffers more than the default replay buffer
+	require.True(t, buf.Len() > maxBinaryHeaderLen+ioReplayBufferSize)
+
+	c, err := p.NewConsumer("io-test/")
+	require.NoError(t, err)
+
+	res := make([]string, 0)
+	for {
+		m, err := c.FetchNext()
+		require.NoError(t, err)
+		if m == nil {
+			break
+		}
+		res = append(res, string(m.([]byte)))
+	}
+	require.NoError(t, c.Close())
+
+	require.Equal(t, msgs, res)
+}
+
+func TestIOPipeSingleStream(t *testing.T) {
+	var buf bytes.Buffer
+	p, err := NewIOPipe(&cfg.Pipe, &buf, &buf)
+	require.NoError(t, err)
+
+	prod, err := p.NewProducer("io-test/")
+	require.NoError(t, err)
+	require.NoError(t, prod.Push([]byte("first")))
+	require.NoError(t, prod.Close())
+
+	//The stream can be written once
+	prod, err = p.NewProducer("io-test/")
+	require.NoError(t, err)
+	require.Equal(t, errIOStreamWritten, prod.Push([]byte("second")))
+
+	_, err = p.NewProducer("io-test/")
+	require.NoError(t, err)
+
+	r, err := NewIOPipe(&cfg.Pipe, nil, &buf)
+	require.NoError(t, err)
+	_, err = r.NewConsumer("io-test/")
+	require.Error(t, err)
+}
@@ -0,0 +1,121 @@
+package pipe
+
+import (
+	"context"
+)
+
+//Message is delivered by the consumer stream
+type Message struct {
+	Data interface{}
+	//Err is the consumer error. Errors, other than transform failures, are
+	//the last message of the stream
+	Err error
+}
+
+type stream struct {
+	ch     chan Message
+	ctx    context.Context
+	done   chan struct{}
+	closed bool //guarded by streamLock
+}
+
+//Stream returns the channel, which delivers the messages as they become
+//available, so as the caller can range over it instead of calling FetchNext.
+//Channel is unbuffered, so the consumer doesn't read ahead of the caller and
+//consumer offset covers only the messages received from the channel.
+//Channel is closed when the ctx is canceled, the consumer is closed or
+//reached the end of the stream. Message already being delivered can still be
+//received after the ctx is canceled. Consumer error is delivered as the last
+//message. Calling Stream again closes previously returned channel
+func (p *baseConsumer) Stream(ctx context.Context) <-chan Message {
+	s := &stream{ch: make(chan Message), ctx: ctx, done: make(chan struct{})}
+
+	p.deliveryLock.Lock()
+	old, finished := p.stream, p.finished
+	if !finished {
+		p.stream = s
+		close(p.changed)
+		p.changed = make(chan struct{})
+	}
+	p.deliveryLock.Unlock()
+
+	if old != nil {
+		p.closeStream(old)
+	}
+
+	if finished {
+		close(s.ch)
+		return s.ch
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-p.ctx.Done():
+		case <-s.done:
+		}
+		p.closeStream(s)
+	}()
+
+	return s.ch
+}
+
+func (p *baseConsumer) closeStream(s *stream) {
+	p.streamLock.Lock()
+	defer p.streamLock.Unlock()
+	p.closeStreamLocked(s)
+}
+
+func (p *baseConsumer) closeStreamLocked(s *stream) {
+	if s.closed {
+		return
+	}
+	s.closed = true
+	close(s.ch)
+	close(s.done)
+
+	p.deliveryLock.Lock()
+	if p.stream == s {
+		p.stream = nil
+	}
+	p.deliveryLock.Unlock()
+}
+
+//sendStream delivers the message to the stream, the message can be peeked
+//meanwhile. Returns retry if the stream has been closed or replaced, so as
+//the message should be delivered according to the new state
+func (p *baseConsumer) sendStream(s *stream, m Message, changed chan struct{}) (sent bool, retry bool) {
+	p.streamLock.Lock()
+	defer p.streamLock.Unlock()
+
+	if s.closed {
+		return false, true
+	}
+
+	for {
+		select {
+		case s.ch <- m:
+			return true, false
+		case p.peekCh <- m:
+		case <-s.ctx.Done():
+			p.closeStreamLocked(s)
+			return false, true
+		case <-changed:
+			return false, true
+		case <-p.ctx.Done():
+			return false, false
+		}
+	}
+}
+
+//finishStream closes the stream when the fetch loop exits
+func (p *baseConsumer) finishStream() {
+	p.deliveryLock.Lock()
+	p.finished = true
+	s := p.stream
+	p.deliveryLock.Unlock()
+
+	if s != nil {
+		p.closeStream(s)
+	}
+}
@@ -0,0 +1,135 @@
+package pipe
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStream(t *testing.T) {
+	deleteTestTopics(t)
+
+	topic := "stream-test-topic/"
+
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	fp.cfg.StartPolicy = StartPolicyEarliest
+
+	saveTimeNow := timeNow
+	defer func() { timeNow = saveTimeNow }()
+	now := time.Now()
+	timeNow = func() time.Time { return now }
+
+	msgs := []string{"msg1", "msg2", "msg3", "msg4"}
+	produceTestMsgs(t, fp, topic, msgs)
+
+	c, err := fp.NewConsumer(topic)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := c.Stream(ctx)
+	res := make([]string, 0)
+	for m := range ch {
+		require.NoError(t, m.Err)
+		res = append(res, string(m.Data.([]byte)))
+		if len(res) == 2 {
+			cancel()
+			break
+		}
+	}
+	require.Equal(t, msgs[:2], res)
+
+	//Records not received from the stream are not committed
+	require.NoError(t, c.Close())
+	_, ok := <-ch
+	require.False(t, ok)
+
+	c, err = fp.NewConsumer(topic)
+	require.NoError(t, err)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	ch = c.Stream(ctx)
+	for _, v := range msgs[2:] {
+		m := <-ch
+		require.NoError(t, m.Err)
+		require.Equal(t, v, string(m.Data.([]byte)))
+	}
+
+	//Live tail
+	now = now.Add(time.Second)
+	produceTestMsgs(t, fp, topic, []string{"msg5"})
+	select {
+	case m := <-ch:
+		require.Equal(t, "msg5", string(m.Data.([]byte)))
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout waiting for the new record")
+	}
+
+	//Closing the consumer closes the stream
+	require.NoError(t, c.Close())
+	_, ok = <-ch
+	require.False(t, ok)
+}
+
+func TestStreamEnd(t *testing.T) {
+	deleteTestTopics(t)
+
+	topic := "stream-end-test-topic/"
+
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	fp.cfg.NonBlocking = true
+	fp.cfg.StartPolicy = StartPolicyEarliest
+
+	msgs := []string{"msg1", "msg2"}
+	produceTestMsgs(t, fp, topic, msgs)
+
+	c, err := fp.NewConsumer(topic)
+	require.NoError(t, err)
+
+	res := make([]string, 0)
+	for m := range c.Stream(context.Background()) {
+		require.NoError(t, m.Err)
+		res = append(res, string(m.Data.([]byte)))
+	}
+	require.Equal(t, msgs, res)
+
+	//Stream of finished consumer is closed
+	_, ok := <-c.Stream(context.Background())
+	require.False(t, ok)
+
+	require.NoError(t, c.Close())
+}
+
+func TestStreamError(t *testing.T) {
+	p, err := Create("local", &cfg.Pipe, nil)
+	require.NoError(t, err)
+
+	c, err := p.NewConsumer("stream-error")
+	require.NoError(t, err)
+	pr, err := p.NewProducer("stream-error")
+	require.NoError(t, err)
+
+	c.SetTransform(maskTestTransform)
+	ch := c.Stream(context.Background())
+
+	require.NoError(t, pr.Push([]byte("ssn=1")))
+	require.NoError(t, pr.Push([]byte("bad")))
+
+	m := <-ch
+	require.NoError(t, m.Err)
+	require.Equal(t, "ssn=***", string(m.Data.([]byte)))
+	m = <-ch
+	require.Error(t, m.Err)
+
+	//Transform failure doesn't end the stream, the message is delivered
+	//once the transform is fixed
+	c.SetTransform(func(msg interface{}) (interface{}, error) { return msg, nil })
+	m = <-ch
+	require.NoError(t, m.Err)
+	require.Equal(t, "bad", string(m.Data.([]byte)))
+
+	require.NoError(t, pr.Close())
+	require.NoError(t, c.Close())
+}
@@ -0,0 +1,64 @@
+package pipe
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const swapMarkerPrefix = "_swap."
+
+//exchanger is implemented by the filesystems, which can atomically exchange
+//two paths
+type exchanger interface {
+	Exchange(oldpath, newpath string) error
+}
+
+//swapMarker returns the id of the last swap of the topic directory data
+func swapMarker(files []os.FileInfo) string {
+	for _, f := range files {
+		if strings.HasPrefix(f.Name(), swapMarkerPrefix) {
+			return strings.TrimPrefix(f.Name(), swapMarkerPrefix)
+		}
+	}
+	return ""
+}
+
+//SwapTopicData atomically replaces the data of directory topic with the
+//content of staging directory. Both topic and stagingDir are relative to pipe
+//base directory. Topic directory is exchanged with the staging directory by
+//single rename, so the topic directory is never missing, and then old data is
+//moved aside to the <topic>.archived.<timestamp> directory.
+//Consumers finish the file they are currently reading from old data and then
+//restart from the beginning of the new data. Only supported by file pipe on
+//Linux, because S3 and HDFS can't exchange directories atomically.
+func (p *filePipe) SwapTopicData(topic string, stagingDir string) error {
+	if !strings.HasSuffix(topic, "/") {
+		return fmt.Errorf("only directory topics can be swapped: %v", topic)
+	}
+
+	e, ok := p.fs.(exchanger)
+	if !ok {
+		return fmt.Errorf("atomic topic data swap is not supported by the pipe")
+	}
+
+	dir := strings.TrimSuffix(topicPath(p.datadir, topic), "/")
+	staging := strings.TrimSuffix(topicPath(p.datadir, stagingDir), "/")
+	id := fmt.Sprintf("%d", time.Now().UnixNano())
+
+	if err := writeMetaFile(p.fs, metaFileName(staging+"/", "swap."+id), struct{}{}); err != nil {
+		return err
+	}
+
+	//Topic, which doesn't exist yet, is created empty to be exchanged
+	if err := p.fs.MkdirAll(dir, dirPerm); err != nil {
+		return err
+	}
+
+	if err := e.Exchange(staging, dir); err != nil {
+		return err
+	}
+
+	return p.fs.Rename(staging, fmt.Sprintf("%s.archived.%s", dir, id))
+}
@@ -0,0 +1,16 @@
+package pipe
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+//Exchange atomically exchanges the files or directories
+func (p *fileFS) Exchange(oldpath, newpath string) error {
+	err := unix.Renameat2(unix.AT_FDCWD, oldpath, unix.AT_FDCWD, newpath, unix.RENAME_EXCHANGE)
+	if err != nil {
+		return &os.LinkError{Op: "exchange", Old: oldpath, New: newpath, Err: err}
+	}
+	return nil
+}
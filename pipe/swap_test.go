@@ -0,0 +1,63 @@
+package pipe
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSwapTopicData(t *testing.T) {
+	deleteTestTopics(t)
+
+	topic := "swap-test-topic/"
+	staging := "swap-test-staging/"
+	oldMsgs := []string{`{"old":1}`, `{"old":2}`, `{"old":3}`}
+	newMsgs := []string{`{"new":1}`, `{"new":2}`}
+
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+
+	produceTestMsgs(t, fp, topic, oldMsgs)
+
+	saveOffset := InitialOffset
+	InitialOffset = OffsetOldest
+	defer func() { InitialOffset = saveOffset }()
+
+	//In-flight consumer finishes old data and then waits for next file
+	c1, err := fp.NewConsumer(topic)
+	require.NoError(t, err)
+	for _, m := range oldMsgs {
+		consumeAndCheck(t, c1, m)
+	}
+
+	produceTestMsgs(t, fp, staging, newMsgs)
+
+	require.Error(t, fp.SwapTopicData("not-a-directory-topic", staging))
+	require.NoError(t, fp.SwapTopicData(topic, staging))
+
+	//In-flight consumer restarts from the beginning of new data
+	for _, m := range newMsgs {
+		consumeAndCheck(t, c1, m)
+	}
+	require.NoError(t, c1.Close())
+
+	fp.cfg.NonBlocking = true
+	require.Equal(t, newMsgs, consumeTestMsgs(t, fp, topic))
+
+	//Old data is archived
+	archived, err := filepath.Glob(baseDir + "/swap-test-topic.archived.*")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(archived))
+	require.Equal(t, oldMsgs, consumeTestMsgs(t, fp, filepath.Base(archived[0])+"/"))
+
+	_, err = ioutil.ReadDir(baseDir + "/" + staging)
+	require.Error(t, err)
+
+	//Storage, which can't exchange the directories atomically, isn't supported
+	produceTestMsgs(t, fp, staging, newMsgs)
+	fp.fs = struct{ fs }{fp.fs}
+	require.Error(t, fp.SwapTopicData(topic, staging))
+	fp.fs = &fileFS{}
+	require.Equal(t, newMsgs, consumeTestMsgs(t, fp, topic))
+}
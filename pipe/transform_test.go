@@ -0,0 +1,125 @@
+package pipe
+
+import (
+	"fmt"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var testSSNRe = regexp.MustCompile(`ssn=[0-9]+`)
+
+func maskTestTransform(msg interface{}) (interface{}, error) {
+	b := msg.([]byte)
+	if string(b) == "bad" {
+		return nil, fmt.Errorf("unparsable record")
+	}
+	return testSSNRe.ReplaceAll(b, []byte("ssn=***")), nil
+}
+
+func TestTransform(t *testing.T) {
+	deleteTestTopics(t)
+
+	topic := "transform-test-topic/"
+
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	fp.cfg.NonBlocking = true
+
+	produceTestMsgs(t, fp, topic, []string{"name=a ssn=123", "name=b", "bad", "name=c ssn=456"})
+
+	saveOffset := InitialOffset
+	InitialOffset = OffsetOldest
+	defer func() { InitialOffset = saveOffset }()
+
+	//Default policy fails the consumer
+	c, err := fp.NewConsumer(topic)
+	require.NoError(t, err)
+	c.SetTransform(maskTestTransform)
+	for _, v := range []string{"name=a ssn=***", "name=b"} {
+		m, err := c.FetchNext()
+		require.NoError(t, err)
+		require.Equal(t, v, string(m.([]byte)))
+	}
+	_, err = c.FetchNext()
+	require.Error(t, err)
+	//Failed message is retried, consumer proceeds once the transform is fixed
+	_, err = c.FetchNext()
+	require.Error(t, err)
+	c.SetTransform(func(msg interface{}) (interface{}, error) { return msg, nil })
+	for _, v := range []string{"bad", "name=c ssn=456"} {
+		m, err := c.FetchNext()
+		require.NoError(t, err)
+		require.Equal(t, v, string(m.([]byte)))
+	}
+	require.NoError(t, c.CloseOnFailure())
+
+	//Skip policy drops failed record
+	fp.cfg.TransformErrorPolicy = TransformErrorSkip
+	c, err = fp.NewConsumer(topic)
+	require.NoError(t, err)
+	c.SetTransform(maskTestTransform)
+	res := make([]string, 0)
+	for {
+		m, err := c.FetchNext()
+		require.NoError(t, err)
+		if m == nil {
+			break
+		}
+		res = append(res, string(m.([]byte)))
+	}
+	require.NoError(t, c.Close())
+	require.Equal(t, []string{"name=a ssn=***", "name=b", "name=c ssn=***"}, res)
+
+	//Dead letter policy writes failed record to the dead letter topic
+	fp.cfg.TransformErrorPolicy = TransformErrorDeadLetter
+	_, err = fp.NewConsumer(topic)
+	require.Error(t, err)
+	fp.cfg.TransformDeadLetterTopic = "transform-dead-letter-topic/"
+	c, err = fp.NewConsumer(topic)
+	require.NoError(t, err)
+	c.SetTransform(maskTestTransform)
+	res = res[:0]
+	for {
+		m, err := c.FetchNext()
+		require.NoError(t, err)
+		if m == nil {
+			break
+		}
+		res = append(res, string(m.([]byte)))
+	}
+	require.NoError(t, c.Close())
+	require.Equal(t, []string{"name=a ssn=***", "name=b", "name=c ssn=***"}, res)
+
+	fp.cfg.TransformErrorPolicy = ""
+	c, err = fp.NewConsumer(fp.cfg.TransformDeadLetterTopic)
+	require.NoError(t, err)
+	m, err := c.FetchNext()
+	require.NoError(t, err)
+	require.Equal(t, "bad", string(m.([]byte)))
+	require.NoError(t, c.Close())
+
+	fp.cfg.TransformErrorPolicy = "unknown"
+	_, err = fp.NewConsumer(topic)
+	require.Error(t, err)
+}
+
+func TestTransformLocal(t *testing.T) {
+	p, err := Create("local", &cfg.Pipe, nil)
+	require.NoError(t, err)
+
+	c, err := p.NewConsumer("transform-local")
+	require.NoError(t, err)
+	pr, err := p.NewProducer("transform-local")
+	require.NoError(t, err)
+
+	//Message fetched before the transform is set is transformed as well
+	require.NoError(t, pr.Push([]byte("name=a ssn=123")))
+	c.SetTransform(maskTestTransform)
+	m, err := c.FetchNext()
+	require.NoError(t, err)
+	require.Equal(t, "name=a ssn=***", string(m.([]byte)))
+
+	require.NoError(t, pr.Close())
+	require.NoError(t, c.Close())
+}
@@ -0,0 +1,40 @@
+package pipe
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/uber/storagetapper/log"
+)
+
+//trashFS is the subset of the filesystem operations required to move files
+//to trash
+type trashFS interface {
+	MkdirAll(path string, perm os.FileMode) error
+	Rename(oldpath, newpath string) error
+}
+
+//hdfsTrashDir returns current trash directory of the HDFS user, like `hdfs
+//dfs -rm` uses
+func hdfsTrashDir(user string) string {
+	return "/user/" + user + "/.Trash/Current"
+}
+
+//trashRemove moves the file to the trash directory, preserving its absolute
+//path under the trash directory. Falls back to permanent removal by remove
+//if trash is unavailable
+func trashRemove(f trashFS, trash string, name string, remove func(string) error) error {
+	dst := trash + filepath.Clean("/"+name)
+
+	err := f.MkdirAll(filepath.Dir(dst), dirPerm)
+	if err == nil {
+		err = f.Rename(name, dst)
+		if err == nil || os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	log.Warnf("Trash is unavailable, removing permanently: %v: %v", name, err)
+
+	return remove(name)
+}
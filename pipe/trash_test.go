@@ -0,0 +1,50 @@
+package pipe
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrashRemove(t *testing.T) {
+	deleteTestTopics(t)
+
+	dir := baseDir + "/trash-test"
+	trash := baseDir + "/trash"
+	require.NoError(t, os.MkdirAll(dir, dirPerm))
+
+	f := &fileFS{}
+	name := dir + "/1600000000.001.default"
+	require.NoError(t, ioutil.WriteFile(name, []byte("data"), 0644))
+
+	//File is moved to trash under its original path
+	require.NoError(t, trashRemove(f, trash, name, f.Remove))
+	_, err := os.Stat(name)
+	require.True(t, os.IsNotExist(err))
+	b, err := ioutil.ReadFile(trash + name)
+	require.NoError(t, err)
+	require.Equal(t, "data", string(b))
+
+	//Missing file is reported, not removed permanently
+	err = trashRemove(f, trash, name, func(string) error { require.FailNow(t, "unexpected remove"); return nil })
+	require.True(t, os.IsNotExist(err))
+
+	//Trash is unavailable, file is removed permanently
+	require.NoError(t, os.RemoveAll(trash))
+	require.NoError(t, ioutil.WriteFile(trash, nil, 0644))
+	require.NoError(t, ioutil.WriteFile(name, []byte("data"), 0644))
+	require.NoError(t, trashRemove(f, trash, name, f.Remove))
+	_, err = os.Stat(name)
+	require.True(t, os.IsNotExist(err))
+
+	require.Equal(t, "/user/hadoop/.Trash/Current", hdfsTrashDir("hadoop"))
+
+	//Client of the pipe with trash enabled moves files to the user's trash
+	p := &hdfsPipe{filePipe: filePipe{cfg: cfg.Pipe}}
+	p.cfg.Hadoop.User = "hadoop"
+	require.Equal(t, "", p.newClient().trash)
+	p.cfg.Hadoop.UseTrash = true
+	require.Equal(t, "/user/hadoop/.Trash/Current", p.newClient().trash)
+}
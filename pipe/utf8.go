@@ -0,0 +1,68 @@
+package pipe
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/uber/storagetapper/log"
+)
+
+//UTF-8 validation policies
+const (
+	UTF8Reject  = "reject"
+	UTF8Replace = "replace"
+)
+
+//ErrInvalidUTF8 is returned for the record with invalid UTF-8 sequences or
+//byte order mark, when UTF8Validation is set to reject
+var ErrInvalidUTF8 = errors.New("record is not valid BOM-free UTF-8")
+
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+var utf8Replacement = []byte(string(utf8.RuneError))
+
+//sanitizeUTF8 validates record according to the policy. In replace mode
+//invalid bytes are replaced with the Unicode replacement character and byte
+//order mark is removed
+func sanitizeUTF8(policy string, b []byte) ([]byte, error) {
+	switch policy {
+	case "":
+		return b, nil
+	case UTF8Reject:
+		if bytes.HasPrefix(b, utf8BOM) || !utf8.Valid(b) {
+			return nil, ErrInvalidUTF8
+		}
+		return b, nil
+	case UTF8Replace:
+		b = bytes.TrimPrefix(b, utf8BOM)
+		if utf8.Valid(b) {
+			return b, nil
+		}
+		res := make([]byte, 0, len(b)+len(utf8Replacement))
+		for len(b) != 0 {
+			r, sz := utf8.DecodeRune(b)
+			if r == utf8.RuneError && sz == 1 {
+				res = append(res, utf8Replacement...)
+			} else {
+				res = append(res, b[:sz]...)
+			}
+			b = b[sz:]
+		}
+		return res, nil
+	}
+
+	return nil, fmt.Errorf("unknown UTF-8 validation policy: %v", policy)
+}
+
+//validateUTF8 validates consumed record, if enabled by UTF8ValidateOnRead
+func (p *fileConsumer) validateUTF8() {
+	if !p.cfg.UTF8ValidateOnRead || p.msg == nil {
+		return
+	}
+	p.msg, p.err = sanitizeUTF8(p.cfg.UTF8Validation, p.msg)
+	if p.err == ErrInvalidUTF8 {
+		log.Errorf("Invalid UTF-8 record in %v", p.name)
+	}
+}
@@ -0,0 +1,84 @@
+package pipe
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSanitizeUTF8(t *testing.T) {
+	tests := []struct {
+		in      string
+		reject  bool
+		replace string
+	}{
+		{"valid ąžuolas", false, "valid ąžuolas"},
+		{"invalid \xff\xfe end", true, "invalid �� end"},
+		{"\xEF\xBB\xBFwith bom", true, "with bom"},
+		{"truncated \xc4", true, "truncated �"},
+		{"", false, ""},
+	}
+
+	for _, tt := range tests {
+		b, err := sanitizeUTF8("", []byte(tt.in))
+		require.NoError(t, err)
+		require.Equal(t, tt.in, string(b))
+
+		b, err = sanitizeUTF8(UTF8Reject, []byte(tt.in))
+		if tt.reject {
+			require.Equal(t, ErrInvalidUTF8, err)
+		} else {
+			require.NoError(t, err)
+			require.Equal(t, tt.in, string(b))
+		}
+
+		b, err = sanitizeUTF8(UTF8Replace, []byte(tt.in))
+		require.NoError(t, err)
+		require.Equal(t, tt.replace, string(b))
+	}
+
+	_, err := sanitizeUTF8("unknown", []byte("a"))
+	require.Error(t, err)
+}
+
+func TestUTF8Validation(t *testing.T) {
+	deleteTestTopics(t)
+
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	fp.cfg.NonBlocking = true
+	fp.cfg.CircuitBreakerThreshold = 1
+
+	//Invalid record is rejected, not tripping the circuit breaker
+	fp.cfg.UTF8Validation = UTF8Reject
+	p, err := fp.NewProducer("utf8-reject-test/")
+	require.NoError(t, err)
+	require.Equal(t, ErrInvalidUTF8, p.Push([]byte("bad \xff")))
+	require.NoError(t, p.Push([]byte("good")))
+	require.NoError(t, p.Close())
+	require.Equal(t, []string{"good"}, consumeTestMsgs(t, fp, "utf8-reject-test/"))
+
+	fp.cfg.UTF8Validation = UTF8Replace
+	produceTestMsgs(t, fp, "utf8-replace-test/", []string{"bad \xff", "good"})
+	require.Equal(t, []string{"bad �", "good"}, consumeTestMsgs(t, fp, "utf8-replace-test/"))
+
+	//Validation on read
+	fp.cfg.UTF8Validation = ""
+	produceTestMsgs(t, fp, "utf8-read-test/", []string{"good", "bad \xff"})
+
+	fp.cfg.UTF8ValidateOnRead = true
+	fp.cfg.UTF8Validation = UTF8Replace
+	require.Equal(t, []string{"good", "bad �"}, consumeTestMsgs(t, fp, "utf8-read-test/"))
+
+	fp.cfg.UTF8Validation = UTF8Reject
+	saveOffset := InitialOffset
+	InitialOffset = OffsetOldest
+	defer func() { InitialOffset = saveOffset }()
+	c, err := fp.NewConsumer("utf8-read-test/")
+	require.NoError(t, err)
+	m, err := c.FetchNext()
+	require.NoError(t, err)
+	require.Equal(t, "good", string(m.([]byte)))
+	_, err = c.FetchNext()
+	require.Equal(t, ErrInvalidUTF8, err)
+	require.NoError(t, c.CloseOnFailure())
+}
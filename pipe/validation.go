@@ -0,0 +1,143 @@
+package pipe
+
+import (
+	"fmt"
+
+	"github.com/uber/storagetapper/log"
+	"github.com/uber/storagetapper/metrics"
+)
+
+//topicSchema returns the JSON Schema of the topic, loaded on first use.
+//Returns nil if the records of the topic are not validated
+func (p *filePipe) topicSchema(topic string) (*jsonSchema, error) {
+	src, ok := p.cfg.SchemaValidation.Schemas[topic]
+	if !ok {
+		return nil, nil
+	}
+
+	switch p.cfg.SchemaValidation.Policy {
+	case "", SchemaReject, SchemaPass:
+	case SchemaDeadLetter:
+		if p.cfg.SchemaValidation.DeadLetterTopic == "" {
+			return nil, fmt.Errorf("dead letter topic is required by schema validation policy")
+		}
+	default:
+		return nil, fmt.Errorf("unknown schema validation policy: %v", p.cfg.SchemaValidation.Policy)
+	}
+
+	if s := p.schemas.get(topic); s != nil {
+		return s, nil
+	}
+
+	s, err := loadJSONSchema(src)
+	if err != nil {
+		return nil, err
+	}
+	p.schemas.put(topic, s)
+
+	return s, nil
+}
+
+//validateSchema applies schema validation policy to the record. Returns false
+//if the record should not be written to the topic
+func (p *fileProducer) validateSchema(key string, b []byte) (bool, error) {
+	if p.schema == nil {
+		return true, nil
+	}
+
+	err := p.schema.validateRecord(p.topic, b)
+	if err == nil {
+		return true, nil
+	}
+
+	p.metrics.SchemaViolations.Inc(1)
+
+	switch p.cfg.SchemaValidation.Policy {
+	case SchemaPass:
+		log.Warnf("Writing invalid record: %v", err)
+		return true, nil
+	case SchemaDeadLetter:
+		log.Warnf("Writing invalid record to dead letter topic %v: %v", p.cfg.SchemaValidation.DeadLetterTopic, err)
+		return false, p.pushDeadLetter(key, b)
+	}
+
+	return false, err
+}
+
+//deadLetterProducer writes the rejected records to the dead letter topic. The
+//producer of the topic is created on first use
+type deadLetterProducer struct {
+	pipe     *filePipe
+	topic    string
+	format   string
+	metrics  *metrics.FilePipeMetrics
+	producer *fileProducer
+}
+
+func (d *deadLetterProducer) push(key string, b []byte) error {
+	if d.producer == nil {
+		enc, err := d.pipe.encryptTopic(d.topic)
+		if err != nil {
+			return err
+		}
+		d.producer = &fileProducer{filePipe: d.pipe, topic: d.topic, files: make(map[string]*file), fs: d.pipe.fs, metrics: d.metrics, stats: make(map[string]*stat), encrypted: enc}
+		d.producer.SetFormat(d.format)
+	}
+
+	return d.producer.PushK(key, b)
+}
+
+//pushMsg writes the consumer message to the dead letter topic
+func (d *deadLetterProducer) pushMsg(msg interface{}) error {
+	switch m := msg.(type) {
+	case []byte:
+		return d.push("default", m)
+	case *LocatedMessage:
+		return d.push("default", m.Data)
+	}
+	return fmt.Errorf("can't write %T message to dead letter topic", msg)
+}
+
+//close closes the producer of the dead letter topic, if it has been created
+func (d *deadLetterProducer) close(graceful bool) error {
+	if d == nil || d.producer == nil {
+		return nil
+	}
+	if graceful {
+		return d.producer.Close()
+	}
+	return d.producer.CloseOnFailure()
+}
+
+//pushDeadLetter writes the record rejected by the schema validation to the
+//dead letter topic
+func (p *fileProducer) pushDeadLetter(key string, b []byte) error {
+	if p.deadLetter == nil {
+		p.deadLetter = &deadLetterProducer{pipe: p.filePipe, topic: p.cfg.SchemaValidation.DeadLetterTopic, format: p.header.Format, metrics: p.metrics}
+	}
+
+	return p.deadLetter.push(key, b)
+}
+
+func (p *fileProducer) closeDeadLetter(graceful bool) error {
+	return p.deadLetter.close(graceful)
+}
+
+//initTransformPolicy sets the transform error policy of the consumer and the
+//producer of the dead letter topic, if the policy requires one
+func (p *filePipe) initTransformPolicy(c *baseConsumer, m *metrics.FilePipeMetrics) error {
+	switch p.cfg.TransformErrorPolicy {
+	case "", TransformErrorFail, TransformErrorSkip:
+	case TransformErrorDeadLetter:
+		if p.cfg.TransformDeadLetterTopic == "" {
+			return fmt.Errorf("dead letter topic is required by transform error policy")
+		}
+		c.deadLetter = &deadLetterProducer{pipe: p, topic: p.cfg.TransformDeadLetterTopic, metrics: m}
+	default:
+		return fmt.Errorf("unknown transform error policy: %v", p.cfg.TransformErrorPolicy)
+	}
+
+	c.transformPolicy = p.cfg.TransformErrorPolicy
+
+	return nil
+}
@@ -0,0 +1,83 @@
+package pipe
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchemaValidationPolicy(t *testing.T) {
+	topic := "schema-validation-test/"
+	dlq := "schema-validation-dead-letter/"
+
+	valid := `{"id":1,"name":"abc"}`
+	invalid := `{"id":"1"}`
+
+	for _, policy := range []string{"", SchemaReject, SchemaDeadLetter, SchemaPass} {
+		t.Run(policy, func(t *testing.T) {
+			deleteTestTopics(t)
+
+			fp := initTestFilePipe(&cfg.Pipe, false, t)
+			fp.cfg.NonBlocking = true
+			fp.cfg.SchemaValidation.Schemas = map[string]string{topic: testJSONSchema}
+			fp.cfg.SchemaValidation.Policy = policy
+			fp.cfg.SchemaValidation.DeadLetterTopic = dlq
+
+			p, err := fp.NewProducer(topic)
+			require.NoError(t, err)
+			pr := p.(*fileProducer)
+
+			require.NoError(t, p.Push([]byte(valid)))
+			err = p.Push([]byte(invalid))
+			require.Equal(t, int64(1), pr.metrics.SchemaViolations.Get())
+			require.NoError(t, p.Close())
+
+			switch policy {
+			case SchemaDeadLetter:
+				require.NoError(t, err)
+				require.Equal(t, []string{valid}, consumeTestMsgs(t, fp, topic))
+				require.Equal(t, []string{invalid}, consumeTestMsgs(t, fp, dlq))
+			case SchemaPass:
+				require.NoError(t, err)
+				require.Equal(t, []string{valid, invalid}, consumeTestMsgs(t, fp, topic))
+			default:
+				e, ok := err.(*SchemaViolationError)
+				require.True(t, ok)
+				require.Equal(t, topic, e.Topic)
+				require.Equal(t, "$", e.Path)
+				require.Equal(t, []string{valid}, consumeTestMsgs(t, fp, topic))
+			}
+
+			if policy != SchemaDeadLetter {
+				require.Equal(t, []string{}, consumeTestMsgs(t, fp, dlq))
+			}
+		})
+	}
+}
+
+func TestSchemaValidationConfig(t *testing.T) {
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+
+	//Topics without schema are not validated
+	fp.cfg.SchemaValidation.Schemas = map[string]string{"other-topic/": testJSONSchema}
+	p, err := fp.NewProducer("schema-config-test/")
+	require.NoError(t, err)
+	require.Nil(t, p.(*fileProducer).schema)
+	require.NoError(t, p.CloseOnFailure())
+
+	topic := "schema-config-test/"
+	fp.cfg.SchemaValidation.Schemas = map[string]string{topic: testJSONSchema}
+
+	fp.cfg.SchemaValidation.Policy = SchemaDeadLetter
+	_, err = fp.NewProducer(topic)
+	require.Error(t, err)
+
+	fp.cfg.SchemaValidation.Policy = "unknown"
+	_, err = fp.NewProducer(topic)
+	require.Error(t, err)
+
+	fp.cfg.SchemaValidation.Policy = SchemaReject
+	fp.cfg.SchemaValidation.Schemas = map[string]string{topic: `{"type": 1}`}
+	_, err = fp.NewProducer(topic)
+	require.Error(t, err)
+}
@@ -0,0 +1,384 @@
+package pipe
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/uber/storagetapper/log"
+	"github.com/uber/storagetapper/metrics"
+)
+
+//VerifyError describes integrity problem found in the topic file
+type VerifyError struct {
+	File   string
+	Offset int64 //Offset in the decoded data of the file, -1 if not applicable
+	Err    string
+}
+
+//VerifyReport is the result of the topic verification
+type VerifyReport struct {
+	Topic        string
+	FilesChecked int64
+	NumRecs      int64
+	Errors       []VerifyError
+	//LastFile is the last verified file. Interrupted verification resumes
+	//after this file
+	LastFile string
+	Complete bool
+}
+
+//verifyState is the progress of the topic verification, saved after every
+//verified file
+type verifyState struct {
+	Report VerifyReport
+	//Sequences is the last sequence number of the records of every partition
+	Sequences map[string]uint64 `json:",omitempty"`
+}
+
+//verifyFileName returns the name of the state of the topic verification
+func verifyFileName(tp string) string {
+	return metaFileName(tp, "verify")
+}
+
+//saveVerifyState saves the progress of the verification, if the pipe can
+//write its state
+func (p *filePipe) saveVerifyState(tp string, s *verifyState) error {
+	if p.readOnly && p.db == nil {
+		return nil
+	}
+	return p.writeState(p.fs, verifyFileName(tp), s)
+}
+
+//sequenceCheck returns the function verifying that the sequence numbers of
+//the records of the partition are contiguous. The first gap is reported to
+//gap. Returns nil if the records don't carry sequence numbers
+func (p *filePipe) sequenceCheck(last map[string]uint64, partition string, name string, gap **VerifyError) func(int64, []byte) {
+	if p.sequence == nil {
+		return nil
+	}
+	return func(offset int64, record []byte) {
+		seq, ok := p.sequence(record)
+		if !ok {
+			return
+		}
+		if l, ok := last[partition]; ok && seq != l+1 && *gap == nil {
+			*gap = &VerifyError{name, offset, fmt.Sprintf("sequence number %v doesn't follow %v", seq, l)}
+		}
+		last[partition] = seq
+	}
+}
+
+//readStats collects file stats recorded by producers in partition manifests
+//and end of stream marks of the topic
+func (p *filePipe) readStats(tp string, files []os.FileInfo) (map[string]*stat, error) {
+	dir := filepath.Dir(tp)
+	mprefix := filepath.Base(metaFileName(tp, ""))
+	stats := make(map[string]*stat)
+
+	add := func(s []stat) {
+		for i := range s {
+			if strings.HasPrefix(s[i].FileName, tp) {
+				stats[s[i].FileName] = &s[i]
+			}
+		}
+	}
+
+	for _, f := range files {
+		if f.IsDir() || !strings.HasPrefix(f.Name(), mprefix) || !strings.HasSuffix(f.Name(), ".manifest") {
+			continue
+		}
+		var m partitionManifest
+		if _, err := readMetaFile(p.fs, dir+"/"+f.Name(), &m); err != nil {
+			return nil, err
+		}
+		add(m.Files)
+	}
+
+	var s []stat
+	if _, err := readMetaFile(p.fs, dir+"/_DONE", &s); err != nil {
+		return nil, err
+	}
+	add(s)
+
+	return stats, nil
+}
+
+//verifyReader decodes file data according to the file name suffixes
+func (p *filePipe) verifyReader(name string, h *Header, r io.Reader) (io.Reader, func() error, error) {
+	var sigErr = func() error { return nil }
+	base := strings.TrimSuffix(name, ".gpg")
+
+	if base != name {
+		c := &fileConsumer{filePipe: p}
+		if h != nil {
+			c.header = *h
+		}
+		dr, md, err := c.initCrypterReader(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		r = dr
+		sigErr = func() error { return md.SignatureError }
+	}
+
+	cfg := p.cfg
+	switch {
+	case strings.HasSuffix(base, ".zst"):
+		cfg.CompressionType = CompressionZstd
+	case strings.HasSuffix(base, ".gz"):
+		cfg.CompressionType = CompressionGzip
+	default:
+		return r, sigErr, nil
+	}
+
+	r, c, err := newDecompressReader(&cfg, r, ioutil.NopCloser(nil))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return r, func() error { log.E(c.Close()); return sigErr() }, nil
+}
+
+//countRecords checks the framing of the records and returns number of records
+//in the file. rec, if set, is called with the offset and the content of every
+//record
+func countRecords(r *bufio.Reader, text bool, rec func(int64, []byte)) (int64, int64, error) {
+	var n, offset, start int64
+	var next uint32 //next expected fragment of the fragmented record
+	var buf []byte  //fragments of the record read so far, starting at start
+	for {
+		var sz int
+		if text {
+			b, err := r.ReadBytes(delimiter)
+			if err == io.EOF && len(b) == 0 {
+				return n, offset, nil
+			}
+			if err == io.EOF {
+				return n, offset, fmt.Errorf("corrupted file. not ending with delimiter")
+			}
+			if err != nil {
+				return n, offset, err
+			}
+			sz = len(b)
+			if rec != nil {
+				rec(offset, b[:len(b)-1])
+			}
+		} else {
+			b, idx, total, err := readFrame(r)
+			if err == io.EOF && next != 0 {
+				return n, offset, fmt.Errorf("file ended in the middle of fragmented record")
+			}
+			if err == io.EOF {
+				return n, offset, nil
+			}
+			if err == io.ErrUnexpectedEOF {
+				return n, offset, fmt.Errorf("truncated record")
+			}
+			if err != nil {
+				return n, offset, err
+			}
+			if idx != next {
+				return n, offset, fmt.Errorf("unexpected fragment %v of %v, expected fragment %v", idx, total, next)
+			}
+			sz = 4 + len(b)
+			if total > 1 {
+				sz += fragmentHeaderLen
+			}
+			if idx == 0 {
+				start, buf = offset, buf[:0]
+			}
+			if rec != nil {
+				buf = append(buf, b...)
+			}
+			if next = idx + 1; next != total {
+				offset += int64(sz)
+				continue
+			}
+			if rec != nil {
+				rec(start, buf)
+			}
+			next = 0
+		}
+		offset += int64(sz)
+		n++
+	}
+}
+
+//scanFile reads whole file, checks its signature and record framing, if
+//count is set. rec is called for every counted record. Returns number of
+//records and the hash of the file
+func (p *filePipe) scanFile(name string, text bool, count bool, rec func(int64, []byte)) (int64, string, *VerifyError) {
+	f, err := p.fs.OpenRead(name, 0)
+	if err != nil {
+		return 0, "", &VerifyError{name, -1, err.Error()}
+	}
+	defer func() { log.E(f.Close()) }()
+
+	h := sha256.New()
+	raw := newHeaderReader(io.TeeReader(f, h))
+
+	//Header is detected from the content, so as the files written with
+	//different configs are verified
+	header, err := detectHeader(raw)
+	if err != nil {
+		return 0, "", &VerifyError{name, -1, fmt.Sprintf("broken file header: %v", err)}
+	}
+
+	r, closeReader, err := p.verifyReader(name, header, raw)
+	if err != nil {
+		return 0, "", &VerifyError{name, 0, err.Error()}
+	}
+
+	var n, offset int64
+	if count {
+		n, offset, err = countRecords(bufio.NewReader(r), text, rec)
+	} else {
+		offset, err = io.Copy(ioutil.Discard, r)
+	}
+	if err == nil {
+		err = closeReader()
+	}
+	if err != nil {
+		return n, "", &VerifyError{name, offset, err.Error()}
+	}
+
+	if _, err := io.Copy(ioutil.Discard, raw); err != nil {
+		return n, "", &VerifyError{name, -1, err.Error()}
+	}
+
+	return n, fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+//verifyFile reads whole file and checks its hash, signature, record framing,
+//number of records against the stats recorded by the producer and
+//continuity of the record sequence numbers of the partition
+func (p *filePipe) verifyFile(name string, st *stat, partition string, sequences map[string]uint64) (int64, *VerifyError) {
+	var gap *VerifyError
+	count := st != nil && p.cfg.FileDelimited
+	n, hash, verr := p.scanFile(name, count && st.Text, count, p.sequenceCheck(sequences, partition, name, &gap))
+	if verr != nil || st == nil {
+		return n, verr
+	}
+
+	if hash != st.Hash {
+		return n, &VerifyError{name, -1, fmt.Sprintf("hash mismatch, expected %v, got %v", st.Hash, hash)}
+	}
+
+	if count && n != st.NumRecs {
+		return n, &VerifyError{name, -1, fmt.Sprintf("number of records mismatch, expected %v, got %v", st.NumRecs, n)}
+	}
+
+	return n, gap
+}
+
+//VerifyTopic reads every finalized file of the topic and verifies its
+//integrity without delivering records to consumers.
+//Files are checked against hashes and number of records recorded by the
+//producer in partition manifests and end of stream marks, if present.
+//Encrypted files signatures are verified. Files present in the manifests, but
+//missing from the topic are reported as well, except the files older than the
+//earliest present file of the partition, which are removed by retention.
+//Sequence numbers of the counted records, extracted by the function set by
+//SetSequenceFunc, must be contiguous within the partition.
+//Progress is saved in the state after every file, so verification
+//interrupted by an error resumes from the file it has been interrupted at.
+func (p *filePipe) VerifyTopic(topic string) (*VerifyReport, error) {
+	tp := topicPath(p.datadir, topic)
+	dir := filepath.Dir(tp)
+	m := metrics.NewFilePipeMetrics("pipe_verify", map[string]string{"topic": topic, "pipeType": "file"})
+
+	st := &verifyState{}
+	if _, err := p.readState(p.fs, verifyFileName(tp), st); err != nil {
+		return nil, err
+	}
+	if st.Report.Complete {
+		st = &verifyState{}
+	}
+	if st.Sequences == nil {
+		st.Sequences = make(map[string]uint64)
+	}
+	r := &st.Report
+	r.Topic = topic
+
+	files, err := p.fs.ReadDir(dir, tp)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	stats, err := p.readStats(tp, files)
+	if err != nil {
+		return nil, err
+	}
+
+	present := make(map[string]bool)
+	earliest := make(map[string]string)
+	for _, f := range files {
+		fn := dir + "/" + f.Name()
+		if !strings.HasPrefix(fn, tp) || f.IsDir() || isMetaFile(f.Name()) || strings.HasSuffix(f.Name(), ".open") {
+			continue
+		}
+		present[fn] = true
+
+		partition, _, perr := parseFileName(tp, fn)
+		if e, ok := earliest[partition]; perr == nil && (!ok || fn < e) {
+			earliest[partition] = fn
+		}
+
+		if fn <= r.LastFile {
+			continue
+		}
+
+		n, verr := p.verifyFile(fn, stats[fn], partition, st.Sequences)
+		if verr != nil {
+			log.Errorf("verification of %v failed: %v", fn, verr.Err)
+			r.Errors = append(r.Errors, *verr)
+			m.VerifyErrors.Inc(1)
+		}
+
+		r.FilesChecked++
+		r.NumRecs += n
+		r.LastFile = fn
+		m.FilesVerified.Inc(1)
+		m.RecordsVerified.Inc(n)
+
+		if err := p.saveVerifyState(tp, st); err != nil {
+			return nil, err
+		}
+	}
+
+	missing := make([]string, 0)
+	for fn := range stats {
+		if !present[fn] && !removedByRetention(tp, fn, earliest) {
+			missing = append(missing, fn)
+		}
+	}
+	sort.Strings(missing)
+	for _, fn := range missing {
+		r.Errors = append(r.Errors, VerifyError{fn, -1, "file is missing"})
+		m.VerifyErrors.Inc(1)
+	}
+
+	r.Complete = true
+
+	return r, p.saveVerifyState(tp, st)
+}
+
+//removedByRetention returns true if the missing file precedes the earliest
+//present file of its partition in the name order, which is the order files
+//are consumed and removed by retention in. Only the files missing after the
+//earliest present one are unexpected gaps
+func removedByRetention(tp string, fn string, earliest map[string]string) bool {
+	partition, _, err := parseFileName(tp, fn)
+	if err != nil {
+		return false
+	}
+	e, ok := earliest[partition]
+	return !ok || fn < e
+}
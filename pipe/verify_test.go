@@ -0,0 +1,177 @@
+package pipe
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testVerifyTopic(t *testing.T, compression bool) {
+	deleteTestTopics(t)
+
+	topic := "verify-test-topic/"
+
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	fp.cfg.PartitionManifest = true
+	fp.cfg.Compression = compression
+	fp.cfg.MaxFileSize = 1 //rotate on every message
+
+	msgs := make([]string, 0)
+	for i := 0; i < 3; i++ {
+		msgs = append(msgs, fmt.Sprintf(`{"verify":%v}`, i))
+	}
+	produceTestMsgs(t, fp, topic, msgs)
+
+	files, err := ioutil.ReadDir(baseDir + "/" + topic)
+	require.NoError(t, err)
+	names := make([]string, 0)
+	for _, f := range files {
+		if !isMetaFile(f.Name()) {
+			names = append(names, baseDir+"/"+topic+f.Name())
+		}
+	}
+	require.Equal(t, 3, len(names))
+
+	r, err := fp.VerifyTopic(topic)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), r.FilesChecked)
+	require.Equal(t, int64(3), r.NumRecs)
+	require.Empty(t, r.Errors)
+	require.True(t, r.Complete)
+
+	//Corrupt the second file
+	b, err := ioutil.ReadFile(names[1])
+	require.NoError(t, err)
+	b[len(b)-3] ^= 0xff
+	require.NoError(t, ioutil.WriteFile(names[1], b, 0644))
+
+	r, err = fp.VerifyTopic(topic)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), r.FilesChecked)
+	require.Equal(t, 1, len(r.Errors))
+	require.Equal(t, names[1], r.Errors[0].File)
+
+	//Resume interrupted verification after the first file
+	require.NoError(t, fp.writeState(fp.fs, verifyFileName(topicPath(fp.datadir, topic)), &verifyState{Report: VerifyReport{LastFile: names[0], FilesChecked: 1, NumRecs: 1}}))
+	require.NoError(t, os.Remove(names[2]))
+
+	r, err = fp.VerifyTopic(topic)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), r.FilesChecked)
+	require.Equal(t, 2, len(r.Errors))
+	require.Equal(t, names[1], r.Errors[0].File)
+	require.Equal(t, names[2], r.Errors[1].File)
+	require.Equal(t, "file is missing", r.Errors[1].Err)
+}
+
+func TestVerifyTopic(t *testing.T) {
+	testVerifyTopic(t, false)
+}
+
+func TestVerifyTopicCompressed(t *testing.T) {
+	testVerifyTopic(t, true)
+}
+
+func TestVerifyTopicRetention(t *testing.T) {
+	deleteTestTopics(t)
+
+	topic := "verify-retention-test-topic/"
+
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	fp.cfg.PartitionManifest = true
+	fp.cfg.MaxFileSize = 1 //rotate on every message
+
+	msgs := make([]string, 0)
+	for i := 0; i < 4; i++ {
+		msgs = append(msgs, fmt.Sprintf(`{"verify":%v}`, i))
+	}
+	produceTestMsgs(t, fp, topic, msgs)
+
+	files, err := ioutil.ReadDir(baseDir + "/" + topic)
+	require.NoError(t, err)
+	names := make([]string, 0)
+	for _, f := range files {
+		if !isMetaFile(f.Name()) {
+			names = append(names, baseDir+"/"+topic+f.Name())
+		}
+	}
+	require.Equal(t, 4, len(names))
+
+	//Oldest files removed by retention are not reported
+	require.NoError(t, os.Remove(names[0]))
+	require.NoError(t, os.Remove(names[1]))
+
+	r, err := fp.VerifyTopic(topic)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), r.FilesChecked)
+	require.Empty(t, r.Errors)
+
+	//Gap after the earliest present file is reported
+	require.NoError(t, os.Remove(names[3]))
+
+	r, err = fp.VerifyTopic(topic)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(r.Errors), "%+v", r.Errors)
+	require.Equal(t, names[3], r.Errors[0].File)
+	require.Equal(t, "file is missing", r.Errors[0].Err)
+}
+
+func TestVerifyTopicSequence(t *testing.T) {
+	deleteTestTopics(t)
+
+	topic := "verify-sequence-test/"
+
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	fp.cfg.PartitionManifest = true
+	fp.cfg.MaxFileSize = 1 //rotate on every message
+	fp.SetSequenceFunc(jsonSequence)
+
+	//Partitions are numbered independently, record 3 of key1 is lost
+	p, err := fp.NewProducer(topic)
+	require.NoError(t, err)
+	for _, s := range []int{1, 2, 4} {
+		require.NoError(t, p.PushK("key1", []byte(fmt.Sprintf(`{"Seq":%v}`, s))))
+		require.NoError(t, p.PushK("key2", []byte(fmt.Sprintf(`{"Seq":%v}`, s+10-s/4))))
+	}
+	require.NoError(t, p.Close())
+
+	r, err := fp.VerifyTopic(topic)
+	require.NoError(t, err)
+	require.Equal(t, int64(6), r.FilesChecked)
+	require.Equal(t, 1, len(r.Errors), "%+v", r.Errors)
+	require.Contains(t, r.Errors[0].File, ".key1")
+	require.Equal(t, int64(0), r.Errors[0].Offset)
+	require.Equal(t, "sequence number 4 doesn't follow 2", r.Errors[0].Err)
+
+	//Sequence numbers of the verified files are kept with the progress
+	var st verifyState
+	ok, err := fp.readState(fp.fs, verifyFileName(topicPath(fp.datadir, topic)), &st)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, map[string]uint64{"key1": 4, "key2": 13}, st.Sequences)
+}
+
+func TestVerifyTopicDetectsHeader(t *testing.T) {
+	deleteTestTopics(t)
+
+	topic := "verify-header-test-topic/"
+
+	fp := initTestFilePipe(&cfg.Pipe, false, t)
+	fp.cfg.PartitionManifest = true
+	fp.cfg.BinaryHeader = true
+	fp.cfg.Compression = true
+	fp.cfg.MaxFileSize = 1 //rotate on every message
+	produceTestMsgs(t, fp, topic, []string{`{"verify":0}`, `{"verify":1}`})
+
+	//Verifier is configured without the header
+	v := initTestFilePipe(&cfg.Pipe, false, t)
+	v.cfg.PartitionManifest = true
+	r, err := v.VerifyTopic(topic)
+	require.NoError(t, err)
+	require.Empty(t, r.Errors)
+	require.Equal(t, int64(2), r.FilesChecked)
+	require.Equal(t, int64(2), r.NumRecs)
+}
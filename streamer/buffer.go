@@ -66,6 +66,10 @@ func (s *Streamer) encodeCommonFormat(outProducer pipe.Producer, data []byte) (k
 
 		key = encoder.GetCommonFormatKey(cfEvent)
 
+		if cfEvent.Type == "schema" && outMsg == nil {
+			outMsg = s.containerSchema()
+		}
+
 		if cfEvent.Type == "schema" && outMsg != nil {
 			key = outProducer.PartitionKey("log", key)
 
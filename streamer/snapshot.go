@@ -120,6 +120,9 @@ func (s *Streamer) pushSchema(outProducer pipe.Producer) bool {
 	if log.EL(s.log, err) {
 		return false
 	}
+	if outMsg == nil {
+		outMsg = s.containerSchema()
+	}
 	if outMsg == nil {
 		return true
 	}
@@ -314,3 +314,12 @@ func Worker(cfg *config.AppConfig, inP pipe.Pipe) bool {
 	s := &Streamer{inPipe: inP}
 	return s.start(cfg)
 }
+
+//containerSchema returns Avro schema for the pipes writing Avro container
+//files, which carry the schema in the file metadata
+func (s *Streamer) containerSchema() []byte {
+	if !s.outPipe.Config().AvroOCF {
+		return nil
+	}
+	return encoder.AvroOutputSchema(s.outEncoder)
+}